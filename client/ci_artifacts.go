@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DownloadCIArtifact writes the content of an artifact of a CI run to writer.
+func (c *Client) DownloadCIArtifact(runID int64, name string, writer io.Writer) error {
+	scheme := c.getHTTPScheme()
+	url := fmt.Sprintf("%s://%s/repository/%d/ci/%d/artifacts/%s", scheme, c.getServer(), c.getRepoId(), runID, escapeArtifactName(name))
+
+	req, err := c.makeHTTPRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Join(errors.New("create request"), err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Join(errors.New("send request"), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	_, err = io.Copy(writer, resp.Body)
+	if err != nil {
+		return errors.Join(errors.New("read response"), err)
+	}
+
+	return nil
+}
+
+// escapeArtifactName escapes each element of a slash separated artifact name
+// for use in a URL path.
+func escapeArtifactName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
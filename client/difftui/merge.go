@@ -0,0 +1,491 @@
+package difftui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/devsisters/go-diff3"
+	"github.com/pogo-vcs/pogo/filecontents"
+)
+
+// Resolution is the choice made for a conflicting hunk.
+type Resolution uint8
+
+const (
+	Unresolved Resolution = iota
+	PickOurs
+	PickTheirs
+	PickBoth
+	PickBase
+	Edited
+)
+
+type MergeHunk struct {
+	Conflict   bool
+	Lines      []string
+	Base       []string
+	Ours       []string
+	Theirs     []string
+	Resolution Resolution
+	Edited     []string
+}
+
+// ResultLines returns the lines of the hunk after applying its resolution.
+// Unresolved conflicts are rendered with conflict markers.
+func (h MergeHunk) ResultLines(oursLabel, theirsLabel string) []string {
+	if !h.Conflict {
+		return h.Lines
+	}
+	switch h.Resolution {
+	case PickOurs:
+		return h.Ours
+	case PickTheirs:
+		return h.Theirs
+	case PickBoth:
+		return append(append([]string{}, h.Ours...), h.Theirs...)
+	case PickBase:
+		return h.Base
+	case Edited:
+		return h.Edited
+	default:
+		lines := []string{filecontents.ConflictMarkerStart + " " + oursLabel}
+		lines = append(lines, h.Ours...)
+		lines = append(lines, filecontents.ConflictMarkerSep)
+		lines = append(lines, h.Theirs...)
+		return append(lines, filecontents.ConflictMarkerEnd+" "+theirsLabel)
+	}
+}
+
+type MergeFile struct {
+	Path            string
+	OursLabel       string
+	TheirsLabel     string
+	Hunks           []MergeHunk
+	trailingNewline bool
+}
+
+// NewMergeFile splits a three-way merge of the given contents into hunks.
+func NewMergeFile(path, oursLabel, theirsLabel, base, ours, theirs string) MergeFile {
+	f := MergeFile{
+		Path:            path,
+		OursLabel:       oursLabel,
+		TheirsLabel:     theirsLabel,
+		trailingNewline: strings.HasSuffix(ours, "\n") || strings.HasSuffix(theirs, "\n"),
+	}
+	for _, item := range diff3.Diff3Merge(splitLines(ours), splitLines(base), splitLines(theirs), true) {
+		if item.Conflict == nil {
+			f.Hunks = append(f.Hunks, MergeHunk{Lines: item.Ok})
+			continue
+		}
+		f.Hunks = append(f.Hunks, MergeHunk{
+			Conflict: true,
+			Base:     item.Conflict.O,
+			Ours:     item.Conflict.A,
+			Theirs:   item.Conflict.B,
+		})
+	}
+	return f
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Resolved reports whether every conflicting hunk has a resolution.
+func (f MergeFile) Resolved() bool {
+	for _, h := range f.Hunks {
+		if h.Conflict && h.Resolution == Unresolved {
+			return false
+		}
+	}
+	return true
+}
+
+// Touched reports whether any conflicting hunk has a resolution.
+func (f MergeFile) Touched() bool {
+	for _, h := range f.Hunks {
+		if h.Conflict && h.Resolution != Unresolved {
+			return true
+		}
+	}
+	return false
+}
+
+// Content returns the merged file content.
+func (f MergeFile) Content() string {
+	var lines []string
+	for _, h := range f.Hunks {
+		lines = append(lines, h.ResultLines(f.OursLabel, f.TheirsLabel)...)
+	}
+	content := strings.Join(lines, "\n")
+	if f.trailingNewline && len(lines) > 0 {
+		content += "\n"
+	}
+	return content
+}
+
+func (f MergeFile) conflicts() []int {
+	var idx []int
+	for i, h := range f.Hunks {
+		if h.Conflict {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+type mergeKeyMap struct {
+	Up         key.Binding
+	Down       key.Binding
+	NextHunk   key.Binding
+	PrevHunk   key.Binding
+	NextFile   key.Binding
+	PrevFile   key.Binding
+	PickOurs   key.Binding
+	PickTheirs key.Binding
+	PickBoth   key.Binding
+	PickBase   key.Binding
+	Undo       key.Binding
+	Edit       key.Binding
+	Save       key.Binding
+	Quit       key.Binding
+}
+
+var mergeKeys = mergeKeyMap{
+	Up:         key.NewBinding(key.WithKeys("k", "up")),
+	Down:       key.NewBinding(key.WithKeys("j", "down")),
+	NextHunk:   key.NewBinding(key.WithKeys("n")),
+	PrevHunk:   key.NewBinding(key.WithKeys("N")),
+	NextFile:   key.NewBinding(key.WithKeys("tab")),
+	PrevFile:   key.NewBinding(key.WithKeys("shift+tab")),
+	PickOurs:   key.NewBinding(key.WithKeys("h", "left")),
+	PickTheirs: key.NewBinding(key.WithKeys("l", "right")),
+	PickBoth:   key.NewBinding(key.WithKeys("b")),
+	PickBase:   key.NewBinding(key.WithKeys("x")),
+	Undo:       key.NewBinding(key.WithKeys("u")),
+	Edit:       key.NewBinding(key.WithKeys("e")),
+	Save:       key.NewBinding(key.WithKeys("s")),
+	Quit:       key.NewBinding(key.WithKeys("q", "ctrl+c")),
+}
+
+const (
+	mergeLineStyle       = "\x1b[48;2;30;30;30m\x1b[38;2;224;224;224m"
+	mergeHeaderStyle     = "\x1b[48;2;30;30;30m\x1b[38;2;144;164;174m\x1b[1m"
+	mergeSideStyle       = "\x1b[48;2;45;45;45m\x1b[38;2;224;224;224m"
+	mergeSelectedStyle   = "\x1b[48;2;70;60;20m\x1b[38;2;255;248;225m"
+	mergeUnresolvedStyle = "\x1b[48;2;100;30;30m\x1b[38;2;255;235;238m"
+	mergeResolvedStyle   = "\x1b[48;2;30;80;30m\x1b[38;2;232;245;233m"
+	mergeSeparator       = "\x1b[38;2;120;144;156m│\x1b[0m"
+)
+
+type editedMsg struct {
+	file  int
+	hunk  int
+	lines []string
+	err   error
+}
+
+type mergeModel struct {
+	files       []MergeFile
+	currentFile int
+	currentHunk int
+	viewport    viewport.Model
+	ready       bool
+	width       int
+	hunkOffset  int
+	saved       bool
+	err         error
+}
+
+func (m mergeModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *mergeModel) selectedHunk() (int, bool) {
+	conflicts := m.files[m.currentFile].conflicts()
+	if m.currentHunk < 0 || m.currentHunk >= len(conflicts) {
+		return 0, false
+	}
+	return conflicts[m.currentHunk], true
+}
+
+func (m *mergeModel) resolve(r Resolution) {
+	if i, ok := m.selectedHunk(); ok {
+		m.files[m.currentFile].Hunks[i].Resolution = r
+		if r != Unresolved && m.currentHunk < len(m.files[m.currentFile].conflicts())-1 {
+			m.currentHunk++
+		}
+	}
+}
+
+func (m *mergeModel) refresh(scrollToHunk bool) {
+	m.viewport.SetContent(m.render())
+	if scrollToHunk {
+		m.viewport.SetYOffset(max(m.hunkOffset-3, 0))
+	}
+}
+
+func (m mergeModel) editHunk() tea.Cmd {
+	i, ok := m.selectedHunk()
+	if !ok {
+		return nil
+	}
+	f := m.files[m.currentFile]
+
+	tmp, err := os.CreateTemp("", "pogo-resolve-*")
+	if err != nil {
+		return func() tea.Msg { return editedMsg{err: err} }
+	}
+	content := strings.Join(f.Hunks[i].ResultLines(f.OursLabel, f.TheirsLabel), "\n") + "\n"
+	_, err = tmp.WriteString(content)
+	tmp.Close()
+	if err != nil {
+		return func() tea.Msg { return editedMsg{err: err} }
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"nano"}
+	}
+	c := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	file := m.currentFile
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		defer os.Remove(tmp.Name())
+		if err != nil {
+			return editedMsg{err: err}
+		}
+		b, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return editedMsg{err: err}
+		}
+		return editedMsg{file: file, hunk: i, lines: splitLines(string(b))}
+	})
+}
+
+func (m mergeModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, mergeKeys.Quit):
+			return m, tea.Quit
+
+		case key.Matches(msg, mergeKeys.Save):
+			m.saved = true
+			return m, tea.Quit
+
+		case key.Matches(msg, mergeKeys.NextFile):
+			if m.currentFile < len(m.files)-1 {
+				m.currentFile++
+				m.currentHunk = 0
+				m.refresh(true)
+			}
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PrevFile):
+			if m.currentFile > 0 {
+				m.currentFile--
+				m.currentHunk = 0
+				m.refresh(true)
+			}
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.NextHunk):
+			if m.currentHunk < len(m.files[m.currentFile].conflicts())-1 {
+				m.currentHunk++
+				m.refresh(true)
+			}
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PrevHunk):
+			if m.currentHunk > 0 {
+				m.currentHunk--
+				m.refresh(true)
+			}
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PickOurs):
+			m.resolve(PickOurs)
+			m.refresh(true)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PickTheirs):
+			m.resolve(PickTheirs)
+			m.refresh(true)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PickBoth):
+			m.resolve(PickBoth)
+			m.refresh(true)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.PickBase):
+			m.resolve(PickBase)
+			m.refresh(true)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.Undo):
+			m.resolve(Unresolved)
+			m.refresh(true)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.Edit):
+			return m, m.editHunk()
+
+		case key.Matches(msg, mergeKeys.Up):
+			m.viewport.LineUp(1)
+			return m, nil
+
+		case key.Matches(msg, mergeKeys.Down):
+			m.viewport.LineDown(1)
+			return m, nil
+		}
+
+	case editedMsg:
+		if msg.err != nil {
+			m.err = msg.err
+			return m, tea.Quit
+		}
+		h := &m.files[msg.file].Hunks[msg.hunk]
+		h.Resolution = Edited
+		h.Edited = msg.lines
+		m.refresh(true)
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		headerHeight := 2
+		footerHeight := 1
+		verticalMargins := headerHeight + footerHeight
+
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, msg.Height-verticalMargins)
+			m.ready = true
+		} else {
+			m.viewport.Width = msg.Width
+			m.viewport.Height = msg.Height - verticalMargins
+		}
+		m.refresh(false)
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m mergeModel) View() string {
+	if !m.ready {
+		return "Initializing..."
+	}
+
+	f := m.files[m.currentFile]
+	resolved := 0
+	conflicts := f.conflicts()
+	for _, i := range conflicts {
+		if f.Hunks[i].Resolution != Unresolved {
+			resolved++
+		}
+	}
+	status := fmt.Sprintf("File %d/%d: %s (%d/%d conflicts resolved)", m.currentFile+1, len(m.files), f.Path, resolved, len(conflicts))
+	help := "h/l: ours/theirs | b: both | x: base | e: edit | u: undo | n/N: next/prev conflict | tab: next file | s: save | q: quit"
+
+	return fmt.Sprintf(
+		"%s\n%s\n%s",
+		statusStyle.Render(status),
+		m.viewport.View(),
+		helpStyle.Render(help),
+	)
+}
+
+func (m *mergeModel) render() string {
+	f := m.files[m.currentFile]
+	colWidth := max((m.width-2)/3, 1)
+	selected, _ := m.selectedHunk()
+
+	var b strings.Builder
+	row := func(left, mid, right string, leftStyle, midStyle, rightStyle string) {
+		b.WriteString(mergeCell(left, colWidth, leftStyle))
+		b.WriteString(mergeSeparator)
+		b.WriteString(mergeCell(mid, colWidth, midStyle))
+		b.WriteString(mergeSeparator)
+		b.WriteString(mergeCell(right, colWidth, rightStyle))
+		b.WriteString("\n")
+	}
+
+	row("ours: "+f.OursLabel, "result", "theirs: "+f.TheirsLabel, mergeHeaderStyle, mergeHeaderStyle, mergeHeaderStyle)
+	rows := 1
+
+	for i, h := range f.Hunks {
+		if !h.Conflict {
+			for _, line := range h.Lines {
+				row(line, line, line, mergeLineStyle, mergeLineStyle, mergeLineStyle)
+				rows++
+			}
+			continue
+		}
+
+		sideStyle := mergeSideStyle
+		if i == selected {
+			sideStyle = mergeSelectedStyle
+			m.hunkOffset = rows
+		}
+		mid := []string{"(unresolved)"}
+		midStyle := mergeUnresolvedStyle
+		if h.Resolution != Unresolved {
+			mid = h.ResultLines(f.OursLabel, f.TheirsLabel)
+			midStyle = mergeResolvedStyle
+		}
+
+		height := max(len(h.Ours), len(h.Theirs), len(mid), 1)
+		for j := range height {
+			row(lineAt(h.Ours, j), lineAt(mid, j), lineAt(h.Theirs, j), sideStyle, midStyle, sideStyle)
+			rows++
+		}
+	}
+
+	return b.String()
+}
+
+func lineAt(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
+
+func mergeCell(line string, width int, style string) string {
+	runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	return padLineWithBg(style+string(runes), width)
+}
+
+// RunMerge opens the three-pane merge tool for the given files. It returns
+// false if the user quit without saving.
+func RunMerge(files []MergeFile) (bool, error) {
+	if len(files) == 0 {
+		return false, errors.New("no files to merge")
+	}
+
+	m := mergeModel{files: files, viewport: viewport.New(80, 24)}
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	final, err := p.Run()
+	if err != nil {
+		return false, err
+	}
+
+	result := final.(mergeModel)
+	if result.err != nil {
+		return false, fmt.Errorf("edit hunk: %w", result.err)
+	}
+	return result.saved, nil
+}
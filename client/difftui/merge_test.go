@@ -0,0 +1,50 @@
+package difftui_test
+
+import (
+	"testing"
+
+	"github.com/pogo-vcs/pogo/client/difftui"
+	"github.com/pogo-vcs/pogo/filecontents"
+)
+
+func TestMergeFileContent(t *testing.T) {
+	base := "a\nb\nc\n"
+	ours := "a\nx\nc\n"
+	theirs := "a\ny\nc\n"
+
+	tests := []struct {
+		name       string
+		resolution difftui.Resolution
+		want       string
+		resolved   bool
+	}{
+		{
+			name:       "unresolved",
+			resolution: difftui.Unresolved,
+			want: "a\n" + filecontents.ConflictMarkerStart + " ours\nx\n" +
+				filecontents.ConflictMarkerSep + "\ny\n" +
+				filecontents.ConflictMarkerEnd + " theirs\nc\n",
+		},
+		{name: "ours", resolution: difftui.PickOurs, want: ours, resolved: true},
+		{name: "theirs", resolution: difftui.PickTheirs, want: theirs, resolved: true},
+		{name: "both", resolution: difftui.PickBoth, want: "a\nx\ny\nc\n", resolved: true},
+		{name: "base", resolution: difftui.PickBase, want: base, resolved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := difftui.NewMergeFile("file.txt", "ours", "theirs", base, ours, theirs)
+			for i := range f.Hunks {
+				if f.Hunks[i].Conflict {
+					f.Hunks[i].Resolution = tt.resolution
+				}
+			}
+			if got := f.Content(); got != tt.want {
+				t.Errorf("Content() = %q, want %q", got, tt.want)
+			}
+			if got := f.Resolved(); got != tt.resolved {
+				t.Errorf("Resolved() = %v, want %v", got, tt.resolved)
+			}
+		})
+	}
+}
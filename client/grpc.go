@@ -284,14 +284,28 @@ func (c *Client) PushFull(force bool) error {
 
 	// Wait for response
 	fmt.Fprintln(c.VerboseOut, "Waiting for response")
-	_, err = stream.CloseAndRecv()
+	response, err := stream.CloseAndRecv()
 	if err != nil {
 		return errors.Join(errors.New("recv response"), err)
 	}
 
+	for _, problem := range response.CiProblems {
+		fmt.Fprintln(os.Stderr, FormatCILintProblem(problem))
+	}
+
 	return nil
 }
 
+// FormatCILintProblem formats a problem of a CI config like
+// .pogo/ci/build.yaml:12: error: do[0].container: missing property image
+func FormatCILintProblem(problem *protos.CILintProblem) string {
+	location := problem.Filename
+	if problem.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, problem.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, problem.Severity, problem.Message)
+}
+
 func (c *Client) DiffLocal() ([]DiffFileInfo, error) {
 	stream, err := c.Pogo.DiffLocal(c.ctx)
 	if err != nil {
@@ -1225,6 +1239,41 @@ func (c *Client) Info() (*protos.InfoResponse, error) {
 	return response, nil
 }
 
+// GetConflicts returns the conflicts of the checked out change.
+// If name is set, only the conflict for this path is returned.
+func (c *Client) GetConflicts(name *string, style protos.ConflictStyle, includeContents bool) ([]*protos.Conflict, error) {
+	request := &protos.GetConflictsRequest{
+		Auth:               c.GetAuth(),
+		RepoId:             c.getRepoId(),
+		CheckedOutChangeId: c.getChangeId(),
+		Name:               name,
+		Style:              style,
+		IncludeContents:    includeContents,
+	}
+
+	response, err := c.Pogo.GetConflicts(c.ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("get conflicts: %w", err)
+	}
+
+	return response.Conflicts, nil
+}
+
+func (c *Client) MarkConflictsResolved(names []string) error {
+	request := &protos.MarkConflictsResolvedRequest{
+		Auth:               c.GetAuth(),
+		RepoId:             c.getRepoId(),
+		CheckedOutChangeId: c.getChangeId(),
+		Names:              names,
+	}
+
+	if _, err := c.Pogo.MarkConflictsResolved(c.ctx, request); err != nil {
+		return fmt.Errorf("mark conflicts resolved: %w", err)
+	}
+
+	return nil
+}
+
 func (c *Client) Checkout(repoId int32, changeId int64) error {
 	// Collect client files
 	var clientFiles []string
@@ -1434,12 +1483,13 @@ func (c *Client) SetRepositoryVisibility(public bool) error {
 	return nil
 }
 
-func (c *Client) SetSecret(key, value string) error {
+func (c *Client) SetSecret(environment, key, value string) error {
 	request := &protos.SetSecretRequest{
-		Auth:   c.GetAuth(),
-		RepoId: c.getRepoId(),
-		Key:    key,
-		Value:  value,
+		Auth:        c.GetAuth(),
+		RepoId:      c.getRepoId(),
+		Key:         key,
+		Value:       value,
+		Environment: environment,
 	}
 
 	_, err := c.Pogo.SetSecret(c.ctx, request)
@@ -1450,11 +1500,12 @@ func (c *Client) SetSecret(key, value string) error {
 	return nil
 }
 
-func (c *Client) GetSecret(key string) (string, error) {
+func (c *Client) GetSecret(environment, key string) (string, error) {
 	request := &protos.GetSecretRequest{
-		Auth:   c.GetAuth(),
-		RepoId: c.getRepoId(),
-		Key:    key,
+		Auth:        c.GetAuth(),
+		RepoId:      c.getRepoId(),
+		Key:         key,
+		Environment: environment,
 	}
 
 	response, err := c.Pogo.GetSecret(c.ctx, request)
@@ -1479,11 +1530,12 @@ func (c *Client) GetAllSecrets() ([]*protos.Secret, error) {
 	return response.Secrets, nil
 }
 
-func (c *Client) DeleteSecret(key string) error {
+func (c *Client) DeleteSecret(environment, key string) error {
 	request := &protos.DeleteSecretRequest{
-		Auth:   c.GetAuth(),
-		RepoId: c.getRepoId(),
-		Key:    key,
+		Auth:        c.GetAuth(),
+		RepoId:      c.getRepoId(),
+		Key:         key,
+		Environment: environment,
 	}
 
 	_, err := c.Pogo.DeleteSecret(c.ctx, request)
@@ -1494,7 +1546,189 @@ func (c *Client) DeleteSecret(key string) error {
 	return nil
 }
 
-func (c *Client) ListCIRuns() ([]*protos.CIRunSummary, error) {
+func (c *Client) SetEnvironment(name string, bookmarks []string) error {
+	request := &protos.SetEnvironmentRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		Environment: &protos.Environment{
+			Name:      name,
+			Bookmarks: bookmarks,
+		},
+	}
+
+	_, err := c.Pogo.SetEnvironment(c.ctx, request)
+	if err != nil {
+		return errors.Join(errors.New("set environment"), err)
+	}
+
+	return nil
+}
+
+func (c *Client) GetEnvironments() ([]*protos.Environment, error) {
+	request := &protos.GetEnvironmentsRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+	}
+
+	response, err := c.Pogo.GetEnvironments(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("get environments"), err)
+	}
+
+	return response.Environments, nil
+}
+
+func (c *Client) DeleteEnvironment(name string) error {
+	request := &protos.DeleteEnvironmentRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		Name:   name,
+	}
+
+	_, err := c.Pogo.DeleteEnvironment(c.ctx, request)
+	if err != nil {
+		return errors.Join(errors.New("delete environment"), err)
+	}
+
+	return nil
+}
+
+func (c *Client) SetOrgSecret(environment, key, value string) error {
+	request := &protos.SetOrgSecretRequest{
+		Auth:        c.GetAuth(),
+		Key:         key,
+		Value:       value,
+		Environment: environment,
+	}
+
+	_, err := c.Pogo.SetOrgSecret(c.ctx, request)
+	if err != nil {
+		return errors.Join(errors.New("set org secret"), err)
+	}
+
+	return nil
+}
+
+func (c *Client) GetAllOrgSecrets() ([]*protos.Secret, error) {
+	request := &protos.GetAllOrgSecretsRequest{
+		Auth: c.GetAuth(),
+	}
+
+	response, err := c.Pogo.GetAllOrgSecrets(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("get all org secrets"), err)
+	}
+
+	return response.Secrets, nil
+}
+
+func (c *Client) DeleteOrgSecret(environment, key string) error {
+	request := &protos.DeleteOrgSecretRequest{
+		Auth:        c.GetAuth(),
+		Key:         key,
+		Environment: environment,
+	}
+
+	_, err := c.Pogo.DeleteOrgSecret(c.ctx, request)
+	if err != nil {
+		return errors.Join(errors.New("delete org secret"), err)
+	}
+
+	return nil
+}
+
+func (c *Client) RotateSecretsKey() (*protos.RotateSecretsKeyResponse, error) {
+	request := &protos.RotateSecretsKeyRequest{
+		Auth: c.GetAuth(),
+	}
+
+	response, err := c.Pogo.RotateSecretsKey(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("rotate secrets key"), err)
+	}
+
+	return response, nil
+}
+
+func (c *Client) CreateWebhook(url string, events []string, secret string, maxAttempts int32) (*protos.CreateWebhookResponse, error) {
+	request := &protos.CreateWebhookRequest{
+		Auth:        c.GetAuth(),
+		RepoId:      c.getRepoId(),
+		Url:         url,
+		Events:      events,
+		Secret:      secret,
+		MaxAttempts: maxAttempts,
+	}
+
+	response, err := c.Pogo.CreateWebhook(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("create webhook"), err)
+	}
+
+	return response, nil
+}
+
+func (c *Client) ListWebhooks() ([]*protos.Webhook, error) {
+	request := &protos.ListWebhooksRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+	}
+
+	response, err := c.Pogo.ListWebhooks(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("list webhooks"), err)
+	}
+
+	return response.Webhooks, nil
+}
+
+func (c *Client) DeleteWebhook(id int32) error {
+	request := &protos.DeleteWebhookRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		Id:     id,
+	}
+
+	_, err := c.Pogo.DeleteWebhook(c.ctx, request)
+	if err != nil {
+		return errors.Join(errors.New("delete webhook"), err)
+	}
+
+	return nil
+}
+
+func (c *Client) ListWebhookDeliveries(webhookId int32, limit int32) ([]*protos.WebhookDelivery, error) {
+	request := &protos.ListWebhookDeliveriesRequest{
+		Auth:      c.GetAuth(),
+		RepoId:    c.getRepoId(),
+		WebhookId: webhookId,
+		Limit:     limit,
+	}
+
+	response, err := c.Pogo.ListWebhookDeliveries(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("list webhook deliveries"), err)
+	}
+
+	return response.Deliveries, nil
+}
+
+func (c *Client) RedeliverWebhook(deliveryId int64) (int64, error) {
+	request := &protos.RedeliverWebhookRequest{
+		Auth:       c.GetAuth(),
+		RepoId:     c.getRepoId(),
+		DeliveryId: deliveryId,
+	}
+
+	response, err := c.Pogo.RedeliverWebhook(c.ctx, request)
+	if err != nil {
+		return 0, errors.Join(errors.New("redeliver webhook"), err)
+	}
+
+	return response.DeliveryId, nil
+}
+
+func (c *Client) ListCIRuns() (*protos.ListCIRunsResponse, error) {
 	request := &protos.ListCIRunsRequest{
 		Auth:   c.GetAuth(),
 		RepoId: c.getRepoId(),
@@ -1505,7 +1739,7 @@ func (c *Client) ListCIRuns() ([]*protos.CIRunSummary, error) {
 		return nil, errors.Join(errors.New("list CI runs"), err)
 	}
 
-	return resp.Runs, nil
+	return resp, nil
 }
 
 func (c *Client) GetCIRun(runID int64) (*protos.GetCIRunResponse, error) {
@@ -1523,7 +1757,90 @@ func (c *Client) GetCIRun(runID int64) (*protos.GetCIRunResponse, error) {
 	return resp, nil
 }
 
-func (c *Client) CollectDiff(rev1, rev2 *string, usePatience, includeLargeFiles bool) (difftui.DiffData, error) {
+// FollowCIRun writes the log of a run to out as it is written and returns the
+// status of the run once it finished.
+func (c *Client) FollowCIRun(runID int64, out io.Writer) (string, error) {
+	stream, err := c.Pogo.FollowCIRun(c.ctx, &protos.FollowCIRunRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		RunId:  runID,
+	})
+	if err != nil {
+		return "", errors.Join(errors.New("follow CI run"), err)
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return "", errors.New("CI run log ended before the run finished")
+		}
+		if err != nil {
+			return "", errors.Join(errors.New("receive CI run log"), err)
+		}
+		if _, err := out.Write(msg.Data); err != nil {
+			return "", errors.Join(errors.New("write CI run log"), err)
+		}
+		if msg.Status != nil {
+			return *msg.Status, nil
+		}
+	}
+}
+
+func (c *Client) CancelCIRun(runID int64) error {
+	_, err := c.Pogo.CancelCIRun(c.ctx, &protos.CancelCIRunRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		RunId:  runID,
+	})
+	if err != nil {
+		return errors.Join(errors.New("cancel CI run"), err)
+	}
+	return nil
+}
+
+func (c *Client) RerunCIRun(runID int64) (int64, error) {
+	resp, err := c.Pogo.RerunCIRun(c.ctx, &protos.RerunCIRunRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		RunId:  runID,
+	})
+	if err != nil {
+		return 0, errors.Join(errors.New("rerun CI run"), err)
+	}
+	return resp.PipelineId, nil
+}
+
+func (c *Client) ApproveCIRun(runID int64) error {
+	_, err := c.Pogo.ApproveCIRun(c.ctx, &protos.ApproveCIRunRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		RunId:  runID,
+	})
+	if err != nil {
+		return errors.Join(errors.New("approve CI run"), err)
+	}
+	return nil
+}
+
+func (c *Client) RunCI(configFilename string, rev *string, inputs map[string]string) (*protos.RunCIResponse, error) {
+	request := &protos.RunCIRequest{
+		Auth:               c.GetAuth(),
+		RepoId:             c.getRepoId(),
+		CheckedOutChangeId: c.getChangeId(),
+		ConfigFilename:     configFilename,
+		Rev:                rev,
+		Inputs:             inputs,
+	}
+
+	resp, err := c.Pogo.RunCI(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("run CI"), err)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) newDiffRequest(rev1, rev2 *string, usePatience, includeLargeFiles bool) *protos.DiffRequest {
 	changeId := c.getChangeId()
 	request := &protos.DiffRequest{
 		Auth:               c.GetAuth(),
@@ -1540,6 +1857,27 @@ func (c *Client) CollectDiff(rev1, rev2 *string, usePatience, includeLargeFiles
 		request.Rev2 = rev2
 	}
 
+	return request
+}
+
+func (c *Client) newRemergeDiffRequest(rev *string, usePatience, includeLargeFiles bool) *protos.DiffRequest {
+	remerge := true
+	request := c.newDiffRequest(rev, nil, usePatience, includeLargeFiles)
+	request.Remerge = &remerge
+	return request
+}
+
+func (c *Client) CollectDiff(rev1, rev2 *string, usePatience, includeLargeFiles bool) (difftui.DiffData, error) {
+	return c.collectDiff(c.newDiffRequest(rev1, rev2, usePatience, includeLargeFiles))
+}
+
+// CollectRemergeDiff collects the difference between a merge change and an
+// automatic re-merge of its parents, i.e. what was changed while resolving it.
+func (c *Client) CollectRemergeDiff(rev *string, usePatience, includeLargeFiles bool) (difftui.DiffData, error) {
+	return c.collectDiff(c.newRemergeDiffRequest(rev, usePatience, includeLargeFiles))
+}
+
+func (c *Client) collectDiff(request *protos.DiffRequest) (difftui.DiffData, error) {
 	stream, err := c.Pogo.Diff(c.ctx, request)
 	if err != nil {
 		return difftui.DiffData{}, errors.Join(errors.New("call diff"), err)
@@ -1593,22 +1931,16 @@ func (c *Client) CollectDiff(rev1, rev2 *string, usePatience, includeLargeFiles
 }
 
 func (c *Client) Diff(rev1, rev2 *string, out io.Writer, colored, usePatience, includeLargeFiles bool) error {
-	changeId := c.getChangeId()
-	request := &protos.DiffRequest{
-		Auth:               c.GetAuth(),
-		RepoId:             c.getRepoId(),
-		CheckedOutChangeId: &changeId,
-		UsePatience:        &usePatience,
-		IncludeLargeFiles:  &includeLargeFiles,
-	}
+	return c.diff(c.newDiffRequest(rev1, rev2, usePatience, includeLargeFiles), out, colored)
+}
 
-	if rev1 != nil {
-		request.Rev1 = rev1
-	}
-	if rev2 != nil {
-		request.Rev2 = rev2
-	}
+// RemergeDiff writes the difference between a merge change and an automatic
+// re-merge of its parents to out.
+func (c *Client) RemergeDiff(rev *string, out io.Writer, colored, usePatience, includeLargeFiles bool) error {
+	return c.diff(c.newRemergeDiffRequest(rev, usePatience, includeLargeFiles), out, colored)
+}
 
+func (c *Client) diff(request *protos.DiffRequest, out io.Writer, colored bool) error {
 	stream, err := c.Pogo.Diff(c.ctx, request)
 	if err != nil {
 		return errors.Join(errors.New("call diff"), err)
@@ -1639,3 +1971,46 @@ func (c *Client) Diff(rev1, rev2 *string, out io.Writer, colored, usePatience, i
 
 	return nil
 }
+
+func (c *Client) CreateCIRunner(name string) (string, error) {
+	request := &protos.CreateCIRunnerRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		Name:   name,
+	}
+
+	resp, err := c.Pogo.CreateCIRunner(c.ctx, request)
+	if err != nil {
+		return "", errors.Join(errors.New("create CI runner"), err)
+	}
+
+	return resp.RunnerToken, nil
+}
+
+func (c *Client) ListCIRunners() ([]*protos.CIRunner, error) {
+	request := &protos.ListCIRunnersRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+	}
+
+	resp, err := c.Pogo.ListCIRunners(c.ctx, request)
+	if err != nil {
+		return nil, errors.Join(errors.New("list CI runners"), err)
+	}
+
+	return resp.Runners, nil
+}
+
+func (c *Client) DeleteCIRunner(name string) error {
+	request := &protos.DeleteCIRunnerRequest{
+		Auth:   c.GetAuth(),
+		RepoId: c.getRepoId(),
+		Name:   name,
+	}
+
+	if _, err := c.Pogo.DeleteCIRunner(c.ctx, request); err != nil {
+		return errors.Join(errors.New("delete CI runner"), err)
+	}
+
+	return nil
+}
@@ -35,6 +35,7 @@ type LogChangeData struct {
 	UpdatedAt     time.Time `json:"updated_at"`
 	IsCheckedOut  bool      `json:"is_checked_out"`
 	Bookmarks     []string  `json:"bookmarks"`
+	CIStatus      string    `json:"ci_status"`
 	X             int       `json:"x"`
 	Y             int       `json:"y"`
 }
@@ -104,6 +105,7 @@ func ExtractLogData(response *protos.LogResponse) *LogData {
 					X:             int(math.Round(n.X)),
 					Y:             int(math.Round(n.Y)),
 					Bookmarks:     protoChange.Bookmarks,
+					CIStatus:      protoChange.CiStatus,
 				})
 			} else {
 				// Placeholder node (like "~")
@@ -133,6 +135,7 @@ func ExtractLogData(response *protos.LogResponse) *LogData {
 				X:             0,
 				Y:             i * 3,
 				Bookmarks:     change.Bookmarks,
+				CIStatus:      change.CiStatus,
 			}
 		}
 	}
@@ -244,6 +247,16 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 			if len(change.ConflictFiles) > 0 {
 				output.WriteString(" 💥")
 			}
+			if symbol, color := ciStatusSymbol(change.CIStatus); symbol != "" {
+				output.WriteString(" ")
+				if coloredOutput {
+					output.WriteString(color)
+					output.WriteString(symbol)
+					output.WriteString(colors.Reset)
+				} else {
+					output.WriteString(symbol)
+				}
+			}
 
 			// Add modification time on the same line
 			output.WriteString(" ")
@@ -296,8 +309,10 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 
 	// Draw edges using adjacency list and node positions
 	changePositions := make(map[string][2]int)
+	occupied := make(map[[2]int]bool)
 	for _, change := range data.Changes {
 		changePositions[change.Name] = [2]int{change.X, change.Y}
+		occupied[[2]int{change.X, change.Y}] = true
 	}
 
 	for _, edge := range data.AdjacencyList {
@@ -306,11 +321,7 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 
 		if childPos, ok := changePositions[childName]; ok {
 			if parentPos, ok := changePositions[parentName]; ok {
-				spline := runedrawer.Spline{
-					{X: childPos[0], Y: childPos[1]},
-					{X: parentPos[0], Y: parentPos[1]},
-				}
-				drawer.DrawSpline(spline)
+				drawer.DrawSpline(edgeSpline(childPos, parentPos, occupied))
 			}
 		}
 	}
@@ -369,6 +380,11 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 					conflictSize = 2
 					drawer.Write(startX+len(change.Name)+1, y, "💥")
 				}
+				// CI status
+				if symbol, color := ciStatusSymbol(change.CIStatus); symbol != "" {
+					drawer.WriteX(startX+len(change.Name)+1+conflictSize, y, color, symbol, colors.Reset)
+					conflictSize += 2
+				}
 				// Add modification time on the same line
 				drawer.WriteX(startX+len(change.Name)+1+conflictSize, y, colors.BrightBlack, change.UpdatedAt.Format(timeFormat), colors.Reset)
 				if len(change.Bookmarks) > 0 {
@@ -399,6 +415,11 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 					conflictSize = 2
 					drawer.Write(startX+len(change.Name)+1, y, "💥")
 				}
+				// CI status
+				if symbol, _ := ciStatusSymbol(change.CIStatus); symbol != "" {
+					drawer.Write(startX+len(change.Name)+1+conflictSize, y, symbol)
+					conflictSize += 2
+				}
 				// Add modification time on the same line
 				drawer.Write(startX+len(change.Name)+1+conflictSize, y, change.UpdatedAt.Format(timeFormat))
 				if len(change.Bookmarks) > 0 {
@@ -428,3 +449,41 @@ func RenderLog(response *protos.LogResponse, coloredOutput bool) string {
 
 	return drawer.String()
 }
+
+// edgeSpline routes an edge from a child to its parent. Edges leave the child
+// vertically and turn towards the parent in its row. If another change lies in
+// the child's column before that row, as the middle parents of a merge with
+// more than two parents do, the edge turns in the child's row instead so it
+// does not run through that change.
+func edgeSpline(child, parent [2]int, occupied map[[2]int]bool) runedrawer.Spline {
+	for y := min(child[1], parent[1]) + 1; y < max(child[1], parent[1]); y++ {
+		if occupied[[2]int{child[0], y}] {
+			return runedrawer.Spline{
+				{X: child[0], Y: child[1]},
+				{X: parent[0], Y: child[1]},
+				{X: parent[0], Y: parent[1]},
+			}
+		}
+	}
+	return runedrawer.Spline{
+		{X: child[0], Y: child[1]},
+		{X: parent[0], Y: parent[1]},
+	}
+}
+
+// ciStatusSymbol returns the symbol and color of a CI status, or an empty
+// symbol if the change has no CI status.
+func ciStatusSymbol(status string) (string, string) {
+	switch status {
+	case "success":
+		return "✓", colors.Green
+	case "failure":
+		return "✗", colors.Red
+	case "pending":
+		return "●", colors.Yellow
+	case "cancelled":
+		return "⊘", colors.BrightBlack
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pogo-vcs/pogo/protos"
+)
+
+// multiParentLog is the log of a merge m of the three children x, y and z of r.
+func multiParentLog() *protos.LogResponse {
+	response := &protos.LogResponse{CheckedOutChangeId: 1}
+	for i, name := range []string{"m", "x", "y", "z", "r"} {
+		response.Changes = append(response.Changes, &protos.LogChange{
+			Id:           int64(i + 1),
+			Name:         name,
+			UniquePrefix: name,
+		})
+	}
+	for _, edge := range [][2]string{{"m", "x"}, {"m", "y"}, {"m", "z"}, {"x", "r"}, {"y", "r"}, {"z", "r"}, {"r", "~"}} {
+		response.Relations = append(response.Relations, &protos.LogRelation{ChildName: edge[0], ParentName: edge[1]})
+	}
+	return response
+}
+
+func TestExtractLogDataMultiParentMerge(t *testing.T) {
+	data := ExtractLogData(multiParentLog())
+
+	positions := make(map[string][2]int)
+	for _, change := range data.Changes {
+		positions[change.Name] = [2]int{change.X, change.Y}
+	}
+	for _, name := range []string{"m", "x", "y", "z", "r", "~"} {
+		if _, ok := positions[name]; !ok {
+			t.Fatalf("change %s has no position", name)
+		}
+	}
+
+	var parents []string
+	for _, edge := range data.AdjacencyList {
+		if edge[0] == "m" {
+			parents = append(parents, edge[1])
+		}
+	}
+	if strings.Join(parents, ",") != "x,y,z" {
+		t.Errorf("parents of m = %v, want [x y z]", parents)
+	}
+
+	seen := make(map[[2]int]string)
+	for _, name := range []string{"x", "y", "z"} {
+		pos := positions[name]
+		if other, ok := seen[pos]; ok {
+			t.Errorf("parents %s and %s are both placed at %v", other, name, pos)
+		}
+		seen[pos] = name
+		if pos[1] <= positions["m"][1] || pos[1] >= positions["r"][1] {
+			t.Errorf("parent %s at row %d is not between m at %d and r at %d", name, pos[1], positions["m"][1], positions["r"][1])
+		}
+	}
+}
+
+func TestRenderLogMultiParentMerge(t *testing.T) {
+	data := ExtractLogData(multiParentLog())
+	width := 0
+	for _, change := range data.Changes {
+		width = max(width, change.X+1)
+	}
+
+	// The edge from m to z must not run through y, which sits below m
+	want := []string{
+		"    ○───╮",
+		"    │   │",
+		"○───┤   │",
+		"│   │   │",
+		"│   ○   │",
+		"│   │   │",
+		"│   │   ○",
+		"│   │   │",
+		"╰───○───╯",
+		"    │",
+		"    ○",
+	}
+
+	output := RenderLog(multiParentLog(), false)
+	var graph []string
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		runes := []rune(line)
+		graph = append(graph, strings.TrimRight(string(runes[:min(width, len(runes))]), " "))
+	}
+	if strings.Join(graph, "\n") != strings.Join(want, "\n") {
+		t.Errorf("graph =\n%s\nwant\n%s\nfull output:\n%s", strings.Join(graph, "\n"), strings.Join(want, "\n"), output)
+	}
+}
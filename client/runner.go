@@ -0,0 +1,468 @@
+package client
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"google.golang.org/grpc"
+)
+
+const (
+	// runnerHeartbeatInterval keeps the pipeline from being requeued as orphaned
+	runnerHeartbeatInterval = 30 * time.Second
+	// runnerRetryInterval is the pause after the server could not be reached
+	runnerRetryInterval = 5 * time.Second
+	// runnerMaxLogSize keeps a result report below the gRPC message limit
+	runnerMaxLogSize = 2 << 20
+	// runnerLogInterval is how often the log output of running jobs is sent
+	runnerLogInterval = time.Second
+)
+
+// Runner executes CI pipelines of one repository on behalf of a server.
+type Runner struct {
+	ctx    context.Context
+	token  []byte
+	labels []string
+	Grpc   *grpc.ClientConn
+	Pogo   protos.PogoClient
+	Out    io.Writer
+}
+
+func OpenRunner(ctx context.Context, addr string, token []byte, labels []string) (*Runner, error) {
+	if len(addr) == 0 {
+		return nil, errors.New("addr is empty")
+	}
+
+	grpcClient, err := createGRPCClientWithTLSDetection(ctx, addr, io.Discard)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("open grpc client targeting %s", addr), err)
+	}
+
+	return &Runner{
+		ctx:    ctx,
+		token:  token,
+		labels: labels,
+		Grpc:   grpcClient,
+		Pogo:   protos.NewPogoClient(grpcClient),
+		Out:    os.Stdout,
+	}, nil
+}
+
+func (r *Runner) Close() {
+	if r.Grpc != nil {
+		_ = r.Grpc.Close()
+		r.Grpc = nil
+	}
+}
+
+// Run registers the runner and executes jobs until the context is cancelled.
+func (r *Runner) Run() error {
+	registration, err := r.Pogo.RegisterCIRunner(r.ctx, &protos.RegisterCIRunnerRequest{
+		RunnerToken: r.token,
+		Labels:      r.labels,
+	})
+	if err != nil {
+		return errors.Join(errors.New("register runner"), err)
+	}
+	fmt.Fprintf(r.Out, "Runner %s registered for %s with labels [%s]\n", registration.Name, registration.RepoName, strings.Join(r.labels, ", "))
+
+	for r.ctx.Err() == nil {
+		resp, err := r.Pogo.AcquireCIJob(r.ctx, &protos.AcquireCIJobRequest{RunnerToken: r.token})
+		if err != nil {
+			if r.ctx.Err() != nil {
+				break
+			}
+			fmt.Fprintf(r.Out, "Acquire job: %v\n", err)
+			select {
+			case <-r.ctx.Done():
+			case <-time.After(runnerRetryInterval):
+			}
+			continue
+		}
+		if resp.Job == nil {
+			continue
+		}
+
+		if err := r.runJob(resp.Job); err != nil {
+			fmt.Fprintf(r.Out, "Pipeline %d: %v\n", resp.Job.PipelineId, err)
+		}
+	}
+	return nil
+}
+
+// runJob executes a pipeline and streams heartbeats, the jobs with their log
+// output and the outcome back to the server. When the server closes the
+// stream, because the pipeline was cancelled, the running jobs are stopped.
+func (r *Runner) runJob(job *protos.CIJob) error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	stream, err := r.Pogo.ReportCIJob(ctx)
+	if err != nil {
+		return errors.Join(errors.New("open report stream"), err)
+	}
+
+	var sendMu sync.Mutex
+	send := func(req *protos.ReportCIJobRequest) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		req.RunnerToken = r.token
+		req.PipelineId = job.PipelineId
+		err := stream.Send(req)
+		if err != nil {
+			cancel()
+		}
+		return err
+	}
+
+	heartbeat := &protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Heartbeat{Heartbeat: &protos.CIJobHeartbeat{}}}
+	if err := send(heartbeat); err != nil {
+		return errors.Join(errors.New("send heartbeat"), err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(runnerHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Heartbeat{Heartbeat: &protos.CIJobHeartbeat{}}})
+			}
+		}
+	}()
+
+	fmt.Fprintf(r.Out, "Pipeline %d: running %s on %s\n", job.PipelineId, job.ConfigFilename, job.Event.Rev)
+	observer := &runnerObserver{
+		send:       send,
+		pogo:       r.Pogo,
+		token:      r.token,
+		pipelineID: job.PipelineId,
+		logs:       make(map[string]*runnerLog),
+	}
+	_, execErr := r.executeJob(ctx, job, observer)
+	close(done)
+
+	if ctx.Err() != nil && r.ctx.Err() == nil {
+		// The server closed the stream, its error tells why
+		_, err := stream.CloseAndRecv()
+		fmt.Fprintf(r.Out, "Pipeline %d: stopped: %v\n", job.PipelineId, err)
+		return nil
+	}
+
+	finish := &protos.CIJobFinish{}
+	if execErr != nil {
+		msg := execErr.Error()
+		finish.Error = &msg
+		fmt.Fprintf(r.Out, "Pipeline %d: failed: %v\n", job.PipelineId, execErr)
+	} else {
+		fmt.Fprintf(r.Out, "Pipeline %d: succeeded\n", job.PipelineId)
+	}
+	if err := send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Finish{Finish: finish}}); err != nil {
+		return errors.Join(errors.New("send finish"), err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return errors.Join(errors.New("close report stream"), err)
+	}
+	return nil
+}
+
+// executeJob runs the pipeline on the downloaded archive of its change,
+// reporting the jobs to the observer.
+func (r *Runner) executeJob(ctx context.Context, job *protos.CIJob, observer *runnerObserver) ([]ci.TaskExecutionResult, error) {
+	eventType, err := ci.ParseEventType(job.Event.Type)
+	if err != nil {
+		return nil, err
+	}
+	event := ci.Event{
+		Rev:                job.Event.Rev,
+		ArchiveUrl:         job.Event.ArchiveUrl,
+		Author:             job.Event.Author,
+		Description:        job.Event.Description,
+		AccessToken:        job.Event.AccessToken,
+		ServerUrl:          job.Event.ServerUrl,
+		RepositoryID:       job.Event.RepositoryId,
+		Type:               eventType,
+		Schedule:           job.Event.Schedule,
+		Inputs:             job.Event.Inputs,
+		ChangeName:         job.Event.ChangeName,
+		ChangeID:           job.Event.ChangeId,
+		Parents:            job.Event.Parents,
+		Bookmark:           job.Event.Bookmark,
+		PreviousChangeName: job.Event.PreviousChangeName,
+		TriggeredBy:        job.Event.TriggeredBy,
+	}
+	if job.Event.ChangedFilesKnown {
+		event.ChangedFiles = job.Event.ChangedFiles
+		if event.ChangedFiles == nil {
+			event.ChangedFiles = []string{}
+		}
+		if len(job.Event.ChangedFileOperations) == len(event.ChangedFiles) {
+			event.ChangedPaths = make([]ci.ChangedPath, len(event.ChangedFiles))
+			for i, name := range event.ChangedFiles {
+				event.ChangedPaths[i] = ci.ChangedPath{Path: name, Operation: ci.FileOperation(job.Event.ChangedFileOperations[i])}
+			}
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "pogo-runner-")
+	if err != nil {
+		return nil, errors.Join(errors.New("create temp directory"), err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := r.downloadArchive(ctx, job.ArchiveUrl, job.Event.AccessToken, tempDir); err != nil {
+		return nil, err
+	}
+
+	executor := ci.NewExecutor()
+	executor.SetRepoContentDir(tempDir)
+	executor.SetSecrets(job.Secrets)
+	executor.SetObserver(observer)
+	executor.SetApprover(observer)
+
+	return executor.ExecuteForEvent(ctx, map[string][]byte{job.ConfigFilename: job.Config}, event)
+}
+
+// runnerObserver reports the jobs of a pipeline to the server while they run.
+type runnerObserver struct {
+	send       func(*protos.ReportCIJobRequest) error
+	pogo       protos.PogoClient
+	token      []byte
+	pipelineID int64
+	mu         sync.Mutex
+	logs       map[string]*runnerLog
+}
+
+// WaitForApproval reports the job as waiting and asks the server whether it
+// was approved until it is.
+func (o *runnerObserver) WaitForApproval(ctx context.Context, res ci.TaskExecutionResult) error {
+	if err := o.send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Waiting{Waiting: ciResultToProto(res)}}); err != nil {
+		return errors.Join(errors.New("report waiting job"), err)
+	}
+	for {
+		resp, err := o.pogo.WaitCIApproval(ctx, &protos.WaitCIApprovalRequest{
+			RunnerToken:    o.token,
+			PipelineId:     o.pipelineID,
+			ConfigFilename: res.ConfigFilename,
+			JobName:        res.JobName,
+		})
+		if err != nil {
+			return errors.Join(errors.New("wait for approval"), err)
+		}
+		if resp.Approved {
+			return nil
+		}
+	}
+}
+
+func (o *runnerObserver) JobStarted(res ci.TaskExecutionResult) io.Writer {
+	_ = o.send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Started{Started: ciResultToProto(res)}})
+
+	l := newRunnerLog(o.send, res.ConfigFilename, res.JobName)
+	o.mu.Lock()
+	o.logs[res.ConfigFilename+"\x00"+res.JobName] = l
+	o.mu.Unlock()
+	return l
+}
+
+func (o *runnerObserver) JobFinished(res ci.TaskExecutionResult) {
+	key := res.ConfigFilename + "\x00" + res.JobName
+	o.mu.Lock()
+	l := o.logs[key]
+	delete(o.logs, key)
+	o.mu.Unlock()
+	if l != nil {
+		l.close()
+	}
+
+	_ = o.send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Result{Result: ciResultToProto(res)}})
+}
+
+// runnerLog sends the log output of a running job to the server in batches.
+type runnerLog struct {
+	send           func(*protos.ReportCIJobRequest) error
+	configFilename string
+	jobName        string
+	mu             sync.Mutex
+	buf            []byte
+	done           chan struct{}
+	wg             sync.WaitGroup
+}
+
+func newRunnerLog(send func(*protos.ReportCIJobRequest) error, configFilename, jobName string) *runnerLog {
+	l := &runnerLog{send: send, configFilename: configFilename, jobName: jobName, done: make(chan struct{})}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(runnerLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				l.flush()
+			}
+		}
+	}()
+	return l
+}
+
+func (l *runnerLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	if len(l.buf) > runnerMaxLogSize {
+		l.buf = l.buf[len(l.buf)-runnerMaxLogSize:]
+	}
+	return len(p), nil
+}
+
+func (l *runnerLog) flush() {
+	l.mu.Lock()
+	data := l.buf
+	l.buf = nil
+	l.mu.Unlock()
+	if len(data) == 0 {
+		return
+	}
+
+	_ = l.send(&protos.ReportCIJobRequest{Report: &protos.ReportCIJobRequest_Log{Log: &protos.CIJobLog{
+		ConfigFilename: l.configFilename,
+		JobName:        l.jobName,
+		Data:           data,
+	}}})
+}
+
+// close sends the remaining output and stops the periodic flush.
+func (l *runnerLog) close() {
+	close(l.done)
+	l.wg.Wait()
+	l.flush()
+}
+
+// downloadArchive extracts the zip archive of the revision into dir.
+func (r *Runner) downloadArchive(ctx context.Context, url, accessToken, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Join(errors.New("create archive request"), err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Join(errors.New("download archive"), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download archive: %s", resp.Status)
+	}
+
+	archiveFile, err := os.CreateTemp("", "pogo-runner-*.zip")
+	if err != nil {
+		return errors.Join(errors.New("create archive file"), err)
+	}
+	defer os.Remove(archiveFile.Name())
+	defer archiveFile.Close()
+
+	size, err := io.Copy(archiveFile, resp.Body)
+	if err != nil {
+		return errors.Join(errors.New("download archive"), err)
+	}
+
+	zipReader, err := zip.NewReader(archiveFile, size)
+	if err != nil {
+		return errors.Join(errors.New("open archive"), err)
+	}
+
+	for _, f := range zipReader.File {
+		destPath := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(destPath, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %s escapes the target directory", f.Name)
+		}
+		if err := extractZipFile(f, destPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return errors.Join(fmt.Errorf("create directory for %s", f.Name), err)
+	}
+
+	src, err := f.Open()
+	if err != nil {
+		return errors.Join(fmt.Errorf("open archive entry %s", f.Name), err)
+	}
+	defer src.Close()
+
+	perm := os.FileMode(0644)
+	if f.Mode()&0111 != 0 {
+		perm = 0755
+	}
+	dst, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.Join(fmt.Errorf("create file %s", f.Name), err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return errors.Join(fmt.Errorf("extract %s", f.Name), err)
+	}
+	return nil
+}
+
+func ciResultToProto(res ci.TaskExecutionResult) *protos.CIJobResult {
+	log := res.Log
+	if len(log) > runnerMaxLogSize {
+		log = "[log truncated]\n" + log[len(log)-runnerMaxLogSize:]
+	}
+
+	result := &protos.CIJobResult{
+		ConfigFilename: res.ConfigFilename,
+		EventType:      res.EventType.String(),
+		Rev:            res.Rev,
+		Pattern:        res.Pattern,
+		Reason:         res.Reason,
+		TaskType:       res.TaskType,
+		JobName:        res.JobName,
+		Needs:          res.Needs,
+		Status:         string(res.Status),
+		StatusCode:     int32(res.StatusCode),
+		Success:        res.Success,
+		Log:            log,
+		Matrix:         res.Matrix,
+	}
+	for _, t := range res.Tests {
+		result.Tests = append(result.Tests, &protos.CITestResult{
+			Suite:      t.Suite,
+			Name:       t.Name,
+			Status:     string(t.Status),
+			DurationMs: t.Duration.Milliseconds(),
+			Message:    t.Message,
+		})
+	}
+	if !res.StartedAt.IsZero() {
+		result.StartedAt = res.StartedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if !res.FinishedAt.IsZero() {
+		result.FinishedAt = res.FinishedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return result
+}
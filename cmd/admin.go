@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pogo-vcs/pogo/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminCmd = &cobra.Command{
+		Use:   "admin",
+		Short: "Administer the server",
+		Long: `Administer the Pogo server.
+
+These commands affect the whole server, not only the current repository, and
+require an admin user. The root user created on the first start is an admin.
+
+The command must be run from within a Pogo repository on the server.`,
+	}
+	adminSecretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage org secrets and the encryption of secrets",
+		Long: `Manage org secrets and the encryption of secrets.
+
+Org secrets are inherited by all repositories of the server. Secrets of a
+repository with the same key and environment take precedence. Org secrets of
+an environment are only used by repositories that define the environment.`,
+	}
+	adminSecretsListCmd = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"l"},
+		Short:   "List all org secrets",
+		Example: `  pogo admin secrets list`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("too many arguments")
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			secrets, err := c.GetAllOrgSecrets()
+			if err != nil {
+				return errors.Join(errors.New("get org secrets"), err)
+			}
+
+			if len(secrets) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "No org secrets found")
+				return nil
+			}
+
+			for _, secret := range secrets {
+				line := secret.Key
+				if secret.Environment != "" {
+					line += "\t" + secret.Environment
+				}
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), line)
+			}
+
+			return nil
+		},
+	}
+	adminSecretsSetCmd = &cobra.Command{
+		Use:     "set <key> <value>",
+		Aliases: []string{"s"},
+		Short:   "Set an org secret",
+		Example: `  # Set a secret inherited by all repositories
+  pogo admin secrets set NPM_TOKEN abc123xyz
+
+  # Set a secret inherited by repositories with a production environment
+  pogo admin secrets set DEPLOY_TOKEN abc123xyz --environment production`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return errors.New("secret key and value are required")
+			}
+			if len(args) > 2 {
+				return errors.New("too many arguments")
+			}
+
+			key := args[0]
+			value := args[1]
+			environment, _ := cmd.Flags().GetString("environment")
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.SetOrgSecret(environment, key, value); err != nil {
+				return errors.Join(errors.New("set org secret"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Org secret '%s' set successfully\n", key)
+
+			return nil
+		},
+	}
+	adminSecretsDeleteCmd = &cobra.Command{
+		Use:     "delete <key>",
+		Aliases: []string{"d", "rm", "remove"},
+		Short:   "Delete an org secret",
+		Example: `  pogo admin secrets delete NPM_TOKEN`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("secret key is required")
+			}
+			if len(args) > 1 {
+				return errors.New("too many arguments")
+			}
+
+			key := args[0]
+			environment, _ := cmd.Flags().GetString("environment")
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.DeleteOrgSecret(environment, key); err != nil {
+				return errors.Join(errors.New("delete org secret"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Org secret '%s' deleted successfully\n", key)
+
+			return nil
+		},
+	}
+	adminSecretsRotateKeyCmd = &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Re-encrypt all secrets under a new key",
+		Long: `Re-encrypt the secrets of all repositories under a new key.
+
+The server generates a new key, re-encrypts every secret with it and replaces
+its key file (SECRETS_KEY_FILE). Back up the new key file afterwards; secrets
+can't be decrypted without it.
+
+This fails if the key is set directly by SECRETS_KEY.`,
+		Example: `  pogo admin secrets rotate-key`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("too many arguments")
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			resp, err := c.RotateSecretsKey()
+			if err != nil {
+				return errors.Join(errors.New("rotate secrets key"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Re-encrypted %d secrets with key %s\n", resp.ReencryptedSecrets, resp.KeyId)
+
+			return nil
+		},
+	}
+)
+
+func init() {
+	adminSecretsSetCmd.Flags().StringP("environment", "e", "", "Environment of the secret, inherited by all configs if empty")
+	adminSecretsDeleteCmd.Flags().StringP("environment", "e", "", "Environment of the secret")
+
+	adminSecretsCmd.AddCommand(adminSecretsListCmd)
+	adminSecretsCmd.AddCommand(adminSecretsSetCmd)
+	adminSecretsCmd.AddCommand(adminSecretsDeleteCmd)
+	adminSecretsCmd.AddCommand(adminSecretsRotateKeyCmd)
+	adminCmd.AddCommand(adminSecretsCmd)
+
+	RootCmd.AddCommand(adminCmd)
+}
@@ -2,12 +2,18 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/pogo-vcs/pogo/auth"
 	"github.com/pogo-vcs/pogo/client"
+	"github.com/pogo-vcs/pogo/protos"
 	"github.com/pogo-vcs/pogo/server/ci"
 	"github.com/spf13/cobra"
 )
@@ -29,7 +35,8 @@ It will execute the pipeline using the same logic as the server would, allowing
 that your configuration works as expected.
 
 The config file should be a YAML file in the .pogo/ci/ directory.
-If no config file is specified, all CI config files in .pogo/ci/ will be tested.`,
+If no config file is specified, all CI config files in .pogo/ci/ will be tested.
+Tasks with requires_approval fail unless --approve is set.`,
 		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cwd, err := os.Getwd()
@@ -65,27 +72,9 @@ If no config file is specified, all CI config files in .pogo/ci/ will be tested.
 				}
 				configFiles[filepath.Base(configPath)] = content
 			} else {
-				ciDir := filepath.Join(repoRoot, ".pogo", "ci")
-				entries, err := os.ReadDir(ciDir)
+				configFiles, err = readCIConfigDir(repoRoot)
 				if err != nil {
-					return fmt.Errorf("read CI directory: %w", err)
-				}
-
-				for _, entry := range entries {
-					if entry.IsDir() {
-						continue
-					}
-
-					ext := filepath.Ext(entry.Name())
-					if ext != ".yaml" && ext != ".yml" {
-						continue
-					}
-
-					content, err := os.ReadFile(filepath.Join(ciDir, entry.Name()))
-					if err != nil {
-						return fmt.Errorf("read config file %s: %w", entry.Name(), err)
-					}
-					configFiles[entry.Name()] = content
+					return err
 				}
 			}
 
@@ -99,33 +88,43 @@ If no config file is specified, all CI config files in .pogo/ci/ will be tested.
 				archiveUrl = fmt.Sprintf("%s/repository/%d/archive/%s", serverUrl, repoId, ciTestEventRev)
 			}
 
+			eventType, err := ci.ParseEventType(ciTestEventType)
+			if err != nil {
+				return err
+			}
+
+			inputs, err := parseCIInputs(ciInputs)
+			if err != nil {
+				return err
+			}
+
 			event := ci.Event{
 				Rev:          ciTestEventRev,
 				ArchiveUrl:   archiveUrl,
 				AccessToken:  accessToken,
 				ServerUrl:    serverUrl,
 				RepositoryID: repoId,
+				Type:         eventType,
+				Schedule:     ciTestEventSchedule,
+				Inputs:       inputs,
+			}
+			switch eventType {
+			case ci.EventTypePush, ci.EventTypeRemove, ci.EventTypeSchedule:
+				event.Bookmark = ciTestEventRev
 			}
 
 			executor := ci.NewExecutor()
 			executor.SetRepoContentDir(repoRoot)
-
-			eventType := ci.EventTypePush
-			if ciTestEventType == "remove" {
-				eventType = ci.EventTypeRemove
-			}
+			executor.SetEnvironmentSecrets(true)
+			executor.SetApprover(ciTestApprover{out: cmd.OutOrStdout(), approve: ciTestApprove})
 
 			fmt.Fprintf(cmd.OutOrStdout(), "Testing CI pipeline with synthetic %s event (rev: %s)\n", ciTestEventType, ciTestEventRev)
 			fmt.Fprintf(cmd.OutOrStdout(), "Found %d configuration file(s)\n\n", len(configFiles))
 
-			results, err := executor.ExecuteForBookmarkEvent(context.Background(), configFiles, event, eventType)
+			results, err := executor.ExecuteForEvent(context.Background(), configFiles, event)
 
 			for _, res := range results {
-				status := "failed"
-				if res.Success {
-					status = "passed"
-				}
-				fmt.Fprintf(cmd.OutOrStdout(), "- %s task (%s) status=%s code=%d\n", res.TaskType, res.ConfigFilename, status, res.StatusCode)
+				fmt.Fprintf(cmd.OutOrStdout(), "- %s %s task (%s) status=%s code=%d\n", res.JobName, res.TaskType, res.ConfigFilename, res.Status, res.StatusCode)
 			}
 
 			if err != nil {
@@ -137,16 +136,266 @@ If no config file is specified, all CI config files in .pogo/ci/ will be tested.
 		},
 	}
 
+	ciRunCmd = &cobra.Command{
+		Use:   "run <config>",
+		Short: "Dispatch a CI pipeline manually",
+		Long: `Dispatch a CI pipeline on the server.
+
+The config must declare the manual trigger:
+
+  on:
+    manual:
+      inputs:
+        target: staging
+
+The config can be given as a path (.pogo/ci/deploy.yaml), a file name (deploy.yaml)
+or a name without extension (deploy). It is read from the revision the pipeline runs on.
+Inputs that are not passed keep the default declared in the config.
+The pipeline runs in the background, use "pogo ci runs list" to see the results.`,
+		Example: `# Run the deploy pipeline on the checked out change
+pogo ci run deploy
+
+# Run it on the main bookmark with an input
+pogo ci run deploy -r main --input target=production`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inputs, err := parseCIInputs(ciInputs)
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			var rev *string
+			if ciRunRev != "" {
+				rev = &ciRunRev
+			}
+
+			resp, err := c.RunCI(args[0], rev, inputs)
+			if err != nil {
+				return errors.Join(errors.New("run CI"), err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Dispatched %s on %s\n", resp.ConfigFilename, resp.Rev)
+			return nil
+		},
+	}
+
+	ciLintCmd = &cobra.Command{
+		Use:   "lint [config-file...]",
+		Short: "Validate CI pipeline configurations",
+		Long: `Validate CI pipeline configurations without running them.
+
+The configs are checked against the CI schema (served at /schemas/ci/schema.json),
+including the template syntax, the secrets they reference, glob patterns, cron
+expressions, if expressions and the needs of their tasks.
+Templates are rendered with a push event of main, like a synthetic "pogo ci test" event.
+
+Secrets are looked up in the shared secrets of the repository, the secrets of the
+environment the config declares and the environment variables.
+If the server can not be reached, secrets are not checked.
+
+If no config file is specified, all CI config files in .pogo/ci/ are validated.
+The server runs the same validation when CI configs are pushed and prints the problems.
+Exits with an error if any config has errors, like a template that can not be
+parsed, an unknown need or a missing secret. Warnings are printed only.`,
+		Example: `# Validate all configs in .pogo/ci/
+pogo ci lint
+
+# Validate a single config
+pogo ci lint .pogo/ci/release.yaml`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("get working directory: %w", err)
+			}
+
+			configFiles := make(map[string][]byte)
+			if len(args) > 0 {
+				for _, configPath := range args {
+					content, err := os.ReadFile(configPath)
+					if err != nil {
+						return fmt.Errorf("read config file: %w", err)
+					}
+					configFiles[filepath.ToSlash(configPath)] = content
+				}
+			} else {
+				repoFile, err := client.FindRepoFile(cwd)
+				if err != nil {
+					return fmt.Errorf("find repository: %w", err)
+				}
+				dirFiles, err := readCIConfigDir(filepath.Dir(repoFile))
+				if err != nil {
+					return err
+				}
+				for name, content := range dirFiles {
+					configFiles[".pogo/ci/"+name] = content
+				}
+			}
+			if len(configFiles) == 0 {
+				return fmt.Errorf("no CI configuration files found")
+			}
+
+			var hasSecret func(key, environment string) bool
+			if scope, err := fetchCISecretKeys(cmd, cwd); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: secrets are not checked: %v\n", err)
+			} else {
+				hasSecret = func(key, environment string) bool {
+					return scope.Has(key, environment) || os.Getenv(key) != ""
+				}
+			}
+
+			names := slices.Sorted(maps.Keys(configFiles))
+			errorCount, warningCount := 0, 0
+			for _, name := range names {
+				for _, problem := range ci.Lint(configFiles[name], hasSecret) {
+					fmt.Fprintln(cmd.OutOrStdout(), client.FormatCILintProblem(&protos.CILintProblem{
+						Filename: name,
+						Severity: string(problem.Severity),
+						Line:     int32(problem.Line),
+						Message:  problem.Message,
+					}))
+					if problem.Severity == ci.LintError {
+						errorCount++
+					} else {
+						warningCount++
+					}
+				}
+			}
+
+			if errorCount > 0 {
+				return fmt.Errorf("%d error(s) and %d warning(s) in %d configuration file(s)", errorCount, warningCount, len(names))
+			}
+			if warningCount > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "\n%d warning(s) in %d configuration file(s)\n", warningCount, len(names))
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "✓ %d configuration file(s) are valid\n", len(names))
+			return nil
+		},
+	}
+
 	ciTestEventType       string
 	ciTestEventRev        string
 	ciTestEventArchiveURL string
+	ciTestEventSchedule   string
+	ciTestApprove         bool
+	ciRunRev              string
+	ciInputs              []string
 )
 
+// ciTestApprover approves the jobs of pogo ci test right away if --approve
+// is set and rejects them otherwise.
+type ciTestApprover struct {
+	out     io.Writer
+	approve bool
+}
+
+func (a ciTestApprover) WaitForApproval(ctx context.Context, res ci.TaskExecutionResult) error {
+	if !a.approve {
+		return errors.New("task requires approval, run with --approve to approve it")
+	}
+	fmt.Fprintf(a.out, "Approving %s\n", res.JobName)
+	return nil
+}
+
+// readCIConfigDir reads the YAML files in .pogo/ci/ of the repository, keyed
+// by their file name.
+func readCIConfigDir(repoRoot string) (map[string][]byte, error) {
+	ciDir := filepath.Join(repoRoot, ".pogo", "ci")
+	entries, err := os.ReadDir(ciDir)
+	if err != nil {
+		return nil, fmt.Errorf("read CI directory: %w", err)
+	}
+
+	configFiles := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(ciDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read config file %s: %w", entry.Name(), err)
+		}
+		configFiles[entry.Name()] = content
+	}
+	return configFiles, nil
+}
+
+// fetchCISecretKeys returns the keys of the secrets of the repository, the
+// values are left empty.
+func fetchCISecretKeys(cmd *cobra.Command, wd string) (ci.Secrets, error) {
+	scope := ci.Secrets{
+		Shared:       make(map[string]string),
+		Environments: make(map[string]ci.Environment),
+	}
+	c, err := client.OpenFromFile(cmd.Context(), wd)
+	if err != nil {
+		return scope, fmt.Errorf("open client: %w", err)
+	}
+	defer c.Close()
+	configureClientOutputs(cmd, c)
+
+	secrets, err := c.GetAllSecrets()
+	if err != nil {
+		return scope, fmt.Errorf("get secrets: %w", err)
+	}
+	for _, secret := range secrets {
+		if secret.Environment == "" {
+			scope.Shared[secret.Key] = ""
+			continue
+		}
+		env, ok := scope.Environments[secret.Environment]
+		if !ok {
+			env.Secrets = make(map[string]string)
+		}
+		env.Secrets[secret.Key] = ""
+		scope.Environments[secret.Environment] = env
+	}
+	return scope, nil
+}
+
+func parseCIInputs(values []string) (map[string]string, error) {
+	inputs := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid input %q, expected key=value", value)
+		}
+		inputs[key] = val
+	}
+	return inputs, nil
+}
+
 func init() {
 	RootCmd.AddCommand(ciCmd)
 	ciCmd.AddCommand(ciTestCmd)
+	ciCmd.AddCommand(ciRunCmd)
+	ciCmd.AddCommand(ciLintCmd)
 
-	ciTestCmd.Flags().StringVarP(&ciTestEventType, "event-type", "t", "push", "Event type to simulate (push or remove)")
+	ciTestCmd.Flags().StringVarP(&ciTestEventType, "event-type", "t", "push", "Event type to simulate (push, remove, change_push, new_change, merge, schedule or manual)")
 	ciTestCmd.Flags().StringVarP(&ciTestEventRev, "rev", "r", "main", "Revision name for the event")
 	ciTestCmd.Flags().StringVarP(&ciTestEventArchiveURL, "archive-url", "a", "", "Archive URL for the event (defaults to server URL)")
+	ciTestCmd.Flags().StringVar(&ciTestEventSchedule, "schedule", "", "Cron expression that fired, for schedule events")
+	ciTestCmd.Flags().StringArrayVar(&ciInputs, "input", nil, "Input for manual events as key=value (repeatable)")
+	ciTestCmd.Flags().BoolVar(&ciTestApprove, "approve", false, "Approve tasks with requires_approval instead of failing them")
+
+	ciRunCmd.Flags().StringVarP(&ciRunRev, "rev", "r", "", "Revision to run on (defaults to the checked out change)")
+	ciRunCmd.Flags().StringArrayVar(&ciInputs, "input", nil, "Input as key=value (repeatable)")
 }
@@ -3,12 +3,17 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"math"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/pogo-vcs/pogo/client"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
 	"github.com/spf13/cobra"
 )
 
@@ -22,6 +27,9 @@ var (
 	ciRunsListCmd = &cobra.Command{
 		Use:   "list",
 		Short: "List CI runs for the repository",
+		Long: `List the CI runs recorded for the repository.
+
+Pipelines that are still queued or running are listed above the runs.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 0 {
 				return errors.New("this command does not accept arguments")
@@ -39,10 +47,25 @@ var (
 			defer c.Close()
 			configureClientOutputs(cmd, c)
 
-			runs, err := c.ListCIRuns()
+			resp, err := c.ListCIRuns()
 			if err != nil {
 				return errors.Join(errors.New("list CI runs"), err)
 			}
+			runs := resp.Runs
+
+			if len(resp.Queued) > 0 {
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "Pipeline\tState\tAttempts\tEvent\tRevision\tQueued\tStarted\tConfig")
+				for _, p := range resp.Queued {
+					started := p.StartedAt
+					if started == "" {
+						started = "-"
+					}
+					fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", p.Id, p.State, p.Attempts, p.EventType, p.Rev, p.QueuedAt, started, p.ConfigFilename)
+				}
+				_ = w.Flush()
+				fmt.Fprintln(cmd.OutOrStdout())
+			}
 
 			if len(runs) == 0 {
 				fmt.Fprintln(cmd.OutOrStdout(), "No CI runs found for this repository.")
@@ -50,11 +73,12 @@ var (
 			}
 
 			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
-			fmt.Fprintln(w, "ID\tEvent\tTask\tStatus\tCode\tStarted\tFinished\tConfig\tPattern\tReason")
+			fmt.Fprintln(w, "ID\tEvent\tJob\tTask\tStatus\tCode\tStarted\tFinished\tConfig\tPattern\tReason")
 			for _, run := range runs {
-				status := "failure"
-				if run.Success {
-					status = "success"
+				status := ciRunStatus(run)
+				job := "-"
+				if run.JobName != nil {
+					job = *run.JobName
 				}
 				pattern := "-"
 				if run.Pattern != nil {
@@ -62,9 +86,10 @@ var (
 				}
 				fmt.Fprintf(
 					w,
-					"%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+					"%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
 					run.Id,
 					run.EventType,
+					job,
 					run.TaskType,
 					status,
 					run.StatusCode,
@@ -84,14 +109,15 @@ var (
 	ciRunsInspectCmd = &cobra.Command{
 		Use:   "inspect <run-id>",
 		Short: "Inspect the log output of a CI run",
-		Args:  cobra.ExactArgs(1),
+		Long: `Inspect the details and log output of a CI run.
+
+With --follow the log of a running job is printed as it is written until
+the job finishes.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			runID, err := strconv.ParseInt(args[0], 10, 64)
+			runID, err := parseCIRunID(args[0])
 			if err != nil {
-				return fmt.Errorf("invalid run id %q: %w", args[0], err)
-			}
-			if runID < 0 || runID > math.MaxInt32 {
-				return fmt.Errorf("run id %d is out of range", runID)
+				return err
 			}
 
 			wd, err := os.Getwd()
@@ -112,10 +138,7 @@ var (
 			}
 
 			run := resp.Run
-			status := "failure"
-			if run.Success {
-				status = "success"
-			}
+			status := ciRunStatus(run)
 			pattern := "-"
 			if run.Pattern != nil {
 				pattern = *run.Pattern
@@ -127,22 +150,315 @@ var (
 			fmt.Fprintf(cmd.OutOrStdout(), "Revision: %s\n", run.Rev)
 			fmt.Fprintf(cmd.OutOrStdout(), "Pattern: %s\n", pattern)
 			fmt.Fprintf(cmd.OutOrStdout(), "Reason: %s\n", run.Reason)
+			if run.JobName != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Job: %s\n", *run.JobName)
+			}
+			if len(run.Needs) > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Needs: %s\n", strings.Join(run.Needs, ", "))
+			}
+			if len(run.Matrix) > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Matrix: %s\n", formatCIMatrix(run.Matrix))
+			}
 			fmt.Fprintf(cmd.OutOrStdout(), "Task: %s\n", run.TaskType)
 			fmt.Fprintf(cmd.OutOrStdout(), "Status: %s\n", status)
+			if run.ApprovedAt != "" {
+				approvedBy := "-"
+				if run.ApprovedBy != nil {
+					approvedBy = *run.ApprovedBy
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Approved: %s by %s\n", run.ApprovedAt, approvedBy)
+			} else if status == string(ci.JobStatusWaiting) {
+				fmt.Fprintf(cmd.OutOrStdout(), "Approval: start the job with \"pogo ci runs approve %d\"\n", run.Id)
+			}
 			fmt.Fprintf(cmd.OutOrStdout(), "Code: %d\n", run.StatusCode)
 			fmt.Fprintf(cmd.OutOrStdout(), "Started: %s\n", run.StartedAt)
 			fmt.Fprintf(cmd.OutOrStdout(), "Finished: %s\n", run.FinishedAt)
+			if len(resp.Pipeline) > 1 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				fmt.Fprintln(cmd.OutOrStdout(), "--- Pipeline ---")
+				printCIPipeline(cmd, resp.Pipeline, run.Id)
+			}
+			if len(resp.Artifacts) > 0 {
+				fmt.Fprintln(cmd.OutOrStdout())
+				fmt.Fprintln(cmd.OutOrStdout(), "--- Artifacts ---")
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				for _, artifact := range resp.Artifacts {
+					fmt.Fprintf(w, "%s\t%d\n", artifact.Name, artifact.Size)
+				}
+				_ = w.Flush()
+			}
+			if counts := resp.TestCounts; counts != nil {
+				fmt.Fprintln(cmd.OutOrStdout())
+				fmt.Fprintln(cmd.OutOrStdout(), "--- Tests ---")
+				fmt.Fprintf(cmd.OutOrStdout(), "%d passed, %d failed, %d skipped\n", counts.Passed, counts.Failed, counts.Skipped)
+				for _, test := range resp.FailedTests {
+					history, flaky := formatCITestHistory(test.History)
+					fmt.Fprintf(cmd.OutOrStdout(), "\nFAIL %s %s (%dms) history: %s", test.Suite, test.Name, test.DurationMs, history)
+					if flaky {
+						fmt.Fprint(cmd.OutOrStdout(), " flaky")
+					}
+					fmt.Fprintln(cmd.OutOrStdout())
+					if test.Message != "" {
+						for _, line := range strings.Split(test.Message, "\n") {
+							fmt.Fprintf(cmd.OutOrStdout(), "    %s\n", line)
+						}
+					}
+				}
+			}
 			fmt.Fprintln(cmd.OutOrStdout())
 			fmt.Fprintln(cmd.OutOrStdout(), "--- Log ---")
-			fmt.Fprintln(cmd.OutOrStdout(), resp.Log)
+			if !ciRunsFollow {
+				fmt.Fprintln(cmd.OutOrStdout(), resp.Log)
+				return nil
+			}
+
+			status, err = c.FollowCIRun(runID, cmd.OutOrStdout())
+			if err != nil {
+				return errors.Join(errors.New("follow CI run"), err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout())
+			fmt.Fprintf(cmd.OutOrStdout(), "--- Finished: %s ---\n", status)
+
+			return nil
+		},
+	}
+
+	ciRunsCancelCmd = &cobra.Command{
+		Use:   "cancel <run-id>",
+		Short: "Cancel the pipeline of a CI run",
+		Long: `Cancel the pipeline a CI run belongs to.
+
+Running containers are stopped and jobs that have not started yet are not run.
+The cancelled jobs are marked as cancelled.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := parseCIRunID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.CancelCIRun(runID); err != nil {
+				return errors.Join(errors.New("cancel CI run"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Run %d cancelled\n", runID)
+			return nil
+		},
+	}
+
+	ciRunsRerunCmd = &cobra.Command{
+		Use:   "rerun <run-id>",
+		Short: "Run the pipeline of a CI run again",
+		Long: `Queue the pipeline a CI run belongs to again.
+
+The new pipeline runs the same config at the same revision, with the same
+event and inputs, e.g. to retry a run that failed because of a flaky service.
+Its runs are listed by "pogo ci runs" once they start.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := parseCIRunID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			pipelineID, err := c.RerunCIRun(runID)
+			if err != nil {
+				return errors.Join(errors.New("rerun CI run"), err)
+			}
 
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Queued pipeline %d\n", pipelineID)
 			return nil
 		},
 	}
+
+	ciRunsApproveCmd = &cobra.Command{
+		Use:   "approve <run-id>",
+		Short: "Approve a CI run that waits for approval",
+		Long: `Approve a CI run of a task with requires_approval, so it starts.
+
+Only users with access to the repository can approve runs. To reject a run,
+cancel it with "pogo ci runs cancel".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := parseCIRunID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.ApproveCIRun(runID); err != nil {
+				return errors.Join(errors.New("approve CI run"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Run %d approved\n", runID)
+			return nil
+		},
+	}
+
+	ciRunsDownloadCmd = &cobra.Command{
+		Use:   "download <run-id> <artifact>",
+		Short: "Download an artifact of a CI run",
+		Long: `Download an artifact of a CI run and write it to stdout.
+
+The artifacts of a run are listed by "pogo ci runs inspect".`,
+		Example: `  # Save an artifact to a file
+  pogo ci runs download 42 dist/app.tar.gz > app.tar.gz`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := parseCIRunID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.DownloadCIArtifact(runID, args[1], cmd.OutOrStdout()); err != nil {
+				return errors.Join(errors.New("download CI artifact"), err)
+			}
+
+			return nil
+		},
+	}
+
+	ciRunsFollow bool
 )
 
+func parseCIRunID(arg string) (int64, error) {
+	runID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid run id %q: %w", arg, err)
+	}
+	if runID < 0 || runID > math.MaxInt32 {
+		return 0, fmt.Errorf("run id %d is out of range", runID)
+	}
+	return runID, nil
+}
+
+// formatCIMatrix formats matrix values as key=value pairs sorted by key.
+func formatCIMatrix(matrix map[string]string) string {
+	pairs := make([]string, 0, len(matrix))
+	for _, k := range slices.Sorted(maps.Keys(matrix)) {
+		pairs = append(pairs, k+"="+matrix[k])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// formatCITestHistory renders the statuses of a test, newest first, and
+// reports whether the test both passed and failed in them.
+func formatCITestHistory(history []string) (string, bool) {
+	var sb strings.Builder
+	passed, failed := false, false
+	for _, status := range history {
+		switch ci.TestStatus(status) {
+		case ci.TestStatusPassed:
+			sb.WriteString("✓")
+			passed = true
+		case ci.TestStatusFailed:
+			sb.WriteString("✗")
+			failed = true
+		default:
+			sb.WriteString("○")
+		}
+	}
+	return sb.String(), passed && failed
+}
+
+func ciRunStatus(run *protos.CIRunSummary) string {
+	if run.Status != "" {
+		return run.Status
+	}
+	if run.Success {
+		return "success"
+	}
+	return "failure"
+}
+
+// printCIPipeline prints the jobs of a pipeline grouped into stages, the
+// inspected run is marked with an arrow.
+func printCIPipeline(cmd *cobra.Command, runs []*protos.CIRunSummary, current int64) {
+	jobs := make([]ci.GraphJob, 0, len(runs))
+	for _, run := range runs {
+		name := fmt.Sprintf("#%d", run.Id)
+		if run.JobName != nil {
+			name = *run.JobName
+		}
+		jobs = append(jobs, ci.GraphJob{Name: name, Needs: run.Needs, Status: ci.JobStatus(ciRunStatus(run)), RunID: run.Id})
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	for i, stage := range ci.LayoutJobGraph(jobs) {
+		for j, job := range stage {
+			stageLabel := ""
+			if j == 0 {
+				stageLabel = fmt.Sprintf("stage %d", i+1)
+			}
+			marker := " "
+			if job.RunID == current {
+				marker = ">"
+			}
+			needs := ""
+			if len(job.Needs) > 0 {
+				needs = "needs " + strings.Join(job.Needs, ", ")
+			}
+			fmt.Fprintf(w, "%s\t%s %s\t%s\t#%d\t%s\n", stageLabel, marker, job.Name, job.Status, job.RunID, needs)
+		}
+	}
+	_ = w.Flush()
+}
+
 func init() {
 	ciCmd.AddCommand(ciRunsCmd)
 	ciRunsCmd.AddCommand(ciRunsListCmd)
 	ciRunsCmd.AddCommand(ciRunsInspectCmd)
+	ciRunsCmd.AddCommand(ciRunsCancelCmd)
+	ciRunsCmd.AddCommand(ciRunsRerunCmd)
+	ciRunsCmd.AddCommand(ciRunsApproveCmd)
+	ciRunsCmd.AddCommand(ciRunsDownloadCmd)
+
+	ciRunsInspectCmd.Flags().BoolVarP(&ciRunsFollow, "follow", "f", false, "Print the log as it is written until the run finishes")
 }
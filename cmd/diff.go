@@ -13,6 +13,7 @@ import (
 var (
 	diffColorFlag         bool
 	diffIncludeLargeFiles bool
+	diffRemerge           bool
 	diffCmd               = &cobra.Command{
 		Use:   "diff [rev1] [rev2]",
 		Short: "Show differences between changes",
@@ -31,6 +32,10 @@ You can specify changes using:
 - Change name prefix (e.g., "bitter-rose")
 - Bookmark name (e.g., "main")
 
+With --remerge the given change (or the current change) must be a merge. Its
+parents are merged again automatically and the result is compared to the
+stored merge, showing only what was changed by hand while resolving it.
+
 The output uses Git-style unified diff format, making it easy to see exactly
 what changed between two versions.`,
 		Example: `# Compare current change to its parent
@@ -43,7 +48,10 @@ pogo diff main
 pogo diff bitter-rose sweet-flower
 
 # Compare using change prefixes
-pogo diff bitter sweet`,
+pogo diff bitter sweet
+
+# Show how the conflicts of a merge change were resolved
+pogo diff --remerge bitter-rose`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 2 {
 				return errors.New("too many arguments")
@@ -81,6 +89,30 @@ pogo diff bitter sweet`,
 
 			_ = c.PushFull(false)
 
+			if diffRemerge {
+				if len(args) > 1 {
+					return errors.New("--remerge accepts at most one revision")
+				}
+				var rev *string
+				if len(args) == 1 {
+					rev = &args[0]
+				}
+				if isInteractive {
+					data, err := c.CollectRemergeDiff(rev, true, diffIncludeLargeFiles)
+					if err != nil {
+						return errors.Join(errors.New("collect remerge diff"), err)
+					}
+					if err := difftui.Run(data); err != nil {
+						return errors.Join(errors.New("run diff tui"), err)
+					}
+				} else {
+					if err := c.RemergeDiff(rev, cmd.OutOrStdout(), diffColorFlag, false, diffIncludeLargeFiles); err != nil {
+						return errors.Join(errors.New("remerge diff"), err)
+					}
+				}
+				return nil
+			}
+
 			var rev1, rev2 *string
 			if len(args) >= 1 {
 				rev1 = &args[0]
@@ -111,5 +143,6 @@ pogo diff bitter sweet`,
 func init() {
 	diffCmd.Flags().BoolVar(&diffColorFlag, "color", tty.IsInteractive(), "Enable colored output")
 	diffCmd.Flags().BoolVar(&diffIncludeLargeFiles, "include-large-files", false, "Include files larger than 1MiB in diff")
+	diffCmd.Flags().BoolVar(&diffRemerge, "remerge", false, "Compare a merge change against an automatic re-merge of its parents")
 	RootCmd.AddCommand(diffCmd)
 }
@@ -39,10 +39,11 @@ Available template variables:
 		"| `{{.ChangeDescription}}` | The description of the current change          |\n" +
 		"| `{{.Bookmarks}}`         | Array of bookmarks pointing to this change     |\n" +
 		"| `{{.IsInConflict}}`      | Boolean indicating if the change has conflicts |\n" +
+		"| `{{.CIStatus}}`          | pending, success, failure, cancelled or empty  |\n" +
 		"| `{{.Error}}`             | Any error message (connection issues, etc.)    |\n" +
 		`
 The default format shows a colored prompt-friendly output with conflict
-indicators and bookmark information.
+indicators, the CI status and bookmark information.
 
 Fish shell integration:
 ` + "\n```fish" + `
@@ -70,7 +71,10 @@ pogo info --format '{{.ChangeName}}: {{.ChangeDescription}}'
 export PS1='$(pogo info --format "{{.ChangeName}}") \$ '
 
 # Check for conflicts in a script
-pogo info --format '{{.IsInConflict}}'`,
+pogo info --format '{{.IsInConflict}}'
+
+# Show the CI status of the current change
+pogo info --format '{{.ChangeName}} {{.CIStatus}}'`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		t, err := template.New("info format").Parse(cmd.Flag("format").Value.String())
 		if err != nil {
@@ -109,6 +113,7 @@ pogo info --format '{{.IsInConflict}}'`,
 			ChangeDescription: ptr.Or(infoResponse.ChangeDescription, ""),
 			Bookmarks:         infoResponse.Bookmarks,
 			IsInConflict:      infoResponse.IsInConflict,
+			CIStatus:          infoResponse.CiStatus,
 		}
 
 		if err = t.Execute(cmd.OutOrStdout(), data); err != nil {
@@ -154,12 +159,13 @@ type InfoData struct {
 	ChangeDescription string
 	Bookmarks         []string
 	IsInConflict      bool
+	CIStatus          string
 	Error             string
 }
 
 func init() {
 	defaultFormat := fmt.Sprintf(
-		"({{if .Error}}%s{{.Error}}%s{{else}}{{if .IsInConflict}}💥{{end}}%s{{.ChangeNamePrefix}}%s{{.ChangeNameSuffix}}%s {{- range $i, $b := .Bookmarks}}{{if $i}}, {{else}} {{end}}{{if eq . \"main\"}}%s{{.}}%s{{else}}{{.}}{{end}}{{end}}{{end}})",
+		"({{if .Error}}%s{{.Error}}%s{{else}}{{if .IsInConflict}}💥{{end}}%s{{.ChangeNamePrefix}}%s{{.ChangeNameSuffix}}%s{{if eq .CIStatus \"success\"}} %s✓%s{{else if eq .CIStatus \"failure\"}} %s✗%s{{else if eq .CIStatus \"pending\"}} %s●%s{{end}} {{- range $i, $b := .Bookmarks}}{{if $i}}, {{else}} {{end}}{{if eq . \"main\"}}%s{{.}}%s{{else}}{{.}}{{end}}{{end}}{{end}})",
 		colors.Red,
 		colors.Reset,
 		colors.Magenta,
@@ -167,6 +173,12 @@ func init() {
 		colors.Reset,
 		colors.Green,
 		colors.Reset,
+		colors.Red,
+		colors.Reset,
+		colors.Yellow,
+		colors.Reset,
+		colors.Green,
+		colors.Reset,
 	)
 	infoCmd.Flags().String(
 		"format",
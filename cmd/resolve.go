@@ -0,0 +1,437 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pogo-vcs/pogo/client"
+	"github.com/pogo-vcs/pogo/client/difftui"
+	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/tty"
+	"github.com/spf13/cobra"
+)
+
+var (
+	resolveList   bool
+	resolveTool   string
+	resolveOurs   bool
+	resolveTheirs bool
+	resolveParent string
+	resolveCmd    = &cobra.Command{
+		Use:   "resolve [file...]",
+		Short: "Resolve merge conflicts of the current change",
+		Long: `Resolve the merge conflicts of the current change.
+
+The current state is pushed first, then the conflicted files are fetched from
+the server. Without any flags an interactive three-pane merge tool is opened
+for all text conflicts. It shows our side, the result and their side next to
+each other, and lets you pick either side, both or the base for each
+conflicting hunk, or edit the hunk in $EDITOR.
+
+With --tool an external merge tool is launched for each text conflict. The
+command may reference the placeholders $BASE, $LOCAL, $REMOTE and $MERGED,
+which are replaced with the paths of the base, our and their version and of
+the file in the working copy. The command must reference $MERGED. Arguments
+containing spaces can be quoted like in a shell.
+
+With --ours or --theirs the conflicted files are replaced with the content of
+the respective side. This is the way to resolve binary conflicts. With --parent
+the side of the parent with the given change name or unique prefix is taken,
+which also resolves conflicts between more than two parents.
+
+Resolved files are pushed automatically. Pass file names to only resolve
+those files.`,
+		Example: `# List the conflicted files
+pogo resolve --list
+
+# Resolve all text conflicts interactively
+pogo resolve
+
+# Use an external merge tool
+pogo resolve --tool 'meld $LOCAL $BASE $REMOTE --output $MERGED'
+
+# Keep our version of a binary file
+pogo resolve --ours assets/logo.png
+
+# Take the version of one parent of a merge
+pogo resolve --parent abc README.md`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if resolveOurs && resolveTheirs {
+				return errors.New("--ours and --theirs are mutually exclusive")
+			}
+			if resolveParent != "" && (resolveOurs || resolveTheirs) {
+				return errors.New("--parent cannot be combined with --ours or --theirs")
+			}
+			pickSide := resolveOurs || resolveTheirs || resolveParent != ""
+			if resolveTool != "" && pickSide {
+				return errors.New("--tool cannot be combined with --ours, --theirs or --parent")
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.PushFull(false); err != nil {
+				return errors.Join(errors.New("push full"), err)
+			}
+
+			list := resolveList || (resolveTool == "" && !pickSide && !tty.IsInteractive())
+
+			conflicts, err := c.GetConflicts(nil, protos.ConflictStyle_CONFLICT_STYLE_GIT, !list)
+			if err != nil {
+				return errors.Join(errors.New("get conflicts"), err)
+			}
+			if len(args) > 0 {
+				conflicts = slices.DeleteFunc(conflicts, func(conflict *protos.Conflict) bool {
+					return !slices.Contains(args, conflict.Name)
+				})
+			}
+
+			out := cmd.OutOrStdout()
+			if len(conflicts) == 0 {
+				_, _ = fmt.Fprintln(out, "No conflicts")
+				return nil
+			}
+
+			if list {
+				for _, conflict := range conflicts {
+					_, _ = fmt.Fprintln(out, describeConflict(conflict))
+				}
+				return nil
+			}
+
+			var resolved []string
+			switch {
+			case pickSide:
+				for _, conflict := range conflicts {
+					if !hasConflictSides(conflict) {
+						_, _ = fmt.Fprintf(out, "skipping %s: no stored sides, resolve it manually\n", conflict.Name)
+						continue
+					}
+					side := conflict.Ours
+					if resolveTheirs {
+						side = conflict.Theirs
+					}
+					if resolveParent != "" {
+						var err error
+						if side, err = parentConflictSide(conflict, resolveParent); err != nil {
+							_, _ = fmt.Fprintf(out, "skipping %s: %v\n", conflict.Name, err)
+							continue
+						}
+					}
+					if err := writeConflictSide(c, conflict.Name, side); err != nil {
+						return errors.Join(fmt.Errorf("resolve %s", conflict.Name), err)
+					}
+					resolved = append(resolved, conflict.Name)
+				}
+
+			case resolveTool != "":
+				for _, conflict := range conflicts {
+					if conflict.Binary || !hasConflictSides(conflict) || len(conflict.Parents) > 2 {
+						_, _ = fmt.Fprintf(out, "skipping %s\n", describeConflict(conflict))
+						continue
+					}
+					if err := runMergeTool(cmd, c, conflict); err != nil {
+						return errors.Join(fmt.Errorf("run merge tool for %s", conflict.Name), err)
+					}
+				}
+
+			default:
+				var files []difftui.MergeFile
+				for _, conflict := range conflicts {
+					if conflict.Binary || !hasConflictSides(conflict) || len(conflict.Parents) > 2 {
+						_, _ = fmt.Fprintf(out, "skipping %s\n", describeConflict(conflict))
+						continue
+					}
+					files = append(files, difftui.NewMergeFile(
+						conflict.Name,
+						conflictSideLabel(conflict.Ours, "ours"),
+						conflictSideLabel(conflict.Theirs, "theirs"),
+						string(conflictSideContent(conflict.Base)),
+						string(conflictSideContent(conflict.Ours)),
+						string(conflictSideContent(conflict.Theirs)),
+					))
+				}
+				if len(files) == 0 {
+					return nil
+				}
+
+				saved, err := difftui.RunMerge(files)
+				if err != nil {
+					return errors.Join(errors.New("run merge tui"), err)
+				}
+				if !saved {
+					_, _ = fmt.Fprintln(out, "Aborted, nothing was written")
+					return nil
+				}
+				for _, f := range files {
+					if !f.Touched() {
+						continue
+					}
+					if err := writeWorkingCopyFile(c, f.Path, []byte(f.Content())); err != nil {
+						return errors.Join(fmt.Errorf("write %s", f.Path), err)
+					}
+				}
+			}
+
+			if err := c.PushFull(false); err != nil {
+				return errors.Join(errors.New("push resolution"), err)
+			}
+			// A binary file resolved to the side that was kept on merge has
+			// unchanged content, so pushing it does not clear the conflict
+			if len(resolved) > 0 {
+				if err := c.MarkConflictsResolved(resolved); err != nil {
+					return errors.Join(errors.New("mark conflicts resolved"), err)
+				}
+			}
+
+			info, err := c.Info()
+			if err != nil {
+				return errors.Join(errors.New("get info"), err)
+			}
+			if info.IsInConflict {
+				_, _ = fmt.Fprintln(out, "Some conflicts remain, run `pogo resolve --list` to see them")
+			} else {
+				_, _ = fmt.Fprintln(out, "All conflicts resolved")
+			}
+
+			return nil
+		},
+	}
+)
+
+func hasConflictSides(conflict *protos.Conflict) bool {
+	return conflict.Structured
+}
+
+func describeConflict(conflict *protos.Conflict) string {
+	switch {
+	case !hasConflictSides(conflict):
+		return conflict.Name + " (conflict markers, resolve manually)"
+	case conflict.Binary:
+		return conflict.Name + " (binary, use --ours or --theirs)"
+	case len(conflict.Parents) > 2:
+		return fmt.Sprintf("%s (conflict between %d parents, use --parent or resolve manually)", conflict.Name, len(conflict.Parents))
+	default:
+		return conflict.Name
+	}
+}
+
+// parentConflictSide returns the side of the parent whose change name is or
+// uniquely starts with name, nil if the file does not exist in that parent.
+func parentConflictSide(conflict *protos.Conflict, name string) (*protos.ConflictSide, error) {
+	var matches []*protos.ConflictSide
+	for _, side := range conflict.Parents {
+		if side.Label == name {
+			matches = []*protos.ConflictSide{side}
+			break
+		}
+		if strings.HasPrefix(side.Label, name) {
+			matches = append(matches, side)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no parent %s", name)
+	case 1:
+		if matches[0].Deleted {
+			return nil, nil
+		}
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("parent prefix %s is ambiguous", name)
+	}
+}
+
+func conflictSideLabel(side *protos.ConflictSide, fallback string) string {
+	if side == nil || side.Label == "" {
+		return fallback
+	}
+	return side.Label
+}
+
+func conflictSideContent(side *protos.ConflictSide) []byte {
+	if side == nil {
+		return nil
+	}
+	return side.Content
+}
+
+// writeConflictSide replaces a file in the working copy with one side of a
+// conflict, removing it if the file does not exist on that side.
+func writeConflictSide(c *client.Client, name string, side *protos.ConflictSide) error {
+	if side == nil {
+		if err := os.Remove(filepath.Join(c.Location, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	absPath := filepath.Join(c.Location, filepath.FromSlash(name))
+	// Replace a symlink instead of writing through it
+	if info, err := os.Lstat(absPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(absPath); err != nil {
+			return err
+		}
+	}
+	if side.SymlinkTarget != nil {
+		_ = os.Remove(absPath)
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			return err
+		}
+		return client.CreateSymlink(filepath.FromSlash(*side.SymlinkTarget), absPath)
+	}
+	return writeWorkingCopyFile(c, name, side.Content)
+}
+
+func writeWorkingCopyFile(c *client.Client, name string, content []byte) error {
+	absPath := filepath.Join(c.Location, filepath.FromSlash(name))
+	perm := os.FileMode(0644)
+	if stat, err := os.Stat(absPath); err == nil {
+		perm = stat.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(absPath, content, perm)
+}
+
+func runMergeTool(cmd *cobra.Command, c *client.Client, conflict *protos.Conflict) error {
+	if !strings.Contains(resolveTool, "$MERGED") {
+		return errors.New("merge tool command must reference $MERGED")
+	}
+
+	tempDir, err := os.MkdirTemp("", "pogo-resolve-*")
+	if err != nil {
+		return errors.Join(errors.New("create temp dir"), err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseName := filepath.Base(filepath.FromSlash(conflict.Name))
+	paths := map[string]string{
+		"$BASE":   filepath.Join(tempDir, "BASE_"+baseName),
+		"$LOCAL":  filepath.Join(tempDir, "LOCAL_"+baseName),
+		"$REMOTE": filepath.Join(tempDir, "REMOTE_"+baseName),
+	}
+	sides := map[string]*protos.ConflictSide{
+		"$BASE":   conflict.Base,
+		"$LOCAL":  conflict.Ours,
+		"$REMOTE": conflict.Theirs,
+	}
+	for placeholder, p := range paths {
+		if err := os.WriteFile(p, conflictSideContent(sides[placeholder]), 0644); err != nil {
+			return errors.Join(fmt.Errorf("write %s", p), err)
+		}
+	}
+	merged := filepath.Join(c.Location, filepath.FromSlash(conflict.Name))
+
+	replacer := strings.NewReplacer("$BASE", paths["$BASE"], "$LOCAL", paths["$LOCAL"], "$REMOTE", paths["$REMOTE"], "$MERGED", merged)
+	fields, err := splitCommand(resolveTool)
+	if err != nil {
+		return errors.Join(errors.New("parse merge tool command"), err)
+	}
+	for i, field := range fields {
+		fields[i] = replacer.Replace(field)
+	}
+
+	tool := exec.CommandContext(cmd.Context(), fields[0], fields[1:]...)
+	tool.Stdin = os.Stdin
+	tool.Stdout = cmd.OutOrStdout()
+	tool.Stderr = cmd.ErrOrStderr()
+	if err := tool.Run(); err != nil {
+		return err
+	}
+
+	if hasMarkers, err := filecontents.HasConflictMarkers(merged); err != nil {
+		return errors.Join(errors.New("check conflict markers"), err)
+	} else if hasMarkers {
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s still contains conflict markers\n", conflict.Name)
+	}
+
+	return nil
+}
+
+// splitCommand splits a command line into words like a POSIX shell: quotes
+// group words and a backslash escapes a quote, space or backslash. Other
+// backslashes are kept, so Windows paths need no escaping.
+func splitCommand(command string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			if !strings.ContainsRune(`\"' `, r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		word.WriteRune('\\')
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return words, nil
+}
+
+func init() {
+	resolveCmd.Flags().BoolVar(&resolveList, "list", false, "Only list the conflicted files")
+	resolveCmd.Flags().StringVar(&resolveTool, "tool", "", "External merge tool command using $BASE, $LOCAL, $REMOTE and $MERGED")
+	resolveCmd.Flags().BoolVar(&resolveOurs, "ours", false, "Resolve conflicts by taking our side")
+	resolveCmd.Flags().BoolVar(&resolveTheirs, "theirs", false, "Resolve conflicts by taking their side")
+	resolveCmd.Flags().StringVar(&resolveParent, "parent", "", "Resolve conflicts by taking the side of the parent with this change name or prefix")
+	RootCmd.AddCommand(resolveCmd)
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/pogo-vcs/pogo/auth"
+	"github.com/pogo-vcs/pogo/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runnerCmd = &cobra.Command{
+		Use:   "runner",
+		Short: "Run CI pipelines on this machine",
+		Long: `Run CI pipelines of a repository on this machine instead of the server.
+
+The runner registers with a runner token and waits for queued pipelines whose
+runs_on labels it carries. It downloads the revision, executes the pipeline
+like the server would and reports the logs and results back.
+
+Pipelines select runners by label:
+
+  runs_on: [linux, docker]
+
+Pipelines without runs_on may run on the server or on any runner.
+Container tasks need Docker on the runner machine.
+
+Create a token from within the repository with "pogo runner create <name>".
+The token can be passed with --token or the POGO_RUNNER_TOKEN environment variable.`,
+		Example: `# Create a runner token
+pogo runner create build-box
+
+# Start the runner on the build machine
+POGO_RUNNER_TOKEN=... pogo runner --server pogo.example.com:8080 --label linux --label docker`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if runnerServer == "" {
+				return errors.New("server is required (use --server flag)")
+			}
+			tokenStr := runnerToken
+			if tokenStr == "" {
+				tokenStr = os.Getenv("POGO_RUNNER_TOKEN")
+			}
+			if tokenStr == "" {
+				return errors.New("runner token is required (use --token flag or POGO_RUNNER_TOKEN)")
+			}
+			token, err := auth.Decode(tokenStr)
+			if err != nil {
+				return errors.Join(errors.New("decode runner token"), err)
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+
+			r, err := client.OpenRunner(ctx, runnerServer, token, runnerLabels)
+			if err != nil {
+				return errors.Join(errors.New("open runner"), err)
+			}
+			defer r.Close()
+			r.Out = cmd.OutOrStdout()
+
+			return r.Run()
+		},
+	}
+
+	runnerCreateCmd = &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a runner token for the repository",
+		Long: `Create a runner for the repository and print its token.
+
+The token is only shown once. Anyone holding it can receive the pipelines
+of the repository together with its secrets.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			token, err := c.CreateCIRunner(args[0])
+			if err != nil {
+				return errors.Join(errors.New("create runner"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Runner %s created\nToken: %s\n", args[0], token)
+			return nil
+		},
+	}
+
+	runnerListCmd = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"l"},
+		Short:   "List the runners of the repository",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			runners, err := c.ListCIRunners()
+			if err != nil {
+				return errors.Join(errors.New("list runners"), err)
+			}
+
+			if len(runners) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "No runners found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "Name\tLabels\tLast Seen\tCreated")
+			for _, runner := range runners {
+				lastSeen := runner.LastSeenAt
+				if lastSeen == "" {
+					lastSeen = "never"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", runner.Name, strings.Join(runner.Labels, ","), lastSeen, runner.CreatedAt)
+			}
+			_ = w.Flush()
+
+			return nil
+		},
+	}
+
+	runnerRemoveCmd = &cobra.Command{
+		Use:     "remove <name>",
+		Aliases: []string{"rm"},
+		Short:   "Remove a runner and revoke its token",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.DeleteCIRunner(args[0]); err != nil {
+				return errors.Join(errors.New("remove runner"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Runner %s removed\n", args[0])
+			return nil
+		},
+	}
+
+	runnerServer string
+	runnerToken  string
+	runnerLabels []string
+)
+
+func init() {
+	RootCmd.AddCommand(runnerCmd)
+	runnerCmd.AddCommand(runnerCreateCmd)
+	runnerCmd.AddCommand(runnerListCmd)
+	runnerCmd.AddCommand(runnerRemoveCmd)
+
+	runnerCmd.Flags().StringVar(&runnerServer, "server", "", "Server address (host:port)")
+	runnerCmd.Flags().StringVar(&runnerToken, "token", "", "Runner token (defaults to POGO_RUNNER_TOKEN)")
+	runnerCmd.Flags().StringArrayVar(&runnerLabels, "label", nil, "Label of the runner (repeatable)")
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/pogo-vcs/pogo/client"
+	"github.com/pogo-vcs/pogo/protos"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +21,14 @@ Secrets are encrypted values that can be referenced in your CI pipeline YAML
 files using the {{ secret "KEY" }} template function. They are useful for
 storing sensitive data like API tokens, deployment keys, and credentials.
 
-Secrets are scoped to a repository and can only be accessed by users with
-access to that repository.`,
+Secrets are scoped to a repository and can only be set by users with
+access to that repository. They are write-only: once set, their values are
+only used by CI pipelines and can only be read back by admins.
+
+Secrets can belong to an environment (see pogo secrets env). Only configs
+declaring the environment with "environment: NAME" receive them, and only
+for events of the bookmarks the environment allows. Repositories also
+inherit the org secrets set by admins; repository secrets take precedence.`,
 	}
 	secretsListCmd = &cobra.Command{
 		Use:     "list",
@@ -28,7 +36,8 @@ access to that repository.`,
 		Short:   "List all secrets in the repository",
 		Long: `List all secrets in the repository.
 
-This shows the keys of all secrets, but not their values for security reasons.`,
+This shows the keys of all secrets with their environment, but not their
+values for security reasons. Inherited org secrets are marked with (org).`,
 		Example: `  # List all secrets
   pogo secrets list
 
@@ -61,7 +70,7 @@ This shows the keys of all secrets, but not their values for security reasons.`,
 			}
 
 			for _, secret := range secrets {
-				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s\n", secret.Key)
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), formatSecret(secret))
 			}
 
 			return nil
@@ -70,14 +79,19 @@ This shows the keys of all secrets, but not their values for security reasons.`,
 	secretsGetCmd = &cobra.Command{
 		Use:     "get <key>",
 		Aliases: []string{"g"},
-		Short:   "Get the value of a secret",
+		Short:   "Get the value of a secret (admins only)",
 		Long: `Get the value of a secret by its key.
 
+Secrets are write-only, so only admins of the server can read their values.
+
 This will display the secret value in plain text, so be careful when using
 this command in shared or recorded terminal sessions.`,
 		Example: `  # Get a secret value
   pogo secrets get DEPLOY_TOKEN
 
+  # Get a secret of an environment
+  pogo secrets get DEPLOY_TOKEN --environment production
+
   # Using the short alias
   pogo secrets g API_KEY`,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -101,7 +115,8 @@ this command in shared or recorded terminal sessions.`,
 			defer c.Close()
 			configureClientOutputs(cmd, c)
 
-			value, err := c.GetSecret(key)
+			environment, _ := cmd.Flags().GetString("environment")
+			value, err := c.GetSecret(environment, key)
 			if err != nil {
 				return errors.Join(errors.New("get secret"), err)
 			}
@@ -125,6 +140,9 @@ new value. Secrets can be used in CI pipeline configurations.`,
   # Update an existing secret
   pogo secrets set API_KEY new-key-value
 
+  # Set a secret only available to the production environment
+  pogo secrets set DEPLOY_TOKEN abc123xyz --environment production
+
   # Using the short alias
   pogo secrets s DATABASE_URL postgres://...`,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -149,7 +167,8 @@ new value. Secrets can be used in CI pipeline configurations.`,
 			defer c.Close()
 			configureClientOutputs(cmd, c)
 
-			if err := c.SetSecret(key, value); err != nil {
+			environment, _ := cmd.Flags().GetString("environment")
+			if err := c.SetSecret(environment, key, value); err != nil {
 				return errors.Join(errors.New("set secret"), err)
 			}
 
@@ -169,6 +188,9 @@ secret will receive an empty string when accessing it.`,
 		Example: `  # Delete a secret
   pogo secrets delete OLD_TOKEN
 
+  # Delete a secret of an environment
+  pogo secrets delete OLD_TOKEN --environment production
+
   # Using the short alias
   pogo secrets d UNUSED_KEY`,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -192,22 +214,174 @@ secret will receive an empty string when accessing it.`,
 			defer c.Close()
 			configureClientOutputs(cmd, c)
 
-			if err := c.DeleteSecret(key); err != nil {
+			environment, _ := cmd.Flags().GetString("environment")
+			if err := c.DeleteSecret(environment, key); err != nil {
 				return errors.Join(errors.New("delete secret"), err)
 			}
 
 			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Secret '%s' deleted successfully\n", key)
 
+			return nil
+		},
+	}
+	secretsEnvCmd = &cobra.Command{
+		Use:     "env",
+		Aliases: []string{"environment"},
+		Short:   "Manage the environments of repository secrets",
+		Long: `Manage the environments of repository secrets.
+
+An environment allows the events of bookmarks matching its globs, e.g.
+"main" or "release/*". A CI config declaring the environment receives its
+secrets in addition to the shared ones, but fails for events of other
+bookmarks and for events without a bookmark, like change events.`,
+	}
+	secretsEnvListCmd = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"l"},
+		Short:   "List the environments of the repository",
+		Example: `  pogo secrets env list`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return errors.New("too many arguments")
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			environments, err := c.GetEnvironments()
+			if err != nil {
+				return errors.Join(errors.New("get environments"), err)
+			}
+
+			if len(environments) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "No environments found")
+				return nil
+			}
+
+			for _, environment := range environments {
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", environment.Name, strings.Join(environment.Bookmarks, ", "))
+			}
+
+			return nil
+		},
+	}
+	secretsEnvSetCmd = &cobra.Command{
+		Use:     "set <name>",
+		Aliases: []string{"s"},
+		Short:   "Create or update an environment",
+		Long: `Create or update an environment of the repository.
+
+The bookmarks flag can be given multiple times and replaces the bookmark
+globs of an existing environment.`,
+		Example: `  # Allow the production environment for main and release bookmarks
+  pogo secrets env set production --bookmark main --bookmark "release/*"`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("environment name is required")
+			}
+			if len(args) > 1 {
+				return errors.New("too many arguments")
+			}
+
+			name := args[0]
+			bookmarks, _ := cmd.Flags().GetStringArray("bookmark")
+			if len(bookmarks) == 0 {
+				return errors.New("at least one --bookmark is required")
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.SetEnvironment(name, bookmarks); err != nil {
+				return errors.Join(errors.New("set environment"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Environment '%s' set successfully\n", name)
+
+			return nil
+		},
+	}
+	secretsEnvDeleteCmd = &cobra.Command{
+		Use:     "delete <name>",
+		Aliases: []string{"d", "rm", "remove"},
+		Short:   "Delete an environment and its secrets",
+		Example: `  pogo secrets env delete staging`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("environment name is required")
+			}
+			if len(args) > 1 {
+				return errors.New("too many arguments")
+			}
+
+			name := args[0]
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.DeleteEnvironment(name); err != nil {
+				return errors.Join(errors.New("delete environment"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Environment '%s' deleted successfully\n", name)
+
 			return nil
 		},
 	}
 )
 
+// formatSecret formats the key of a secret with its environment and whether
+// it is an org secret.
+func formatSecret(secret *protos.Secret) string {
+	line := secret.Key
+	if secret.Environment != "" {
+		line += "\t" + secret.Environment
+	}
+	if secret.Org {
+		line += "\t(org)"
+	}
+	return line
+}
+
 func init() {
+	secretsGetCmd.Flags().StringP("environment", "e", "", "Environment of the secret")
+	secretsSetCmd.Flags().StringP("environment", "e", "", "Environment of the secret, shared by all configs if empty")
+	secretsDeleteCmd.Flags().StringP("environment", "e", "", "Environment of the secret")
+	secretsEnvSetCmd.Flags().StringArrayP("bookmark", "b", nil, "Glob of bookmarks allowed to use the environment")
+
+	secretsEnvCmd.AddCommand(secretsEnvListCmd)
+	secretsEnvCmd.AddCommand(secretsEnvSetCmd)
+	secretsEnvCmd.AddCommand(secretsEnvDeleteCmd)
+
 	secretsCmd.AddCommand(secretsListCmd)
 	secretsCmd.AddCommand(secretsGetCmd)
 	secretsCmd.AddCommand(secretsSetCmd)
 	secretsCmd.AddCommand(secretsDeleteCmd)
+	secretsCmd.AddCommand(secretsEnvCmd)
 
 	RootCmd.AddCommand(secretsCmd)
 }
@@ -24,6 +24,10 @@ The server provides:
 - HTTP web interface for browsing repositories
 - Go module proxy support for importing Pogo repos as Go modules
 - Automatic daily garbage collection at 3 AM
+- Scheduled CI pipelines
+- Durable CI queue that survives restarts
+- Signed repository webhooks with retries
+- Pre-receive policies for pushes and descriptions
 - PostgreSQL backend for metadata storage
 - File-based object storage for content
 
@@ -34,6 +38,11 @@ Configuration:
 - DATABASE_URL - PostgreSQL connection string
 - OBJECT_STORAGE_PATH - Directory for storing file objects
 - GC_MEMORY_THRESHOLD - File count threshold for GC strategy
+- CI_MAX_PARALLEL_JOBS - Container CI jobs running at the same time (default: CPU count)
+- CI_WORKERS - CI pipelines running at the same time on the server, 0 leaves them to runners (default: CPU count)
+- SECRETS_KEY - Base64 encoded 32 byte key encrypting repository secrets
+- SECRETS_KEY_FILE - File with the key if SECRETS_KEY is not set, generated if missing (default: data/secrets.key)
+- POLICY_FILE - YAML file with the policies checking pushes, new changes and descriptions
 
 The server requires a PostgreSQL database to be running and accessible.
 On first run, it will automatically set up the required database schema.
@@ -41,7 +50,8 @@ On first run, it will automatically set up the required database schema.
 Security:
 - Authentication via personal access tokens
 - Public repositories allow read-only access without auth
-- All write operations require authentication`,
+- All write operations require authentication
+- Repository secrets are encrypted at rest and write-only for non-admins`,
 	Example: `# Start server on default port 8080
 pogo serve
 
@@ -63,6 +73,10 @@ docker run -p 8080:8080 -e DATABASE_URL=... ghcr.io/pogo-vcs/pogo:alpine`,
 
 		db.Connect()
 
+		if err := server.EncryptPlaintextSecrets(cmd.Context()); err != nil {
+			return err
+		}
+
 		srv := server.NewServer()
 		defer srv.Stop(cmd.Context())
 
@@ -91,6 +105,18 @@ docker run -p 8080:8080 -e DATABASE_URL=... ghcr.io/pogo-vcs/pogo:alpine`,
 			cmd.Println("Automatic garbage collection scheduled for 3 AM daily")
 		}
 
+		if err := server.StartCIScheduler(cmd.Context()); err != nil {
+			_, _ = fmt.Fprintf(cmd.OutOrStderr(), "Warning: Failed to start CI scheduler: %v\n", err)
+		} else {
+			defer server.StopCIScheduler()
+		}
+
+		server.StartCIWorkers(cmd.Context(), env.CiWorkers)
+		defer server.StopCIWorkers()
+
+		server.StartWebhookDeliveries(cmd.Context())
+		defer server.StopWebhookDeliveries()
+
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGABRT)
 		<-sig
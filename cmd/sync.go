@@ -14,7 +14,7 @@ import (
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Trunk based update of your divergent line of changes",
-	Long:  `Sync is for a Trunk based workflow. It merges your change with a trunk (defaults to main, can be adjusted passing another tag or change name) and if there are no conflicts, sets the given trunk bookmark to the newly created change. If there are conflicts, you are prompted to do so yourself.`,
+	Long:  `Sync is for a Trunk based workflow. It merges your change with a trunk (defaults to main, can be adjusted passing another tag or change name) and if there are no conflicts, sets the given trunk bookmark to the newly created change. If there are conflicts, you are prompted to resolve them with 'pogo resolve'.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var trunk string
 		switch len(args) {
@@ -71,7 +71,7 @@ var syncCmd = &cobra.Command{
 			if trunk != "main" {
 				syncCommand = fmt.Sprintf("pogo sync --trunk %s", trunkCliName)
 			}
-			return fmt.Errorf("conflicts found, please resolve them and re-sync by running `%s` again", syncCommand)
+			return fmt.Errorf("conflicts found, resolve them with `pogo resolve` and re-sync by running `%s` again", syncCommand)
 		}
 
 		// no conflict, set trunk bookmark
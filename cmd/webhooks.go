@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pogo-vcs/pogo/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	webhooksCmd = &cobra.Command{
+		Use:     "webhooks",
+		Aliases: []string{"webhook"},
+		Short:   "Manage outgoing webhooks of the repository",
+		Long: `Manage webhooks that notify other services about repository events.
+
+Webhooks receive a signed JSON POST request for every event they subscribe
+to: change.pushed, change.created, bookmark.set, bookmark.removed,
+ci_run.finished and repository.settings_changed. Webhooks without events
+receive all of them.
+
+Each request carries the headers X-Pogo-Event, X-Pogo-Delivery and
+X-Pogo-Signature-256, the HMAC-SHA256 of the body with the webhook secret
+as sha256=<hex>. Failed deliveries are retried with exponential backoff.`,
+	}
+	webhooksListCmd = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"l"},
+		Short:   "List the webhooks of the repository",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			webhooks, err := c.ListWebhooks()
+			if err != nil {
+				return errors.Join(errors.New("list webhooks"), err)
+			}
+
+			if len(webhooks) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "No webhooks found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tURL\tEvents\tAttempts\tCreated")
+			for _, webhook := range webhooks {
+				events := "all"
+				if len(webhook.Events) > 0 {
+					events = strings.Join(webhook.Events, ",")
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", webhook.Id, webhook.Url, events, webhook.MaxAttempts, webhook.CreatedAt)
+			}
+			_ = w.Flush()
+
+			return nil
+		},
+	}
+	webhooksAddCmd = &cobra.Command{
+		Use:     "add <url>",
+		Aliases: []string{"a", "create"},
+		Short:   "Add a webhook to the repository",
+		Long: `Add a webhook that receives the events of the repository.
+
+Without --secret a random secret is generated and printed once. Keep it to
+verify the X-Pogo-Signature-256 header of deliveries.`,
+		Example: `  # Notify a chat bot about all events
+  pogo webhooks add https://chat.example.com/hooks/pogo
+
+  # Only notify about bookmarks and finished CI runs
+  pogo webhooks add https://deploy.example.com/pogo --event bookmark.set --event ci_run.finished
+
+  # Use an own secret and fewer delivery attempts
+  pogo webhooks add https://example.com/hook --secret s3cret --max-attempts 3`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			events, _ := cmd.Flags().GetStringArray("event")
+			secret, _ := cmd.Flags().GetString("secret")
+			maxAttempts, _ := cmd.Flags().GetInt32("max-attempts")
+
+			response, err := c.CreateWebhook(args[0], events, secret, maxAttempts)
+			if err != nil {
+				return errors.Join(errors.New("create webhook"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Webhook %d created\n", response.Id)
+			if secret == "" {
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Secret: %s\n", response.Secret)
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "Store this secret now, it will not be shown again")
+			}
+
+			return nil
+		},
+	}
+	webhooksDeleteCmd = &cobra.Command{
+		Use:     "delete <id>",
+		Aliases: []string{"d", "rm"},
+		Short:   "Delete a webhook and its delivery log",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseWebhookID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			if err := c.DeleteWebhook(id); err != nil {
+				return errors.Join(errors.New("delete webhook"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Webhook %d deleted\n", id)
+
+			return nil
+		},
+	}
+	webhooksDeliveriesCmd = &cobra.Command{
+		Use:   "deliveries <id>",
+		Short: "Show the recent deliveries of a webhook",
+		Long: `Show the recent deliveries of a webhook, newest first, with their state,
+the response status and the error of the last attempt.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseWebhookID(args[0])
+			if err != nil {
+				return err
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			limit, _ := cmd.Flags().GetInt32("number")
+			deliveries, err := c.ListWebhookDeliveries(id, limit)
+			if err != nil {
+				return errors.Join(errors.New("list webhook deliveries"), err)
+			}
+
+			if len(deliveries) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStderr(), "No deliveries found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tEvent\tState\tStatus\tAttempts\tCreated\tError")
+			for _, delivery := range deliveries {
+				status := "-"
+				if delivery.StatusCode != nil {
+					status = strconv.Itoa(int(*delivery.StatusCode))
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+					delivery.Id,
+					delivery.Event,
+					delivery.State,
+					status,
+					delivery.Attempts,
+					delivery.CreatedAt,
+					delivery.GetError(),
+				)
+			}
+			_ = w.Flush()
+
+			return nil
+		},
+	}
+	webhooksRedeliverCmd = &cobra.Command{
+		Use:   "redeliver <delivery-id>",
+		Short: "Deliver the payload of a previous delivery again",
+		Long: `Deliver the payload of a previous delivery again as a new delivery.
+
+The new delivery has its own ID and attempts, and refers to the delivery it
+repeats.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deliveryID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid delivery id %q: %w", args[0], err)
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return errors.Join(errors.New("get working directory"), err)
+			}
+			c, err := client.OpenFromFile(cmd.Context(), wd)
+			if err != nil {
+				return errors.Join(errors.New("open client"), err)
+			}
+			defer c.Close()
+			configureClientOutputs(cmd, c)
+
+			newID, err := c.RedeliverWebhook(deliveryID)
+			if err != nil {
+				return errors.Join(errors.New("redeliver webhook"), err)
+			}
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Queued delivery %d\n", newID)
+
+			return nil
+		},
+	}
+)
+
+func parseWebhookID(arg string) (int32, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook id %q: %w", arg, err)
+	}
+	if id < 0 || id > math.MaxInt32 {
+		return 0, fmt.Errorf("webhook id %d is out of range", id)
+	}
+	return int32(id), nil
+}
+
+func init() {
+	webhooksAddCmd.Flags().StringArray("event", nil, "Event to deliver, all events if not set")
+	webhooksAddCmd.Flags().String("secret", "", "Secret to sign deliveries with, generated if not set")
+	webhooksAddCmd.Flags().Int32("max-attempts", 0, "Delivery attempts before giving up, server default if not set")
+	webhooksDeliveriesCmd.Flags().Int32P("number", "n", 20, "Maximum number of deliveries to display")
+
+	webhooksCmd.AddCommand(webhooksListCmd)
+	webhooksCmd.AddCommand(webhooksAddCmd)
+	webhooksCmd.AddCommand(webhooksDeleteCmd)
+	webhooksCmd.AddCommand(webhooksDeliveriesCmd)
+	webhooksCmd.AddCommand(webhooksRedeliverCmd)
+
+	RootCmd.AddCommand(webhooksCmd)
+}
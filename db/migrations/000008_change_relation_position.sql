@@ -0,0 +1,3 @@
+-- Record the order in which parents were given when a change was created
+-- The first parent of a merge is "ours" and the second is "theirs"
+ALTER TABLE change_relations ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,28 @@
+-- Store merge conflicts structurally so they can be re-materialized, resolved
+-- by picking a side and re-merged when a parent changes
+-- A NULL hash means the file does not exist in the base or on that side
+-- result_hash is the content that was written to the change for this path
+-- The symlink targets are set for versions that are symlinks
+CREATE TABLE conflicts (
+    change_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    base_hash BYTEA,
+    base_symlink_target TEXT,
+    result_hash BYTEA NOT NULL,
+    is_binary BOOLEAN NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (change_id) REFERENCES changes (id) ON DELETE CASCADE,
+    PRIMARY KEY (change_id, name)
+);
+
+-- The version of a conflicted file in every parent of the merge, so merges
+-- with more than two parents keep all sides
+-- position matches change_relations.position of the parent
+CREATE TABLE conflict_sides (
+    change_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    content_hash BYTEA,
+    symlink_target TEXT,
+    FOREIGN KEY (change_id, name) REFERENCES conflicts (change_id, name) ON DELETE CASCADE,
+    PRIMARY KEY (change_id, name, position)
+);
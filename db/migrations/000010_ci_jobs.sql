@@ -0,0 +1,12 @@
+CREATE SEQUENCE IF NOT EXISTS ci_pipeline_id_seq;
+
+ALTER TABLE ci_runs
+    ADD COLUMN pipeline_id BIGINT,
+    ADD COLUMN job_name TEXT,
+    ADD COLUMN needs TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN status TEXT NOT NULL DEFAULT '';
+
+UPDATE ci_runs SET status = CASE WHEN success THEN 'success' ELSE 'failure' END;
+
+CREATE INDEX IF NOT EXISTS ci_runs_pipeline_id_idx
+    ON ci_runs (pipeline_id);
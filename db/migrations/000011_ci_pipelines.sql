@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS ci_pipelines (
+    id BIGINT PRIMARY KEY DEFAULT nextval('ci_pipeline_id_seq'),
+    repository_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    change_id BIGINT NOT NULL REFERENCES changes(id) ON DELETE CASCADE,
+    config_filename TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    rev TEXT NOT NULL,
+    schedule TEXT,
+    inputs JSONB,
+    changed_files TEXT[],
+    state TEXT NOT NULL DEFAULT 'queued'
+        CHECK (state IN ('queued', 'running', 'succeeded', 'failed', 'cancelled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    queued_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS ci_pipelines_state_id_idx
+    ON ci_pipelines (state, id);
+
+CREATE INDEX IF NOT EXISTS ci_pipelines_repository_id_idx
+    ON ci_pipelines (repository_id, id DESC);
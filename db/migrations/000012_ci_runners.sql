@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ci_runners (
+    id SERIAL PRIMARY KEY,
+    repository_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token BYTEA NOT NULL UNIQUE,
+    labels TEXT[] NOT NULL DEFAULT '{}',
+    created_by_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (repository_id, name)
+);
+
+ALTER TABLE ci_pipelines
+    ADD COLUMN IF NOT EXISTS runs_on TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS runner_id INTEGER REFERENCES ci_runners(id) ON DELETE SET NULL;
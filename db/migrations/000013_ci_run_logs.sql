@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ci_run_logs (
+    id BIGSERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES ci_runs(id) ON DELETE CASCADE,
+    data BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ci_run_logs_run_id_idx
+    ON ci_run_logs (run_id, id);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS ci_artifacts (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES ci_runs(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    content_hash BYTEA NOT NULL,
+    UNIQUE (run_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS ci_artifacts_content_hash_idx
+    ON ci_artifacts (content_hash);
+
+CREATE TABLE IF NOT EXISTS ci_caches (
+    id SERIAL PRIMARY KEY,
+    repository_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    content_hash BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (repository_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS ci_caches_content_hash_idx
+    ON ci_caches (content_hash);
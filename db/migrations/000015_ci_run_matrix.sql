@@ -0,0 +1,2 @@
+ALTER TABLE ci_runs
+    ADD COLUMN matrix JSONB;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ci_test_results (
+    id BIGSERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES ci_runs(id) ON DELETE CASCADE,
+    suite TEXT NOT NULL,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('passed', 'failed', 'skipped')),
+    duration_ms BIGINT NOT NULL,
+    message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS ci_test_results_run_id_idx
+    ON ci_test_results (run_id);
+
+CREATE INDEX IF NOT EXISTS ci_test_results_suite_name_idx
+    ON ci_test_results (suite, name, run_id DESC);
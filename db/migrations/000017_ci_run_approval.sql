@@ -0,0 +1,3 @@
+ALTER TABLE ci_runs
+    ADD COLUMN approved_by_user_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    ADD COLUMN approved_at TIMESTAMP WITH TIME ZONE;
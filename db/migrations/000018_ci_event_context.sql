@@ -0,0 +1,4 @@
+ALTER TABLE ci_pipelines
+    ADD COLUMN previous_change_id BIGINT REFERENCES changes (id) ON DELETE SET NULL,
+    ADD COLUMN changed_file_operations TEXT[],
+    ADD COLUMN triggered_by_user_id INTEGER REFERENCES users (id) ON DELETE SET NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS ci_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    token BYTEA NOT NULL UNIQUE,
+    repository_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    pipeline_id BIGINT NOT NULL REFERENCES ci_pipelines(id) ON DELETE CASCADE,
+    permissions TEXT[] NOT NULL,
+    webhook_grace_period BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ci_tokens_pipeline_id_idx
+    ON ci_tokens (pipeline_id);
@@ -0,0 +1,9 @@
+ALTER TABLE secrets
+    ADD COLUMN ciphertext BYTEA,
+    ADD COLUMN key_id TEXT,
+    ALTER COLUMN value DROP NOT NULL;
+
+ALTER TABLE users
+    ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET admin = TRUE WHERE username = 'root';
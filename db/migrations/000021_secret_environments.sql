@@ -0,0 +1,19 @@
+CREATE TABLE environments (
+    repository_id INTEGER NOT NULL REFERENCES repositories (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    bookmarks TEXT[] NOT NULL,
+    PRIMARY KEY (repository_id, name)
+);
+
+ALTER TABLE secrets
+    ADD COLUMN environment TEXT NOT NULL DEFAULT '',
+    DROP CONSTRAINT secrets_pkey,
+    ADD PRIMARY KEY (repository_id, environment, key);
+
+CREATE TABLE org_secrets (
+    environment TEXT NOT NULL DEFAULT '',
+    key TEXT NOT NULL,
+    ciphertext BYTEA NOT NULL,
+    key_id TEXT NOT NULL,
+    PRIMARY KEY (environment, key)
+);
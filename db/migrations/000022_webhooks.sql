@@ -0,0 +1,33 @@
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    repository_id INTEGER NOT NULL REFERENCES repositories (id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- Events the webhook subscribes to, empty for all events
+    events TEXT[] NOT NULL DEFAULT '{}',
+    secret_ciphertext BYTEA NOT NULL,
+    key_id TEXT NOT NULL,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX webhooks_repository ON webhooks (repository_id);
+
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    -- The signed JSON body, kept byte for byte for redeliveries
+    payload BYTEA NOT NULL,
+    -- pending, delivering, succeeded or failed
+    state TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    claimed_at TIMESTAMP WITH TIME ZONE,
+    status_code INTEGER,
+    response TEXT,
+    error TEXT,
+    redelivery_of BIGINT REFERENCES webhook_deliveries (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX webhook_deliveries_queue ON webhook_deliveries (next_attempt_at) WHERE state IN ('pending', 'delivering');
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
@@ -123,6 +123,15 @@ WHERE cf.change_id = $1
   AND (f.name LIKE '%.gitignore' OR f.name LIKE '%.pogoignore')
 ORDER BY f.name;
 
+-- name: GetAttributesFilesForChangeId :many
+SELECT f.*
+FROM files f
+JOIN change_files cf ON f.id = cf.file_id
+WHERE cf.change_id = $1
+  AND (f.name = '.pogoattributes' OR f.name LIKE '%/.pogoattributes')
+  AND f.symlink_target IS NULL
+ORDER BY f.name;
+
 -- name: GetRepositoryBookmarkFileByName :one
 SELECT DISTINCT f.name, f.executable, f.content_hash, f.conflict, f.symlink_target
 FROM files f
@@ -150,6 +159,9 @@ SELECT u.* FROM users u
 JOIN personal_access_tokens pat ON u.id = pat.user_id
 WHERE pat.token = $1;
 
+-- name: SetUserAdmin :exec
+UPDATE users SET admin = $2 WHERE username = $1;
+
 -- name: CountUsers :one
 SELECT COUNT(*) FROM users;
 
@@ -185,11 +197,13 @@ RETURNING id AS change_id;
 -- name: SetParent :exec
 INSERT INTO change_relations (
   change_id,
-  parent_id
+  parent_id,
+  position
 )
 VALUES (
   $1,  -- child change_id
-  $2   -- parent_id
+  $2,  -- parent_id
+  $3   -- position of the parent in the merge
 )
 ON CONFLICT (change_id, parent_id) DO NOTHING;
 
@@ -212,7 +226,8 @@ JOIN changes child
   ON child.id = cr.change_id
 LEFT JOIN changes parent
   ON parent.id = cr.parent_id
-WHERE child.repository_id = $1;
+WHERE child.repository_id = $1
+ORDER BY cr.change_id, cr.position;
 
 -- name: GetNewestChanges :many
 SELECT
@@ -238,7 +253,8 @@ JOIN changes child
   ON child.id = cr.change_id
 LEFT JOIN changes parent
   ON parent.id = cr.parent_id
-WHERE child.id = ANY(@change_ids::BIGINT[]);
+WHERE child.id = ANY(@change_ids::BIGINT[])
+ORDER BY cr.change_id, cr.position;
 
 -- name: GetChange :one
 SELECT
@@ -274,11 +290,71 @@ SELECT EXISTS (
 );
 
 -- name: GetConflictFilesForChange :many
-SELECT f.name FROM change_files cf
+-- Files with inline conflict markers that were not produced by a merge are not structured.
+SELECT f.name, co.base_hash, co.base_symlink_target, COALESCE(co.is_binary, FALSE)::BOOLEAN AS is_binary, (co.change_id IS NOT NULL)::BOOLEAN AS structured
+FROM change_files cf
 JOIN files f ON cf.file_id = f.id
+LEFT JOIN conflicts co ON co.change_id = cf.change_id AND co.name = f.name
 WHERE cf.change_id = $1 AND f.conflict = TRUE
 ORDER BY f.name;
 
+-- name: SetConflict :exec
+INSERT INTO conflicts (change_id, name, base_hash, base_symlink_target, result_hash, is_binary)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (change_id, name) DO UPDATE SET
+    base_hash = EXCLUDED.base_hash,
+    base_symlink_target = EXCLUDED.base_symlink_target,
+    result_hash = EXCLUDED.result_hash,
+    is_binary = EXCLUDED.is_binary;
+
+-- name: AddConflictSide :exec
+INSERT INTO conflict_sides (change_id, name, position, content_hash, symlink_target)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: DeleteConflictSides :exec
+DELETE FROM conflict_sides WHERE change_id = $1 AND name = $2;
+
+-- name: GetConflictSidesForChange :many
+SELECT name, position, content_hash, symlink_target
+FROM conflict_sides
+WHERE change_id = $1
+ORDER BY name, position;
+
+-- name: GetConflict :one
+SELECT * FROM conflicts WHERE change_id = $1 AND name = $2;
+
+-- name: GetConflictsForChange :many
+SELECT * FROM conflicts WHERE change_id = $1 ORDER BY name;
+
+-- name: DeleteConflict :exec
+DELETE FROM conflicts WHERE change_id = $1 AND name = $2;
+
+-- name: DeleteResolvedConflicts :exec
+-- Removes conflicts whose path is no longer marked as conflicted in the change.
+DELETE FROM conflicts co
+WHERE co.change_id = $1
+  AND NOT EXISTS (
+    SELECT 1 FROM change_files cf
+    JOIN files f ON cf.file_id = f.id
+    WHERE cf.change_id = co.change_id AND f.name = co.name AND f.conflict = TRUE
+  );
+
+-- name: GetUntouchedConflictsInChildren :many
+-- Conflicts in direct children of a change whose conflicted file still has the
+-- content produced by the merge, so they can be re-merged safely.
+SELECT co.change_id, co.name
+FROM conflicts co
+JOIN change_relations cr ON cr.change_id = co.change_id
+JOIN change_files cf ON cf.change_id = co.change_id
+JOIN files f ON cf.file_id = f.id AND f.name = co.name
+WHERE cr.parent_id = $1 AND f.content_hash = co.result_hash
+ORDER BY co.change_id, co.name;
+
+-- name: RemoveFileFromChange :exec
+DELETE FROM change_files cf
+USING files f
+WHERE cf.file_id = f.id AND cf.change_id = $1 AND f.name = $2;
+
 -- name: FindLCA :one
 WITH RECURSIVE ancestors AS (
     -- Start with the two target changes
@@ -313,54 +389,6 @@ HAVING COUNT(DISTINCT origin_id) = 2  -- Reachable from both inputs
 ORDER BY MAX(depth) DESC  -- Highest depth = closest to leaves
 LIMIT 1;
 
--- name: GetThreeWayMergeFiles :many
-WITH all_file_names AS (
-    SELECT DISTINCT f.name
-    FROM change_files cf
-    JOIN files f ON cf.file_id = f.id
-    WHERE cf.change_id IN (@lca, @a, @b)
-),
-lca_files AS (
-    SELECT DISTINCT ON (f.name) f.id, f.name, f.executable, f.content_hash, f.symlink_target
-    FROM change_files cf
-    JOIN files f ON cf.file_id = f.id
-    WHERE cf.change_id = @lca
-    ORDER BY f.name, f.id
-),
-a_files AS (
-    SELECT DISTINCT ON (f.name) f.id, f.name, f.executable, f.content_hash, f.symlink_target
-    FROM change_files cf
-    JOIN files f ON cf.file_id = f.id
-    WHERE cf.change_id = @a
-    ORDER BY f.name, f.id
-),
-b_files AS (
-    SELECT DISTINCT ON (f.name) f.id, f.name, f.executable, f.content_hash, f.symlink_target
-    FROM change_files cf
-    JOIN files f ON cf.file_id = f.id
-    WHERE cf.change_id = @b
-    ORDER BY f.name, f.id
-)
-SELECT
-    afn.name as file_name,
-    lca.id as lca_file_id,
-    lca.executable as lca_executable,
-    lca.content_hash as lca_content_hash,
-    lca.symlink_target as lca_symlink_target,
-    a.id as a_file_id,
-    a.executable as a_executable,
-    a.content_hash as a_content_hash,
-    a.symlink_target as a_symlink_target,
-    b.id as b_file_id,
-    b.executable as b_executable,
-    b.content_hash as b_content_hash,
-    b.symlink_target as b_symlink_target
-FROM all_file_names afn
-LEFT JOIN lca_files lca ON afn.name = lca.name
-LEFT JOIN a_files a ON afn.name = a.name
-LEFT JOIN b_files b ON afn.name = b.name
-ORDER BY afn.name;
-
 -- name: CopyChangeFiles :exec
 INSERT INTO change_files (
   change_id,
@@ -376,7 +404,7 @@ WHERE
 ON CONFLICT (change_id, file_id) DO NOTHING;
 
 -- name: GetChangeFiles :many
-SELECT f.id, f.content_hash
+SELECT f.id, f.name, f.content_hash
 FROM files f
 JOIN change_files cf ON f.id = cf.file_id
 WHERE cf.change_id = $1;
@@ -494,6 +522,10 @@ WHERE b.repository_id = $1;
 DELETE FROM bookmarks
 WHERE repository_id = $1 AND name = $2;
 
+-- name: GetRepositoriesWithBookmarks :many
+SELECT DISTINCT repository_id
+FROM bookmarks;
+
 -- name: GetCIConfigFiles :many
 SELECT f.name, f.content_hash
 FROM files f
@@ -510,7 +542,8 @@ WHERE cr.parent_id = $1;
 SELECT c.id, c.name
 FROM changes c
 JOIN change_relations cr ON c.id = cr.parent_id
-WHERE cr.change_id = $1;
+WHERE cr.change_id = $1
+ORDER BY cr.position, c.id;
 
 -- name: DeleteChange :exec
 DELETE FROM changes WHERE id = $1;
@@ -588,7 +621,16 @@ WHERE NOT EXISTS (
 );
 
 -- name: GetAllFileHashes :many
-SELECT DISTINCT content_hash FROM files ORDER BY content_hash;
+SELECT content_hash FROM files
+UNION
+SELECT base_hash FROM conflicts WHERE base_hash IS NOT NULL
+UNION
+SELECT content_hash FROM conflict_sides WHERE content_hash IS NOT NULL
+UNION
+SELECT content_hash FROM ci_artifacts
+UNION
+SELECT content_hash FROM ci_caches
+ORDER BY content_hash;
 
 -- name: CountFiles :one
 SELECT COUNT(DISTINCT content_hash) AS count FROM files;
@@ -607,7 +649,11 @@ DELETE FROM files
 WHERE id = ANY(@file_ids::BIGINT[]);
 
 -- name: CheckFileHashExists :one
-SELECT EXISTS(SELECT 1 FROM files WHERE content_hash = $1) AS exists;
+SELECT EXISTS(SELECT 1 FROM files WHERE content_hash = $1)
+    OR EXISTS(SELECT 1 FROM conflicts WHERE base_hash = $1)
+    OR EXISTS(SELECT 1 FROM conflict_sides WHERE content_hash = $1)
+    OR EXISTS(SELECT 1 FROM ci_artifacts WHERE content_hash = $1)
+    OR EXISTS(SELECT 1 FROM ci_caches WHERE content_hash = $1) AS exists;
 
 -- name: IsContentHashReferenced :one
 -- Checks if a content_hash is still referenced by any change (via change_files).
@@ -617,6 +663,14 @@ SELECT EXISTS (
     SELECT 1 FROM files f
     JOIN change_files cf ON f.id = cf.file_id
     WHERE f.content_hash = $1
+) OR EXISTS (
+    SELECT 1 FROM conflicts WHERE base_hash = $1
+) OR EXISTS (
+    SELECT 1 FROM conflict_sides WHERE content_hash = $1
+) OR EXISTS (
+    SELECT 1 FROM ci_artifacts WHERE content_hash = $1
+) OR EXISTS (
+    SELECT 1 FROM ci_caches WHERE content_hash = $1
 ) AS is_referenced;
 
 -- name: CheckMultipleFileHashesExist :many
@@ -677,18 +731,166 @@ DELETE FROM invites WHERE expires_at < CURRENT_TIMESTAMP;
 DELETE FROM invites WHERE token = $1 AND created_by_user_id = $2 AND used_at IS NULL;
 
 -- name: SetSecret :exec
-INSERT INTO secrets (repository_id, key, value)
-VALUES ($1, $2, $3)
-ON CONFLICT (repository_id, key) DO UPDATE SET value = $3;
+INSERT INTO secrets (repository_id, environment, key, ciphertext, key_id)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (repository_id, environment, key) DO UPDATE SET value = NULL, ciphertext = $4, key_id = $5;
 
 -- name: GetSecret :one
-SELECT value FROM secrets WHERE repository_id = $1 AND key = $2;
+SELECT ciphertext FROM secrets WHERE repository_id = $1 AND environment = $2 AND key = $3;
 
 -- name: GetAllSecrets :many
-SELECT key, value FROM secrets WHERE repository_id = $1 ORDER BY key;
+SELECT environment, key, ciphertext FROM secrets WHERE repository_id = $1 ORDER BY environment, key;
+
+-- name: GetSecretKeys :many
+SELECT environment, key FROM secrets WHERE repository_id = $1 ORDER BY environment, key;
+
+-- name: GetPlaintextSecrets :many
+-- Secrets stored before values were encrypted.
+SELECT repository_id, environment, key, value FROM secrets WHERE ciphertext IS NULL;
+
+-- name: GetEncryptedSecretsForUpdate :many
+SELECT repository_id, environment, key, ciphertext FROM secrets WHERE ciphertext IS NOT NULL FOR UPDATE;
+
+-- name: UpdateSecretCiphertext :exec
+UPDATE secrets SET value = NULL, ciphertext = $4, key_id = $5
+WHERE repository_id = $1 AND environment = $2 AND key = $3;
 
 -- name: DeleteSecret :exec
-DELETE FROM secrets WHERE repository_id = $1 AND key = $2;
+DELETE FROM secrets WHERE repository_id = $1 AND environment = $2 AND key = $3;
+
+-- name: DeleteEnvironmentSecrets :exec
+DELETE FROM secrets WHERE repository_id = $1 AND environment = $2;
+
+-- name: SetEnvironment :exec
+INSERT INTO environments (repository_id, name, bookmarks)
+VALUES ($1, $2, $3)
+ON CONFLICT (repository_id, name) DO UPDATE SET bookmarks = $3;
+
+-- name: GetEnvironments :many
+SELECT name, bookmarks FROM environments WHERE repository_id = $1 ORDER BY name;
+
+-- name: EnvironmentExists :one
+SELECT EXISTS(SELECT 1 FROM environments WHERE repository_id = $1 AND name = $2);
+
+-- name: DeleteEnvironment :exec
+DELETE FROM environments WHERE repository_id = $1 AND name = $2;
+
+-- name: SetOrgSecret :exec
+INSERT INTO org_secrets (environment, key, ciphertext, key_id)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (environment, key) DO UPDATE SET ciphertext = $3, key_id = $4;
+
+-- name: GetAllOrgSecrets :many
+SELECT environment, key, ciphertext FROM org_secrets ORDER BY environment, key;
+
+-- name: GetOrgSecretKeys :many
+SELECT environment, key FROM org_secrets ORDER BY environment, key;
+
+-- name: GetOrgSecretsForUpdate :many
+SELECT environment, key, ciphertext FROM org_secrets FOR UPDATE;
+
+-- name: UpdateOrgSecretCiphertext :exec
+UPDATE org_secrets SET ciphertext = $3, key_id = $4
+WHERE environment = $1 AND key = $2;
+
+-- name: DeleteOrgSecret :exec
+DELETE FROM org_secrets WHERE environment = $1 AND key = $2;
+
+-- name: CreateWebhook :one
+INSERT INTO webhooks (repository_id, url, events, secret_ciphertext, key_id, max_attempts)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id;
+
+-- name: GetWebhooks :many
+SELECT id, url, events, max_attempts, created_at
+FROM webhooks
+WHERE repository_id = $1
+ORDER BY id;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks WHERE id = $1;
+
+-- name: GetWebhooksForEvent :many
+SELECT * FROM webhooks
+WHERE repository_id = @repository_id AND (events = '{}' OR @event::TEXT = ANY(events))
+ORDER BY id;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE repository_id = $1 AND id = $2;
+
+-- name: GetWebhookSecretsForUpdate :many
+SELECT id, repository_id, url, secret_ciphertext FROM webhooks FOR UPDATE;
+
+-- name: UpdateWebhookSecret :exec
+UPDATE webhooks SET secret_ciphertext = $2, key_id = $3 WHERE id = $1;
+
+-- name: QueueWebhookDelivery :one
+INSERT INTO webhook_deliveries (webhook_id, event, payload)
+VALUES ($1, $2, $3)
+RETURNING id;
+
+-- name: RedeliverWebhookDelivery :one
+-- Queues the payload of a delivery again as a new delivery.
+INSERT INTO webhook_deliveries (webhook_id, event, payload, redelivery_of)
+SELECT d.webhook_id, d.event, d.payload, d.id
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE w.repository_id = @repository_id AND d.id = @id
+RETURNING id;
+
+-- name: ClaimWebhookDelivery :one
+-- Claims a delivery that is due, or whose worker stopped before finishing it.
+UPDATE webhook_deliveries
+SET
+  state = 'delivering',
+  attempts = attempts + 1,
+  claimed_at = CURRENT_TIMESTAMP
+WHERE id = (
+  SELECT id
+  FROM webhook_deliveries
+  WHERE (state = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP)
+    OR (state = 'delivering' AND claimed_at < @stale_before)
+  ORDER BY next_attempt_at, id
+  FOR UPDATE SKIP LOCKED
+  LIMIT 1
+)
+RETURNING *;
+
+-- name: FinishWebhookDeliveryAttempt :exec
+-- Records an attempt. Deliveries that are retried are pending again until
+-- next_attempt_at.
+UPDATE webhook_deliveries
+SET
+  state = @state,
+  status_code = @status_code,
+  response = @response,
+  error = @error,
+  next_attempt_at = @next_attempt_at,
+  finished_at = CASE WHEN @state::TEXT = 'pending' THEN NULL ELSE CURRENT_TIMESTAMP END
+WHERE id = @id;
+
+-- name: GetWebhookDeliveries :many
+SELECT
+  d.id,
+  d.event,
+  d.state,
+  d.attempts,
+  d.status_code,
+  d.response,
+  d.error,
+  d.redelivery_of,
+  d.created_at,
+  d.next_attempt_at,
+  d.finished_at
+FROM webhook_deliveries d
+JOIN webhooks w ON w.id = d.webhook_id
+WHERE w.repository_id = @repository_id AND d.webhook_id = @webhook_id
+ORDER BY d.id DESC
+LIMIT @limit_count;
+
+-- name: DeleteExpiredWebhookDeliveries :exec
+DELETE FROM webhook_deliveries
+WHERE state IN ('succeeded', 'failed') AND finished_at < $1;
 
 -- name: CreateCIRun :one
 INSERT INTO ci_runs (
@@ -703,7 +905,12 @@ INSERT INTO ci_runs (
   success,
   started_at,
   finished_at,
-  log
+  log,
+  pipeline_id,
+  job_name,
+  needs,
+  status,
+  matrix
 ) VALUES (
   @repository_id,
   @config_filename,
@@ -716,9 +923,338 @@ INSERT INTO ci_runs (
   @success,
   @started_at,
   @finished_at,
-  @log
+  @log,
+  @pipeline_id,
+  @job_name,
+  @needs,
+  @status,
+  @matrix
+) RETURNING id;
+
+-- name: EnqueueCIPipeline :one
+INSERT INTO ci_pipelines (
+  repository_id,
+  change_id,
+  config_filename,
+  event_type,
+  rev,
+  schedule,
+  inputs,
+  changed_files,
+  runs_on,
+  previous_change_id,
+  changed_file_operations,
+  triggered_by_user_id
+) VALUES (
+  @repository_id,
+  @change_id,
+  @config_filename,
+  @event_type,
+  @rev,
+  @schedule,
+  @inputs,
+  @changed_files,
+  @runs_on,
+  @previous_change_id,
+  @changed_file_operations,
+  @triggered_by_user_id
 ) RETURNING id;
 
+-- name: RerunCIPipeline :one
+-- Queues the pipeline of a run again, with the same config, change, event and
+-- inputs. The rerun is triggered by the given user.
+INSERT INTO ci_pipelines (
+  repository_id,
+  change_id,
+  config_filename,
+  event_type,
+  rev,
+  schedule,
+  inputs,
+  changed_files,
+  runs_on,
+  previous_change_id,
+  changed_file_operations,
+  triggered_by_user_id
+)
+SELECT
+  p.repository_id,
+  p.change_id,
+  p.config_filename,
+  p.event_type,
+  p.rev,
+  p.schedule,
+  p.inputs,
+  p.changed_files,
+  p.runs_on,
+  p.previous_change_id,
+  p.changed_file_operations,
+  @triggered_by_user_id::INTEGER
+FROM ci_pipelines p
+JOIN ci_runs r ON r.pipeline_id = p.id
+WHERE r.repository_id = @repository_id AND r.id = @run_id
+RETURNING id;
+
+-- name: ClaimCIPipeline :one
+UPDATE ci_pipelines
+SET
+  state = 'running',
+  attempts = attempts + 1,
+  started_at = CURRENT_TIMESTAMP,
+  heartbeat_at = CURRENT_TIMESTAMP,
+  runner_id = NULL
+WHERE id = (
+  SELECT id
+  FROM ci_pipelines
+  WHERE state = 'queued' AND runs_on = '{}'
+  ORDER BY id
+  FOR UPDATE SKIP LOCKED
+  LIMIT 1
+)
+RETURNING *;
+
+-- name: ClaimCIPipelineForRunner :one
+UPDATE ci_pipelines
+SET
+  state = 'running',
+  attempts = attempts + 1,
+  started_at = CURRENT_TIMESTAMP,
+  heartbeat_at = CURRENT_TIMESTAMP,
+  runner_id = @runner_id
+WHERE id = (
+  SELECT id
+  FROM ci_pipelines
+  WHERE state = 'queued'
+    AND repository_id = @repository_id
+    AND runs_on <@ @labels::TEXT[]
+  ORDER BY id
+  FOR UPDATE SKIP LOCKED
+  LIMIT 1
+)
+RETURNING *;
+
+-- name: GetRunningCIPipelineForRunner :one
+SELECT * FROM ci_pipelines
+WHERE id = @id AND runner_id = @runner_id AND state = 'running';
+
+-- name: HeartbeatCIPipeline :execrows
+UPDATE ci_pipelines
+SET heartbeat_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND state = 'running';
+
+-- name: FinishCIPipeline :execrows
+UPDATE ci_pipelines
+SET
+  state = @state,
+  error = @error,
+  finished_at = CURRENT_TIMESTAMP
+WHERE id = @id AND state = 'running';
+
+-- name: ReleaseCIPipeline :exec
+UPDATE ci_pipelines
+SET
+  state = 'queued',
+  attempts = attempts - 1,
+  started_at = NULL,
+  heartbeat_at = NULL
+WHERE id = $1 AND state = 'running';
+
+-- name: RequeueOrphanedCIPipelines :many
+UPDATE ci_pipelines
+SET
+  state = CASE WHEN attempts < @max_attempts THEN 'queued' ELSE 'failed' END,
+  error = CASE WHEN attempts < @max_attempts THEN error ELSE 'worker stopped responding' END,
+  finished_at = CASE WHEN attempts < @max_attempts THEN NULL ELSE CURRENT_TIMESTAMP END
+WHERE state = 'running' AND heartbeat_at < @stale_before
+RETURNING id, state;
+
+-- name: CancelCIPipeline :execrows
+UPDATE ci_pipelines
+SET
+  state = 'cancelled',
+  error = @error,
+  finished_at = CURRENT_TIMESTAMP
+WHERE id = @id AND state IN ('queued', 'running');
+
+-- name: FinishCIRun :exec
+UPDATE ci_runs
+SET
+  status_code = @status_code,
+  success = @success,
+  finished_at = @finished_at,
+  log = @log,
+  status = @status
+WHERE id = @id;
+
+-- name: AppendCIRunLog :exec
+INSERT INTO ci_run_logs (run_id, data) VALUES ($1, $2);
+
+-- name: GetCIRunLogChunks :many
+SELECT id, data
+FROM ci_run_logs
+WHERE run_id = @run_id AND id > @after_id
+ORDER BY id;
+
+-- name: DeleteCIRunLogs :exec
+DELETE FROM ci_run_logs WHERE run_id = $1;
+
+-- name: GetCIRunStatus :one
+SELECT status, log FROM ci_runs WHERE id = $1;
+
+-- name: StartCIRun :exec
+UPDATE ci_runs
+SET
+  status = 'running',
+  started_at = @started_at
+WHERE id = @id;
+
+-- name: ApproveCIRun :execrows
+UPDATE ci_runs
+SET
+  approved_by_user_id = @user_id,
+  approved_at = CURRENT_TIMESTAMP
+WHERE repository_id = @repository_id AND id = @id AND status = 'waiting' AND approved_at IS NULL;
+
+-- name: IsCIRunApproved :one
+SELECT approved_at IS NOT NULL AS approved FROM ci_runs WHERE id = $1;
+
+-- name: IsCIJobApproved :one
+SELECT approved_at IS NOT NULL AS approved
+FROM ci_runs
+WHERE pipeline_id = @pipeline_id AND config_filename = @config_filename AND job_name = @job_name
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: CreateCIArtifact :exec
+INSERT INTO ci_artifacts (run_id, name, size, content_hash)
+VALUES (@run_id, @name, @size, @content_hash)
+ON CONFLICT (run_id, name) DO UPDATE
+SET size = EXCLUDED.size, content_hash = EXCLUDED.content_hash;
+
+-- name: ListCIArtifacts :many
+SELECT name, size FROM ci_artifacts
+WHERE run_id = $1
+ORDER BY name;
+
+-- name: GetCIArtifact :one
+SELECT a.name, a.size, a.content_hash
+FROM ci_artifacts a
+JOIN ci_runs r ON a.run_id = r.id
+WHERE r.repository_id = @repository_id AND a.run_id = @run_id AND a.name = @name;
+
+-- name: CreateCITestResults :exec
+INSERT INTO ci_test_results (run_id, suite, name, status, duration_ms, message)
+SELECT @run_id::INTEGER, t.suite, t.name, t.status, t.duration_ms, NULLIF(t.message, '')
+FROM unnest(
+  @suites::TEXT[],
+  @names::TEXT[],
+  @statuses::TEXT[],
+  @durations_ms::BIGINT[],
+  @messages::TEXT[]
+) AS t(suite, name, status, duration_ms, message);
+
+-- name: GetCIRunTestCounts :one
+SELECT
+  COUNT(*) FILTER (WHERE status = 'passed') AS passed,
+  COUNT(*) FILTER (WHERE status = 'failed') AS failed,
+  COUNT(*) FILTER (WHERE status = 'skipped') AS skipped
+FROM ci_test_results
+WHERE run_id = $1;
+
+-- name: ListCIRunFailedTests :many
+-- History holds the statuses of the test in the latest runs of the
+-- repository up to this run, newest first.
+SELECT
+  t.suite,
+  t.name,
+  t.duration_ms,
+  t.message,
+  ARRAY(
+    SELECT h.status
+    FROM ci_test_results h
+    JOIN ci_runs hr ON hr.id = h.run_id
+    WHERE hr.repository_id = r.repository_id
+      AND h.suite = t.suite
+      AND h.name = t.name
+      AND h.run_id <= t.run_id
+    ORDER BY h.run_id DESC
+    LIMIT @history_size::INTEGER
+  )::TEXT[] AS history
+FROM ci_test_results t
+JOIN ci_runs r ON r.id = t.run_id
+WHERE r.repository_id = @repository_id AND t.run_id = @run_id AND t.status = 'failed'
+ORDER BY t.suite, t.name;
+
+-- name: UseCICache :one
+UPDATE ci_caches
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE repository_id = @repository_id AND key = @key
+RETURNING content_hash;
+
+-- name: CreateCICache :exec
+INSERT INTO ci_caches (repository_id, key, size, content_hash)
+VALUES (@repository_id, @key, @size, @content_hash)
+ON CONFLICT (repository_id, key) DO NOTHING;
+
+-- name: DeleteExpiredCICaches :exec
+DELETE FROM ci_caches WHERE last_used_at < $1;
+
+-- name: DeleteCIRunsForPipeline :exec
+DELETE FROM ci_runs WHERE pipeline_id = $1;
+
+-- name: ListActiveCIPipelines :many
+SELECT id, config_filename, event_type, rev, state, attempts, queued_at, started_at
+FROM ci_pipelines
+WHERE repository_id = $1 AND state IN ('queued', 'running')
+ORDER BY id;
+
+-- name: CreateCIRunner :one
+INSERT INTO ci_runners (repository_id, name, token, created_by_user_id)
+VALUES (@repository_id, @name, @token, @created_by_user_id)
+RETURNING id;
+
+-- name: GetCIRunnerByToken :one
+SELECT * FROM ci_runners WHERE token = $1;
+
+-- name: RegisterCIRunner :exec
+UPDATE ci_runners
+SET labels = @labels, last_seen_at = CURRENT_TIMESTAMP
+WHERE id = @id;
+
+-- name: TouchCIRunner :exec
+UPDATE ci_runners SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: ListCIRunners :many
+SELECT id, name, labels, created_at, last_seen_at
+FROM ci_runners
+WHERE repository_id = $1
+ORDER BY name;
+
+-- name: DeleteCIRunner :execrows
+DELETE FROM ci_runners WHERE repository_id = $1 AND name = $2;
+
+-- name: GetChangeCIStatuses :many
+-- The status of a change is aggregated over the runs of the latest pipeline
+-- of each config file: pending while any of them runs, failure if a job
+-- failed, cancelled if all were cancelled and success otherwise.
+WITH latest AS (
+  SELECT DISTINCT ON (change_id, config_filename) id, change_id, state
+  FROM ci_pipelines
+  WHERE repository_id = @repository_id AND change_id = ANY(@change_ids::BIGINT[])
+  ORDER BY change_id, config_filename, id DESC
+)
+SELECT
+  latest.change_id,
+  (CASE
+    WHEN bool_or(latest.state IN ('queued', 'running') OR COALESCE(r.status, '') IN ('running', 'waiting')) THEN 'pending'
+    WHEN bool_or(latest.state = 'failed' OR COALESCE(r.status, '') = 'failure') THEN 'failure'
+    WHEN bool_and(latest.state = 'cancelled') THEN 'cancelled'
+    ELSE 'success'
+  END)::TEXT AS status
+FROM latest
+LEFT JOIN ci_runs r ON r.pipeline_id = latest.id
+GROUP BY latest.change_id;
+
 -- name: ListCIRuns :many
 SELECT
   id,
@@ -732,11 +1268,30 @@ SELECT
   status_code,
   success,
   started_at,
-  finished_at
+  finished_at,
+  pipeline_id,
+  job_name,
+  needs,
+  status
 FROM ci_runs
 WHERE repository_id = $1
 ORDER BY started_at DESC;
 
+-- name: GetCIPipelineRuns :many
+SELECT
+  id,
+  task_type,
+  status_code,
+  success,
+  started_at,
+  finished_at,
+  job_name,
+  needs,
+  status
+FROM ci_runs
+WHERE repository_id = $1 AND pipeline_id = $2
+ORDER BY id;
+
 -- name: GetCIRun :one
 SELECT
   id,
@@ -751,10 +1306,21 @@ SELECT
   success,
   started_at,
   finished_at,
-  log
+  log,
+  pipeline_id,
+  job_name,
+  needs,
+  status,
+  matrix
 FROM ci_runs
 WHERE repository_id = $1 AND id = $2;
 
+-- name: GetCIRunApproval :one
+SELECT r.approved_at, u.username AS approved_by
+FROM ci_runs r
+LEFT JOIN users u ON u.id = r.approved_by_user_id
+WHERE r.id = $1;
+
 -- name: UpdateCIRun :exec
 UPDATE ci_runs
 SET
@@ -770,6 +1336,50 @@ WITH deleted AS (
 )
 SELECT COUNT(*) FROM deleted;
 
+-- name: DeleteExpiredCIPipelines :exec
+DELETE FROM ci_pipelines
+WHERE state IN ('succeeded', 'failed', 'cancelled') AND finished_at < $1;
+
+-- name: CreateCIToken :exec
+INSERT INTO ci_tokens (
+  token,
+  repository_id,
+  pipeline_id,
+  permissions,
+  webhook_grace_period,
+  expires_at
+) VALUES (
+  @token,
+  @repository_id,
+  @pipeline_id,
+  @permissions,
+  @webhook_grace_period,
+  @expires_at
+);
+
+-- name: GetCIToken :one
+SELECT repository_id, pipeline_id, permissions
+FROM ci_tokens
+WHERE token = $1 AND expires_at > CURRENT_TIMESTAMP;
+
+-- name: RevokeCITokensForPipeline :exec
+-- Revokes the tokens of a finished pipeline, except those kept for webhooks
+-- until they expire.
+DELETE FROM ci_tokens WHERE pipeline_id = $1 AND NOT webhook_grace_period;
+
+-- name: DeleteExpiredCITokens :exec
+DELETE FROM ci_tokens WHERE expires_at < CURRENT_TIMESTAMP;
+
+-- name: GetCIPipeline :one
+SELECT * FROM ci_pipelines WHERE id = $1;
+
+-- name: GetExternalCIRun :one
+SELECT id, started_at
+FROM ci_runs
+WHERE pipeline_id = @pipeline_id AND task_type = 'external' AND job_name = @job_name
+ORDER BY id DESC
+LIMIT 1;
+
 -- name: GetFilesForChange :many
 SELECT f.name, f.executable, f.content_hash, f.symlink_target
 FROM files f
@@ -35,6 +35,9 @@ func Setup(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to create root user with token: %w", err)
 	}
+	if err := Q.SetUserAdmin(ctx, "root", true); err != nil {
+		return fmt.Errorf("failed to make root user admin: %w", err)
+	}
 
 	tokenString := EncodeToken(tokenBytes)
 	fmt.Printf("Root user created with personal access token: %s\n", tokenString)
@@ -0,0 +1,241 @@
+package filecontents
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/pogo-vcs/pogo/ptr"
+)
+
+// AttributesFileName is the name of the checked-in file that configures
+// per-path merge and diff behaviour.
+const AttributesFileName = ".pogoattributes"
+
+// MergeDriver selects how a file is merged.
+type MergeDriver uint8
+
+const (
+	// MergeDriverDefault merges text line by line and records a conflict for differing binaries.
+	MergeDriverDefault MergeDriver = iota
+	// MergeDriverText always merges line by line.
+	MergeDriverText
+	// MergeDriverBinary never merges content, differing sides are always a conflict.
+	MergeDriverBinary
+	// MergeDriverUnion keeps the lines of both sides for conflicting hunks.
+	MergeDriverUnion
+	// MergeDriverOurs keeps our version.
+	MergeDriverOurs
+	// MergeDriverTheirs keeps their version.
+	MergeDriverTheirs
+)
+
+func (d MergeDriver) String() string {
+	switch d {
+	case MergeDriverText:
+		return "text"
+	case MergeDriverBinary:
+		return "binary"
+	case MergeDriverUnion:
+		return "union"
+	case MergeDriverOurs:
+		return "ours"
+	case MergeDriverTheirs:
+		return "theirs"
+	default:
+		return "default"
+	}
+}
+
+var ErrInvalidAttribute = errors.New("invalid attribute")
+
+// FileAttributes are the attributes that apply to a single path.
+// Unset fields fall back to the detected file type.
+type FileAttributes struct {
+	Merge MergeDriver
+	// Text forces the file to be treated as text (true) or binary (false)
+	Text       *bool
+	LineEnding LineEnding
+	Encoding   Encoding
+	NoDiff     bool
+}
+
+// ApplyTo overrides the detected file type with the configured attributes.
+func (fa FileAttributes) ApplyTo(ft FileType) FileType {
+	if fa.Text != nil {
+		ft.Binary = !*fa.Text
+	}
+	if ft.Binary {
+		return ft
+	}
+	if fa.Encoding != UnknownEncoding {
+		ft.Encoding = fa.Encoding
+	}
+	if fa.LineEnding != "" {
+		ft.LineEnding = fa.LineEnding
+	}
+	return ft
+}
+
+type attributeRule struct {
+	pattern gitignore.Pattern
+	apply   []func(*FileAttributes)
+}
+
+// Attributes holds the parsed rules of one or more attributes files.
+// Later rules override earlier ones for every attribute they set.
+type Attributes struct {
+	rules []attributeRule
+}
+
+// Parse reads an attributes file. Each line consists of a gitignore style
+// pattern followed by whitespace separated attributes:
+//
+//	*.lock        -diff merge=ours
+//	*.png         binary
+//	*.bat         text eol=crlf
+//	CHANGELOG.md  merge=union
+//
+// domain is the directory of the attributes file, patterns only match below it.
+// Like git, unsupported patterns and attributes are skipped and the rest of
+// the file still applies. They are reported in the returned error, which then
+// wraps ErrInvalidAttribute.
+func (a *Attributes) Parse(r io.Reader, domain []string) error {
+	var invalid []error
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "!") {
+			invalid = append(invalid, fmt.Errorf("line %d: %w: negative patterns are not supported", lineNo, ErrInvalidAttribute))
+			continue
+		}
+
+		rule := attributeRule{pattern: gitignore.ParsePattern(fields[0], domain)}
+		for _, field := range fields[1:] {
+			apply, err := parseAttribute(field)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("line %d: %w", lineNo, err))
+				continue
+			}
+			rule.apply = append(rule.apply, apply)
+		}
+		a.rules = append(a.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.Join(invalid...)
+}
+
+// Lookup returns the attributes for a slash separated path.
+func (a *Attributes) Lookup(path string) FileAttributes {
+	var fa FileAttributes
+	if a == nil {
+		return fa
+	}
+	parts := strings.Split(path, "/")
+	for _, rule := range a.rules {
+		if rule.pattern.Match(parts, false) != gitignore.Exclude {
+			continue
+		}
+		for _, apply := range rule.apply {
+			apply(&fa)
+		}
+	}
+	return fa
+}
+
+func parseAttribute(field string) (func(*FileAttributes), error) {
+	key, value, hasValue := strings.Cut(field, "=")
+	if !hasValue {
+		switch key {
+		case "text":
+			return func(fa *FileAttributes) { fa.Text = ptr.True }, nil
+		case "-text":
+			return func(fa *FileAttributes) { fa.Text = ptr.False }, nil
+		case "binary":
+			return func(fa *FileAttributes) {
+				fa.Text = ptr.False
+				fa.Merge = MergeDriverBinary
+			}, nil
+		case "diff":
+			return func(fa *FileAttributes) { fa.NoDiff = false }, nil
+		case "-diff":
+			return func(fa *FileAttributes) { fa.NoDiff = true }, nil
+		case "-merge":
+			return func(fa *FileAttributes) { fa.Merge = MergeDriverBinary }, nil
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAttribute, field)
+		}
+	}
+
+	switch key {
+	case "merge":
+		driver, err := parseMergeDriver(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(fa *FileAttributes) { fa.Merge = driver }, nil
+	case "eol":
+		var lineEnding LineEnding
+		switch strings.ToLower(value) {
+		case "lf":
+			lineEnding = LF
+		case "crlf":
+			lineEnding = CRLF
+		default:
+			return nil, fmt.Errorf("%w: unknown eol %q", ErrInvalidAttribute, value)
+		}
+		return func(fa *FileAttributes) { fa.LineEnding = lineEnding }, nil
+	case "encoding":
+		encoding, err := parseEncoding(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(fa *FileAttributes) { fa.Encoding = encoding }, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAttribute, field)
+	}
+}
+
+func parseMergeDriver(s string) (MergeDriver, error) {
+	switch strings.ToLower(s) {
+	case "text":
+		return MergeDriverText, nil
+	case "binary":
+		return MergeDriverBinary, nil
+	case "union":
+		return MergeDriverUnion, nil
+	case "ours":
+		return MergeDriverOurs, nil
+	case "theirs":
+		return MergeDriverTheirs, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown merge driver %q", ErrInvalidAttribute, s)
+	}
+}
+
+func parseEncoding(s string) (Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(s, "-", "")) {
+	case "utf8":
+		return UTF8, nil
+	case "utf16le":
+		return UTF16LE, nil
+	case "utf16be":
+		return UTF16BE, nil
+	case "utf32le":
+		return UTF32LE, nil
+	case "utf32be":
+		return UTF32BE, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown encoding %q", ErrInvalidAttribute, s)
+	}
+}
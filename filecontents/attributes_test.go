@@ -0,0 +1,112 @@
+package filecontents_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/ptr"
+)
+
+func TestAttributesLookup(t *testing.T) {
+	var attrs filecontents.Attributes
+	root := "# generated files\n" +
+		"*.lock -diff merge=ours\n" +
+		"*.png binary\n" +
+		"*.bat text eol=crlf\n" +
+		"CHANGELOG.md merge=union\n"
+	if err := attrs.Parse(strings.NewReader(root), nil); err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if err := attrs.Parse(strings.NewReader("*.lock diff\n*.txt encoding=utf-16le\n"), []string{"docs"}); err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	tests := []struct {
+		path       string
+		merge      filecontents.MergeDriver
+		text       *bool
+		lineEnding filecontents.LineEnding
+		encoding   filecontents.Encoding
+		noDiff     bool
+	}{
+		{path: "go.lock", merge: filecontents.MergeDriverOurs, noDiff: true},
+		{path: "sub/dir/go.lock", merge: filecontents.MergeDriverOurs, noDiff: true},
+		{path: "docs/go.lock", merge: filecontents.MergeDriverOurs},
+		{path: "logo.png", merge: filecontents.MergeDriverBinary, text: ptr.False},
+		{path: "build.bat", text: ptr.True, lineEnding: filecontents.CRLF},
+		{path: "CHANGELOG.md", merge: filecontents.MergeDriverUnion},
+		{path: "docs/readme.txt", encoding: filecontents.UTF16LE},
+		{path: "readme.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := attrs.Lookup(tt.path)
+			if got.Merge != tt.merge {
+				t.Errorf("Merge = %s, want %s", got.Merge, tt.merge)
+			}
+			if (got.Text == nil) != (tt.text == nil) || (got.Text != nil && *got.Text != *tt.text) {
+				t.Errorf("Text = %v, want %v", got.Text, tt.text)
+			}
+			if got.LineEnding != tt.lineEnding {
+				t.Errorf("LineEnding = %q, want %q", got.LineEnding, tt.lineEnding)
+			}
+			if got.Encoding != tt.encoding {
+				t.Errorf("Encoding = %s, want %s", got.Encoding, tt.encoding)
+			}
+			if got.NoDiff != tt.noDiff {
+				t.Errorf("NoDiff = %v, want %v", got.NoDiff, tt.noDiff)
+			}
+		})
+	}
+}
+
+func TestAttributesParseInvalid(t *testing.T) {
+	for _, line := range []string{"*.go merge=magic", "*.go eol=cr", "*.go frobnicate", "!*.go text"} {
+		var attrs filecontents.Attributes
+		if err := attrs.Parse(strings.NewReader(line), nil); !errors.Is(err, filecontents.ErrInvalidAttribute) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidAttribute", line, err)
+		}
+	}
+}
+
+func TestAttributesParseSkipsInvalid(t *testing.T) {
+	var attrs filecontents.Attributes
+	content := "*.lock merge=magic -diff\n" +
+		"!*.png binary\n" +
+		"*.bat text eol=crlf\n"
+	if err := attrs.Parse(strings.NewReader(content), nil); !errors.Is(err, filecontents.ErrInvalidAttribute) {
+		t.Fatalf("Parse() error = %v, want ErrInvalidAttribute", err)
+	}
+
+	if got := attrs.Lookup("go.lock"); !got.NoDiff || got.Merge != filecontents.MergeDriverDefault {
+		t.Errorf("Lookup(go.lock) = %+v, want only -diff", got)
+	}
+	if got := attrs.Lookup("logo.png"); got.Text != nil || got.Merge != filecontents.MergeDriverDefault {
+		t.Errorf("Lookup(logo.png) = %+v, want no attributes", got)
+	}
+	if got := attrs.Lookup("build.bat"); got.Text == nil || !*got.Text || got.LineEnding != filecontents.CRLF {
+		t.Errorf("Lookup(build.bat) = %+v, want text eol=crlf", got)
+	}
+}
+
+func TestMergeUnion(t *testing.T) {
+	r, err := filecontents.MergeUnion(
+		strings.NewReader("a\nb\nc"),
+		strings.NewReader("a\nx\nc"),
+		strings.NewReader("a\ny\nc"),
+	)
+	if err != nil {
+		t.Fatalf("MergeUnion() failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read result: %v", err)
+	}
+	if want := "a\nx\ny\nc"; string(got) != want {
+		t.Errorf("MergeUnion() = %q, want %q", got, want)
+	}
+}
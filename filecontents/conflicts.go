@@ -0,0 +1,171 @@
+package filecontents
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/devsisters/go-diff3"
+	"github.com/devsisters/go-diff3/linereader"
+)
+
+// ConflictStyle determines how a stored conflict is rendered into file content.
+type ConflictStyle uint8
+
+const (
+	// ConflictStyleGit shows ours and theirs, narrowed down to the lines that differ.
+	ConflictStyleGit ConflictStyle = iota
+	// ConflictStyleDiff3 additionally shows the base version of each conflicting hunk.
+	ConflictStyleDiff3
+	// ConflictStyleZdiff3 is like diff3 but moves lines shared by both sides out of the hunk.
+	ConflictStyleZdiff3
+)
+
+var ErrUnknownConflictStyle = errors.New("unknown conflict style")
+
+func ParseConflictStyle(s string) (ConflictStyle, error) {
+	switch strings.ToLower(s) {
+	case "", "git", "merge":
+		return ConflictStyleGit, nil
+	case "diff3":
+		return ConflictStyleDiff3, nil
+	case "zdiff3":
+		return ConflictStyleZdiff3, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownConflictStyle, s)
+	}
+}
+
+func (s ConflictStyle) String() string {
+	switch s {
+	case ConflictStyleDiff3:
+		return "diff3"
+	case ConflictStyleZdiff3:
+		return "zdiff3"
+	default:
+		return "git"
+	}
+}
+
+// ConflictLabels are written next to the conflict markers to name each side.
+type ConflictLabels struct {
+	Base   string
+	Ours   string
+	Theirs string
+}
+
+// MaterializeConflict merges ours and theirs against base and renders all
+// conflicting hunks in the given style. The readers must contain canonical text.
+func MaterializeConflict(base, ours, theirs io.Reader, labels ConflictLabels, style ConflictStyle) (io.Reader, bool, error) {
+	o, err := linereader.GetLines(base)
+	if err != nil {
+		return nil, false, fmt.Errorf("read base: %w", err)
+	}
+	a, err := linereader.GetLines(ours)
+	if err != nil {
+		return nil, false, fmt.Errorf("read ours: %w", err)
+	}
+	b, err := linereader.GetLines(theirs)
+	if err != nil {
+		return nil, false, fmt.Errorf("read theirs: %w", err)
+	}
+
+	var (
+		lines     []string
+		conflicts bool
+	)
+	for _, item := range diff3.Diff3Merge(a, o, b, true) {
+		if item.Conflict == nil {
+			lines = append(lines, item.Ok...)
+			continue
+		}
+		conflicts = true
+		c := item.Conflict
+
+		switch style {
+		case ConflictStyleDiff3:
+			lines = appendConflictHunk(lines, c.A, c.O, c.B, labels, true)
+		case ConflictStyleZdiff3:
+			prefix := commonPrefixLen(c.A, c.B)
+			lines = append(lines, c.A[:prefix]...)
+			cA, cB := c.A[prefix:], c.B[prefix:]
+			suffix := commonSuffixLen(cA, cB)
+			lines = appendConflictHunk(lines, cA[:len(cA)-suffix], c.O, cB[:len(cB)-suffix], labels, true)
+			lines = append(lines, cA[len(cA)-suffix:]...)
+		default:
+			consumed := 0
+			for _, d := range diff3.DiffComm(c.A, c.B) {
+				if d.Common != nil {
+					lines = append(lines, d.Common...)
+					consumed += len(d.Common)
+					continue
+				}
+				lines = appendConflictHunk(lines, d.File1, nil, d.File2, labels, false)
+				consumed += len(d.File1)
+			}
+			// DiffComm does not report the common lines after the last difference
+			lines = append(lines, c.A[consumed:]...)
+		}
+	}
+
+	return strings.NewReader(strings.Join(lines, "\n")), conflicts, nil
+}
+
+// MergeUnion merges ours and theirs against base and resolves conflicting
+// hunks by keeping the lines of ours followed by the lines of theirs.
+func MergeUnion(base, ours, theirs io.Reader) (io.Reader, error) {
+	o, err := linereader.GetLines(base)
+	if err != nil {
+		return nil, fmt.Errorf("read base: %w", err)
+	}
+	a, err := linereader.GetLines(ours)
+	if err != nil {
+		return nil, fmt.Errorf("read ours: %w", err)
+	}
+	b, err := linereader.GetLines(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("read theirs: %w", err)
+	}
+
+	var lines []string
+	for _, item := range diff3.Diff3Merge(a, o, b, true) {
+		if item.Conflict == nil {
+			lines = append(lines, item.Ok...)
+			continue
+		}
+		lines = append(lines, item.Conflict.A...)
+		lines = append(lines, item.Conflict.B...)
+	}
+
+	return strings.NewReader(strings.Join(lines, "\n")), nil
+}
+
+func appendConflictHunk(lines, ours, base, theirs []string, labels ConflictLabels, withBase bool) []string {
+	lines = append(lines, ConflictMarkerStart+" "+labels.Ours)
+	lines = append(lines, ours...)
+	if withBase {
+		lines = append(lines, ConflictMarkerBase+" "+labels.Base)
+		lines = append(lines, base...)
+	}
+	lines = append(lines, ConflictMarkerSep)
+	lines = append(lines, theirs...)
+	lines = append(lines, ConflictMarkerEnd+" "+labels.Theirs)
+	return lines
+}
+
+func commonPrefixLen(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffixLen(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
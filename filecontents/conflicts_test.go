@@ -0,0 +1,94 @@
+package filecontents_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pogo-vcs/pogo/filecontents"
+)
+
+func TestMaterializeConflict(t *testing.T) {
+	labels := filecontents.ConflictLabels{Base: "base", Ours: "ours", Theirs: "theirs"}
+	tests := []struct {
+		name          string
+		base          string
+		ours          string
+		theirs        string
+		style         filecontents.ConflictStyle
+		want          string
+		wantConflicts bool
+	}{
+		{
+			name:   "clean merge",
+			base:   "a\nb\nc",
+			ours:   "A\nb\nc",
+			theirs: "a\nb\nC",
+			style:  filecontents.ConflictStyleGit,
+			want:   "A\nb\nC",
+		},
+		{
+			name:   "git",
+			base:   "a\nb\nc",
+			ours:   "a\nx\nc",
+			theirs: "a\ny\nc",
+			style:  filecontents.ConflictStyleGit,
+			want: "a\n" +
+				filecontents.ConflictMarkerStart + " ours\nx\n" +
+				filecontents.ConflictMarkerSep + "\ny\n" +
+				filecontents.ConflictMarkerEnd + " theirs\nc",
+			wantConflicts: true,
+		},
+		{
+			name:   "diff3",
+			base:   "a\nb\nc",
+			ours:   "a\nx\nc",
+			theirs: "a\ny\nc",
+			style:  filecontents.ConflictStyleDiff3,
+			want: "a\n" +
+				filecontents.ConflictMarkerStart + " ours\nx\n" +
+				filecontents.ConflictMarkerBase + " base\nb\n" +
+				filecontents.ConflictMarkerSep + "\ny\n" +
+				filecontents.ConflictMarkerEnd + " theirs\nc",
+			wantConflicts: true,
+		},
+		{
+			name:   "zdiff3 moves shared lines out",
+			base:   "a\nb\nc",
+			ours:   "a\nshared\nx\nend\nc",
+			theirs: "a\nshared\ny\nend\nc",
+			style:  filecontents.ConflictStyleZdiff3,
+			want: "a\nshared\n" +
+				filecontents.ConflictMarkerStart + " ours\nx\n" +
+				filecontents.ConflictMarkerBase + " base\nb\n" +
+				filecontents.ConflictMarkerSep + "\ny\n" +
+				filecontents.ConflictMarkerEnd + " theirs\nend\nc",
+			wantConflicts: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, conflicts, err := filecontents.MaterializeConflict(
+				strings.NewReader(tt.base),
+				strings.NewReader(tt.ours),
+				strings.NewReader(tt.theirs),
+				labels,
+				tt.style,
+			)
+			if err != nil {
+				t.Fatalf("MaterializeConflict() failed: %v", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read result: %v", err)
+			}
+			if conflicts != tt.wantConflicts {
+				t.Errorf("MaterializeConflict() conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MaterializeConflict() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"unicode/utf16"
 	"unicode/utf8"
 
@@ -1144,11 +1143,3 @@ func HasConflictMarkers(filePath string) (bool, error) {
 
 	return hasStart && hasSeparator && hasEnd, nil
 }
-
-var binaryConflictFileNameRegex = regexp.MustCompile(`^.*\.conflict-(?:a|b)-[abcdefhkmnprwxyACDEFHJKLMNPRXY34]{16}$`)
-
-func IsBinaryConflictFileName(filePath string) bool {
-	c := binaryConflictFileNameRegex.MatchString(filePath)
-	fmt.Printf("IsBinaryConflictFileName: %q %t\n", filePath, c)
-	return c
-}
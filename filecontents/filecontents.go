@@ -18,6 +18,7 @@ var (
 	ConflictMarkerStart = strings.Repeat("<", 7)
 	ConflictMarkerEnd   = strings.Repeat(">", 7)
 	ConflictMarkerSep   = strings.Repeat("=", 7)
+	ConflictMarkerBase  = strings.Repeat("|", 7)
 )
 
 // HashFile computes the SHA-256 hash of a file at the given path and returns it as URL-safe base64.
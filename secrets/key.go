@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the size of keys encrypting secret values in bytes.
+const KeySize = 32
+
+// Key encrypts secret values with AES-256-GCM.
+type Key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKey creates a key from KeySize random bytes.
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &Key{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// GenerateKey returns KeySize random bytes for NewKey.
+func GenerateKey() ([]byte, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// ID identifies the key without revealing it, so ciphertexts can record
+// which key encrypted them.
+func (k *Key) ID() string {
+	return k.id
+}
+
+// Encrypt encrypts the value. The additional data is authenticated but not
+// encrypted; Decrypt must be given the same additional data, which binds the
+// ciphertext to e.g. the name of the secret.
+func (k *Key) Encrypt(value string, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(value)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, []byte(value), additionalData), nil
+}
+
+// Decrypt decrypts a ciphertext of Encrypt.
+func (k *Key) Decrypt(ciphertext, additionalData []byte) (string, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:k.aead.NonceSize()], ciphertext[k.aead.NonceSize():]
+	value, err := k.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return "", fmt.Errorf("decrypt with key %s: %w", k.id, err)
+	}
+	return string(value), nil
+}
+
+// DecodeKey decodes a base64 encoded key.
+func DecodeKey(s string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return raw, nil
+}
+
+// ReadKeyFile reads a base64 encoded key from a file.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := DecodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return raw, nil
+}
+
+// WriteKeyFile writes a base64 encoded key to a file only readable by its
+// owner.
+func WriteKeyFile(path string, raw []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(raw)+"\n"), 0o600)
+}
+
+// LoadOrCreateKeyFile reads the key from a file, generating the file with a
+// new key if it does not exist.
+func LoadOrCreateKeyFile(path string) ([]byte, error) {
+	raw, err := ReadKeyFile(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return raw, err
+	}
+	if raw, err = GenerateKey(); err != nil {
+		return nil, err
+	}
+	if err := WriteKeyFile(path, raw); err != nil {
+		return nil, fmt.Errorf("write key file %s: %w", path, err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestKey_EncryptDecrypt(t *testing.T) {
+	raw, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key, err := NewKey(raw)
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+
+	ciphertext, err := key.Encrypt("hunter2", []byte("1/PASSWORD"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("hunter2")) {
+		t.Error("ciphertext contains the value")
+	}
+
+	got, err := key.Decrypt(ciphertext, []byte("1/PASSWORD"))
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if got != "hunter2" {
+		t.Errorf("Decrypt() = %q, want %q", got, "hunter2")
+	}
+
+	if _, err := key.Decrypt(ciphertext, []byte("2/PASSWORD")); err == nil {
+		t.Error("Decrypt() with other additional data succeeded")
+	}
+
+	other, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	otherKey, err := NewKey(other)
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	if otherKey.ID() == key.ID() {
+		t.Error("different keys have the same ID")
+	}
+	if _, err := otherKey.Decrypt(ciphertext, []byte("1/PASSWORD")); err == nil {
+		t.Error("Decrypt() with other key succeeded")
+	}
+
+	if _, err := key.Decrypt(ciphertext[:4], nil); err == nil {
+		t.Error("Decrypt() of a truncated ciphertext succeeded")
+	}
+}
+
+func TestNewKey_InvalidSize(t *testing.T) {
+	if _, err := NewKey(make([]byte, 16)); err == nil {
+		t.Error("NewKey() with 16 bytes succeeded")
+	}
+}
+
+func TestLoadOrCreateKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "secrets.key")
+
+	created, err := LoadOrCreateKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKeyFile() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key file not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm&0o077 != 0 {
+		t.Errorf("key file mode = %v, want it only readable by its owner", perm)
+	}
+
+	loaded, err := LoadOrCreateKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKeyFile() error = %v", err)
+	}
+	if !bytes.Equal(created, loaded) {
+		t.Error("LoadOrCreateKeyFile() did not load the existing key")
+	}
+
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateKeyFile(path); err == nil {
+		t.Error("LoadOrCreateKeyFile() of an invalid file succeeded")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/pogo-vcs/pogo/server/ci"
 )
 
 const assetsDir = "data/assets"
@@ -168,7 +170,7 @@ func handlePutAsset(w http.ResponseWriter, r *http.Request, repoID int32, assetN
 	ctx := r.Context()
 
 	// Check authentication and repository access
-	if !CheckRepoAccess(ctx, repoID) {
+	if !CheckRepoAccess(ctx, repoID) && !CheckCITokenPermission(ctx, repoID, ci.TokenWriteAssets) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -225,7 +227,7 @@ func handleDeleteAsset(w http.ResponseWriter, r *http.Request, repoID int32, ass
 	ctx := r.Context()
 
 	// Check authentication and repository access
-	if !CheckRepoAccess(ctx, repoID) {
+	if !CheckRepoAccess(ctx, repoID) && !CheckCITokenPermission(ctx, repoID, ci.TokenWriteAssets) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
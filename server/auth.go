@@ -66,3 +66,17 @@ func checkRepositoryAccessFromAuth(ctx context.Context, auth *protos.Auth, repos
 
 	return &user.ID, nil
 }
+
+// checkAdminFromAuth validates auth and checks that the user is an admin.
+func checkAdminFromAuth(ctx context.Context, auth *protos.Auth) (*db.User, error) {
+	user, err := getUserFromAuth(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate user: %w", err)
+	}
+
+	if !user.Admin {
+		return nil, errors.Join(auth_.ErrAccessDenied, fmt.Errorf("user %s is not an admin", user.Username))
+	}
+
+	return user, nil
+}
@@ -0,0 +1,30 @@
+package ci
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Approver holds jobs of tasks with requires_approval until they are approved.
+type Approver interface {
+	// WaitForApproval blocks until the job is approved. It returns an error
+	// if the job may not run or once ctx is done. res has the status
+	// JobStatusWaiting and the time the job started waiting.
+	WaitForApproval(ctx context.Context, res TaskExecutionResult) error
+}
+
+// SetApprover sets who approves jobs of tasks with requires_approval.
+// Without an approver, these jobs fail.
+func (e *Executor) SetApprover(approver Approver) {
+	e.approver = approver
+}
+
+func (e *Executor) waitForApproval(ctx context.Context, res TaskExecutionResult) error {
+	if e.approver == nil {
+		return errors.New("task requires approval, but no one can approve it here")
+	}
+	res.Status = JobStatusWaiting
+	res.StartedAt = time.Now()
+	return e.approver.WaitForApproval(ctx, res)
+}
@@ -0,0 +1,102 @@
+package ci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type approverFunc func(ctx context.Context, res TaskExecutionResult) error
+
+func (f approverFunc) WaitForApproval(ctx context.Context, res TaskExecutionResult) error {
+	return f(ctx, res)
+}
+
+func TestExecutor_Approval(t *testing.T) {
+	var requests atomic.Int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - name: deploy
+    requires_approval: true
+    webhook:
+      url: %s/deploy
+      method: POST
+`, testServer.URL)),
+	}
+	event := Event{Type: EventTypePush, Rev: "main"}
+
+	t.Run("approved", func(t *testing.T) {
+		requests.Store(0)
+		var waiting TaskExecutionResult
+		executor := NewExecutor()
+		executor.SetApprover(approverFunc(func(ctx context.Context, res TaskExecutionResult) error {
+			waiting = res
+			return nil
+		}))
+		results, err := executor.ExecuteForEvent(context.Background(), configFiles, event)
+		if err != nil {
+			t.Fatalf("ExecuteForEvent() error = %v", err)
+		}
+		if waiting.JobName != "deploy" || waiting.Status != JobStatusWaiting || waiting.StartedAt.IsZero() {
+			t.Errorf("approver called with job %q status %q started %v", waiting.JobName, waiting.Status, waiting.StartedAt)
+		}
+		if len(results) != 1 || results[0].Status != JobStatusSuccess {
+			t.Fatalf("results = %+v, want a successful deploy", results)
+		}
+		if requests.Load() != 1 {
+			t.Errorf("deploy ran %d times, want 1", requests.Load())
+		}
+	})
+
+	t.Run("without approver", func(t *testing.T) {
+		requests.Store(0)
+		results, err := NewExecutor().ExecuteForEvent(context.Background(), configFiles, event)
+		if err == nil {
+			t.Fatal("ExecuteForEvent() succeeded without an approver")
+		}
+		if len(results) != 1 || results[0].Status != JobStatusFailure || !strings.Contains(results[0].Log, "requires approval") {
+			t.Fatalf("results = %+v, want a failed deploy", results)
+		}
+		if requests.Load() != 0 {
+			t.Error("deploy ran without approval")
+		}
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		requests.Store(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		executor := NewExecutor()
+		executor.SetApprover(approverFunc(func(ctx context.Context, res TaskExecutionResult) error {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		results, err := executor.ExecuteForEvent(ctx, configFiles, event)
+		if !errors.Is(err, ErrCancelled) {
+			t.Fatalf("ExecuteForEvent() error = %v, want ErrCancelled", err)
+		}
+		if len(results) != 1 || results[0].Status != JobStatusCancelled {
+			t.Fatalf("results = %+v, want a cancelled deploy", results)
+		}
+		if requests.Load() != 0 {
+			t.Error("deploy ran without approval")
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package ci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Storage keeps the artifacts and caches of container jobs beyond the
+// lifetime of their containers.
+type Storage interface {
+	// StoreArtifacts stores the files of a finished job. Names are slash
+	// separated paths relative to dir.
+	StoreArtifacts(res TaskExecutionResult, dir string, names []string) error
+	// RestoreCache extracts the cache stored under key into dir and reports
+	// whether it exists.
+	RestoreCache(key string, dir string) (bool, error)
+	// StoreCache stores the content of dir under key.
+	StoreCache(key string, dir string) error
+}
+
+// SetStorage sets where artifacts and caches of container jobs are kept.
+// Without a storage, artifacts and caches are ignored.
+func (e *Executor) SetStorage(storage Storage) {
+	e.storage = storage
+}
+
+// MatchGlob reports whether the slash separated name matches the pattern.
+// Patterns are relative paths where * and ? match within one path element and
+// ** matches any number of directories. Files inside a matched directory
+// match as well.
+func MatchGlob(pattern, name string) bool {
+	patternParts := strings.Split(strings.Trim(path.Clean(pattern), "/"), "/")
+	nameParts := strings.Split(name, "/")
+	for i := 1; i <= len(nameParts); i++ {
+		if matchGlobParts(patternParts, nameParts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlobParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// checkOutputPattern checks that an artifact or report pattern stays inside
+// the working directory, the files it matches are copied to the host.
+func checkOutputPattern(pattern string) error {
+	if !filepath.IsLocal(filepath.FromSlash(pattern)) {
+		return fmt.Errorf("pattern %q must be relative to the working directory and must not leave it", pattern)
+	}
+	return nil
+}
+
+// globBase returns the leading directories of a pattern that contain no
+// wildcards, "." if the first element already has one.
+func globBase(pattern string) string {
+	parts := strings.Split(strings.Trim(path.Clean(pattern), "/"), "/")
+	var base []string
+	for _, part := range parts {
+		if strings.ContainsAny(part, "*?[\\") {
+			break
+		}
+		base = append(base, part)
+	}
+	if len(base) == 0 {
+		return "."
+	}
+	return path.Join(base...)
+}
+
+// hashFiles returns the hex encoded SHA-256 hash of the names and contents of
+// the files in dir that match one of the patterns, or "" if none match or dir
+// is empty.
+func hashFiles(dir string, patterns ...string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			if MatchGlob(pattern, rel) {
+				names = append(names, rel)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("hash files: %w", err)
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	slices.Sort(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", fmt.Errorf("hash files: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("hash files: %w", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachePath returns the absolute path of a cached path in the container.
+func cachePath(workingDir, p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(workingDir, p)
+}
+
+// restoreCaches copies the stored caches into the created container. The
+// keys of the restored caches are returned, they are not stored again.
+func (e *Executor) restoreCaches(ctx context.Context, containerName, workingDir string, caches []Cache, log io.Writer) map[string]bool {
+	restored := make(map[string]bool)
+	if len(caches) == 0 {
+		return restored
+	}
+	if e.storage == nil {
+		fmt.Fprintf(log, "Skipping caches, they are not kept by this executor\n")
+		return restored
+	}
+
+	for _, cache := range caches {
+		dir, err := os.MkdirTemp("", "pogo-ci-cache-")
+		if err != nil {
+			fmt.Fprintf(log, "Restoring cache %s failed: %v\n", cache.Key, err)
+			continue
+		}
+
+		found, err := e.storage.RestoreCache(cache.Key, dir)
+		switch {
+		case err != nil:
+			fmt.Fprintf(log, "Restoring cache %s failed: %v\n", cache.Key, err)
+		case !found:
+			fmt.Fprintf(log, "No cache found for key %s\n", cache.Key)
+		default:
+			if err := e.dockerClient.CopyToContainer(ctx, containerName, dir, "/"); err != nil {
+				fmt.Fprintf(log, "Restoring cache %s failed: %v\n", cache.Key, err)
+			} else {
+				fmt.Fprintf(log, "Restored cache %s\n", cache.Key)
+				restored[cache.Key] = true
+			}
+		}
+		os.RemoveAll(dir)
+	}
+	return restored
+}
+
+// saveCaches copies the cached paths out of the container and stores them
+// under their keys, except for the caches that were restored.
+func (e *Executor) saveCaches(ctx context.Context, containerName, workingDir string, caches []Cache, restored map[string]bool, log io.Writer) {
+	if e.storage == nil {
+		return
+	}
+
+	for _, cache := range caches {
+		if restored[cache.Key] {
+			continue
+		}
+		if err := e.saveCache(ctx, containerName, workingDir, cache); err != nil {
+			fmt.Fprintf(log, "Saving cache %s failed: %v\n", cache.Key, err)
+			continue
+		}
+		fmt.Fprintf(log, "Saved cache %s\n", cache.Key)
+	}
+}
+
+func (e *Executor) saveCache(ctx context.Context, containerName, workingDir string, cache Cache) error {
+	dir, err := os.MkdirTemp("", "pogo-ci-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, p := range cache.Paths {
+		src := cachePath(workingDir, p)
+		dst := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(src, "/")))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := e.dockerClient.CopyFromContainer(ctx, containerName, src, dst); err != nil {
+			return err
+		}
+	}
+	return e.storage.StoreCache(cache.Key, dir)
+}
+
+// collectArtifacts copies the files matching the artifact patterns out of the
+// container. It returns the directory holding them, which the caller removes,
+// and their names relative to the working directory.
+func (e *Executor) collectArtifacts(ctx context.Context, containerName, workingDir string, patterns []string, log io.Writer) (string, []string, error) {
+	if e.storage == nil {
+		fmt.Fprintf(log, "Skipping artifacts, they are not kept by this executor\n")
+		return "", nil, nil
+	}
+
+	dir, names, err := e.copyMatchingFiles(ctx, containerName, workingDir, patterns, "artifacts", log)
+	if err != nil {
+		return "", nil, err
+	}
+	fmt.Fprintf(log, "Collected %d artifacts\n", len(names))
+	return dir, names, nil
+}
+
+// copyMatchingFiles copies the files matching the patterns out of the
+// container into a new directory, which the caller removes. The names of the
+// files are relative to the working directory, kind names them in the log.
+func (e *Executor) copyMatchingFiles(ctx context.Context, containerName, workingDir string, patterns []string, kind string, log io.Writer) (string, []string, error) {
+	dir, err := os.MkdirTemp("", "pogo-ci-"+kind+"-")
+	if err != nil {
+		return "", nil, err
+	}
+	// The copy creates the directory, a whole working directory is copied into it
+	if err := os.Remove(dir); err != nil {
+		return "", nil, err
+	}
+
+	// Copy the directories the patterns start in, skipping those inside another
+	bases := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		bases = append(bases, globBase(pattern))
+	}
+	if slices.Contains(bases, ".") {
+		bases = []string{"."}
+	}
+	slices.Sort(bases)
+	var copied []string
+	for _, base := range bases {
+		if slices.ContainsFunc(copied, func(c string) bool {
+			return base == c || strings.HasPrefix(base, c+"/")
+		}) {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(base))
+		// Matrix values are only known after the config was checked
+		if rel, err := filepath.Rel(dir, dst); err != nil || !filepath.IsLocal(rel) {
+			fmt.Fprintf(log, "Skipping %s outside the working directory: %s\n", kind, base)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		if err := e.dockerClient.CopyFromContainer(ctx, containerName, path.Join(workingDir, base), dst); err != nil {
+			fmt.Fprintf(log, "No %s found in %s\n", kind, base)
+			continue
+		}
+		copied = append(copied, base)
+	}
+
+	var names []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			if MatchGlob(pattern, rel) {
+				names = append(names, rel)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, names, nil
+}
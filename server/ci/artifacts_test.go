@@ -0,0 +1,142 @@
+package ci
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"dist/app", "dist/app", true},
+		{"dist", "dist/app", true},
+		{"dist", "src/dist/app", false},
+		{"*.txt", "report.txt", true},
+		{"*.txt", "out/report.txt", false},
+		{"**/*.txt", "out/report.txt", true},
+		{"**/*.txt", "report.txt", true},
+		{"out/**/coverage.xml", "out/a/b/coverage.xml", true},
+		{"out/**/coverage.xml", "out/coverage.xml", true},
+		{"out/*.tar.gz", "out/app.tar.gz", true},
+		{"out/*.tar.gz", "out/app.zip", false},
+		{"./bin/", "bin/pogo", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	tests := map[string]string{
+		"dist/app":        "dist/app",
+		"dist/*.tar.gz":   "dist",
+		"out/**/cov.xml":  "out",
+		"*.txt":           ".",
+		"**/report.xml":   ".",
+		"./build/[ab].go": "build",
+	}
+
+	for pattern, want := range tests {
+		if got := globBase(pattern); got != want {
+			t.Errorf("globBase(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "go.sum"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := hashFiles(dir, "go.sum")
+	if err != nil {
+		t.Fatalf("hashFiles() error = %v", err)
+	}
+	all, err := hashFiles(dir, "**/go.sum")
+	if err != nil {
+		t.Fatalf("hashFiles() error = %v", err)
+	}
+	if root == "" || all == "" || root == all {
+		t.Errorf("hashFiles() = %q and %q, want two different hashes", root, all)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := hashFiles(dir, "go.sum")
+	if err != nil {
+		t.Fatalf("hashFiles() error = %v", err)
+	}
+	if changed == root {
+		t.Error("hashFiles() did not change with the file content")
+	}
+
+	if none, err := hashFiles(dir, "package-lock.json"); err != nil || none != "" {
+		t.Errorf("hashFiles() without matches = %q, %v, want empty", none, err)
+	}
+	if none, err := hashFiles("", "go.sum"); err != nil || none != "" {
+		t.Errorf("hashFiles() without directory = %q, %v, want empty", none, err)
+	}
+}
+
+func TestUnmarshalConfigArtifactsAndCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := hashFiles(dir, "go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configYAML := []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - container:
+      image: golang:latest
+      commands: ["go build -o dist/app ."]
+      artifacts: ["dist/*"]
+      cache:
+        - key: go-{{ hashFiles "go.sum" }}
+          paths: ["/root/go/pkg/mod"]
+`)
+
+	config, _, err := unmarshalConfig(configYAML, Event{Rev: "main"}, nil, nil, dir, false)
+	if err != nil {
+		t.Fatalf("unmarshalConfig() error = %v", err)
+	}
+
+	task := config.Do[0].Container
+	if len(task.Artifacts) != 1 || task.Artifacts[0] != "dist/*" {
+		t.Errorf("Artifacts = %v, want [dist/*]", task.Artifacts)
+	}
+	if len(task.Cache) != 1 {
+		t.Fatalf("Cache = %v, want one entry", task.Cache)
+	}
+	if want := "go-" + sum; task.Cache[0].Key != want {
+		t.Errorf("Cache key = %q, want %q", task.Cache[0].Key, want)
+	}
+	if cachePath("/workspace", task.Cache[0].Paths[0]) != "/root/go/pkg/mod" {
+		t.Errorf("cachePath() = %q", cachePath("/workspace", task.Cache[0].Paths[0]))
+	}
+	if got := cachePath("/workspace", "node_modules"); got != "/workspace/node_modules" {
+		t.Errorf("cachePath() = %q, want /workspace/node_modules", got)
+	}
+}
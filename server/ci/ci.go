@@ -13,6 +13,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -28,8 +30,20 @@ var (
 type EventType uint8
 
 const (
+	// EventTypePush is a bookmark being created or moved
 	EventTypePush EventType = iota
+	// EventTypeRemove is a bookmark being removed
 	EventTypeRemove
+	// EventTypeChangePush is new content being pushed to any change
+	EventTypeChangePush
+	// EventTypeNewChange is a change with a single parent being created
+	EventTypeNewChange
+	// EventTypeMerge is a change with multiple parents being created
+	EventTypeMerge
+	// EventTypeSchedule is a cron schedule firing
+	EventTypeSchedule
+	// EventTypeManual is a run dispatched with pogo ci run
+	EventTypeManual
 )
 
 func (t EventType) String() string {
@@ -38,17 +52,42 @@ func (t EventType) String() string {
 		return "push"
 	case EventTypeRemove:
 		return "remove"
+	case EventTypeChangePush:
+		return "change_push"
+	case EventTypeNewChange:
+		return "new_change"
+	case EventTypeMerge:
+		return "merge"
+	case EventTypeSchedule:
+		return "schedule"
+	case EventTypeManual:
+		return "manual"
 	default:
 		return "unknown"
 	}
 }
 
+func ParseEventType(s string) (EventType, error) {
+	for t := EventTypePush; t <= EventTypeManual; t++ {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event type %q", s)
+}
+
 type (
 	Config struct {
 		// CI version
 		Version int `yaml:"version" json:"version"`
 		// On what events should the CI run
 		On On `yaml:"on" json:"on"`
+		// Labels a runner must have to run the pipeline, without labels it may also run on the server
+		RunsOn []string `yaml:"runs_on,omitempty" json:"runs_on,omitempty"`
+		// Lifetime and permissions of the access token of the runs
+		Token *TokenConfig `yaml:"token,omitempty" json:"token,omitempty"`
+		// Environment whose secrets the config receives, it must allow the bookmark of the event
+		Environment string `yaml:"environment,omitempty" json:"environment,omitempty"`
 		// Tasks to run when the events are triggered
 		Do []Task `yaml:"do" json:"do"`
 	}
@@ -57,20 +96,64 @@ type (
 		Push *OnPush `yaml:"push,omitempty" json:"push,omitempty"`
 		// Remove events
 		Remove *OnRemove `yaml:"remove,omitempty" json:"remove,omitempty"`
+		// Change events
+		Change *OnChange `yaml:"change,omitempty" json:"change,omitempty"`
+		// Cron schedules
+		Schedule []OnSchedule `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+		// Manual dispatch with pogo ci run
+		Manual *OnManual `yaml:"manual,omitempty" json:"manual,omitempty"`
 	}
 	OnPush struct {
 		// Bookmark globs that, when matched a created or updated bookmark name, will trigger the CI
-		Bookmarks []string `yaml:"bookmarks" json:"bookmarks"`
+		Bookmarks  []string `yaml:"bookmarks" json:"bookmarks"`
+		PathFilter `yaml:",inline"`
 	}
 	OnRemove struct {
 		// Bookmark globs that, when matched a removed bookmark name, will trigger the CI
 		Bookmarks []string `yaml:"bookmarks" json:"bookmarks"`
 	}
+	OnChange struct {
+		// Run when new content is pushed to any change
+		Push bool `yaml:"push,omitempty" json:"push,omitempty"`
+		// Run when a change with a single parent is created
+		New bool `yaml:"new,omitempty" json:"new,omitempty"`
+		// Run when a merge change is created
+		Merge      bool `yaml:"merge,omitempty" json:"merge,omitempty"`
+		PathFilter `yaml:",inline"`
+	}
+	PathFilter struct {
+		// Only trigger if a changed file matches one of these patterns
+		Paths []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+		// Do not trigger if all changed files match one of these patterns
+		PathsIgnore []string `yaml:"paths_ignore,omitempty" json:"paths_ignore,omitempty"`
+	}
+	OnSchedule struct {
+		// Cron expression (minute hour day-of-month month day-of-week)
+		Cron string `yaml:"cron" json:"cron"`
+		// Bookmark globs whose current revision is used for the scheduled run
+		Bookmarks []string `yaml:"bookmarks" json:"bookmarks"`
+	}
+	OnManual struct {
+		// Inputs accepted by the run with their default values
+		Inputs map[string]string `yaml:"inputs,omitempty" json:"inputs,omitempty"`
+	}
 	Task struct {
+		// Name used to refer to the task in needs and if expressions
+		Name string `yaml:"name,omitempty" json:"name,omitempty"`
+		// Names of the tasks that must finish before this task starts
+		Needs []string `yaml:"needs,omitempty" json:"needs,omitempty"`
+		// Condition that must be true to run the task, defaults to all needed tasks succeeding
+		If string `yaml:"if,omitempty" json:"if,omitempty"`
+		// Runs the task once for every combination of the matrix values
+		Matrix *Matrix `yaml:"matrix,omitempty" json:"matrix,omitempty"`
+		// Hold the task until a user with access to the repository approves it, e.g. for deploys
+		RequiresApproval bool `yaml:"requires_approval,omitempty" json:"requires_approval,omitempty"`
 		// Webhook-specific fields
 		Webhook *WebhookTask `yaml:"webhook,omitempty" json:"webhook,omitempty"`
 		// Container-specific fields
 		Container *ContainerTask `yaml:"container,omitempty" json:"container,omitempty"`
+		// Process-specific fields
+		Process *ProcessTask `yaml:"process,omitempty" json:"process,omitempty"`
 	}
 	WebhookTask struct {
 		// Url to make a HTTP request to
@@ -95,6 +178,42 @@ type (
 		WorkingDir string `yaml:"working_dir,omitempty" json:"working_dir,omitempty"`
 		// Services to start alongside the main container
 		Services []Service `yaml:"services,omitempty" json:"services,omitempty"`
+		// Gitignore-style patterns of files, relative to the working directory, kept after the run
+		Artifacts []string `yaml:"artifacts,omitempty" json:"artifacts,omitempty"`
+		// Directories restored from and saved for runs with the same key
+		Cache []Cache `yaml:"cache,omitempty" json:"cache,omitempty"`
+		// Test reports, relative to the working directory, parsed after the run
+		Reports []Report `yaml:"reports,omitempty" json:"reports,omitempty"`
+		// Maximum run time of the task, e.g. 30m
+		Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+		// Number of CPUs each container may use, e.g. 1.5
+		CPU float64 `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+		// Memory limit of each container, e.g. 512m or 2g
+		Memory string `yaml:"memory,omitempty" json:"memory,omitempty"`
+		// Network access: bridge (default) reaches the internet and the services, services only the services, none nothing
+		Network string `yaml:"network,omitempty" json:"network,omitempty"`
+	}
+	ProcessTask struct {
+		// Commands to run with the shell of the host, one after another
+		Commands []string `yaml:"commands" json:"commands"`
+		// Environment variables to set, only allowlisted variables of the host are kept
+		Environment map[string]string `yaml:"environment,omitempty" json:"environment,omitempty"`
+		// Working directory relative to the repository content
+		WorkingDir string `yaml:"working_dir,omitempty" json:"working_dir,omitempty"`
+		// Maximum run time of the task, e.g. 30m
+		Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	}
+	Cache struct {
+		// Key of the cache, e.g. go-{{ hashFiles "go.sum" }}
+		Key string `yaml:"key" json:"key"`
+		// Paths relative to the working directory that are cached
+		Paths []string `yaml:"paths" json:"paths"`
+	}
+	Report struct {
+		// Format of the files: junit or go-test
+		Format string `yaml:"format" json:"format"`
+		// Paths relative to the working directory, * ? and ** match like in artifacts
+		Paths []string `yaml:"paths" json:"paths"`
 	}
 	Service struct {
 		// Name of the service (used for network hostname)
@@ -110,6 +229,25 @@ type (
 	}
 )
 
+// Attempts returns the number of attempts the policy allows, at least one.
+func (p *RetryPolicy) Attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns how long to wait after the given failed attempt, counting
+// from 1, before the next one. The delay starts at base and doubles with every
+// attempt up to limit.
+func (p *RetryPolicy) Backoff(attempt int, base, limit time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
 type TaskExecutionResult struct {
 	ConfigFilename string
 	EventType      EventType
@@ -117,11 +255,22 @@ type TaskExecutionResult struct {
 	Pattern        string
 	Reason         string
 	TaskType       string
+	JobName        string
+	Needs          []string
+	Status         JobStatus
 	StatusCode     int
 	Success        bool
 	StartedAt      time.Time
 	FinishedAt     time.Time
 	Log            string
+	// Matrix holds the matrix values the job ran with
+	Matrix map[string]string
+	// Artifacts lists the names of the stored artifacts
+	Artifacts []string
+	// Tests holds the results parsed from the reports of the job
+	Tests []TestResult
+	// artifactDir holds the collected artifacts until they are stored
+	artifactDir string
 }
 
 type Event struct {
@@ -139,10 +288,102 @@ type Event struct {
 	ServerUrl string
 	// RepositoryID is the ID of the repository (needed for asset URLs)
 	RepositoryID int32
+	// Type is the kind of event that triggered the run
+	Type EventType
+	// Schedule is the cron expression that fired, only set for schedule events
+	Schedule string
+	// Inputs are the inputs of a manual run, with defaults applied
+	Inputs map[string]string
+	// ChangedFiles are the paths changed by the event, nil if unknown
+	ChangedFiles []string
+	// ChangedPaths are the changed files with how they changed, nil if unknown
+	ChangedPaths []ChangedPath
+	// ChangeName is the name of the change the event runs on
+	ChangeName string
+	// ChangeID is the ID of the change the event runs on
+	ChangeID int64
+	// Parents are the names of the parent changes of the change
+	Parents []string
+	// Bookmark is the pushed, removed or scheduled bookmark, empty for other events
+	Bookmark string
+	// Pattern is the bookmark glob of the config that matched, set once the config is triggered
+	Pattern string
+	// PreviousChangeName is the change the bookmark pointed to before a push, empty for new bookmarks
+	PreviousChangeName string
+	// TriggeredBy is the username of the user who caused the event, empty for schedules
+	TriggeredBy string
+}
+
+// ResolveInputs applies the declared defaults to the given inputs and rejects undeclared ones.
+func (m *OnManual) ResolveInputs(given map[string]string) (map[string]string, error) {
+	inputs := make(map[string]string, len(m.Inputs))
+	for key, value := range m.Inputs {
+		inputs[key] = value
+	}
+	for key, value := range given {
+		if _, ok := m.Inputs[key]; !ok {
+			return nil, fmt.Errorf("unknown input %q", key)
+		}
+		inputs[key] = value
+	}
+	return inputs, nil
 }
 
-func makeUnmarshalConfigFuncs(secrets map[string]string) template.FuncMap {
+// Trigger reports whether the config runs for the event and which pattern matched.
+func (c *Config) Trigger(event Event) (string, bool) {
+	switch event.Type {
+	case EventTypePush:
+		if c.On.Push != nil && c.On.Push.PathFilter.Match(event.ChangedFiles) {
+			return matchAny(event.Rev, c.On.Push.Bookmarks)
+		}
+	case EventTypeRemove:
+		if c.On.Remove != nil {
+			return matchAny(event.Rev, c.On.Remove.Bookmarks)
+		}
+	case EventTypeChangePush:
+		return "", c.On.Change != nil && c.On.Change.Push && c.On.Change.PathFilter.Match(event.ChangedFiles)
+	case EventTypeNewChange:
+		return "", c.On.Change != nil && c.On.Change.New
+	case EventTypeMerge:
+		return "", c.On.Change != nil && c.On.Change.Merge
+	case EventTypeSchedule:
+		for _, schedule := range c.On.Schedule {
+			if schedule.Cron != event.Schedule {
+				continue
+			}
+			if _, ok := matchAny(event.Rev, schedule.Bookmarks); ok {
+				return schedule.Cron, true
+			}
+		}
+	case EventTypeManual:
+		return "", c.On.Manual != nil
+	}
+	return "", false
+}
+
+func matchAny(str string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if matchesPattern(str, pattern) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// makeUnmarshalConfigFuncs returns the functions available in configs.
+// hashFiles hashes the files of contentDir and is empty without one, like when
+// the triggers of a config are checked. matrix is replaced by the value of a
+// matrix task once the task is expanded.
+// withheld are the keys of secrets of environments the config may not use.
+// Only with envSecrets, like when configs are tested locally, secrets fall back
+// to environment variables; on servers and runners they would expose the
+// environment of the host.
+func makeUnmarshalConfigFuncs(secrets map[string]string, withheld map[string][]string, contentDir string, envSecrets bool) template.FuncMap {
 	return template.FuncMap{
+		"hashFiles": func(patterns ...string) (string, error) {
+			return hashFiles(contentDir, patterns...)
+		},
+		"matrix":  matrixPlaceholder,
 		"toUpper": strings.ToUpper,
 		"toLower": strings.ToLower,
 		"trim":    strings.TrimSpace,
@@ -155,11 +396,16 @@ func makeUnmarshalConfigFuncs(secrets map[string]string) template.FuncMap {
 					return value, nil
 				}
 			}
-			// Fall back to environment variables (useful for local testing)
-			if value := os.Getenv(key); value != "" {
-				return value, nil
+			if environments, ok := withheld[key]; ok {
+				return "", withheldSecretError(key, environments)
+			}
+			if envSecrets {
+				if value := os.Getenv(key); value != "" {
+					return value, nil
+				}
+				return "", fmt.Errorf("secret %q not found (checked secrets store and environment variables)", key)
 			}
-			return "", fmt.Errorf("secret %q not found (checked secrets store and environment variables)", key)
+			return "", fmt.Errorf("secret %q not found", key)
 		},
 	}
 }
@@ -169,10 +415,30 @@ func UnmarshalConfig(yamlString []byte, data Event) (*Config, string, error) {
 }
 
 func UnmarshalConfigWithSecrets(yamlString []byte, data Event, secrets map[string]string) (*Config, string, error) {
+	return unmarshalConfig(yamlString, data, secrets, nil, "", false)
+}
+
+// UnmarshalConfigWithScope renders a config with the shared secrets and the
+// secrets of the environment it declares. It fails if the event triggers the
+// config, but the environment does not exist or does not allow the bookmark
+// of the event. Configs the event does not trigger are rendered without
+// secrets.
+func UnmarshalConfigWithScope(yamlString []byte, data Event, secrets Secrets) (*Config, string, error) {
+	values, withheld, untriggered, err := secrets.forConfig(yamlString, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if untriggered != nil {
+		return untriggered, "", nil
+	}
+	return unmarshalConfig(yamlString, data, values, withheld, "", false)
+}
+
+func unmarshalConfig(yamlString []byte, data Event, secrets map[string]string, withheld map[string][]string, contentDir string, envSecrets bool) (*Config, string, error) {
 	var c Config
 
 	t, err := template.New("ci_config").
-		Funcs(makeUnmarshalConfigFuncs(secrets)).
+		Funcs(makeUnmarshalConfigFuncs(secrets, withheld, contentDir, envSecrets)).
 		Parse(string(yamlString))
 
 	if err != nil {
@@ -182,6 +448,9 @@ func UnmarshalConfigWithSecrets(yamlString []byte, data Event, secrets map[strin
 				fmt.Errorf("parse template: %w", err),
 			)
 		}
+		if err := c.checkOutputPaths(); err != nil {
+			return nil, "", err
+		}
 		warning := fmt.Sprintf("WARNING: Template parsing failed (%v), falling back to plain YAML. "+
 			"Template expressions like {{secret \"...\"}} and {{.ArchiveUrl}} will NOT be replaced.", err)
 		return &c, warning, nil
@@ -196,15 +465,73 @@ func UnmarshalConfigWithSecrets(yamlString []byte, data Event, secrets map[strin
 	if err := yaml.Unmarshal([]byte(sb.String()), &c); err != nil {
 		return nil, "", err
 	}
+	if err := c.checkOutputPaths(); err != nil {
+		return nil, "", err
+	}
 
 	return &c, "", nil
 }
 
+// checkOutputPaths rejects artifact and report patterns that leave the
+// working directory.
+func (c *Config) checkOutputPaths() error {
+	for i, task := range c.Do {
+		if task.Container == nil {
+			continue
+		}
+		for _, pattern := range task.Container.Artifacts {
+			if err := checkOutputPattern(pattern); err != nil {
+				return fmt.Errorf("do[%d].container.artifacts: %w", i, err)
+			}
+		}
+		for j, report := range task.Container.Reports {
+			for _, pattern := range report.Paths {
+				if err := checkOutputPattern(pattern); err != nil {
+					return fmt.Errorf("do[%d].container.reports[%d].paths: %w", i, j, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 type Executor struct {
 	httpClient     *http.Client
 	dockerClient   docker.Client
 	repoContentDir string
 	secrets        map[string]string
+	withheld       map[string][]string
+	scope          *Secrets
+	observer       JobObserver
+	storage        Storage
+	approver       Approver
+	envSecrets     bool
+}
+
+// ErrCancelled is returned when the context of a pipeline was cancelled before all jobs finished.
+var ErrCancelled = errors.New("pipeline cancelled")
+
+// syncWriter serializes writes of concurrently copied stdout and stderr.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// JobObserver is notified about the jobs of a pipeline while they run.
+// Its methods are called concurrently for independent jobs.
+type JobObserver interface {
+	// JobStarted is called before a job runs. The log output of the job is
+	// also written to the returned writer, which may be nil.
+	JobStarted(res TaskExecutionResult) io.Writer
+	// JobFinished is called with the final result of every job, including
+	// skipped and cancelled ones.
+	JobFinished(res TaskExecutionResult)
 }
 
 func NewExecutor() *Executor {
@@ -221,11 +548,37 @@ func (e *Executor) SetRepoContentDir(dir string) {
 	e.repoContentDir = dir
 }
 
+// SetSecrets sets the secrets of every config, e.g. the ones the server
+// resolved for the config of a pipeline.
 func (e *Executor) SetSecrets(secrets map[string]string) {
 	e.secrets = secrets
+	e.scope = nil
+}
+
+// SetSecretScope sets the secrets of a repository, each config gets the shared
+// secrets and those of the environment it declares.
+func (e *Executor) SetSecretScope(secrets Secrets) {
+	e.scope = &secrets
+}
+
+// SetEnvironmentSecrets lets configs read secrets missing from the secrets of
+// the executor from environment variables, for testing configs locally.
+func (e *Executor) SetEnvironmentSecrets(enabled bool) {
+	e.envSecrets = enabled
+}
+
+// SetObserver sets the observer that follows the jobs while they run.
+func (e *Executor) SetObserver(observer JobObserver) {
+	e.observer = observer
 }
 
 func (e *Executor) ExecuteForBookmarkEvent(ctx context.Context, configFiles map[string][]byte, event Event, eventType EventType) ([]TaskExecutionResult, error) {
+	event.Type = eventType
+	return e.ExecuteForEvent(ctx, configFiles, event)
+}
+
+// ExecuteForEvent runs the tasks of every config that is triggered by the event.
+func (e *Executor) ExecuteForEvent(ctx context.Context, configFiles map[string][]byte, event Event) ([]TaskExecutionResult, error) {
 	var allResults []TaskExecutionResult
 
 	for filename, configData := range configFiles {
@@ -233,98 +586,290 @@ func (e *Executor) ExecuteForBookmarkEvent(ctx context.Context, configFiles map[
 			continue
 		}
 
-		config, configWarning, err := UnmarshalConfigWithSecrets(configData, event, e.secrets)
+		ce := e
+		if e.scope != nil {
+			secrets, withheld, untriggered, err := e.scope.forConfig(configData, event)
+			if untriggered != nil {
+				continue
+			}
+			if err != nil {
+				return allResults, fmt.Errorf("secrets for config %s: %w", filename, err)
+			}
+			scoped := *e
+			scoped.secrets, scoped.withheld = secrets, withheld
+			ce = &scoped
+		}
+
+		config, configWarning, err := unmarshalConfig(configData, event, ce.secrets, ce.withheld, ce.repoContentDir, ce.envSecrets)
 		if err != nil {
 			return allResults, fmt.Errorf("unmarshal config %s: %w", filename, err)
 		}
 
-		if eventType == EventTypePush && config.On.Push != nil {
-			for _, pattern := range config.On.Push.Bookmarks {
-				if matchesPattern(event.Rev, pattern) {
-					reason := fmt.Sprintf("config=%s event=%s rev=%s pattern=%s", filename, eventType.String(), event.Rev, pattern)
-					fmt.Printf("CI run reason: %s\n", reason)
-					taskResults, execErr := e.executeTasks(ctx, config.Do)
-					for i := range taskResults {
-						taskResults[i].ConfigFilename = filename
-						taskResults[i].EventType = eventType
-						taskResults[i].Rev = event.Rev
-						taskResults[i].Pattern = pattern
-						taskResults[i].Reason = reason
-						if configWarning != "" {
-							taskResults[i].Log = configWarning + "\n\n" + taskResults[i].Log
+		pattern, ok := config.Trigger(event)
+		if !ok {
+			continue
+		}
+
+		// Render the config again now that the matched pattern and the
+		// declared defaults of manual inputs are known
+		triggered := event
+		triggered.Pattern = pattern
+		if event.Type == EventTypeManual {
+			inputs, err := config.On.Manual.ResolveInputs(event.Inputs)
+			if err != nil {
+				return allResults, fmt.Errorf("resolve inputs for %s: %w", filename, err)
+			}
+			triggered.Inputs = inputs
+		}
+		if triggered.Pattern != event.Pattern || event.Type == EventTypeManual {
+			if config, configWarning, err = unmarshalConfig(configData, triggered, ce.secrets, ce.withheld, ce.repoContentDir, ce.envSecrets); err != nil {
+				return allResults, fmt.Errorf("unmarshal config %s: %w", filename, err)
+			}
+		}
+
+		reason := fmt.Sprintf("config=%s event=%s rev=%s pattern=%s", filename, event.Type.String(), event.Rev, pattern)
+		fmt.Printf("CI run reason: %s\n", reason)
+		base := TaskExecutionResult{
+			ConfigFilename: filename,
+			EventType:      event.Type,
+			Rev:            event.Rev,
+			Pattern:        pattern,
+			Reason:         reason,
+		}
+		taskResults, execErr := ce.executeTasks(ctx, config.Do, triggered, base, configWarning)
+		allResults = append(allResults, taskResults...)
+		if execErr != nil {
+			return allResults, fmt.Errorf("execute tasks for %s: %w", filename, execErr)
+		}
+	}
+	return allResults, nil
+}
+
+// executeTasks runs the tasks as a graph of jobs. A job starts once all jobs
+// it needs have finished. Jobs whose if expression is false, or that have no
+// if expression and a failed ancestor, are skipped. Once ctx is cancelled,
+// running jobs are stopped and the remaining ones are not started.
+// Every result starts as a copy of base, the preamble is put in front of each log.
+func (e *Executor) executeTasks(ctx context.Context, tasks []Task, event Event, base TaskExecutionResult, preamble string) ([]TaskExecutionResult, error) {
+	jobs, err := buildJobGraph(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TaskExecutionResult, len(jobs))
+	errs := make([]error, len(jobs))
+	done := make([]chan struct{}, len(jobs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			j := jobs[i]
+
+			taskCtx := TaskContext{Event: event, Success: true, Tasks: make(map[string]TaskStatus), Matrix: j.matrix}
+			for _, a := range j.ancestors {
+				<-done[a]
+				ancestor := results[a]
+				status := TaskStatus{
+					Success:    ancestor.Status == JobStatusSuccess,
+					Skipped:    ancestor.Status == JobStatusSkipped,
+					StatusCode: ancestor.StatusCode,
+				}
+				taskCtx.Tasks[jobs[a].name] = status
+				if group := jobs[a].group; group != "" {
+					// A matrix task succeeded if all of its jobs did
+					if groupStatus, ok := taskCtx.Tasks[group]; ok {
+						status.Success = status.Success && groupStatus.Success
+						status.Skipped = status.Skipped && groupStatus.Skipped
+						if groupStatus.StatusCode != 0 {
+							status.StatusCode = groupStatus.StatusCode
 						}
 					}
-					allResults = append(allResults, taskResults...)
-					if execErr != nil {
-						return allResults, fmt.Errorf("execute tasks for %s: %w", filename, execErr)
-					}
-					break
+					taskCtx.Tasks[group] = status
+				}
+				if ancestor.Status == JobStatusFailure || ancestor.Status == JobStatusCancelled {
+					taskCtx.Success = false
+					taskCtx.Failure = true
 				}
 			}
-		} else if eventType == EventTypeRemove && config.On.Remove != nil {
-			for _, pattern := range config.On.Remove.Bookmarks {
-				if matchesPattern(event.Rev, pattern) {
-					reason := fmt.Sprintf("config=%s event=%s rev=%s pattern=%s", filename, eventType.String(), event.Rev, pattern)
-					fmt.Printf("CI run reason: %s\n", reason)
-					taskResults, execErr := e.executeTasks(ctx, config.Do)
-					for i := range taskResults {
-						taskResults[i].ConfigFilename = filename
-						taskResults[i].EventType = eventType
-						taskResults[i].Rev = event.Rev
-						taskResults[i].Pattern = pattern
-						taskResults[i].Reason = reason
-						if configWarning != "" {
-							taskResults[i].Log = configWarning + "\n\n" + taskResults[i].Log
-						}
+
+			res := base
+			res.TaskType = taskType(j.task)
+			res.JobName = j.name
+			res.Needs = j.needs
+			res.Matrix = j.matrix
+
+			run, err := e.evaluateCondition(j.task.If, taskCtx)
+			switch {
+			case ctx.Err() != nil:
+				res.Status = JobStatusCancelled
+				err = nil
+			case err != nil:
+				res.StatusCode = -1
+				res.StartedAt = time.Now()
+				res.FinishedAt = time.Now()
+				res.Log = err.Error()
+			case !run:
+				fmt.Printf("Skipping task %s (%s)\n", j.name, res.TaskType)
+				res.Status = JobStatusSkipped
+			default:
+				if j.task.RequiresApproval {
+					// Wait before taking a slot, so waiting jobs don't block others
+					err = e.waitForApproval(ctx, res)
+					switch {
+					case ctx.Err() != nil:
+						res.Status = JobStatusCancelled
+						err = nil
+					case err != nil:
+						res.StatusCode = -1
+						res.StartedAt = time.Now()
+						res.FinishedAt = time.Now()
+						res.Log = err.Error()
 					}
-					allResults = append(allResults, taskResults...)
-					if execErr != nil {
-						return allResults, fmt.Errorf("execute tasks for %s: %w", filename, execErr)
+					if res.Status == JobStatusCancelled || err != nil {
+						break
 					}
+				}
+				if j.slots != nil {
+					select {
+					case j.slots <- struct{}{}:
+						defer func() { <-j.slots }()
+					case <-ctx.Done():
+						res.Status = JobStatusCancelled
+					}
+				}
+				if (j.task.Container != nil || j.task.Process != nil) && res.Status != JobStatusCancelled {
+					select {
+					case jobSlots <- struct{}{}:
+						defer func() { <-jobSlots }()
+					case <-ctx.Done():
+						res.Status = JobStatusCancelled
+					}
+				}
+				if res.Status == JobStatusCancelled {
 					break
 				}
+
+				var out io.Writer
+				if e.observer != nil {
+					started := res
+					started.Status = JobStatusRunning
+					started.StartedAt = time.Now()
+					out = e.observer.JobStarted(started)
+				}
+				if out == nil {
+					out = io.Discard
+				}
+				if preamble != "" {
+					fmt.Fprintf(out, "%s\n\n", preamble)
+				}
+
+				var taskRes TaskExecutionResult
+				taskRes, err = e.executeTask(ctx, j.task.withEventEnv(event), out)
+				res.StatusCode = taskRes.StatusCode
+				res.Success = taskRes.Success
+				res.StartedAt = taskRes.StartedAt
+				res.FinishedAt = taskRes.FinishedAt
+				res.Log = taskRes.Log
+				if preamble != "" {
+					res.Log = preamble + "\n\n" + res.Log
+				}
+				if taskRes.artifactDir != "" {
+					if len(taskRes.Artifacts) > 0 {
+						if err := e.storage.StoreArtifacts(res, taskRes.artifactDir, taskRes.Artifacts); err != nil {
+							msg := fmt.Sprintf("Storing artifacts failed: %v\n", err)
+							fmt.Fprint(out, msg)
+							res.Log += msg
+						} else {
+							res.Artifacts = taskRes.Artifacts
+						}
+					}
+					os.RemoveAll(taskRes.artifactDir)
+				}
+				if !res.Success && ctx.Err() != nil {
+					res.Status = JobStatusCancelled
+					err = nil
+				}
 			}
-		}
+
+			if res.Status == "" {
+				if err == nil && res.Success {
+					res.Status = JobStatusSuccess
+				} else {
+					res.Status = JobStatusFailure
+				}
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("execute task %s: %w", j.name, err)
+			}
+			results[i] = res
+			if e.observer != nil {
+				e.observer.JobFinished(res)
+			}
+		}(i)
 	}
-	return allResults, nil
-}
+	wg.Wait()
 
-func (e *Executor) executeTasks(ctx context.Context, tasks []Task) ([]TaskExecutionResult, error) {
-	var results []TaskExecutionResult
-	for _, task := range tasks {
-		result, err := e.executeTask(ctx, task)
-		results = append(results, result)
+	for _, err := range errs {
 		if err != nil {
-			return results, fmt.Errorf("execute task: %w", err)
+			return results, err
 		}
 	}
+	if ctx.Err() != nil {
+		return results, ErrCancelled
+	}
 	return results, nil
 }
 
-func (e *Executor) executeTask(ctx context.Context, task Task) (TaskExecutionResult, error) {
+func taskType(task Task) string {
 	switch {
 	case task.Webhook != nil:
-		return e.executeWebhookTask(ctx, *task.Webhook)
+		return "webhook"
+	case task.Container != nil && processConfig.Mode == ProcessModeForce:
+		return "process"
 	case task.Container != nil:
-		return e.executeContainerTask(ctx, *task.Container)
+		return "container"
+	case task.Process != nil:
+		return "process"
 	default:
-		return TaskExecutionResult{TaskType: "unknown", Success: false}, fmt.Errorf("task must have either webhook or container configuration")
+		return "unknown"
 	}
 }
 
-func (e *Executor) executeWebhookTask(ctx context.Context, task WebhookTask) (TaskExecutionResult, error) {
+// executeTask runs a single task. Its log output is also written to out.
+func (e *Executor) executeTask(ctx context.Context, task Task, out io.Writer) (TaskExecutionResult, error) {
+	switch {
+	case task.Webhook != nil:
+		return e.executeWebhookTask(ctx, *task.Webhook, out)
+	case task.Container != nil && processConfig.Mode == ProcessModeForce:
+		if len(task.Container.Services) > 0 || len(task.Container.Artifacts) > 0 || len(task.Container.Cache) > 0 || len(task.Container.Reports) > 0 {
+			fmt.Fprintf(out, "Container tasks run as processes on this server, services, artifacts, caches and reports are not supported\n")
+		}
+		return e.executeProcessTask(ctx, containerAsProcess(*task.Container), out)
+	case task.Container != nil:
+		return e.executeContainerTask(ctx, *task.Container, out)
+	case task.Process != nil:
+		return e.executeProcessTask(ctx, *task.Process, out)
+	default:
+		return TaskExecutionResult{TaskType: "unknown", Success: false}, fmt.Errorf("task must have either webhook, container or process configuration")
+	}
+}
+
+func (e *Executor) executeWebhookTask(ctx context.Context, task WebhookTask, out io.Writer) (TaskExecutionResult, error) {
 	result := TaskExecutionResult{
 		TaskType:  "webhook",
 		StartedAt: time.Now(),
 	}
 
-	attempts := 1
-	if task.Retry != nil && task.Retry.MaxAttempts > 1 {
-		attempts = task.Retry.MaxAttempts
-	}
+	attempts := task.Retry.Attempts()
 
 	var logBuf bytes.Buffer
-	logWriter := io.MultiWriter(&logBuf, os.Stdout)
+	logWriter := io.MultiWriter(&logBuf, os.Stdout, out)
 	fmt.Fprintf(logWriter, "Request: %s %s\n", task.Method, task.Url)
 	if len(task.Headers) > 0 {
 		fmt.Fprintln(logWriter, "Request Headers:")
@@ -339,6 +884,20 @@ func (e *Executor) executeWebhookTask(ctx context.Context, task WebhookTask) (Ta
 
 	var lastErr error
 	var statusCode int
+	failed := func() (TaskExecutionResult, error) {
+		if statusCode == 0 {
+			result.StatusCode = -1
+		} else {
+			result.StatusCode = statusCode
+		}
+		result.Success = false
+		result.FinishedAt = time.Now()
+		result.Log = logBuf.String()
+		if lastErr != nil {
+			return result, lastErr
+		}
+		return result, fmt.Errorf("webhook task failed without response")
+	}
 	for i := 0; i < attempts; i++ {
 		fmt.Fprintf(logWriter, "Attempt %d/%d\n", i+1, attempts)
 		code, headers, body, err := e.makeHTTPRequest(ctx, task)
@@ -360,7 +919,13 @@ func (e *Executor) executeWebhookTask(ctx context.Context, task WebhookTask) (Ta
 			lastErr = err
 			fmt.Fprintf(logWriter, "Error: %v\n", err)
 			if i < attempts-1 {
-				time.Sleep(time.Duration(i+1) * time.Second)
+				select {
+				case <-ctx.Done():
+					lastErr = fmt.Errorf("retry canceled: %w", ctx.Err())
+					fmt.Fprintf(logWriter, "Error: %v\n", lastErr)
+					return failed()
+				case <-time.After(time.Duration(i+1) * time.Second):
+				}
 			}
 			continue
 		}
@@ -370,22 +935,10 @@ func (e *Executor) executeWebhookTask(ctx context.Context, task WebhookTask) (Ta
 		result.Log = logBuf.String()
 		return result, nil
 	}
-
-	if statusCode == 0 {
-		result.StatusCode = -1
-	} else {
-		result.StatusCode = statusCode
-	}
-	result.Success = false
-	result.FinishedAt = time.Now()
-	result.Log = logBuf.String()
-	if lastErr != nil {
-		return result, lastErr
-	}
-	return result, fmt.Errorf("webhook task failed without response")
+	return failed()
 }
 
-func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask) (TaskExecutionResult, error) {
+func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask, out io.Writer) (TaskExecutionResult, error) {
 	result := TaskExecutionResult{
 		TaskType:  "container",
 		StartedAt: time.Now(),
@@ -399,7 +952,7 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 	}
 
 	var logBuf bytes.Buffer
-	logWriter := io.MultiWriter(os.Stdout, &logBuf)
+	logWriter := &syncWriter{w: io.MultiWriter(os.Stdout, &logBuf, out)}
 
 	// Set CI=true for all container runs, allowing user overrides
 	env := map[string]string{"CI": "true"}
@@ -407,19 +960,44 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 		env[k] = v
 	}
 
-	networkName := fmt.Sprintf("pogo-ci-%d", time.Now().UnixNano())
-
-	if err := e.dockerClient.CreateNetwork(ctx, networkName); err != nil {
-		result.Log = logBuf.String()
+	limits, err := containerLimits.resolve(task, logWriter)
+	if err != nil {
+		result.Log = logBuf.String() + err.Error()
 		result.StatusCode = -1
 		result.FinishedAt = time.Now()
-		return result, fmt.Errorf("create network: %w", err)
+		return result, err
+	}
+	ownNetwork, internalNetwork, err := networkMode(task)
+	if err != nil {
+		result.Log = logBuf.String() + err.Error()
+		result.StatusCode = -1
+		result.FinishedAt = time.Now()
+		return result, err
+	}
+
+	// Outputs are kept after a timeout, so they use the context without it
+	outerCtx := ctx
+	if limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.timeout)
+		defer cancel()
+	}
+
+	networkName := NetworkNone
+	if ownNetwork {
+		networkName = uniqueName("pogo-ci")
+		if err := e.dockerClient.CreateNetwork(ctx, networkName, internalNetwork); err != nil {
+			result.Log = logBuf.String()
+			result.StatusCode = -1
+			result.FinishedAt = time.Now()
+			return result, fmt.Errorf("create network: %w", err)
+		}
+		defer e.dockerClient.RemoveNetwork(context.Background(), networkName)
 	}
-	defer e.dockerClient.RemoveNetwork(context.Background(), networkName)
 
 	var serviceContainers []string
 	for _, service := range task.Services {
-		serviceID := fmt.Sprintf("%s-%d", service.Name, time.Now().UnixNano())
+		serviceID := uniqueName(service.Name)
 
 		fmt.Fprintf(logWriter, "Pulling service image %s\n", service.Image)
 		if err := e.dockerClient.PullImage(ctx, service.Image); err != nil {
@@ -431,15 +1009,19 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 
 		go func(svc Service, svcID string) {
 			runOpts := docker.RunOptions{
-				Image:       svc.Image,
-				Name:        svc.Name,
-				Environment: svc.Environment,
-				NetworkName: networkName,
+				Image:        svc.Image,
+				Name:         svcID,
+				Environment:  svc.Environment,
+				NetworkName:  networkName,
+				NetworkAlias: svc.Name,
+				NanoCPUs:     limits.nanoCPUs,
+				Memory:       limits.memory,
+				PidsLimit:    containerLimits.PidsLimit,
 			}
 			e.dockerClient.RunContainer(context.Background(), runOpts)
 		}(service, serviceID)
 
-		serviceContainers = append(serviceContainers, service.Name)
+		serviceContainers = append(serviceContainers, serviceID)
 		time.Sleep(2 * time.Second)
 	}
 
@@ -458,7 +1040,18 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 		return result, fmt.Errorf("pull image: %w", err)
 	}
 
-	containerName := fmt.Sprintf("pogo-ci-%d", time.Now().UnixNano())
+	containerName := uniqueName("pogo-ci")
+	// Stop the container as soon as the pipeline is cancelled
+	stopOnCancel := context.AfterFunc(ctx, func() {
+		if outerCtx.Err() == nil {
+			fmt.Fprintf(logWriter, "Timed out after %s, stopping container\n", limits.timeout)
+		} else {
+			fmt.Fprintf(logWriter, "Cancelled, stopping container\n")
+		}
+		e.dockerClient.StopContainer(context.Background(), containerName)
+	})
+	defer stopOnCancel()
+
 	workingDir := task.WorkingDir
 	if workingDir == "" {
 		workingDir = "/workspace"
@@ -475,6 +1068,9 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 			CreateOnly:  true,
 			Name:        containerName,
 			Entrypoint:  []string{"tail", "-f", "/dev/null"},
+			NanoCPUs:    limits.nanoCPUs,
+			Memory:      limits.memory,
+			PidsLimit:   containerLimits.PidsLimit,
 		}
 
 		fmt.Fprintf(logWriter, "Creating container %s\n", task.Image)
@@ -496,6 +1092,7 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 				return result, fmt.Errorf("copy to container: %w", err)
 			}
 		}
+		restored := e.restoreCaches(ctx, containerName, workingDir, task.Cache, logWriter)
 
 		fmt.Fprintf(logWriter, "Starting container %s\n", task.Image)
 		// Start container in background (it will stay alive due to tail -f /dev/null)
@@ -509,6 +1106,7 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 				statusCode := exitCodeFromError(err)
 				result.StatusCode = statusCode
 				result.Success = false
+				e.keepOutputs(outerCtx, containerName, workingDir, task, restored, &result, logWriter)
 				result.FinishedAt = time.Now()
 				result.Log = logBuf.String()
 				if statusCode == -1 {
@@ -520,6 +1118,7 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 
 		result.StatusCode = 0
 		result.Success = true
+		e.keepOutputs(outerCtx, containerName, workingDir, task, restored, &result, logWriter)
 		result.FinishedAt = time.Now()
 		result.Log = logBuf.String()
 		return result, nil
@@ -531,13 +1130,17 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 		Environment: env,
 		WorkingDir:  workingDir,
 		NetworkName: networkName,
+		Name:        containerName,
 		Stdout:      logWriter,
 		Stderr:      logWriter,
+		NanoCPUs:    limits.nanoCPUs,
+		Memory:      limits.memory,
+		PidsLimit:   containerLimits.PidsLimit,
 	}
 
-	if e.repoContentDir != "" {
+	// Files can only be copied into and out of a created container
+	if e.repoContentDir != "" || len(task.Artifacts) > 0 || len(task.Cache) > 0 || len(task.Reports) > 0 {
 		runOpts.CreateOnly = true
-		runOpts.Name = containerName
 
 		fmt.Fprintf(logWriter, "Creating container %s\n", task.Image)
 
@@ -549,23 +1152,27 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 		}
 		defer e.dockerClient.RemoveContainer(context.Background(), containerName)
 
-		fmt.Fprintf(logWriter, "Copying repository content to /workspace\n")
-		if err := e.dockerClient.CopyToContainer(ctx, containerName, e.repoContentDir, "/workspace"); err != nil {
-			result.Log = logBuf.String()
-			result.StatusCode = -1
-			result.FinishedAt = time.Now()
-			return result, fmt.Errorf("copy to container: %w", err)
+		if e.repoContentDir != "" {
+			fmt.Fprintf(logWriter, "Copying repository content to /workspace\n")
+			if err := e.dockerClient.CopyToContainer(ctx, containerName, e.repoContentDir, "/workspace"); err != nil {
+				result.Log = logBuf.String()
+				result.StatusCode = -1
+				result.FinishedAt = time.Now()
+				return result, fmt.Errorf("copy to container: %w", err)
+			}
 		}
+		restored := e.restoreCaches(ctx, containerName, workingDir, task.Cache, logWriter)
 
 		fmt.Fprintf(logWriter, "Starting container %s\n", task.Image)
 		err := e.dockerClient.StartContainer(ctx, containerName, logWriter, logWriter)
+		result.Success = err == nil
+		e.keepOutputs(outerCtx, containerName, workingDir, task, restored, &result, logWriter)
 		result.FinishedAt = time.Now()
 		result.Log = logBuf.String()
 
 		if err != nil {
 			statusCode := exitCodeFromError(err)
 			result.StatusCode = statusCode
-			result.Success = false
 			if statusCode == -1 {
 				result.Log += fmt.Sprintf("\nError: %v\n", err)
 			}
@@ -573,13 +1180,12 @@ func (e *Executor) executeContainerTask(ctx context.Context, task ContainerTask)
 		}
 
 		result.StatusCode = 0
-		result.Success = true
 		return result, nil
 	}
 
 	fmt.Fprintf(logWriter, "Running container %s\n", task.Image)
 
-	err := e.dockerClient.RunContainer(ctx, runOpts)
+	err = e.dockerClient.RunContainer(ctx, runOpts)
 	result.FinishedAt = time.Now()
 	result.Log = logBuf.String()
 
@@ -629,6 +1235,13 @@ func (e *Executor) makeHTTPRequest(ctx context.Context, task WebhookTask) (int,
 	return resp.StatusCode, headers, bodyBytes, nil
 }
 
+var nameCounter atomic.Uint64
+
+// uniqueName returns a name for docker resources that does not collide between concurrent jobs.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), nameCounter.Add(1))
+}
+
 func isYAMLFile(filename string) bool {
 	ext := filepath.Ext(filename)
 	return ext == ".yaml" || ext == ".yml"
@@ -639,6 +1252,26 @@ func matchesPattern(str, pattern string) bool {
 	return matched
 }
 
+// keepOutputs saves the caches of a successful task and collects its reports
+// and artifacts, while the container still exists.
+func (e *Executor) keepOutputs(ctx context.Context, containerName, workingDir string, task ContainerTask, restored map[string]bool, result *TaskExecutionResult, log io.Writer) {
+	if result.Success && len(task.Cache) > 0 {
+		e.saveCaches(ctx, containerName, workingDir, task.Cache, restored, log)
+	}
+	if len(task.Reports) > 0 {
+		result.Tests = e.collectReports(ctx, containerName, workingDir, task.Reports, log)
+	}
+	if len(task.Artifacts) > 0 {
+		dir, names, err := e.collectArtifacts(ctx, containerName, workingDir, task.Artifacts, log)
+		if err != nil {
+			fmt.Fprintf(log, "Collecting artifacts failed: %v\n", err)
+			return
+		}
+		result.artifactDir = dir
+		result.Artifacts = names
+	}
+}
+
 func exitCodeFromError(err error) int {
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
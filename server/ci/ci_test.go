@@ -2,12 +2,16 @@ package ci
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestExecutor_ExecuteForBookmarkEvent(t *testing.T) {
@@ -200,6 +204,166 @@ do:
 	}
 }
 
+func TestExecutor_ExecuteForEvent(t *testing.T) {
+	ctx := context.Background()
+
+	var receivedBodies []string
+	var mu sync.Mutex
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body := make([]byte, 4096)
+		n, _ := r.Body.Read(body)
+		receivedBodies = append(receivedBodies, string(body[:n]))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	executor := NewExecutor()
+
+	configFiles := map[string][]byte{
+		"change.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  change:
+    push: true
+    merge: true
+do:
+  - webhook:
+      url: %s/webhook
+      method: POST
+      body: "change {{ .Type }} {{ .Rev }}"
+`, testServer.URL)),
+		"nightly.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  schedule:
+    - cron: "0 3 * * *"
+      bookmarks: ["main"]
+do:
+  - webhook:
+      url: %s/webhook
+      method: POST
+      body: "nightly {{ .Schedule }} {{ .Rev }}"
+`, testServer.URL)),
+		"deploy.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  manual:
+    inputs:
+      target: staging
+      dry_run: "true"
+do:
+  - webhook:
+      url: %s/webhook
+      method: POST
+      body: "deploy {{ .Inputs.target }} {{ index .Inputs "dry_run" }}"
+`, testServer.URL)),
+	}
+
+	tests := []struct {
+		name       string
+		event      Event
+		wantBodies []string
+		wantErr    bool
+	}{
+		{
+			name:       "change push",
+			event:      Event{Type: EventTypeChangePush, Rev: "brave-fox"},
+			wantBodies: []string{"change change_push brave-fox"},
+		},
+		{
+			name:       "merge",
+			event:      Event{Type: EventTypeMerge, Rev: "calm-owl"},
+			wantBodies: []string{"change merge calm-owl"},
+		},
+		{
+			name:  "new change not configured",
+			event: Event{Type: EventTypeNewChange, Rev: "calm-owl"},
+		},
+		{
+			name:       "schedule matches cron and bookmark",
+			event:      Event{Type: EventTypeSchedule, Rev: "main", Schedule: "0 3 * * *"},
+			wantBodies: []string{"nightly 0 3 * * * main"},
+		},
+		{
+			name:  "schedule with other bookmark",
+			event: Event{Type: EventTypeSchedule, Rev: "dev", Schedule: "0 3 * * *"},
+		},
+		{
+			name:  "schedule with other cron",
+			event: Event{Type: EventTypeSchedule, Rev: "main", Schedule: "0 4 * * *"},
+		},
+		{
+			name:       "manual with defaults",
+			event:      Event{Type: EventTypeManual, Rev: "main"},
+			wantBodies: []string{"deploy staging true"},
+		},
+		{
+			name:       "manual with inputs",
+			event:      Event{Type: EventTypeManual, Rev: "main", Inputs: map[string]string{"target": "production"}},
+			wantBodies: []string{"deploy production true"},
+		},
+		{
+			name:    "manual with unknown input",
+			event:   Event{Type: EventTypeManual, Rev: "main", Inputs: map[string]string{"region": "eu"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			receivedBodies = nil
+			mu.Unlock()
+
+			results, err := executor.ExecuteForEvent(ctx, configFiles, tt.event)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ExecuteForEvent() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecuteForEvent() error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(receivedBodies) != len(tt.wantBodies) {
+				t.Fatalf("expected %d requests, got %d: %v", len(tt.wantBodies), len(receivedBodies), receivedBodies)
+			}
+			for i, want := range tt.wantBodies {
+				if receivedBodies[i] != want {
+					t.Errorf("body %d = %q, want %q", i, receivedBodies[i], want)
+				}
+			}
+			for _, result := range results {
+				if result.EventType != tt.event.Type {
+					t.Errorf("result event type = %v, want %v", result.EventType, tt.event.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	for eventType := EventTypePush; eventType <= EventTypeManual; eventType++ {
+		got, err := ParseEventType(eventType.String())
+		if err != nil {
+			t.Fatalf("ParseEventType(%q) error = %v", eventType.String(), err)
+		}
+		if got != eventType {
+			t.Errorf("ParseEventType(%q) = %v, want %v", eventType.String(), got, eventType)
+		}
+	}
+	if _, err := ParseEventType("unknown"); err == nil {
+		t.Error("ParseEventType(\"unknown\") expected error")
+	}
+}
+
 func TestExecutor_Retry(t *testing.T) {
 	ctx := context.Background()
 
@@ -328,6 +492,72 @@ do:
 	}
 }
 
+func TestExecutor_RetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requestCount int
+	var mu sync.Mutex
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestCount++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer testServer.Close()
+
+	executor := NewExecutor()
+	task := WebhookTask{
+		Url:    testServer.URL + "/webhook",
+		Method: http.MethodPost,
+		Retry:  &RetryPolicy{MaxAttempts: 5},
+	}
+
+	// Cancel while the executor waits a second before the next attempt
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	result, err := executor.executeWebhookTask(ctx, task, io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("executeWebhookTask() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("executeWebhookTask() took %v, want it to stop waiting when canceled", elapsed)
+	}
+	if result.Success {
+		t.Error("expected task to report failure")
+	}
+	if result.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, result.StatusCode)
+	}
+
+	mu.Lock()
+	finalCount := requestCount
+	mu.Unlock()
+
+	if finalCount != 1 {
+		t.Errorf("Expected 1 request before the cancellation, got %d", finalCount)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var none *RetryPolicy
+	if got := none.Attempts(); got != 1 {
+		t.Errorf("Attempts() of nil policy = %d, want 1", got)
+	}
+	policy := &RetryPolicy{MaxAttempts: 4}
+	if got := policy.Attempts(); got != 4 {
+		t.Errorf("Attempts() = %d, want 4", got)
+	}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		if got := policy.Backoff(attempt, time.Second, 5*time.Second); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
 func TestMatchesPattern(t *testing.T) {
 	tests := []struct {
 		str     string
@@ -408,6 +638,62 @@ do:
 	}
 }
 
+func TestUnmarshalConfigOutputPaths(t *testing.T) {
+	for _, pattern := range []string{"../../../etc/cron.d/*", "dist/../../x", "/etc/passwd"} {
+		configYAML := []byte(`version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - container:
+      image: alpine
+      artifacts: ["` + pattern + `"]
+`)
+		if _, _, err := UnmarshalConfig(configYAML, Event{Rev: "main"}); err == nil {
+			t.Errorf("UnmarshalConfig() with artifact pattern %q succeeded", pattern)
+		}
+	}
+
+	configYAML := []byte(`version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - container:
+      image: alpine
+      artifacts: ["./dist/", "out/**/*.tar.gz"]
+      reports:
+        - format: junit
+          paths: ["reports/*.xml"]
+`)
+	if _, _, err := UnmarshalConfig(configYAML, Event{Rev: "main"}); err != nil {
+		t.Errorf("UnmarshalConfig() error = %v", err)
+	}
+}
+
+func TestUnmarshalConfigRunsOn(t *testing.T) {
+	configYAML := []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+runs_on: [linux, docker]
+do:
+  - container:
+      image: alpine:latest
+      commands: ["true"]
+`)
+
+	config, _, err := UnmarshalConfig(configYAML, Event{Rev: "main"})
+	if err != nil {
+		t.Fatalf("UnmarshalConfig() error = %v", err)
+	}
+
+	if want := []string{"linux", "docker"}; !slices.Equal(config.RunsOn, want) {
+		t.Errorf("RunsOn = %v, want %v", config.RunsOn, want)
+	}
+}
+
 type TestRequest struct {
 	Method  string
 	URL     string
@@ -0,0 +1,121 @@
+package ci
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// TaskContext is the data an if expression is evaluated against.
+// The event fields are available directly, e.g. `eq .Rev "main"`.
+type TaskContext struct {
+	Event
+	// Tasks holds the status of the previous tasks that have a name
+	Tasks map[string]TaskStatus
+	// Success is true if all previous tasks succeeded
+	Success bool
+	// Failure is true if any previous task failed
+	Failure bool
+	// Matrix holds the values of the combination a matrix task runs with
+	Matrix map[string]string
+}
+
+type TaskStatus struct {
+	Success    bool
+	Skipped    bool
+	StatusCode int
+}
+
+// evaluateCondition evaluates an if expression. Expressions use the template
+// pipeline syntax without delimiters:
+//
+//	if: and .Success (eq .Type.String "push")
+//	if: .Failure
+//	if: and (hasSecret "DEPLOY_TOKEN") .Tasks.build.Success
+//	if: ne .Matrix.go "1.22"
+//
+// An empty expression runs the task only if all previous tasks succeeded.
+func (e *Executor) evaluateCondition(expr string, data TaskContext) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return data.Success, nil
+	}
+
+	funcs := makeUnmarshalConfigFuncs(e.secrets, e.withheld, e.repoContentDir, e.envSecrets)
+	funcs["matrix"] = func(key string) string {
+		return data.Matrix[key]
+	}
+	funcs["hasSecret"] = func(key string) bool {
+		if _, ok := e.secrets[key]; ok {
+			return true
+		}
+		if _, ok := e.withheld[key]; ok {
+			return false
+		}
+		return e.envSecrets && os.Getenv(key) != ""
+	}
+
+	t, err := parseCondition(expr, funcs)
+	if err != nil {
+		return false, err
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return false, fmt.Errorf("evaluate if expression %q: %w", expr, err)
+	}
+	return sb.String() == "true", nil
+}
+
+// parseCondition parses an if expression with the given functions.
+func parseCondition(expr string, funcs template.FuncMap) (*template.Template, error) {
+	t, err := template.New("if").
+		Funcs(funcs).
+		Option("missingkey=zero").
+		Parse("{{ if " + expr + " }}true{{ end }}")
+	if err != nil {
+		return nil, fmt.Errorf("parse if expression %q: %w", expr, err)
+	}
+	return t, nil
+}
+
+// Match reports whether the changed files pass the filter. Patterns use the
+// gitignore syntax. Unknown changes (nil) always pass.
+func (f PathFilter) Match(changedFiles []string) bool {
+	if changedFiles == nil || (len(f.Paths) == 0 && len(f.PathsIgnore) == 0) {
+		return true
+	}
+	include := parsePathPatterns(f.Paths)
+	ignore := parsePathPatterns(f.PathsIgnore)
+	for _, file := range changedFiles {
+		parts := strings.Split(file, "/")
+		if len(include) > 0 && !matchPathPatterns(include, parts) {
+			continue
+		}
+		if matchPathPatterns(ignore, parts) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func parsePathPatterns(patterns []string) []gitignore.Pattern {
+	parsed := make([]gitignore.Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		parsed = append(parsed, gitignore.ParsePattern(pattern, nil))
+	}
+	return parsed
+}
+
+func matchPathPatterns(patterns []gitignore.Pattern, parts []string) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(parts, false) == gitignore.Exclude {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package ci
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPathFilter_Match(t *testing.T) {
+	tests := []struct {
+		name         string
+		filter       PathFilter
+		changedFiles []string
+		want         bool
+	}{
+		{
+			name:         "no filter",
+			changedFiles: []string{"README.md"},
+			want:         true,
+		},
+		{
+			name:   "unknown changes",
+			filter: PathFilter{Paths: []string{"src/**"}},
+			want:   true,
+		},
+		{
+			name:         "no changes",
+			filter:       PathFilter{Paths: []string{"src/**"}},
+			changedFiles: []string{},
+			want:         false,
+		},
+		{
+			name:         "paths match",
+			filter:       PathFilter{Paths: []string{"src/**"}},
+			changedFiles: []string{"README.md", "src/main.go"},
+			want:         true,
+		},
+		{
+			name:         "paths do not match",
+			filter:       PathFilter{Paths: []string{"src/**"}},
+			changedFiles: []string{"README.md", "docs/index.md"},
+			want:         false,
+		},
+		{
+			name:         "directory pattern",
+			filter:       PathFilter{Paths: []string{"src"}},
+			changedFiles: []string{"src/pkg/util.go"},
+			want:         true,
+		},
+		{
+			name:         "only ignored files",
+			filter:       PathFilter{PathsIgnore: []string{"docs/", "*.md"}},
+			changedFiles: []string{"README.md", "docs/index.html"},
+			want:         false,
+		},
+		{
+			name:         "ignored and other files",
+			filter:       PathFilter{PathsIgnore: []string{"*.md"}},
+			changedFiles: []string{"README.md", "main.go"},
+			want:         true,
+		},
+		{
+			name:         "paths and paths_ignore",
+			filter:       PathFilter{Paths: []string{"src/**"}, PathsIgnore: []string{"**/*_test.go"}},
+			changedFiles: []string{"src/main_test.go"},
+			want:         false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.changedFiles); got != tt.want {
+				t.Errorf("Match(%v) = %v, want %v", tt.changedFiles, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_TriggerWithPathFilter(t *testing.T) {
+	config, _, err := UnmarshalConfig([]byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+    paths: ["src/**"]
+  change:
+    push: true
+    paths_ignore: ["docs/**"]
+do:
+  - webhook:
+      url: http://example.com
+      method: POST
+`), Event{})
+	if err != nil {
+		t.Fatalf("UnmarshalConfig() error = %v", err)
+	}
+
+	if _, ok := config.Trigger(Event{Type: EventTypePush, Rev: "main", ChangedFiles: []string{"src/a.go"}}); !ok {
+		t.Error("expected push with matching path to trigger")
+	}
+	if _, ok := config.Trigger(Event{Type: EventTypePush, Rev: "main", ChangedFiles: []string{"docs/a.md"}}); ok {
+		t.Error("expected push without matching path not to trigger")
+	}
+	if _, ok := config.Trigger(Event{Type: EventTypeChangePush, Rev: "calm-owl", ChangedFiles: []string{"docs/a.md"}}); ok {
+		t.Error("expected change push with only ignored paths not to trigger")
+	}
+	if _, ok := config.Trigger(Event{Type: EventTypeChangePush, Rev: "calm-owl", ChangedFiles: []string{"main.go"}}); !ok {
+		t.Error("expected change push with other paths to trigger")
+	}
+}
+
+func TestExecutor_TaskConditions(t *testing.T) {
+	ctx := context.Background()
+
+	var receivedPaths []string
+	var mu sync.Mutex
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		receivedPaths = append(receivedPaths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	executor := NewExecutor()
+	executor.SetSecrets(map[string]string{"DEPLOY_TOKEN": "secret"})
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  push:
+    bookmarks: ["main", "dev"]
+do:
+  - name: build
+    webhook:
+      url: %[1]s/build
+      method: POST
+  - name: deploy
+    if: and .Success (eq .Rev "main") (hasSecret "DEPLOY_TOKEN")
+    webhook:
+      url: %[1]s/deploy
+      method: POST
+  - if: hasSecret "MISSING_TOKEN"
+    webhook:
+      url: %[1]s/missing
+      method: POST
+  - name: test
+    webhook:
+      url: %[1]s/fail
+      method: POST
+  - webhook:
+      url: %[1]s/after-failure
+      method: POST
+  - if: and .Failure .Tasks.build.Success (not .Tasks.deploy.Skipped)
+    webhook:
+      url: %[1]s/notify
+      method: POST
+`, testServer.URL)),
+	}
+
+	tests := []struct {
+		rev          string
+		wantPaths    []string
+		wantStatuses []JobStatus
+	}{
+		{
+			rev:          "main",
+			wantPaths:    []string{"/build", "/deploy", "/fail", "/notify"},
+			wantStatuses: []JobStatus{JobStatusSuccess, JobStatusSuccess, JobStatusSkipped, JobStatusFailure, JobStatusSkipped, JobStatusSuccess},
+		},
+		{
+			rev:          "dev",
+			wantPaths:    []string{"/build", "/fail"},
+			wantStatuses: []JobStatus{JobStatusSuccess, JobStatusSkipped, JobStatusSkipped, JobStatusFailure, JobStatusSkipped, JobStatusSkipped},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rev, func(t *testing.T) {
+			mu.Lock()
+			receivedPaths = nil
+			mu.Unlock()
+
+			results, err := executor.ExecuteForEvent(ctx, configFiles, Event{Type: EventTypePush, Rev: tt.rev})
+			if err == nil {
+				t.Error("ExecuteForEvent() expected error from failing task")
+			}
+			if len(results) != len(tt.wantStatuses) {
+				t.Fatalf("expected %d results, got %d", len(tt.wantStatuses), len(results))
+			}
+			for i, want := range tt.wantStatuses {
+				if results[i].Status != want {
+					t.Errorf("result %d (%s) status = %s, want %s", i, results[i].JobName, results[i].Status, want)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(receivedPaths) != fmt.Sprint(tt.wantPaths) {
+				t.Errorf("requests = %v, want %v", receivedPaths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestExecutor_InvalidCondition(t *testing.T) {
+	executor := NewExecutor()
+	if _, err := executor.evaluateCondition("eq .Rev", TaskContext{}); err == nil {
+		t.Error("expected error for invalid expression")
+	}
+	if _, err := executor.evaluateCondition("and (", TaskContext{}); err == nil {
+		t.Error("expected error for unparsable expression")
+	}
+}
@@ -32,8 +32,8 @@ func (c *cliClient) BuildImage(ctx context.Context, dockerfilePath string, tag s
 	return nil
 }
 
-func (c *cliClient) CreateNetwork(ctx context.Context, networkName string) error {
-	cmd := exec.CommandContext(ctx, "docker", "network", "create", networkName)
+func (c *cliClient) CreateNetwork(ctx context.Context, networkName string, internal bool) error {
+	cmd := exec.CommandContext(ctx, "docker", networkCreateArgs(networkName, internal)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil && !strings.Contains(string(output), "already exists") {
 		return fmt.Errorf("create network %s: %w: %s", networkName, err, string(output))
@@ -60,8 +60,13 @@ func (c *cliClient) RunContainer(ctx context.Context, opts RunOptions) error {
 
 		if opts.NetworkName != "" {
 			args = append(args, "--network", opts.NetworkName)
+			if opts.NetworkAlias != "" {
+				args = append(args, "--network-alias", opts.NetworkAlias)
+			}
 		}
 
+		args = append(args, resourceArgs(opts)...)
+
 		if opts.WorkingDir != "" {
 			args = append(args, "--workdir", opts.WorkingDir)
 		}
@@ -100,8 +105,13 @@ func (c *cliClient) RunContainer(ctx context.Context, opts RunOptions) error {
 
 	if opts.NetworkName != "" {
 		args = append(args, "--network", opts.NetworkName)
+		if opts.NetworkAlias != "" {
+			args = append(args, "--network-alias", opts.NetworkAlias)
+		}
 	}
 
+	args = append(args, resourceArgs(opts)...)
+
 	if opts.WorkingDir != "" {
 		args = append(args, "--workdir", opts.WorkingDir)
 	}
@@ -166,6 +176,15 @@ func (c *cliClient) CopyToContainer(ctx context.Context, containerID string, src
 	return nil
 }
 
+func (c *cliClient) CopyFromContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error {
+	cmd := exec.CommandContext(ctx, "docker", "cp", containerID+":"+srcPath, dstPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("copy %s from container %s to %s: %w: %s", srcPath, containerID, dstPath, err, string(output))
+	}
+	return nil
+}
+
 func (c *cliClient) StartContainer(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) error {
 	cmd := exec.CommandContext(ctx, "docker", "start", "-a", containerID)
 	if stdout != nil {
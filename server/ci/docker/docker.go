@@ -6,15 +6,21 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 )
 
 type Client interface {
 	PullImage(ctx context.Context, image string) error
 	BuildImage(ctx context.Context, dockerfilePath string, tag string) error
-	CreateNetwork(ctx context.Context, networkName string) error
+	// CreateNetwork creates a bridge network. Containers on an internal network
+	// can only reach each other.
+	CreateNetwork(ctx context.Context, networkName string, internal bool) error
 	RemoveNetwork(ctx context.Context, networkName string) error
 	RunContainer(ctx context.Context, opts RunOptions) error
 	CopyToContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error
+	// CopyFromContainer copies the file or directory at srcPath in the container
+	// to dstPath on the host, which must not exist yet.
+	CopyFromContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error
 	StartContainer(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) error
 	StopContainer(ctx context.Context, containerID string) error
 	RemoveContainer(ctx context.Context, containerID string) error
@@ -29,11 +35,21 @@ type RunOptions struct {
 	Entrypoint  []string
 	Environment map[string]string
 	WorkingDir  string
+	// NetworkName is the network to connect to, "none" disables networking
 	NetworkName string
-	Volumes     map[string]string
-	Stdout      io.Writer
-	Stderr      io.Writer
-	CreateOnly  bool
+	// NetworkAlias is an extra name the container is reachable by on the
+	// network
+	NetworkAlias string
+	Volumes      map[string]string
+	Stdout       io.Writer
+	Stderr       io.Writer
+	CreateOnly   bool
+	// NanoCPUs limits the CPU time in units of 10^-9 CPUs, 0 for no limit
+	NanoCPUs int64
+	// Memory limits the memory in bytes, 0 for no limit
+	Memory int64
+	// PidsLimit limits the number of processes, 0 for no limit
+	PidsLimit int64
 }
 
 func NewClient() (Client, error) {
@@ -58,6 +74,30 @@ func NewClient() (Client, error) {
 	return nil, fmt.Errorf("docker sdk unavailable: %w; docker CLI not found", sdkErr)
 }
 
+// resourceArgs returns the docker CLI flags for the resource limits of opts.
+func resourceArgs(opts RunOptions) []string {
+	var args []string
+	if opts.NanoCPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(opts.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+	if opts.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(opts.Memory, 10))
+	}
+	if opts.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(opts.PidsLimit, 10))
+	}
+	return args
+}
+
+// networkCreateArgs returns the docker CLI arguments creating a network.
+func networkCreateArgs(networkName string, internal bool) []string {
+	args := []string{"network", "create"}
+	if internal {
+		args = append(args, "--internal")
+	}
+	return append(args, networkName)
+}
+
 func isCLIAvailable() bool {
 	_, err := exec.LookPath("docker")
 	return err == nil
@@ -93,8 +93,8 @@ func (c *sdkClient) BuildImage(ctx context.Context, dockerfilePath string, tag s
 	return nil
 }
 
-func (c *sdkClient) CreateNetwork(ctx context.Context, networkName string) error {
-	_, err := c.cli.NetworkCreate(ctx, networkName, network.CreateOptions{})
+func (c *sdkClient) CreateNetwork(ctx context.Context, networkName string, internal bool) error {
+	_, err := c.cli.NetworkCreate(ctx, networkName, network.CreateOptions{Internal: internal})
 	if err == nil || errdefs.IsConflict(err) {
 		return nil
 	}
@@ -139,15 +139,31 @@ func (c *sdkClient) RunContainer(ctx context.Context, opts RunOptions) error {
 		AttachStderr: true,
 	}
 
+	// Created containers are removed by the caller, so files can still be
+	// copied out of them after they exited.
 	hostConfig := &container.HostConfig{
 		Binds:      binds,
-		AutoRemove: true,
+		AutoRemove: !opts.CreateOnly,
+		Resources: container.Resources{
+			NanoCPUs: opts.NanoCPUs,
+			Memory:   opts.Memory,
+		},
+	}
+	if opts.PidsLimit > 0 {
+		hostConfig.PidsLimit = &opts.PidsLimit
 	}
 
 	networkingConfig := &network.NetworkingConfig{}
 	if opts.NetworkName != "" {
+		hostConfig.NetworkMode = container.NetworkMode(opts.NetworkName)
+	}
+	if opts.NetworkName != "" && opts.NetworkName != "none" {
+		endpoint := &network.EndpointSettings{}
+		if opts.NetworkAlias != "" {
+			endpoint.Aliases = []string{opts.NetworkAlias}
+		}
 		networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{
-			opts.NetworkName: {},
+			opts.NetworkName: endpoint,
 		}
 	}
 
@@ -269,6 +285,25 @@ func (c *sdkClient) CopyToContainer(ctx context.Context, containerID string, src
 	return nil
 }
 
+func (c *sdkClient) CopyFromContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error {
+	content, stat, err := c.cli.CopyFromContainer(ctx, containerID, srcPath)
+	if err != nil {
+		return fmt.Errorf("copy %s from container %s: %w", srcPath, containerID, err)
+	}
+	defer content.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   srcPath,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}
+	if err := archive.CopyTo(content, srcInfo, dstPath); err != nil {
+		return fmt.Errorf("copy %s from container %s to %s: %w", srcPath, containerID, dstPath, err)
+	}
+
+	return nil
+}
+
 func (c *sdkClient) StartContainer(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) error {
 	if stdout == nil {
 		stdout = io.Discard
@@ -36,8 +36,8 @@ func (c *socketClient) BuildImage(ctx context.Context, dockerfilePath string, ta
 	return nil
 }
 
-func (c *socketClient) CreateNetwork(ctx context.Context, networkName string) error {
-	cmd := exec.CommandContext(ctx, "docker", "network", "create", networkName)
+func (c *socketClient) CreateNetwork(ctx context.Context, networkName string, internal bool) error {
+	cmd := exec.CommandContext(ctx, "docker", networkCreateArgs(networkName, internal)...)
 	cmd.Env = append(cmd.Env, fmt.Sprintf("DOCKER_HOST=%s", c.dockerHost))
 	output, err := cmd.CombinedOutput()
 	if err != nil && !strings.Contains(string(output), "already exists") {
@@ -66,8 +66,13 @@ func (c *socketClient) RunContainer(ctx context.Context, opts RunOptions) error
 
 		if opts.NetworkName != "" {
 			args = append(args, "--network", opts.NetworkName)
+			if opts.NetworkAlias != "" {
+				args = append(args, "--network-alias", opts.NetworkAlias)
+			}
 		}
 
+		args = append(args, resourceArgs(opts)...)
+
 		if opts.WorkingDir != "" {
 			args = append(args, "--workdir", opts.WorkingDir)
 		}
@@ -107,8 +112,13 @@ func (c *socketClient) RunContainer(ctx context.Context, opts RunOptions) error
 
 	if opts.NetworkName != "" {
 		args = append(args, "--network", opts.NetworkName)
+		if opts.NetworkAlias != "" {
+			args = append(args, "--network-alias", opts.NetworkAlias)
+		}
 	}
 
+	args = append(args, resourceArgs(opts)...)
+
 	if opts.WorkingDir != "" {
 		args = append(args, "--workdir", opts.WorkingDir)
 	}
@@ -177,6 +187,16 @@ func (c *socketClient) CopyToContainer(ctx context.Context, containerID string,
 	return nil
 }
 
+func (c *socketClient) CopyFromContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error {
+	cmd := exec.CommandContext(ctx, "docker", "cp", containerID+":"+srcPath, dstPath)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("DOCKER_HOST=%s", c.dockerHost))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("copy %s from container %s to %s: %w: %s", srcPath, containerID, dstPath, err, string(output))
+	}
+	return nil
+}
+
 func (c *socketClient) StartContainer(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) error {
 	cmd := exec.CommandContext(ctx, "docker", "start", "-a", containerID)
 	cmd.Env = append(cmd.Env, fmt.Sprintf("DOCKER_HOST=%s", c.dockerHost))
@@ -0,0 +1,163 @@
+package ci
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment groups secrets that only configs declaring the environment
+// receive, and only for events of the bookmarks it allows.
+type Environment struct {
+	// Bookmarks are globs of the bookmarks whose events may use the environment
+	Bookmarks []string
+	// Secrets of the environment, they take precedence over shared secrets
+	Secrets map[string]string
+}
+
+// Allows reports whether events of the bookmark may use the environment.
+// Events without a bookmark, like change events, may not.
+func (e Environment) Allows(bookmark string) bool {
+	if bookmark == "" {
+		return false
+	}
+	_, ok := matchAny(bookmark, e.Bookmarks)
+	return ok
+}
+
+// Validate checks that the environment allows at least one bookmark and that
+// its globs are valid.
+func (e Environment) Validate() error {
+	if len(e.Bookmarks) == 0 {
+		return errors.New("an environment must allow at least one bookmark glob")
+	}
+	for _, pattern := range e.Bookmarks {
+		if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid bookmark glob %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Secrets are the secrets of a repository, including the server-wide ones it
+// inherits, grouped by environment.
+type Secrets struct {
+	// Shared secrets are available to every config
+	Shared map[string]string
+	// Environments by name
+	Environments map[string]Environment
+}
+
+// Values returns the values of all secrets, e.g. to mask them in logs.
+func (s Secrets) Values() []string {
+	values := slices.Collect(maps.Values(s.Shared))
+	for _, env := range s.Environments {
+		values = slices.AppendSeq(values, maps.Values(env.Secrets))
+	}
+	return values
+}
+
+// Has reports whether a config declaring the environment, empty for none, may
+// use the secret.
+func (s Secrets) Has(key, environment string) bool {
+	if _, ok := s.Shared[key]; ok {
+		return true
+	}
+	_, ok := s.Environments[environment].Secrets[key]
+	return ok
+}
+
+// Resolve returns the secrets the config may use for the event: the shared
+// secrets and those of the environment it declares. It fails if the
+// environment does not exist or does not allow the bookmark of the event.
+func (s Secrets) Resolve(config []byte, event Event) (map[string]string, error) {
+	secrets, _, _, err := s.forConfig(config, event)
+	return secrets, err
+}
+
+// forConfig returns the secrets of a config for the event. Configs the event
+// does not trigger get no secrets, so their environment is not checked, and
+// their config rendered with placeholder secrets is returned instead.
+func (s Secrets) forConfig(config []byte, event Event) (map[string]string, map[string][]string, *Config, error) {
+	var environment string
+	if probe := probeConfig(config, event); probe != nil {
+		if _, ok := probe.Trigger(event); !ok {
+			return nil, nil, probe, nil
+		}
+		environment = probe.Environment
+	}
+	secrets, withheld, err := s.forEnvironment(environment, event)
+	return secrets, withheld, nil, err
+}
+
+// forEnvironment returns the secrets of a config declaring the environment,
+// which is empty for configs without one. withheld maps the keys of the
+// secrets of other environments to these environments, so the secret
+// function can tell why they are missing.
+func (s Secrets) forEnvironment(environment string, event Event) (map[string]string, map[string][]string, error) {
+	secrets := maps.Clone(s.Shared)
+	if secrets == nil {
+		secrets = make(map[string]string)
+	}
+	if environment != "" {
+		env, ok := s.Environments[environment]
+		if !ok {
+			return nil, nil, fmt.Errorf("environment %q not found", environment)
+		}
+		if !env.Allows(event.Bookmark) {
+			if event.Bookmark == "" {
+				return nil, nil, fmt.Errorf("environment %q is only available to events of bookmarks", environment)
+			}
+			return nil, nil, fmt.Errorf("environment %q does not allow bookmark %s", environment, event.Bookmark)
+		}
+		maps.Copy(secrets, env.Secrets)
+	}
+
+	withheld := make(map[string][]string)
+	for _, name := range slices.Sorted(maps.Keys(s.Environments)) {
+		if name == environment {
+			continue
+		}
+		for key := range s.Environments[name].Secrets {
+			if _, ok := secrets[key]; !ok {
+				withheld[key] = append(withheld[key], name)
+			}
+		}
+	}
+	return secrets, withheld, nil
+}
+
+// probeConfig renders a config with placeholder secrets, as the secrets
+// depend on the environment it declares. It returns nil for invalid configs,
+// rendering them with the secrets reports the error.
+func probeConfig(config []byte, event Event) *Config {
+	funcs := makeUnmarshalConfigFuncs(nil, nil, "", false)
+	funcs["secret"] = func(key string) string {
+		return ""
+	}
+	rendered := config
+	if t, err := template.New("ci_config").Funcs(funcs).Parse(string(config)); err == nil {
+		var sb strings.Builder
+		if err := t.Execute(&sb, event); err == nil {
+			rendered = []byte(sb.String())
+		}
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(rendered, &c); err != nil {
+		return nil
+	}
+	return &c
+}
+
+// withheldSecretError explains why a config can't use a secret of other
+// environments.
+func withheldSecretError(key string, environments []string) error {
+	return fmt.Errorf("secret %q is only available to configs with environment %s", key, strings.Join(environments, " or "))
+}
@@ -0,0 +1,185 @@
+package ci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testSecrets() Secrets {
+	return Secrets{
+		Shared: map[string]string{"NPM_TOKEN": "npm", "DEPLOY_KEY": "shared"},
+		Environments: map[string]Environment{
+			"production": {
+				Bookmarks: []string{"main", "release/*"},
+				Secrets:   map[string]string{"DEPLOY_KEY": "prod", "PROD_DB": "db"},
+			},
+			"staging": {
+				Bookmarks: []string{"*"},
+				Secrets:   map[string]string{"STAGING_DB": "staging"},
+			},
+		},
+	}
+}
+
+func TestSecrets_Resolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		event   Event
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "no environment",
+			config: "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\ndo: []\n",
+			event:  Event{Type: EventTypePush, Bookmark: "feature"},
+			want:   map[string]string{"NPM_TOKEN": "npm", "DEPLOY_KEY": "shared"},
+		},
+		{
+			name:   "allowed bookmark",
+			config: "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\nenvironment: production\ndo: []\n",
+			event:  Event{Type: EventTypePush, Bookmark: "release/1.0"},
+			want:   map[string]string{"NPM_TOKEN": "npm", "DEPLOY_KEY": "prod", "PROD_DB": "db"},
+		},
+		{
+			name:    "disallowed bookmark",
+			config:  "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\nenvironment: production\ndo: []\n",
+			event:   Event{Type: EventTypePush, Bookmark: "feature"},
+			wantErr: `environment "production" does not allow bookmark feature`,
+		},
+		{
+			name:    "no bookmark",
+			config:  "version: 1\non:\n  change:\n    push: true\nenvironment: staging\ndo: []\n",
+			event:   Event{Type: EventTypeChangePush},
+			wantErr: `environment "staging" is only available to events of bookmarks`,
+		},
+		{
+			name:    "unknown environment",
+			config:  "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\nenvironment: qa\ndo: []\n",
+			event:   Event{Type: EventTypePush, Bookmark: "main"},
+			wantErr: `environment "qa" not found`,
+		},
+		{
+			name:   "templated config",
+			config: "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\nenvironment: {{ if eq .Bookmark \"main\" }}production{{ else }}staging{{ end }}\ndo:\n  - webhook:\n      url: '{{ secret \"PROD_DB\" }}'\n",
+			event:  Event{Type: EventTypePush, Bookmark: "main"},
+			want:   map[string]string{"NPM_TOKEN": "npm", "DEPLOY_KEY": "prod", "PROD_DB": "db"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSecrets().Resolve([]byte(tt.config), tt.event)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("Resolve()[%s] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvironment_Validate(t *testing.T) {
+	if err := (Environment{Bookmarks: []string{"main", "release/*"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (Environment{}).Validate(); err == nil {
+		t.Error("Validate() without bookmarks succeeded")
+	}
+	if err := (Environment{Bookmarks: []string{"release/["}}).Validate(); err == nil {
+		t.Error("Validate() with an invalid glob succeeded")
+	}
+}
+
+func TestUnmarshalConfigWithScope(t *testing.T) {
+	t.Run("secret of other environment", func(t *testing.T) {
+		t.Setenv("PROD_DB", "from-env")
+		config := "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\ndo:\n  - webhook:\n      url: '{{ secret \"PROD_DB\" }}'\n"
+		_, _, err := UnmarshalConfigWithScope([]byte(config), Event{Type: EventTypePush, Bookmark: "main"}, testSecrets())
+		if err == nil || !strings.Contains(err.Error(), `secret "PROD_DB" is only available to configs with environment production`) {
+			t.Fatalf("UnmarshalConfigWithScope() error = %v, want the secret to be withheld", err)
+		}
+	})
+
+	t.Run("server environment", func(t *testing.T) {
+		t.Setenv("SECRETS_KEY", "master-key")
+		config := "version: 1\non:\n  push:\n    bookmarks: [\"*\"]\ndo:\n  - webhook:\n      url: '{{ secret \"SECRETS_KEY\" }}'\n"
+		_, _, err := UnmarshalConfigWithScope([]byte(config), Event{Type: EventTypePush, Bookmark: "main"}, testSecrets())
+		if err == nil || !strings.Contains(err.Error(), `secret "SECRETS_KEY" not found`) {
+			t.Fatalf("UnmarshalConfigWithScope() error = %v, want the secret to be missing", err)
+		}
+	})
+
+	t.Run("untriggered config", func(t *testing.T) {
+		config := "version: 1\non:\n  push:\n    bookmarks: [\"main\"]\nenvironment: production\ndo:\n  - webhook:\n      url: '{{ secret \"PROD_DB\" }}'\n"
+		event := Event{Type: EventTypePush, Bookmark: "feature"}
+		c, _, err := UnmarshalConfigWithScope([]byte(config), event, testSecrets())
+		if err != nil {
+			t.Fatalf("UnmarshalConfigWithScope() error = %v", err)
+		}
+		if _, ok := c.Trigger(event); ok {
+			t.Error("Trigger() = true, want false")
+		}
+		if strings.Contains(c.Do[0].Webhook.Url, "db") {
+			t.Error("untriggered config was rendered with the secrets")
+		}
+	})
+}
+
+func TestExecutor_SecretScope(t *testing.T) {
+	var body string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	configFiles := map[string][]byte{
+		"deploy.yaml": []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["*"]
+environment: production
+do:
+  - webhook:
+      url: ` + testServer.URL + `
+      method: POST
+      body: '{{ secret "DEPLOY_KEY" }} {{ secret "NPM_TOKEN" }}'
+`),
+	}
+
+	executor := NewExecutor()
+	executor.SetSecretScope(testSecrets())
+
+	results, err := executor.ExecuteForEvent(context.Background(), configFiles, Event{Type: EventTypePush, Rev: "main", Bookmark: "main"})
+	if err != nil {
+		t.Fatalf("ExecuteForEvent() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("ExecuteForEvent() results = %+v, want one successful run", results)
+	}
+	if body != "prod npm" {
+		t.Errorf("body = %q, want %q", body, "prod npm")
+	}
+
+	if _, err := executor.ExecuteForEvent(context.Background(), configFiles, Event{Type: EventTypePush, Rev: "feature", Bookmark: "feature"}); err == nil {
+		t.Error("ExecuteForEvent() for a bookmark the environment does not allow succeeded")
+	}
+}
@@ -0,0 +1,118 @@
+package ci
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FileOperation tells how a changed file changed.
+type FileOperation string
+
+const (
+	FileAdded    FileOperation = "added"
+	FileModified FileOperation = "modified"
+	FileRemoved  FileOperation = "removed"
+)
+
+// ChangedPath is a file changed by an event.
+type ChangedPath struct {
+	// Path is the slash separated path relative to the repository root
+	Path string
+	// Operation tells whether the file was added, modified or removed
+	Operation FileOperation
+}
+
+// ChangedPathNames returns the paths of the changed files, nil if they are
+// unknown.
+func ChangedPathNames(paths []ChangedPath) []string {
+	if paths == nil {
+		return nil
+	}
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = p.Path
+	}
+	return names
+}
+
+// ChangedPathOperations returns the operations of the changed files in the
+// order of ChangedPathNames, nil if they are unknown.
+func ChangedPathOperations(paths []ChangedPath) []string {
+	if paths == nil {
+		return nil
+	}
+	operations := make([]string, len(paths))
+	for i, p := range paths {
+		operations[i] = string(p.Operation)
+	}
+	return operations
+}
+
+// Env returns the event as POGO_* environment variables. Lists are separated
+// by newlines. Empty values are left out, except for the file lists of events
+// whose changed files are known. The access token is never exported.
+func (e Event) Env() map[string]string {
+	env := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			env[name] = value
+		}
+	}
+	set("POGO_EVENT", e.Type.String())
+	set("POGO_REV", e.Rev)
+	set("POGO_SERVER_URL", e.ServerUrl)
+	set("POGO_ARCHIVE_URL", e.ArchiveUrl)
+	set("POGO_AUTHOR", e.Author)
+	set("POGO_DESCRIPTION", e.Description)
+	set("POGO_CHANGE_NAME", e.ChangeName)
+	set("POGO_PARENTS", strings.Join(e.Parents, "\n"))
+	set("POGO_BOOKMARK", e.Bookmark)
+	set("POGO_PATTERN", e.Pattern)
+	set("POGO_PREVIOUS_CHANGE_NAME", e.PreviousChangeName)
+	set("POGO_TRIGGERED_BY", e.TriggeredBy)
+	set("POGO_SCHEDULE", e.Schedule)
+	if e.RepositoryID != 0 {
+		env["POGO_REPOSITORY_ID"] = strconv.FormatInt(int64(e.RepositoryID), 10)
+	}
+	if e.ChangeID != 0 {
+		env["POGO_CHANGE_ID"] = strconv.FormatInt(e.ChangeID, 10)
+	}
+
+	switch {
+	case e.ChangedPaths != nil:
+		byOperation := make(map[FileOperation][]string)
+		for _, p := range e.ChangedPaths {
+			byOperation[p.Operation] = append(byOperation[p.Operation], p.Path)
+		}
+		env["POGO_CHANGED_FILES"] = strings.Join(ChangedPathNames(e.ChangedPaths), "\n")
+		env["POGO_ADDED_FILES"] = strings.Join(byOperation[FileAdded], "\n")
+		env["POGO_MODIFIED_FILES"] = strings.Join(byOperation[FileModified], "\n")
+		env["POGO_REMOVED_FILES"] = strings.Join(byOperation[FileRemoved], "\n")
+	case e.ChangedFiles != nil:
+		env["POGO_CHANGED_FILES"] = strings.Join(e.ChangedFiles, "\n")
+	}
+	return env
+}
+
+// withEventEnv returns a copy of the task where container and process tasks
+// get the POGO_* variables of the event, unless they set them themselves.
+func (t Task) withEventEnv(event Event) Task {
+	withEnv := func(env map[string]string) map[string]string {
+		merged := event.Env()
+		for k, v := range env {
+			merged[k] = v
+		}
+		return merged
+	}
+	if t.Container != nil {
+		c := *t.Container
+		c.Environment = withEnv(c.Environment)
+		t.Container = &c
+	}
+	if t.Process != nil {
+		p := *t.Process
+		p.Environment = withEnv(p.Environment)
+		t.Process = &p
+	}
+	return t
+}
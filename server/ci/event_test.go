@@ -0,0 +1,142 @@
+package ci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvent_Env(t *testing.T) {
+	event := Event{
+		Type:               EventTypePush,
+		Rev:                "main",
+		AccessToken:        "s3cr3t",
+		RepositoryID:       7,
+		ChangeName:         "quick-fox-42",
+		ChangeID:           42,
+		Parents:            []string{"lazy-dog-1", "lazy-dog-2"},
+		Bookmark:           "main",
+		Pattern:            "ma*",
+		PreviousChangeName: "lazy-dog-1",
+		TriggeredBy:        "johndoe",
+		ChangedPaths: []ChangedPath{
+			{Path: "README.md", Operation: FileModified},
+			{Path: "new.go", Operation: FileAdded},
+			{Path: "old.go", Operation: FileRemoved},
+			{Path: "other.go", Operation: FileAdded},
+		},
+	}
+
+	env := event.Env()
+	want := map[string]string{
+		"POGO_EVENT":                "push",
+		"POGO_REV":                  "main",
+		"POGO_REPOSITORY_ID":        "7",
+		"POGO_CHANGE_NAME":          "quick-fox-42",
+		"POGO_CHANGE_ID":            "42",
+		"POGO_PARENTS":              "lazy-dog-1\nlazy-dog-2",
+		"POGO_BOOKMARK":             "main",
+		"POGO_PATTERN":              "ma*",
+		"POGO_PREVIOUS_CHANGE_NAME": "lazy-dog-1",
+		"POGO_TRIGGERED_BY":         "johndoe",
+		"POGO_CHANGED_FILES":        "README.md\nnew.go\nold.go\nother.go",
+		"POGO_ADDED_FILES":          "new.go\nother.go",
+		"POGO_MODIFIED_FILES":       "README.md",
+		"POGO_REMOVED_FILES":        "old.go",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %q, want %q", k, env[k], v)
+		}
+	}
+	if len(env) != len(want) {
+		t.Errorf("Env() = %v, want exactly %v", env, want)
+	}
+	for k, v := range env {
+		if v == "s3cr3t" {
+			t.Errorf("%s exports the access token", k)
+		}
+	}
+
+	t.Run("unknown changed files", func(t *testing.T) {
+		env := Event{Type: EventTypeSchedule, Rev: "main"}.Env()
+		if _, ok := env["POGO_CHANGED_FILES"]; ok {
+			t.Error("POGO_CHANGED_FILES set although the changed files are unknown")
+		}
+		if _, ok := env["POGO_TRIGGERED_BY"]; ok {
+			t.Error("POGO_TRIGGERED_BY set for a schedule")
+		}
+	})
+
+	t.Run("no changed files", func(t *testing.T) {
+		env := Event{Type: EventTypePush, ChangedPaths: []ChangedPath{}}.Env()
+		if v, ok := env["POGO_CHANGED_FILES"]; !ok || v != "" {
+			t.Errorf("POGO_CHANGED_FILES = %q, %v, want an empty value", v, ok)
+		}
+	})
+}
+
+func TestTask_WithEventEnv(t *testing.T) {
+	container := &ContainerTask{Image: "alpine", Environment: map[string]string{"POGO_REV": "custom"}}
+	task := Task{Container: container}
+	event := Event{Type: EventTypePush, Rev: "main", ChangeName: "quick-fox-42"}
+
+	got := task.withEventEnv(event)
+	if got.Container.Environment["POGO_REV"] != "custom" {
+		t.Errorf("POGO_REV = %q, want the value of the task", got.Container.Environment["POGO_REV"])
+	}
+	if got.Container.Environment["POGO_CHANGE_NAME"] != "quick-fox-42" {
+		t.Errorf("POGO_CHANGE_NAME = %q, want quick-fox-42", got.Container.Environment["POGO_CHANGE_NAME"])
+	}
+	if _, ok := container.Environment["POGO_CHANGE_NAME"]; ok {
+		t.Error("withEventEnv() changed the original task")
+	}
+
+	process := Task{Process: &ProcessTask{Commands: []string{"true"}}}.withEventEnv(event)
+	if process.Process.Environment["POGO_EVENT"] != "push" {
+		t.Errorf("POGO_EVENT = %q, want push", process.Process.Environment["POGO_EVENT"])
+	}
+}
+
+func TestExecutor_EventContextInTemplates(t *testing.T) {
+	var body string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["release/*"]
+do:
+  - webhook:
+      url: ` + testServer.URL + `
+      method: POST
+      body: '{{ .Bookmark }} {{ .Pattern }} {{ .PreviousChangeName }} {{ .ChangeName }} {{ .TriggeredBy }}{{ range .ChangedPaths }} {{ .Operation }}:{{ .Path }}{{ end }}'
+`),
+	}
+	event := Event{
+		Type:               EventTypePush,
+		Rev:                "release/1.0",
+		Bookmark:           "release/1.0",
+		ChangeName:         "quick-fox-42",
+		PreviousChangeName: "lazy-dog-1",
+		TriggeredBy:        "johndoe",
+		ChangedPaths:       []ChangedPath{{Path: "a.go", Operation: FileAdded}},
+	}
+
+	if _, err := NewExecutor().ExecuteForEvent(context.Background(), configFiles, event); err != nil {
+		t.Fatalf("ExecuteForEvent() error = %v", err)
+	}
+	want := "release/1.0 release/* lazy-dog-1 quick-fox-42 johndoe added:a.go"
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
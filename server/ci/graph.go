@@ -0,0 +1,223 @@
+package ci
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+)
+
+// JobStatus is the outcome of a single job of a pipeline.
+type JobStatus string
+
+const (
+	JobStatusSuccess JobStatus = "success"
+	JobStatusFailure JobStatus = "failure"
+	JobStatusSkipped JobStatus = "skipped"
+	// JobStatusCancelled marks jobs stopped or never started because the pipeline was cancelled
+	JobStatusCancelled JobStatus = "cancelled"
+	// JobStatusRunning is reported to observers while the job runs
+	JobStatusRunning JobStatus = "running"
+	// JobStatusWaiting is reported to approvers while the job waits for approval
+	JobStatusWaiting JobStatus = "waiting"
+)
+
+// jobSlots limits the number of container jobs running at the same time across all pipelines.
+var jobSlots = make(chan struct{}, runtime.NumCPU())
+
+// SetMaxParallelJobs sets the server-wide limit of concurrently running container jobs.
+// It must be called before any pipeline is executed.
+func SetMaxParallelJobs(n int) {
+	if n < 1 {
+		n = 1
+	}
+	jobSlots = make(chan struct{}, n)
+}
+
+type job struct {
+	task  Task
+	name  string
+	needs []string
+	// ancestors are the indices of all jobs this job transitively depends on
+	ancestors []int
+	// group is the name of the task for all jobs of a matrix task
+	group string
+	// matrix holds the values of the combination a matrix job runs
+	matrix map[string]string
+	// slots limits the running jobs of a matrix task, nil if unlimited
+	slots chan struct{}
+}
+
+// buildJobGraph names the tasks and resolves their dependencies. Tasks without
+// a name are called task-<n>. If no task declares needs, every task needs the
+// previous one so the do list runs in order. A matrix task becomes one job per
+// combination, needing it means needing all of them.
+func buildJobGraph(tasks []Task) ([]job, error) {
+	explicit := slices.ContainsFunc(tasks, func(t Task) bool { return len(t.Needs) > 0 })
+
+	var jobs []job
+	// taskIndex is the index of the task each job belongs to
+	var taskIndex []int
+	names := make([]string, len(tasks))
+	groups := make(map[string][]string, len(tasks))
+	indices := make(map[string]int, len(tasks))
+	add := func(i int, j job) error {
+		if _, ok := indices[j.name]; ok {
+			return fmt.Errorf("duplicate task name %q", j.name)
+		}
+		indices[j.name] = len(jobs)
+		jobs = append(jobs, j)
+		taskIndex = append(taskIndex, i)
+		groups[names[i]] = append(groups[names[i]], j.name)
+		return nil
+	}
+	for i, task := range tasks {
+		name := task.Name
+		if name == "" {
+			name = fmt.Sprintf("task-%d", i+1)
+		}
+		if _, ok := groups[name]; ok {
+			return nil, fmt.Errorf("duplicate task name %q", name)
+		}
+		names[i] = name
+
+		if task.Matrix == nil {
+			instance, err := task.withMatrix(nil)
+			if err != nil {
+				return nil, fmt.Errorf("task %q: %w", name, err)
+			}
+			if err := add(i, job{task: instance, name: name}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		combinations := task.Matrix.Combinations()
+		if len(combinations) == 0 {
+			return nil, fmt.Errorf("matrix of task %q has no combinations", name)
+		}
+		var slots chan struct{}
+		if task.Matrix.MaxParallel > 0 {
+			slots = make(chan struct{}, task.Matrix.MaxParallel)
+		}
+		for _, values := range combinations {
+			instance, err := task.withMatrix(values)
+			if err != nil {
+				return nil, fmt.Errorf("task %q: %w", name, err)
+			}
+			j := job{task: instance, name: matrixJobName(name, values), group: name, matrix: values, slots: slots}
+			if err := add(i, j); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := range jobs {
+		var needs []string
+		if explicit {
+			needs = jobs[i].task.Needs
+		} else if t := taskIndex[i]; t > 0 {
+			needs = []string{names[t-1]}
+		}
+		for _, need := range needs {
+			group, ok := groups[need]
+			if !ok {
+				return nil, fmt.Errorf("task %q needs unknown task %q", jobs[i].name, need)
+			}
+			jobs[i].needs = append(jobs[i].needs, group...)
+		}
+	}
+
+	// Depth first search for cycles, collecting the ancestors on the way
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(jobs))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("task %q is part of a dependency cycle", jobs[i].name)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		seen := make(map[int]struct{})
+		for _, need := range jobs[i].needs {
+			n := indices[need]
+			if err := visit(n); err != nil {
+				return err
+			}
+			seen[n] = struct{}{}
+			for _, a := range jobs[n].ancestors {
+				seen[a] = struct{}{}
+			}
+		}
+		for a := range seen {
+			jobs[i].ancestors = append(jobs[i].ancestors, a)
+		}
+		slices.Sort(jobs[i].ancestors)
+		state[i] = visited
+		return nil
+	}
+	for i := range jobs {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
+
+// GraphJob is a job as shown in a rendered pipeline graph.
+type GraphJob struct {
+	Name   string
+	Needs  []string
+	Status JobStatus
+	// RunID is the ID of the stored run of the job, if any
+	RunID int64
+}
+
+// LayoutJobGraph groups jobs into stages. Every job is placed in the stage
+// after the last of its needs, jobs in the same stage can run in parallel.
+// Needs that are not part of the given jobs are ignored.
+func LayoutJobGraph(jobs []GraphJob) [][]GraphJob {
+	byName := make(map[string]int, len(jobs))
+	for i, j := range jobs {
+		byName[j.Name] = i
+	}
+
+	depth := make([]int, len(jobs))
+	for i := range depth {
+		depth[i] = -1
+	}
+	var resolve func(i int, path map[int]bool) int
+	resolve = func(i int, path map[int]bool) int {
+		if depth[i] >= 0 {
+			return depth[i]
+		}
+		path[i] = true
+		d := 0
+		for _, need := range jobs[i].Needs {
+			n, ok := byName[need]
+			if !ok || path[n] {
+				continue
+			}
+			d = max(d, resolve(n, path)+1)
+		}
+		delete(path, i)
+		depth[i] = d
+		return d
+	}
+
+	var stages [][]GraphJob
+	for i, j := range jobs {
+		d := resolve(i, make(map[int]bool))
+		for len(stages) <= d {
+			stages = append(stages, nil)
+		}
+		stages[d] = append(stages[d], j)
+	}
+	return stages
+}
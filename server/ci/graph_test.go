@@ -0,0 +1,295 @@
+package ci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildJobGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []Task
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name:  "sequential without needs",
+			tasks: []Task{{}, {Name: "test"}, {}},
+			want: map[string][]string{
+				"task-1": nil,
+				"test":   {"task-1"},
+				"task-3": {"test"},
+			},
+		},
+		{
+			name: "explicit needs",
+			tasks: []Task{
+				{Name: "build"},
+				{Name: "lint"},
+				{Name: "test", Needs: []string{"build"}},
+				{Name: "deploy", Needs: []string{"test", "lint"}},
+			},
+			want: map[string][]string{
+				"build":  nil,
+				"lint":   nil,
+				"test":   {"build"},
+				"deploy": {"test", "lint"},
+			},
+		},
+		{
+			name: "matrix needs all combinations",
+			tasks: []Task{
+				{Name: "test", Matrix: &Matrix{Axes: map[string][]string{"go": {"1.22", "1.23"}}}},
+				{Name: "deploy"},
+			},
+			want: map[string][]string{
+				"test (1.22)": nil,
+				"test (1.23)": nil,
+				"deploy":      {"test (1.22)", "test (1.23)"},
+			},
+		},
+		{
+			name:    "duplicate name",
+			tasks:   []Task{{Name: "build"}, {Name: "build"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown need",
+			tasks:   []Task{{Name: "test", Needs: []string{"build"}}},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			tasks: []Task{
+				{Name: "a", Needs: []string{"c"}},
+				{Name: "b", Needs: []string{"a"}},
+				{Name: "c", Needs: []string{"b"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := buildJobGraph(tt.tasks)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("buildJobGraph() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildJobGraph() error = %v", err)
+			}
+			for _, j := range jobs {
+				if fmt.Sprint(j.needs) != fmt.Sprint(tt.want[j.name]) {
+					t.Errorf("needs of %s = %v, want %v", j.name, j.needs, tt.want[j.name])
+				}
+			}
+		})
+	}
+}
+
+func TestExecutor_JobGraphRunsIndependentJobsConcurrently(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu      sync.Mutex
+		order   []string
+		arrived = make(chan struct{}, 2)
+		release = make(chan struct{})
+	)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/deploy" {
+			// Both independent jobs must be in flight before either finishes
+			arrived <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	go func() {
+		for range 2 {
+			select {
+			case <-arrived:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		close(release)
+	}()
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - name: build
+    webhook:
+      url: %[1]s/build
+      method: POST
+  - name: lint
+    webhook:
+      url: %[1]s/lint
+      method: POST
+  - name: deploy
+    needs: [build, lint]
+    webhook:
+      url: %[1]s/deploy
+      method: POST
+`, testServer.URL)),
+	}
+
+	start := time.Now()
+	results, err := NewExecutor().ExecuteForEvent(ctx, configFiles, Event{Type: EventTypePush, Rev: "main"})
+	if err != nil {
+		t.Fatalf("ExecuteForEvent() error = %v", err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Error("independent jobs did not run concurrently")
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for _, res := range results {
+		if res.Status != JobStatusSuccess {
+			t.Errorf("job %s status = %s, want success", res.JobName, res.Status)
+		}
+	}
+	if results[2].JobName != "deploy" || fmt.Sprint(results[2].Needs) != "[build lint]" {
+		t.Errorf("unexpected deploy result %s needs %v", results[2].JobName, results[2].Needs)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 3 || order[2] != "/deploy" {
+		t.Errorf("deploy must run after its needs, got order %v", order)
+	}
+}
+
+func TestLayoutJobGraph(t *testing.T) {
+	stages := LayoutJobGraph([]GraphJob{
+		{Name: "build"},
+		{Name: "lint"},
+		{Name: "test", Needs: []string{"build"}},
+		{Name: "deploy", Needs: []string{"test", "lint"}},
+		{Name: "orphan", Needs: []string{"missing"}},
+	})
+
+	var got [][]string
+	for _, stage := range stages {
+		var names []string
+		for _, j := range stage {
+			names = append(names, j.Name)
+		}
+		got = append(got, names)
+	}
+	want := "[[build lint orphan] [test] [deploy]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("LayoutJobGraph() = %v, want %s", got, want)
+	}
+}
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	started  []string
+	finished map[string]JobStatus
+	logs     map[string]*bytes.Buffer
+}
+
+func (o *recordingObserver) JobStarted(res TaskExecutionResult) io.Writer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started = append(o.started, res.JobName)
+	buf := &bytes.Buffer{}
+	o.logs[res.JobName] = buf
+	return buf
+}
+
+func (o *recordingObserver) JobFinished(res TaskExecutionResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished[res.JobName] = res.Status
+}
+
+func TestExecutor_ObserverAndCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(fmt.Sprintf(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - name: build
+    webhook:
+      url: %[1]s/build
+      method: POST
+  - name: slow
+    needs: [build]
+    webhook:
+      url: %[1]s/slow
+      method: POST
+  - name: deploy
+    needs: [slow]
+    if: "true"
+    webhook:
+      url: %[1]s/deploy
+      method: POST
+`, testServer.URL)),
+	}
+
+	observer := &recordingObserver{finished: make(map[string]JobStatus), logs: make(map[string]*bytes.Buffer)}
+	executor := NewExecutor()
+	executor.SetObserver(observer)
+
+	results, err := executor.ExecuteForEvent(ctx, configFiles, Event{Type: EventTypePush, Rev: "main"})
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("ExecuteForEvent() error = %v, want ErrCancelled", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	want := map[string]JobStatus{"build": JobStatusSuccess, "slow": JobStatusCancelled, "deploy": JobStatusCancelled}
+	for name, status := range want {
+		if observer.finished[name] != status {
+			t.Errorf("job %s finished with %q, want %q", name, observer.finished[name], status)
+		}
+	}
+	if fmt.Sprint(observer.started) != "[build slow]" {
+		t.Errorf("started jobs = %v, want [build slow]", observer.started)
+	}
+	if !strings.Contains(observer.logs["build"].String(), "Request: POST") {
+		t.Errorf("build log was not streamed to the observer: %q", observer.logs["build"].String())
+	}
+	if results[0].ConfigFilename != "ci.yaml" || results[2].ConfigFilename != "ci.yaml" {
+		t.Error("results must carry the config filename")
+	}
+}
@@ -0,0 +1,167 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// NetworkBridge gives the container access to the internet and its services
+	NetworkBridge = "bridge"
+	// NetworkServices only lets the container reach its services
+	NetworkServices = "services"
+	// NetworkNone disables networking, the task can not have services
+	NetworkNone = "none"
+)
+
+// Limits are the defaults and maximums applied to the containers of container
+// tasks. The timeouts also apply to process tasks. Zero values mean no limit.
+type Limits struct {
+	// DefaultTimeout is used for tasks without a timeout
+	DefaultTimeout time.Duration
+	// MaxTimeout is the longest timeout a task may set
+	MaxTimeout time.Duration
+	// DefaultCPU is used for tasks without a cpu limit
+	DefaultCPU float64
+	// MaxCPU is the largest cpu limit a task may set
+	MaxCPU float64
+	// DefaultMemory is used for tasks without a memory limit, in bytes
+	DefaultMemory int64
+	// MaxMemory is the largest memory limit a task may set, in bytes
+	MaxMemory int64
+	// PidsLimit is the maximum number of processes in every container
+	PidsLimit int64
+}
+
+// containerLimits are the limits applied by all executors.
+var containerLimits Limits
+
+// SetContainerLimits sets the limits applied to the containers of all
+// executors. It must be called before any pipeline is executed.
+func SetContainerLimits(limits Limits) {
+	containerLimits = limits
+}
+
+// taskLimits are the resolved limits of a single task.
+type taskLimits struct {
+	timeout  time.Duration
+	nanoCPUs int64
+	memory   int64
+}
+
+// resolve returns the limits of a task, using the defaults for unset values
+// and lowering values above the maximums. Lowered values are logged.
+func (l Limits) resolve(task ContainerTask, log io.Writer) (taskLimits, error) {
+	var resolved taskLimits
+
+	timeout, err := l.resolveTimeout(task.Timeout, log)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.timeout = timeout
+
+	cpu := l.DefaultCPU
+	if task.CPU < 0 {
+		return resolved, fmt.Errorf("cpu must not be negative, got %g", task.CPU)
+	}
+	if task.CPU > 0 {
+		cpu = task.CPU
+	}
+	if l.MaxCPU > 0 && (cpu == 0 || cpu > l.MaxCPU) {
+		if cpu > 0 {
+			fmt.Fprintf(log, "CPU limit %g exceeds the maximum of %g\n", cpu, l.MaxCPU)
+		}
+		cpu = l.MaxCPU
+	}
+	resolved.nanoCPUs = int64(math.Round(cpu * 1e9))
+
+	memory := l.DefaultMemory
+	if task.Memory != "" {
+		var err error
+		if memory, err = ParseMemory(task.Memory); err != nil {
+			return resolved, err
+		}
+	}
+	if l.MaxMemory > 0 && (memory == 0 || memory > l.MaxMemory) {
+		if memory > 0 {
+			fmt.Fprintf(log, "Memory limit %d bytes exceeds the maximum of %d bytes\n", memory, l.MaxMemory)
+		}
+		memory = l.MaxMemory
+	}
+	resolved.memory = memory
+
+	return resolved, nil
+}
+
+// resolveTimeout returns the timeout of a task, the default if it sets none
+// and the maximum if it is above. Lowered timeouts are logged.
+func (l Limits) resolveTimeout(s string, log io.Writer) (time.Duration, error) {
+	timeout := l.DefaultTimeout
+	if s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: %w", s, err)
+		}
+		if timeout <= 0 {
+			return 0, fmt.Errorf("timeout must be positive, got %s", s)
+		}
+	}
+	if l.MaxTimeout > 0 && (timeout == 0 || timeout > l.MaxTimeout) {
+		if timeout > 0 {
+			fmt.Fprintf(log, "Timeout %s exceeds the maximum of %s\n", timeout, l.MaxTimeout)
+		}
+		timeout = l.MaxTimeout
+	}
+	return timeout, nil
+}
+
+// ParseMemory parses a memory size like 512m or 2g into bytes. The suffixes
+// b, k, m and g are powers of 1024, a number without suffix is in bytes.
+func ParseMemory(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "ib")
+	str = strings.TrimSuffix(str, "b")
+
+	multiplier := int64(1)
+	if str != "" {
+		switch str[len(str)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			str = str[:len(str)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value <= 0 || value*float64(multiplier) > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// networkMode validates the network setting of a task and reports whether
+// the task needs an own network and whether that network is internal.
+func networkMode(task ContainerTask) (own bool, internal bool, err error) {
+	switch task.Network {
+	case "", NetworkBridge:
+		return true, false, nil
+	case NetworkServices:
+		return true, true, nil
+	case NetworkNone:
+		if len(task.Services) > 0 {
+			return false, false, fmt.Errorf("network %q can not be used with services", NetworkNone)
+		}
+		return false, false, nil
+	default:
+		return false, false, fmt.Errorf("unknown network %q, expected %s, %s or %s", task.Network, NetworkBridge, NetworkServices, NetworkNone)
+	}
+}
@@ -0,0 +1,148 @@
+package ci
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1024", want: 1024},
+		{in: "512m", want: 512 << 20},
+		{in: "512MB", want: 512 << 20},
+		{in: "2g", want: 2 << 30},
+		{in: "1.5GiB", want: 3 << 29},
+		{in: "64k", want: 64 << 10},
+		{in: "", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "lots", wantErr: true},
+		{in: "-1g", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMemory(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMemory(%q) expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMemory(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMemory(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsResolve(t *testing.T) {
+	limits := Limits{
+		DefaultTimeout: time.Hour,
+		MaxTimeout:     2 * time.Hour,
+		MaxCPU:         2,
+		DefaultMemory:  1 << 30,
+	}
+
+	tests := []struct {
+		name    string
+		task    ContainerTask
+		want    taskLimits
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: taskLimits{timeout: time.Hour, nanoCPUs: 2e9, memory: 1 << 30},
+		},
+		{
+			name: "within maximums",
+			task: ContainerTask{Timeout: "90m", CPU: 0.5, Memory: "256m"},
+			want: taskLimits{timeout: 90 * time.Minute, nanoCPUs: 5e8, memory: 256 << 20},
+		},
+		{
+			name: "above maximums",
+			task: ContainerTask{Timeout: "10h", CPU: 8},
+			want: taskLimits{timeout: 2 * time.Hour, nanoCPUs: 2e9, memory: 1 << 30},
+		},
+		{
+			name:    "invalid timeout",
+			task:    ContainerTask{Timeout: "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "negative cpu",
+			task:    ContainerTask{CPU: -1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid memory",
+			task:    ContainerTask{Memory: "much"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := limits.resolve(tt.task, io.Discard)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("resolve() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	unlimited, err := Limits{}.resolve(ContainerTask{}, io.Discard)
+	if err != nil {
+		t.Fatalf("resolve() error = %v", err)
+	}
+	if unlimited != (taskLimits{}) {
+		t.Errorf("resolve() without limits = %+v, want no limits", unlimited)
+	}
+}
+
+func TestNetworkMode(t *testing.T) {
+	tests := []struct {
+		task     ContainerTask
+		own      bool
+		internal bool
+		wantErr  bool
+	}{
+		{task: ContainerTask{}, own: true},
+		{task: ContainerTask{Network: NetworkBridge}, own: true},
+		{task: ContainerTask{Network: NetworkServices}, own: true, internal: true},
+		{task: ContainerTask{Network: NetworkNone}},
+		{task: ContainerTask{Network: NetworkNone, Services: []Service{{Name: "db", Image: "postgres"}}}, wantErr: true},
+		{task: ContainerTask{Network: "host"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		own, internal, err := networkMode(tt.task)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("networkMode(%q) expected error", tt.task.Network)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("networkMode(%q) error = %v", tt.task.Network, err)
+			continue
+		}
+		if own != tt.own || internal != tt.internal {
+			t.Errorf("networkMode(%q) = %v, %v, want %v, %v", tt.task.Network, own, internal, tt.own, tt.internal)
+		}
+	}
+}
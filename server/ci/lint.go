@@ -0,0 +1,328 @@
+package ci
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// LintSeverity tells whether a config problem breaks the config.
+type LintSeverity string
+
+const (
+	// LintError marks problems that make the config fail or not run as written
+	LintError LintSeverity = "error"
+	// LintWarning marks problems the config still runs with, like unknown
+	// properties that are ignored
+	LintWarning LintSeverity = "warning"
+)
+
+// LintProblem is a problem found in a config.
+type LintProblem struct {
+	Severity LintSeverity
+	// Line is the line of the problem in the config, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s: line %d: %s", p.Severity, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+}
+
+// lintEvent is the event a config is rendered with while it is linted.
+var lintEvent = Event{Rev: "main", Type: EventTypePush}
+
+// Lint validates a config without running it. It checks the template syntax,
+// the secret references, the rendered config against schema.json and the glob
+// patterns, cron expressions, if expressions and needs of the tasks.
+// hasSecret reports whether a config declaring the environment, empty for
+// none, may use a secret; secrets are not checked if it is nil. Templates are
+// rendered with a push event of main, so only the branches taken for that
+// event are validated against the schema.
+func Lint(config []byte, hasSecret func(key, environment string) bool) []LintProblem {
+	l := linter{source: string(config), hasSecret: hasSecret}
+	l.lint()
+	slices.SortStableFunc(l.problems, func(a, b LintProblem) int {
+		return a.Line - b.Line
+	})
+	return l.problems
+}
+
+type linter struct {
+	source    string
+	hasSecret func(key, environment string) bool
+	// environment is the environment the config declares
+	environment string
+	problems    []LintProblem
+}
+
+func (l *linter) add(severity LintSeverity, line int, format string, args ...any) {
+	l.problems = append(l.problems, LintProblem{Severity: severity, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// line returns the line of a byte offset in the config.
+func (l *linter) line(pos parse.Pos) int {
+	if int(pos) > len(l.source) {
+		return 0
+	}
+	return strings.Count(l.source[:pos], "\n") + 1
+}
+
+// funcs returns the config functions with secrets replaced by a placeholder,
+// the references are checked separately.
+func (l *linter) funcs() template.FuncMap {
+	funcs := makeUnmarshalConfigFuncs(nil, nil, "", false)
+	funcs["secret"] = func(key string) string {
+		return "secret"
+	}
+	funcs["hasSecret"] = func(key string) bool {
+		return true
+	}
+	return funcs
+}
+
+func (l *linter) lint() {
+	if probe := probeConfig([]byte(l.source), lintEvent); probe != nil {
+		l.environment = probe.Environment
+	}
+
+	rendered := []byte(l.source)
+	t, err := template.New("ci_config").Funcs(l.funcs()).Parse(l.source)
+	if err != nil {
+		l.add(LintError, 0, "template parsing failed, the config would be used as plain YAML without replacing template expressions: %v", err)
+	} else {
+		for _, tmpl := range t.Templates() {
+			if tmpl.Tree != nil {
+				l.checkTemplate(tmpl.Tree.Root, "")
+			}
+		}
+		var sb strings.Builder
+		if err := t.Execute(&sb, lintEvent); err != nil {
+			l.add(LintError, 0, "execute template: %v", err)
+			return
+		}
+		rendered = []byte(sb.String())
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(rendered, &doc); err != nil {
+		l.add(LintError, 0, "%v", err)
+		return
+	}
+	if doc.Kind == 0 {
+		l.add(LintError, 0, "config is empty")
+		return
+	}
+
+	s, err := configSchema()
+	if err != nil {
+		l.add(LintError, 0, "load schema: %v", err)
+		return
+	}
+	invalid := false
+	for _, problem := range validateSchema(s, &doc) {
+		severity := LintError
+		if problem.unknown {
+			severity = LintWarning
+		} else {
+			invalid = true
+		}
+		l.add(severity, problem.line, "%s", problem)
+	}
+	if invalid {
+		return
+	}
+
+	var c Config
+	if err := doc.Decode(&c); err != nil {
+		l.add(LintError, 0, "%v", err)
+		return
+	}
+	l.checkConfig(&c)
+}
+
+// checkTemplate reports calls of secret with unknown keys and invalid
+// hashFiles patterns. field is set for templates that are not parsed from the
+// config itself, like if expressions, and is used instead of the line.
+func (l *linter) checkTemplate(node parse.Node, field string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			l.checkTemplate(child, field)
+		}
+	case *parse.ActionNode:
+		l.checkTemplate(n.Pipe, field)
+	case *parse.IfNode:
+		l.checkBranch(&n.BranchNode, field)
+	case *parse.RangeNode:
+		l.checkBranch(&n.BranchNode, field)
+	case *parse.WithNode:
+		l.checkBranch(&n.BranchNode, field)
+	case *parse.TemplateNode:
+		l.checkTemplate(n.Pipe, field)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			l.checkCommand(cmd, field)
+			for _, arg := range cmd.Args {
+				l.checkTemplate(arg, field)
+			}
+		}
+	}
+}
+
+func (l *linter) checkBranch(n *parse.BranchNode, field string) {
+	l.checkTemplate(n.Pipe, field)
+	l.checkTemplate(n.List, field)
+	l.checkTemplate(n.ElseList, field)
+}
+
+func (l *linter) checkCommand(cmd *parse.CommandNode, field string) {
+	if len(cmd.Args) < 2 {
+		return
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return
+	}
+	line, prefix := 0, field+": "
+	if field == "" {
+		line, prefix = l.line(cmd.Position()), ""
+	}
+	switch ident.Ident {
+	case "secret":
+		key, ok := cmd.Args[1].(*parse.StringNode)
+		if !ok || l.hasSecret == nil || l.hasSecret(key.Text, l.environment) {
+			break
+		}
+		if l.environment != "" {
+			l.add(LintError, line, "%ssecret %q not found in the shared secrets or environment %q", prefix, key.Text, l.environment)
+		} else {
+			l.add(LintError, line, "%ssecret %q not found", prefix, key.Text)
+		}
+	case "hashFiles":
+		for _, arg := range cmd.Args[1:] {
+			if pattern, ok := arg.(*parse.StringNode); ok {
+				if err := checkGlob(pattern.Text); err != nil {
+					l.add(LintError, line, "%shashFiles: %v", prefix, err)
+				}
+			}
+		}
+	}
+}
+
+// checkConfig validates the values of a config that schema.json can not.
+func (l *linter) checkConfig(c *Config) {
+	if c.On.Push != nil {
+		l.checkBookmarks("on.push.bookmarks", c.On.Push.Bookmarks)
+		l.checkPathFilter("on.push", c.On.Push.PathFilter)
+	}
+	if c.On.Remove != nil {
+		l.checkBookmarks("on.remove.bookmarks", c.On.Remove.Bookmarks)
+	}
+	if c.On.Change != nil {
+		l.checkPathFilter("on.change", c.On.Change.PathFilter)
+	}
+	for i, schedule := range c.On.Schedule {
+		if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+			l.add(LintError, 0, "on.schedule[%d].cron: invalid cron expression %q: %v", i, schedule.Cron, err)
+		}
+		l.checkBookmarks(fmt.Sprintf("on.schedule[%d].bookmarks", i), schedule.Bookmarks)
+	}
+
+	if _, err := c.Token.Resolve(); err != nil {
+		l.add(LintError, 0, "token: %v", err)
+	}
+
+	for i, task := range c.Do {
+		prefix := fmt.Sprintf("do[%d]", i)
+		if expr := strings.TrimSpace(task.If); expr != "" {
+			if t, err := parseCondition(expr, l.funcs()); err != nil {
+				l.add(LintError, 0, "%s.if: %v", prefix, err)
+			} else {
+				l.checkTemplate(t.Tree.Root, prefix+".if")
+			}
+		}
+		if task.Container == nil {
+			continue
+		}
+		l.checkOutputGlobs(prefix+".container.artifacts", task.Container.Artifacts)
+		for j, cache := range task.Container.Cache {
+			l.checkGlobs(fmt.Sprintf("%s.container.cache[%d].paths", prefix, j), cache.Paths)
+		}
+		for j, report := range task.Container.Reports {
+			l.checkOutputGlobs(fmt.Sprintf("%s.container.reports[%d].paths", prefix, j), report.Paths)
+		}
+	}
+
+	if _, err := buildJobGraph(c.Do); err != nil {
+		l.add(LintError, 0, "do: %v", err)
+	}
+}
+
+func (l *linter) checkBookmarks(field string, patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			l.add(LintError, 0, "%s: invalid pattern %q", field, pattern)
+		}
+	}
+}
+
+func (l *linter) checkPathFilter(field string, f PathFilter) {
+	for _, pattern := range f.Paths {
+		if err := checkGlob(strings.TrimPrefix(pattern, "!")); err != nil {
+			l.add(LintError, 0, "%s.paths: %v", field, err)
+		}
+	}
+	for _, pattern := range f.PathsIgnore {
+		if err := checkGlob(strings.TrimPrefix(pattern, "!")); err != nil {
+			l.add(LintError, 0, "%s.paths_ignore: %v", field, err)
+		}
+	}
+}
+
+func (l *linter) checkGlobs(field string, patterns []string) {
+	for _, pattern := range patterns {
+		if err := checkGlob(pattern); err != nil {
+			l.add(LintError, 0, "%s: %v", field, err)
+		}
+	}
+}
+
+// checkOutputGlobs checks the patterns of files copied out of containers,
+// which must stay inside the working directory.
+func (l *linter) checkOutputGlobs(field string, patterns []string) {
+	for _, pattern := range patterns {
+		if err := checkGlob(pattern); err != nil {
+			l.add(LintError, 0, "%s: %v", field, err)
+		} else if err := checkOutputPattern(pattern); err != nil {
+			l.add(LintError, 0, "%s: %v", field, err)
+		}
+	}
+}
+
+// checkGlob checks every element of a slash separated pattern, like
+// artifacts, caches and path filters match them.
+func checkGlob(pattern string) error {
+	for _, part := range strings.Split(pattern, "/") {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
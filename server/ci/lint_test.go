@@ -0,0 +1,246 @@
+package ci
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	scope := Secrets{
+		Shared:       map[string]string{"TOKEN": ""},
+		Environments: map[string]Environment{"production": {Secrets: map[string]string{"DEPLOY_TOKEN": ""}}},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "valid",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main", "release/*"]
+    paths: ["src/**", "!docs"]
+  schedule:
+    - cron: "0 3 * * *"
+      bookmarks: ["main"]
+do:
+  - name: test
+    container:
+      image: golang:1.25
+      commands:
+        - go test ./...
+      environment:
+        PORT: 8080
+        TOKEN: '{{ secret "TOKEN" }}'
+      cache:
+        - key: go-{{ hashFiles "go.sum" }}
+          paths: ["/go/pkg/mod"]
+      reports:
+        - format: go-test
+          paths: ["report.json"]
+      timeout: 10m
+  - name: notify
+    needs: [test]
+    if: and .Failure (hasSecret "TOKEN")
+    webhook:
+      url: https://example.com
+      method: POST
+`,
+		},
+		{
+			name: "template syntax",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - webhook:
+      url: "{{ .ArchiveUrl"
+      method: POST
+`,
+			want: []string{"error: template parsing failed"},
+		},
+		{
+			name: "unknown secret",
+			config: `version: 1
+on:
+  manual: {}
+do:
+  - process:
+      commands:
+        - deploy
+      environment:
+        TOKEN: '{{ secret "DEPLOY_TOKEN" }}'
+`,
+			want: []string{`error: line 9: secret "DEPLOY_TOKEN" not found`},
+		},
+		{
+			name: "secret of environment",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main"]
+environment: production
+do:
+  - process:
+      commands:
+        - deploy
+      environment:
+        TOKEN: '{{ secret "DEPLOY_TOKEN" }}'
+`,
+		},
+		{
+			name: "secret of other environment",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main"]
+environment: staging
+do:
+  - process:
+      commands:
+        - deploy
+      environment:
+        TOKEN: '{{ secret "DEPLOY_TOKEN" }}'
+`,
+			want: []string{`error: line 11: secret "DEPLOY_TOKEN" not found in the shared secrets or environment "staging"`},
+		},
+		{
+			name: "schema",
+			config: `version: 2
+on:
+  push: {}
+do:
+  - name: build
+    container:
+      comands: ["make"]
+      network: host
+  - name: build
+    webhook:
+      url: https://example.com
+      method: POST
+    process:
+      commands: ["true"]
+`,
+			want: []string{
+				"error: line 1: version: must be 1",
+				"error: line 3: on.push: missing property bookmarks",
+				"warning: line 7: do[0].container: unknown property comands",
+				"error: line 7: do[0].container: missing property image",
+				"error: line 8: do[0].container.network: must be one of bridge, services, none",
+				"error: line 9: do[1]: must set only one of webhook, container, process",
+			},
+		},
+		{
+			name: "missing trigger and task type",
+			config: `version: 1
+on: {}
+do:
+  - name: build
+`,
+			want: []string{
+				"error: line 2: on: must set one of push, remove, change, schedule, manual",
+				"error: line 4: do[0]: must set one of webhook, container, process",
+			},
+		},
+		{
+			name: "globs and graph",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["[main"]
+  schedule:
+    - cron: "every day"
+      bookmarks: ["main"]
+do:
+  - name: build
+    needs: [lint]
+    if: and .Success (
+    container:
+      image: alpine
+      artifacts: ["dist/[a-"]
+`,
+			want: []string{
+				`error: on.push.bookmarks: invalid pattern "[main"`,
+				`error: on.schedule[0].cron: invalid cron expression "every day"`,
+				`error: do[0].if: parse if expression`,
+				`error: do[0].container.artifacts: invalid pattern "dist/[a-"`,
+				`error: do: task "build" needs unknown task "lint"`,
+			},
+		},
+		{
+			name: "outputs outside working directory",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - container:
+      image: alpine
+      artifacts: ["../../../etc/cron.d/*"]
+      reports:
+        - format: junit
+          paths: ["/tmp/report.xml"]
+`,
+			want: []string{
+				`error: do[0].container.artifacts: pattern "../../../etc/cron.d/*" must be relative`,
+				`error: do[0].container.reports[0].paths: pattern "/tmp/report.xml" must be relative`,
+			},
+		},
+		{
+			name: "token",
+			config: `version: 1
+on:
+  push:
+    bookmarks: ["main"]
+token:
+  ttl: 48h
+  permissions: [report_status]
+do:
+  - webhook:
+      url: https://example.com
+      method: POST
+`,
+			want: []string{
+				`error: token: ttl must be between 0 and 24h0m0s, got 48h0m0s`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := Lint([]byte(tt.config), scope.Has)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("Lint() = %v, want %d problems", problems, len(tt.want))
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem.String(), tt.want[i]) {
+					t.Errorf("problem %d = %q, want prefix %q", i, problem.String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLintRepositoryConfigs(t *testing.T) {
+	entries, err := os.ReadDir("../../.pogo/ci")
+	if err != nil {
+		t.Skipf("no configs: %v", err)
+	}
+	for _, entry := range entries {
+		if !isYAMLFile(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile("../../.pogo/ci/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := Lint(data, nil); len(problems) > 0 {
+			t.Errorf("Lint(%s) = %v", entry.Name(), problems)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package ci
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Matrix runs a task once for every combination of its axes.
+type Matrix struct {
+	// Axes map a name to the values the task runs with, e.g. go: ["1.22", "1.23"]
+	Axes map[string][]string `yaml:",inline" json:"-"`
+	// Combinations added to the expanded axes
+	Include []map[string]string `yaml:"include,omitempty" json:"include,omitempty"`
+	// Combinations removed from the expanded axes, an entry matches every combination with its values
+	Exclude []map[string]string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// Maximum number of combinations running at the same time, unlimited if 0
+	MaxParallel int `yaml:"max_parallel,omitempty" json:"max_parallel,omitempty"`
+}
+
+// Combinations returns the value combinations of the matrix. Axes are expanded
+// in the order of their sorted names, excluded combinations are removed before
+// the included ones are added.
+func (m Matrix) Combinations() []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, axis := range slices.Sorted(maps.Keys(m.Axes)) {
+		values := m.Axes[axis]
+		if len(values) == 0 {
+			continue
+		}
+		expanded := make([]map[string]string, 0, len(combinations)*len(values))
+		for _, combination := range combinations {
+			for _, value := range values {
+				c := maps.Clone(combination)
+				c[axis] = value
+				expanded = append(expanded, c)
+			}
+		}
+		combinations = expanded
+	}
+	if len(combinations) == 1 && len(combinations[0]) == 0 {
+		combinations = nil
+	}
+
+	combinations = slices.DeleteFunc(combinations, func(c map[string]string) bool {
+		return slices.ContainsFunc(m.Exclude, func(exclude map[string]string) bool {
+			return matrixContains(c, exclude)
+		})
+	})
+
+	for _, include := range m.Include {
+		if !slices.ContainsFunc(combinations, func(c map[string]string) bool { return maps.Equal(c, include) }) {
+			combinations = append(combinations, maps.Clone(include))
+		}
+	}
+	return combinations
+}
+
+// matrixContains reports whether the combination has all values of sub.
+func matrixContains(combination, sub map[string]string) bool {
+	for k, v := range sub {
+		if combination[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matrixJobName returns the name of the job running a combination, e.g.
+// "test (1.22, 16)" with the values in the order of their sorted names.
+func matrixJobName(name string, values map[string]string) string {
+	parts := make([]string, 0, len(values))
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		parts = append(parts, values[k])
+	}
+	return name + " (" + strings.Join(parts, ", ") + ")"
+}
+
+// matrixPlaceholder is what the matrix function returns while a config is
+// rendered, it is replaced by the value once the tasks are expanded.
+func matrixPlaceholder(key string) string {
+	return "${{ matrix." + key + " }}"
+}
+
+// matrixEnvName returns the environment variable a matrix value is set as,
+// e.g. MATRIX_GO_VERSION for go-version.
+func matrixEnvName(key string) string {
+	return "MATRIX_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+// withMatrix returns a copy of the task with the matrix placeholders replaced
+// by the values. Container and process tasks also get the values as MATRIX_*
+// environment variables, unless they set them themselves.
+func (t Task) withMatrix(values map[string]string) (Task, error) {
+	var err error
+	replace := func(s string) string {
+		var sb strings.Builder
+		for {
+			before, after, found := strings.Cut(s, "${{ matrix.")
+			if !found {
+				break
+			}
+			key, rest, found := strings.Cut(after, " }}")
+			if !found {
+				break
+			}
+			value, ok := values[key]
+			if !ok && err == nil {
+				err = fmt.Errorf("matrix has no value %q", key)
+			}
+			sb.WriteString(before)
+			sb.WriteString(value)
+			s = rest
+		}
+		sb.WriteString(s)
+		return sb.String()
+	}
+	replaceAll := func(ss []string) []string {
+		if ss == nil {
+			return nil
+		}
+		out := make([]string, len(ss))
+		for i, s := range ss {
+			out[i] = replace(s)
+		}
+		return out
+	}
+	replaceMap := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			out[k] = replace(v)
+		}
+		return out
+	}
+
+	if t.Webhook != nil {
+		w := *t.Webhook
+		w.Url = replace(w.Url)
+		w.Method = replace(w.Method)
+		w.Headers = replaceMap(w.Headers)
+		w.Body = replace(w.Body)
+		t.Webhook = &w
+	}
+
+	if t.Container != nil {
+		c := *t.Container
+		c.Image = replace(c.Image)
+		c.Commands = replaceAll(c.Commands)
+		c.Environment = replaceMap(c.Environment)
+		c.WorkingDir = replace(c.WorkingDir)
+		c.Timeout = replace(c.Timeout)
+		c.Memory = replace(c.Memory)
+		c.Network = replace(c.Network)
+		c.Artifacts = replaceAll(c.Artifacts)
+		if c.Services != nil {
+			services := make([]Service, len(c.Services))
+			for i, s := range c.Services {
+				services[i] = Service{Name: replace(s.Name), Image: replace(s.Image), Environment: replaceMap(s.Environment)}
+			}
+			c.Services = services
+		}
+		if c.Cache != nil {
+			caches := make([]Cache, len(c.Cache))
+			for i, cache := range c.Cache {
+				caches[i] = Cache{Key: replace(cache.Key), Paths: replaceAll(cache.Paths)}
+			}
+			c.Cache = caches
+		}
+		if c.Reports != nil {
+			reports := make([]Report, len(c.Reports))
+			for i, report := range c.Reports {
+				reports[i] = Report{Format: replace(report.Format), Paths: replaceAll(report.Paths)}
+			}
+			c.Reports = reports
+		}
+		c.Environment = withMatrixEnv(c.Environment, values)
+		t.Container = &c
+	}
+
+	if t.Process != nil {
+		p := *t.Process
+		p.Commands = replaceAll(p.Commands)
+		p.Environment = withMatrixEnv(replaceMap(p.Environment), values)
+		p.WorkingDir = replace(p.WorkingDir)
+		p.Timeout = replace(p.Timeout)
+		t.Process = &p
+	}
+
+	return t, err
+}
+
+// withMatrixEnv adds the matrix values as MATRIX_* variables to env, keeping
+// variables the task sets itself.
+func withMatrixEnv(env map[string]string, values map[string]string) map[string]string {
+	if len(values) > 0 && env == nil {
+		env = make(map[string]string, len(values))
+	}
+	for k, v := range values {
+		if _, ok := env[matrixEnvName(k)]; !ok {
+			env[matrixEnvName(k)] = v
+		}
+	}
+	return env
+}
@@ -0,0 +1,154 @@
+package ci
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		want   string
+	}{
+		{
+			name: "axes",
+			matrix: Matrix{Axes: map[string][]string{
+				"postgres": {"15", "16"},
+				"go":       {"1.22", "1.23"},
+			}},
+			want: "[map[go:1.22 postgres:15] map[go:1.22 postgres:16] map[go:1.23 postgres:15] map[go:1.23 postgres:16]]",
+		},
+		{
+			name: "exclude and include",
+			matrix: Matrix{
+				Axes: map[string][]string{
+					"postgres": {"15", "16"},
+					"go":       {"1.22", "1.23"},
+				},
+				Exclude: []map[string]string{{"go": "1.22", "postgres": "16"}, {"postgres": "15"}},
+				Include: []map[string]string{{"go": "1.24", "postgres": "17"}, {"go": "1.23", "postgres": "16"}},
+			},
+			want: "[map[go:1.23 postgres:16] map[go:1.24 postgres:17]]",
+		},
+		{
+			name:   "include only",
+			matrix: Matrix{Include: []map[string]string{{"os": "linux"}}},
+			want:   "[map[os:linux]]",
+		},
+		{
+			name:   "empty",
+			matrix: Matrix{},
+			want:   "[]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(tt.matrix.Combinations()); got != tt.want {
+				t.Errorf("Combinations() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalConfigMatrix(t *testing.T) {
+	configYAML := []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - name: test
+    matrix:
+      go: ["1.22", "1.23"]
+      postgres: ["16"]
+      max_parallel: 1
+    container:
+      image: golang:{{ matrix "go" }}
+      commands: ["go test ./..."]
+      environment:
+        PG_VERSION: '{{ matrix "postgres" }}'
+        GO_VERSION: custom
+      services:
+        - name: db
+          image: postgres:{{ matrix "postgres" }}
+`)
+
+	config, _, err := UnmarshalConfig(configYAML, Event{Rev: "main"})
+	if err != nil {
+		t.Fatalf("UnmarshalConfig() error = %v", err)
+	}
+	if m := config.Do[0].Matrix; m == nil || m.MaxParallel != 1 || len(m.Axes) != 2 {
+		t.Fatalf("Matrix = %+v, want two axes and max_parallel 1", m)
+	}
+
+	jobs, err := buildJobGraph(config.Do)
+	if err != nil {
+		t.Fatalf("buildJobGraph() error = %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+
+	j := jobs[1]
+	if j.name != "test (1.23, 16)" || j.group != "test" || cap(j.slots) != 1 {
+		t.Errorf("job = %s in group %s with %d slots", j.name, j.group, cap(j.slots))
+	}
+	if jobs[0].slots != j.slots {
+		t.Error("jobs of one matrix task do not share their slots")
+	}
+	c := j.task.Container
+	if c.Image != "golang:1.23" {
+		t.Errorf("Image = %q, want golang:1.23", c.Image)
+	}
+	if c.Services[0].Image != "postgres:16" {
+		t.Errorf("service Image = %q, want postgres:16", c.Services[0].Image)
+	}
+	wantEnv := map[string]string{
+		"PG_VERSION":      "16",
+		"GO_VERSION":      "custom",
+		"MATRIX_GO":       "1.23",
+		"MATRIX_POSTGRES": "16",
+	}
+	if fmt.Sprint(c.Environment) != fmt.Sprint(wantEnv) {
+		t.Errorf("Environment = %v, want %v", c.Environment, wantEnv)
+	}
+	if config.Do[0].Container.Image != "golang:${{ matrix.go }}" {
+		t.Errorf("expanding changed the task, Image = %q", config.Do[0].Container.Image)
+	}
+}
+
+func TestBuildJobGraphMatrixErrors(t *testing.T) {
+	unknown := Task{Name: "test", Matrix: &Matrix{Axes: map[string][]string{"go": {"1.23"}}}, Container: &ContainerTask{Image: matrixPlaceholder("os")}}
+	if _, err := buildJobGraph([]Task{unknown}); err == nil {
+		t.Error("buildJobGraph() expected error for unknown matrix value")
+	}
+
+	outside := Task{Name: "build", Container: &ContainerTask{Image: matrixPlaceholder("go")}}
+	if _, err := buildJobGraph([]Task{outside}); err == nil {
+		t.Error("buildJobGraph() expected error for matrix value outside of a matrix task")
+	}
+
+	empty := Task{Name: "test", Matrix: &Matrix{Exclude: []map[string]string{{}}}}
+	if _, err := buildJobGraph([]Task{empty}); err == nil {
+		t.Error("buildJobGraph() expected error for matrix without combinations")
+	}
+}
+
+func TestEvaluateConditionMatrix(t *testing.T) {
+	e := &Executor{}
+	data := TaskContext{Success: true, Matrix: map[string]string{"go": "1.23"}}
+	for expr, want := range map[string]bool{
+		`eq .Matrix.go "1.23"`:    true,
+		`eq (matrix "go") "1.22"`: false,
+	} {
+		got, err := e.evaluateCondition(expr, data)
+		if err != nil {
+			t.Fatalf("evaluateCondition(%q) error = %v", expr, err)
+		}
+		if got != want {
+			t.Errorf("evaluateCondition(%q) = %v, want %v", expr, got, want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package ci
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pogo-vcs/pogo/secrets"
+)
+
+// ProcessMode controls whether tasks may run as processes on the host.
+type ProcessMode string
+
+const (
+	// ProcessModeAllow runs process tasks next to webhook and container tasks
+	ProcessModeAllow ProcessMode = "allow"
+	// ProcessModeDeny lets process tasks fail
+	ProcessModeDeny ProcessMode = "deny"
+	// ProcessModeForce runs the commands of container tasks as processes too,
+	// for hosts without Docker
+	ProcessModeForce ProcessMode = "force"
+)
+
+// DefaultProcessEnvAllowlist are the environment variables of the host that
+// process tasks see by default.
+var DefaultProcessEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR", "TEMP", "TMP",
+	"SYSTEMROOT", "COMSPEC", "PATHEXT",
+}
+
+// ProcessConfig controls the process tasks of all executors.
+type ProcessConfig struct {
+	// Mode defaults to ProcessModeAllow
+	Mode ProcessMode
+	// EnvAllowlist are the environment variables of the host passed to the
+	// processes, DefaultProcessEnvAllowlist if nil
+	EnvAllowlist []string
+}
+
+var processConfig ProcessConfig
+
+// SetProcessConfig sets how process tasks are run by all executors. It must
+// be called before any pipeline is executed.
+func SetProcessConfig(config ProcessConfig) {
+	processConfig = config
+}
+
+// ParseProcessMode parses "allow", "deny" or "force".
+func ParseProcessMode(s string) (ProcessMode, error) {
+	switch mode := ProcessMode(s); mode {
+	case ProcessModeAllow, ProcessModeDeny, ProcessModeForce:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown process mode %q, expected %s, %s or %s", s, ProcessModeAllow, ProcessModeDeny, ProcessModeForce)
+	}
+}
+
+// containerAsProcess returns the process task running the commands of a
+// container task when process tasks are forced. Paths below /workspace become
+// relative to the repository content.
+func containerAsProcess(task ContainerTask) ProcessTask {
+	workingDir := strings.TrimPrefix(path.Clean("/"+task.WorkingDir), "/workspace")
+	return ProcessTask{
+		Commands:    task.Commands,
+		Environment: task.Environment,
+		WorkingDir:  strings.TrimPrefix(workingDir, "/"),
+		Timeout:     task.Timeout,
+	}
+}
+
+// processEnv returns the environment of a process task: the allowlisted
+// variables of the host, CI=true and the variables of the task.
+func processEnv(taskEnv map[string]string) []string {
+	allowlist := processConfig.EnvAllowlist
+	if allowlist == nil {
+		allowlist = DefaultProcessEnvAllowlist
+	}
+
+	env := map[string]string{"CI": "true"}
+	for _, key := range allowlist {
+		if value, ok := os.LookupEnv(key); ok {
+			env[key] = value
+		}
+	}
+	for k, v := range taskEnv {
+		env[k] = v
+	}
+
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// maskingWriter replaces secret values in complete lines before passing them
+// on, so a secret is never split across writes.
+type maskingWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets []string
+	buf     []byte
+}
+
+func newMaskingWriter(w io.Writer, secretValues map[string]string) *maskingWriter {
+	m := &maskingWriter{w: w}
+	for _, value := range secretValues {
+		if value != "" {
+			m.secrets = append(m.secrets, value)
+		}
+	}
+	return m
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buf = append(m.buf, p...)
+	if n := bytes.LastIndexByte(m.buf, '\n') + 1; n > 0 {
+		if _, err := io.WriteString(m.w, secrets.Hide(string(m.buf[:n]), m.secrets)); err != nil {
+			return 0, err
+		}
+		m.buf = append([]byte(nil), m.buf[n:]...)
+	}
+	return len(p), nil
+}
+
+// flush writes the remaining incomplete line.
+func (m *maskingWriter) flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.buf) > 0 {
+		_, _ = io.WriteString(m.w, secrets.Hide(string(m.buf), m.secrets))
+		m.buf = nil
+	}
+}
+
+// executeProcessTask runs the commands of the task one after another on the
+// host, in a copy of the repository content that is removed afterwards.
+func (e *Executor) executeProcessTask(ctx context.Context, task ProcessTask, out io.Writer) (TaskExecutionResult, error) {
+	result := TaskExecutionResult{
+		TaskType:  "process",
+		StartedAt: time.Now(),
+	}
+
+	var logBuf bytes.Buffer
+	masked := newMaskingWriter(io.MultiWriter(os.Stdout, &logBuf, out), e.secrets)
+	logWriter := &syncWriter{w: masked}
+
+	if processConfig.Mode == ProcessModeDeny {
+		result.Log = "process tasks are disabled on this server"
+		result.StatusCode = -1
+		result.FinishedAt = time.Now()
+		return result, fmt.Errorf("process tasks are disabled")
+	}
+	if len(task.Commands) == 0 {
+		result.Log = "process task has no commands"
+		result.StatusCode = -1
+		result.FinishedAt = time.Now()
+		return result, fmt.Errorf("process task has no commands")
+	}
+
+	timeout, err := containerLimits.resolveTimeout(task.Timeout, logWriter)
+	if err != nil {
+		masked.flush()
+		result.Log = logBuf.String() + err.Error()
+		result.StatusCode = -1
+		result.FinishedAt = time.Now()
+		return result, err
+	}
+
+	dir, err := os.MkdirTemp("", "pogo-ci-process-")
+	if err != nil {
+		result.Log = err.Error()
+		result.StatusCode = -1
+		result.FinishedAt = time.Now()
+		return result, fmt.Errorf("create working directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if e.repoContentDir != "" {
+		fmt.Fprintf(logWriter, "Copying repository content to the working directory\n")
+		if err := os.CopyFS(dir, os.DirFS(e.repoContentDir)); err != nil {
+			masked.flush()
+			result.Log = logBuf.String()
+			result.StatusCode = -1
+			result.FinishedAt = time.Now()
+			return result, fmt.Errorf("copy repository content: %w", err)
+		}
+	}
+
+	workingDir := dir
+	if task.WorkingDir != "" {
+		if !filepath.IsLocal(filepath.FromSlash(task.WorkingDir)) {
+			result.Log = fmt.Sprintf("working directory %q must be relative to the repository content", task.WorkingDir)
+			result.StatusCode = -1
+			result.FinishedAt = time.Now()
+			return result, fmt.Errorf("invalid working directory %q", task.WorkingDir)
+		}
+		workingDir = filepath.Join(dir, filepath.FromSlash(task.WorkingDir))
+		if err := os.MkdirAll(workingDir, 0755); err != nil {
+			result.Log = err.Error()
+			result.StatusCode = -1
+			result.FinishedAt = time.Now()
+			return result, fmt.Errorf("create working directory: %w", err)
+		}
+	}
+
+	outerCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	env := processEnv(task.Environment)
+	for i, command := range task.Commands {
+		fmt.Fprintf(logWriter, "Running command %d/%d: %s\n", i+1, len(task.Commands), command)
+
+		cmd := shellCommand(ctx, command)
+		cmd.Dir = workingDir
+		cmd.Env = env
+		cmd.Stdout = logWriter
+		cmd.Stderr = logWriter
+		// Do not wait for children holding on to the output after a kill
+		cmd.WaitDelay = 5 * time.Second
+
+		if err := cmd.Run(); err != nil {
+			switch {
+			case ctx.Err() != nil && outerCtx.Err() == nil:
+				fmt.Fprintf(logWriter, "Timed out after %s\n", timeout)
+			case outerCtx.Err() != nil:
+				fmt.Fprintf(logWriter, "Cancelled\n")
+			}
+			statusCode := exitCodeFromError(err)
+			masked.flush()
+			result.StatusCode = statusCode
+			result.Success = false
+			result.FinishedAt = time.Now()
+			result.Log = logBuf.String()
+			if statusCode == -1 {
+				result.Log += fmt.Sprintf("\nError: %v\n", err)
+			}
+			return result, fmt.Errorf("run command %q: %w", command, err)
+		}
+	}
+
+	masked.flush()
+	result.StatusCode = 0
+	result.Success = true
+	result.FinishedAt = time.Now()
+	result.Log = logBuf.String()
+	return result, nil
+}
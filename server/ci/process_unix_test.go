@@ -0,0 +1,158 @@
+//go:build !windows
+
+package ci
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setProcessConfigForTest(t *testing.T, config ProcessConfig) {
+	t.Helper()
+	previous := processConfig
+	SetProcessConfig(config)
+	t.Cleanup(func() { SetProcessConfig(previous) })
+}
+
+func TestExecutor_ProcessTask(t *testing.T) {
+	setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeAllow, EnvAllowlist: []string{"PATH"}})
+	t.Setenv("POGO_TEST_HIDDEN", "host-value")
+
+	contentDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(contentDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(contentDir, "sub", "hello.txt"), []byte("hello from repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	executor := NewExecutor()
+	executor.SetRepoContentDir(contentDir)
+	executor.SetSecrets(map[string]string{"TOKEN": "s3cr3t-value"})
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - process:
+      working_dir: sub
+      environment:
+        GREETING: hi
+        TOKEN: '{{ secret "TOKEN" }}'
+      commands:
+        - cat hello.txt
+        - echo "greeting=$GREETING ci=$CI hidden=${POGO_TEST_HIDDEN:-unset}"
+        - echo "token=$TOKEN"
+        - echo changed > hello.txt
+`),
+	}
+
+	results, err := executor.ExecuteForBookmarkEvent(context.Background(), configFiles, Event{Rev: "main"}, EventTypePush)
+	if err != nil {
+		t.Fatalf("ExecuteForBookmarkEvent() error = %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	result := results[0]
+	if !result.Success || result.TaskType != "process" {
+		t.Fatalf("expected successful process task, got %+v", result)
+	}
+	for _, want := range []string{"hello from repo", "greeting=hi ci=true hidden=unset", "token=***"} {
+		if !strings.Contains(result.Log, want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, result.Log)
+		}
+	}
+	if strings.Contains(result.Log, "s3cr3t-value") {
+		t.Errorf("expected secret to be masked, got:\n%s", result.Log)
+	}
+
+	content, err := os.ReadFile(filepath.Join(contentDir, "sub", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello from repo\n" {
+		t.Errorf("expected repository content to stay unchanged, got %q", content)
+	}
+}
+
+func TestExecutor_ProcessTaskFailure(t *testing.T) {
+	setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeAllow})
+
+	task := ProcessTask{Commands: []string{"echo first", "exit 3", "echo never"}}
+	result, err := NewExecutor().executeProcessTask(context.Background(), task, &strings.Builder{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if result.Success || result.StatusCode != 3 {
+		t.Errorf("expected exit code 3, got %d", result.StatusCode)
+	}
+	if strings.Contains(result.Log, "never") {
+		t.Errorf("expected commands after the failure not to run, got:\n%s", result.Log)
+	}
+}
+
+func TestExecutor_ProcessTaskTimeout(t *testing.T) {
+	setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeAllow})
+
+	task := ProcessTask{Commands: []string{"sleep 30"}, Timeout: "200ms"}
+	start := time.Now()
+	result, err := NewExecutor().executeProcessTask(context.Background(), task, &strings.Builder{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the process to be killed, took %s", time.Since(start))
+	}
+	if result.Success || !strings.Contains(result.Log, "Timed out after 200ms") {
+		t.Errorf("expected timeout in log, got:\n%s", result.Log)
+	}
+}
+
+func TestExecutor_ProcessTaskModes(t *testing.T) {
+	t.Run("deny", func(t *testing.T) {
+		setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeDeny})
+
+		task := Task{Process: &ProcessTask{Commands: []string{"true"}}}
+		result, err := NewExecutor().executeTask(context.Background(), task, &strings.Builder{})
+		if err == nil || result.Success {
+			t.Fatal("expected denied process task to fail")
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeForce})
+
+		task := Task{Container: &ContainerTask{
+			Image:      "alpine:latest",
+			WorkingDir: "/workspace/sub",
+			Commands:   []string{"pwd"},
+		}}
+		if got := taskType(task); got != "process" {
+			t.Errorf("taskType() = %q, want process", got)
+		}
+		result, err := NewExecutor().executeTask(context.Background(), task, &strings.Builder{})
+		if err != nil {
+			t.Fatalf("executeTask() error = %v", err)
+		}
+		if !result.Success || !strings.Contains(result.Log, "/sub\n") {
+			t.Errorf("expected container commands to run in sub, got:\n%s", result.Log)
+		}
+	})
+
+	t.Run("working dir outside", func(t *testing.T) {
+		setProcessConfigForTest(t, ProcessConfig{Mode: ProcessModeAllow})
+
+		task := ProcessTask{Commands: []string{"true"}, WorkingDir: "../outside"}
+		if _, err := NewExecutor().executeProcessTask(context.Background(), task, &strings.Builder{}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
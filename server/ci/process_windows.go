@@ -0,0 +1,11 @@
+package ci
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command with cmd, which is killed once ctx is done.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
@@ -0,0 +1,264 @@
+package ci
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ReportFormatJUnit are JUnit XML files with testsuites or testsuite roots
+	ReportFormatJUnit = "junit"
+	// ReportFormatGoTest is the output of go test -json
+	ReportFormatGoTest = "go-test"
+)
+
+// TestStatus is the outcome of a single test of a report.
+type TestStatus string
+
+const (
+	TestStatusPassed  TestStatus = "passed"
+	TestStatusFailed  TestStatus = "failed"
+	TestStatusSkipped TestStatus = "skipped"
+)
+
+// maxTestMessageSize limits the failure output kept per test, longer output
+// keeps its end.
+const maxTestMessageSize = 8 << 10
+
+// TestResult is a single test parsed from the reports of a job.
+type TestResult struct {
+	// Suite is the JUnit class name or suite, or the Go package
+	Suite    string
+	Name     string
+	Status   TestStatus
+	Duration time.Duration
+	// Message is the failure message and output of failed tests
+	Message string
+}
+
+// collectReports copies the report files of a task out of the container and
+// parses them. Reports that can not be read are logged and skipped.
+func (e *Executor) collectReports(ctx context.Context, containerName, workingDir string, reports []Report, log io.Writer) []TestResult {
+	var results []TestResult
+	files := 0
+	for _, report := range reports {
+		dir, names, err := e.copyMatchingFiles(ctx, containerName, workingDir, report.Paths, "reports", log)
+		if err != nil {
+			fmt.Fprintf(log, "Collecting reports failed: %v\n", err)
+			continue
+		}
+		for _, name := range names {
+			tests, err := parseReportFile(report.Format, filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				fmt.Fprintf(log, "Parsing report %s failed: %v\n", name, err)
+				continue
+			}
+			results = append(results, tests...)
+			files++
+		}
+		os.RemoveAll(dir)
+	}
+	fmt.Fprintf(log, "Collected %d test results from %d reports\n", len(results), files)
+	return results
+}
+
+func parseReportFile(format, name string) ([]TestResult, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReport(format, f)
+}
+
+// ParseReport parses a test report in the given format.
+func ParseReport(format string, r io.Reader) ([]TestResult, error) {
+	switch format {
+	case ReportFormatJUnit:
+		return parseJUnit(r)
+	case ReportFormatGoTest:
+		return parseGoTest(r)
+	default:
+		return nil, fmt.Errorf("unknown report format %q, expected %s or %s", format, ReportFormatJUnit, ReportFormatGoTest)
+	}
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func parseJUnit(r io.Reader) ([]TestResult, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("no testsuites or testsuite element")
+			}
+			return nil, fmt.Errorf("parse junit: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "testsuites", "testsuite":
+			var suite junitSuite
+			if err := decoder.DecodeElement(&suite, &start); err != nil {
+				return nil, fmt.Errorf("parse junit: %w", err)
+			}
+			if start.Name.Local == "testsuites" {
+				suite.Name = ""
+			}
+			var results []TestResult
+			suite.results(&results)
+			return results, nil
+		default:
+			return nil, fmt.Errorf("unexpected root element %s", start.Name.Local)
+		}
+	}
+}
+
+func (s junitSuite) results(results *[]TestResult) {
+	for _, c := range s.Cases {
+		result := TestResult{
+			Suite:  c.Classname,
+			Name:   c.Name,
+			Status: TestStatusPassed,
+		}
+		if result.Suite == "" {
+			result.Suite = s.Name
+		}
+		if seconds, err := strconv.ParseFloat(strings.ReplaceAll(c.Time, ",", ""), 64); err == nil {
+			result.Duration = time.Duration(seconds * float64(time.Second))
+		}
+		switch {
+		case c.Failure != nil || c.Error != nil:
+			result.Status = TestStatusFailed
+			var parts []string
+			for _, m := range []*junitMessage{c.Failure, c.Error} {
+				if m == nil {
+					continue
+				}
+				for _, part := range []string{m.Message, m.Text} {
+					if part = strings.TrimSpace(part); part != "" {
+						parts = append(parts, part)
+					}
+				}
+			}
+			if len(parts) == 0 {
+				parts = append(parts, strings.TrimSpace(c.SystemOut))
+			}
+			result.Message = truncateTestMessage(strings.Join(parts, "\n"))
+		case c.Skipped != nil:
+			result.Status = TestStatusSkipped
+		}
+		*results = append(*results, result)
+	}
+	for _, nested := range s.Suites {
+		nested.results(results)
+	}
+}
+
+// goTestEvent is a line of go test -json output.
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func parseGoTest(r io.Reader) ([]TestResult, error) {
+	var results []TestResult
+	output := make(map[[2]string]*strings.Builder)
+	parsed := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			// Build output and other text between the events
+			continue
+		}
+		var event goTestEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		parsed = true
+		if event.Test == "" {
+			continue
+		}
+
+		key := [2]string{event.Package, event.Test}
+		switch event.Action {
+		case "output":
+			sb, ok := output[key]
+			if !ok {
+				sb = &strings.Builder{}
+				output[key] = sb
+			}
+			sb.WriteString(event.Output)
+		case "pass", "fail", "skip":
+			result := TestResult{
+				Suite:    event.Package,
+				Name:     event.Test,
+				Status:   TestStatusPassed,
+				Duration: time.Duration(event.Elapsed * float64(time.Second)),
+			}
+			switch event.Action {
+			case "fail":
+				result.Status = TestStatusFailed
+				if sb, ok := output[key]; ok {
+					result.Message = truncateTestMessage(strings.TrimSpace(sb.String()))
+				}
+			case "skip":
+				result.Status = TestStatusSkipped
+			}
+			delete(output, key)
+			results = append(results, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parse go test output: %w", err)
+	}
+	if !parsed {
+		return nil, fmt.Errorf("no go test events")
+	}
+	return results, nil
+}
+
+func truncateTestMessage(message string) string {
+	if len(message) <= maxTestMessageSize {
+		return message
+	}
+	return "[truncated]\n" + message[len(message)-maxTestMessageSize:]
+}
@@ -0,0 +1,104 @@
+package ci
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReportJUnit(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="math" tests="4">
+    <testcase classname="math.AddTest" name="adds" time="0.25"/>
+    <testcase classname="math.AddTest" name="overflows" time="1,000.5">
+      <failure message="expected 3">got 4
+at AddTest.java:12</failure>
+    </testcase>
+    <testcase name="divides">
+      <error message="division by zero"/>
+    </testcase>
+    <testcase classname="math.SubTest" name="ignored">
+      <skipped/>
+    </testcase>
+    <testsuite name="nested">
+      <testcase name="inner" time="0"/>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+	got, err := ParseReport(ReportFormatJUnit, strings.NewReader(report))
+	if err != nil {
+		t.Fatalf("ParseReport() error = %v", err)
+	}
+	want := []TestResult{
+		{Suite: "math.AddTest", Name: "adds", Status: TestStatusPassed, Duration: 250 * time.Millisecond},
+		{Suite: "math.AddTest", Name: "overflows", Status: TestStatusFailed, Duration: 1000500 * time.Millisecond, Message: "expected 3\ngot 4\nat AddTest.java:12"},
+		{Suite: "math", Name: "divides", Status: TestStatusFailed, Message: "division by zero"},
+		{Suite: "math.SubTest", Name: "ignored", Status: TestStatusSkipped},
+		{Suite: "nested", Name: "inner", Status: TestStatusPassed},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseReportJUnitSingleSuite(t *testing.T) {
+	report := `<testsuite name="pytest"><testcase classname="tests.test_api" name="test_get" time="0.01"/></testsuite>`
+
+	got, err := ParseReport(ReportFormatJUnit, strings.NewReader(report))
+	if err != nil {
+		t.Fatalf("ParseReport() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Suite != "tests.test_api" || got[0].Name != "test_get" {
+		t.Errorf("unexpected results %+v", got)
+	}
+
+	if _, err := ParseReport(ReportFormatJUnit, strings.NewReader(`<coverage/>`)); err == nil {
+		t.Error("expected error for unknown root element")
+	}
+}
+
+func TestParseReportGoTest(t *testing.T) {
+	report := `go: downloading example.com/dep v1.0.0
+{"Action":"start","Package":"example.com/pkg"}
+{"Action":"run","Package":"example.com/pkg","Test":"TestOK"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"example.com/pkg","Test":"TestOK","Elapsed":0.5}
+{"Action":"run","Package":"example.com/pkg","Test":"TestBad/case"}
+{"Action":"output","Package":"example.com/pkg","Test":"TestBad/case","Output":"    bad_test.go:10: want 1, got 2\n"}
+{"Action":"fail","Package":"example.com/pkg","Test":"TestBad/case","Elapsed":0.01}
+{"Action":"skip","Package":"example.com/pkg","Test":"TestLater"}
+{"Action":"fail","Package":"example.com/pkg","Elapsed":0.6}
+`
+
+	got, err := ParseReport(ReportFormatGoTest, strings.NewReader(report))
+	if err != nil {
+		t.Fatalf("ParseReport() error = %v", err)
+	}
+	want := []TestResult{
+		{Suite: "example.com/pkg", Name: "TestOK", Status: TestStatusPassed, Duration: 500 * time.Millisecond},
+		{Suite: "example.com/pkg", Name: "TestBad/case", Status: TestStatusFailed, Duration: 10 * time.Millisecond, Message: "bad_test.go:10: want 1, got 2"},
+		{Suite: "example.com/pkg", Name: "TestLater", Status: TestStatusSkipped},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := ParseReport(ReportFormatGoTest, strings.NewReader("ok  \texample.com/pkg\n")); err == nil {
+		t.Error("expected error for plain go test output")
+	}
+	if _, err := ParseReport("tap", strings.NewReader("")); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
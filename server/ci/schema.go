@@ -0,0 +1,364 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schema is the subset of JSON schema used by schema.json. Annotations like
+// format and default are ignored.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Const                any                `json:"const"`
+	Enum                 []any              `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	MinProperties        *int               `json:"minProperties"`
+	Items                *schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+	AnyOf                []*schema          `json:"anyOf"`
+	OneOf                []*schema          `json:"oneOf"`
+	Defs                 map[string]*schema `json:"$defs"`
+	// never is set for the schema false, no value matches it
+	never bool
+}
+
+func (s *schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		s.never = !b
+		return nil
+	}
+	type plain schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// schemaTypes is the type keyword, a single type or a list of types.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = schemaTypes{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// configSchema is the parsed schema.json.
+var configSchema = sync.OnceValues(func() (*schema, error) {
+	data, err := Schemas.ReadFile("schema.json")
+	if err != nil {
+		return nil, err
+	}
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse schema.json: %w", err)
+	}
+	return &s, nil
+})
+
+// schemaProblem is a value that does not match the schema.
+type schemaProblem struct {
+	// path is the location of the value, e.g. do[0].container.image
+	path    string
+	line    int
+	message string
+	// unknown is set for properties that are not allowed, the config still
+	// unmarshals with them
+	unknown bool
+	// missing is the name of a required property that is not set
+	missing string
+}
+
+func (p schemaProblem) String() string {
+	if p.path == "" {
+		return p.message
+	}
+	return p.path + ": " + p.message
+}
+
+// validateSchema checks the YAML node against the schema.
+func validateSchema(root *schema, node *yaml.Node) []schemaProblem {
+	v := schemaValidator{root: root}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	return v.validate(root, node, "")
+}
+
+type schemaValidator struct {
+	root *schema
+}
+
+func (v schemaValidator) resolve(s *schema) (*schema, error) {
+	for s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if !ok || v.root.Defs[name] == nil {
+			return nil, fmt.Errorf("unsupported schema reference %q", s.Ref)
+		}
+		s = v.root.Defs[name]
+	}
+	return s, nil
+}
+
+func (v schemaValidator) validate(s *schema, node *yaml.Node, path string) []schemaProblem {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	s, err := v.resolve(s)
+	if err != nil {
+		return []schemaProblem{{path: path, line: node.Line, message: err.Error()}}
+	}
+	problem := func(format string, args ...any) schemaProblem {
+		return schemaProblem{path: path, line: node.Line, message: fmt.Sprintf(format, args...)}
+	}
+
+	if s.never {
+		return []schemaProblem{problem("is not allowed")}
+	}
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return matchesSchemaType(t, node) }) {
+		return []schemaProblem{problem("must be %s, got %s", strings.Join(s.Type, " or "), nodeType(node))}
+	}
+
+	var problems []schemaProblem
+	if s.Const != nil && scalarValue(node) != s.Const {
+		problems = append(problems, problem("must be %v", s.Const))
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, scalarValue(node)) {
+		values := make([]string, len(s.Enum))
+		for i, value := range s.Enum {
+			values[i] = fmt.Sprint(value)
+		}
+		problems = append(problems, problem("must be one of %s", strings.Join(values, ", ")))
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if s.MinLength != nil && utf8.RuneCountInString(node.Value) < *s.MinLength {
+			if *s.MinLength == 1 {
+				problems = append(problems, problem("must not be empty"))
+			} else {
+				problems = append(problems, problem("must be at least %d characters long", *s.MinLength))
+			}
+		}
+		if s.Pattern != "" {
+			if ok, err := regexp.MatchString(s.Pattern, node.Value); err != nil {
+				problems = append(problems, problem("invalid schema pattern %q: %v", s.Pattern, err))
+			} else if !ok {
+				problems = append(problems, problem("%q does not match %s", node.Value, s.Pattern))
+			}
+		}
+		if number, ok := scalarValue(node).(float64); ok {
+			if s.Minimum != nil && number < *s.Minimum {
+				problems = append(problems, problem("must be at least %v", *s.Minimum))
+			}
+			if s.ExclusiveMinimum != nil && number <= *s.ExclusiveMinimum {
+				problems = append(problems, problem("must be greater than %v", *s.ExclusiveMinimum))
+			}
+		}
+	case yaml.SequenceNode:
+		if s.MinItems != nil && len(node.Content) < *s.MinItems {
+			problems = append(problems, problem("must have at least %d items", *s.MinItems))
+		}
+		if s.Items != nil {
+			for i, item := range node.Content {
+				problems = append(problems, v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case yaml.MappingNode:
+		keys := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keys[key.Value] = true
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			if property, ok := s.Properties[key.Value]; ok {
+				problems = append(problems, v.validate(property, value, keyPath)...)
+				continue
+			}
+			if s.AdditionalProperties == nil {
+				continue
+			}
+			if s.AdditionalProperties.never {
+				problems = append(problems, schemaProblem{path: path, line: key.Line, message: fmt.Sprintf("unknown property %s", key.Value), unknown: true})
+				continue
+			}
+			problems = append(problems, v.validate(s.AdditionalProperties, value, keyPath)...)
+		}
+		for _, name := range s.Required {
+			if !keys[name] {
+				p := problem("missing property %s", name)
+				p.missing = name
+				problems = append(problems, p)
+			}
+		}
+		if s.MinProperties != nil && len(keys) < *s.MinProperties {
+			problems = append(problems, problem("must have at least %d properties", *s.MinProperties))
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		matches, failed := v.alternatives(s.AnyOf, node, path)
+		if matches == 0 {
+			problems = append(problems, noAlternative(failed, problem)...)
+		}
+	}
+	if len(s.OneOf) > 0 {
+		matches, failed := v.alternatives(s.OneOf, node, path)
+		switch {
+		case matches == 0:
+			problems = append(problems, noAlternative(failed, problem)...)
+		case matches > 1:
+			if names, ok := requiredAlternatives(s.OneOf); ok {
+				problems = append(problems, problem("must set only one of %s", strings.Join(names, ", ")))
+			} else {
+				problems = append(problems, problem("matches more than one of the allowed alternatives"))
+			}
+		}
+	}
+	return problems
+}
+
+// alternatives returns how many of the schemas match the node and the
+// problems of the ones that do not.
+func (v schemaValidator) alternatives(schemas []*schema, node *yaml.Node, path string) (int, [][]schemaProblem) {
+	matches := 0
+	var failed [][]schemaProblem
+	for _, s := range schemas {
+		problems := v.validate(s, node, path)
+		if len(problems) == 0 {
+			matches++
+			continue
+		}
+		failed = append(failed, problems)
+	}
+	return matches, failed
+}
+
+// noAlternative returns the problems of the alternative that is closest to
+// matching, the one with the fewest and then the deepest problems. If several
+// are equally close and each only misses a property at the same place, they
+// are reported together as choice.
+func noAlternative(failed [][]schemaProblem, problem func(string, ...any) schemaProblem) []schemaProblem {
+	closer := func(a, b []schemaProblem) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return len(a[0].path) - len(b[0].path)
+	}
+	var closest [][]schemaProblem
+	for _, problems := range failed {
+		switch {
+		case len(closest) == 0 || closer(problems, closest[0]) > 0:
+			closest = [][]schemaProblem{problems}
+		case closer(problems, closest[0]) == 0:
+			closest = append(closest, problems)
+		}
+	}
+	if len(closest) == 1 {
+		return closest[0]
+	}
+
+	var missing []string
+	first := closest[0][0]
+	for _, problems := range closest {
+		if len(problems) != 1 || problems[0].missing == "" || problems[0].path != first.path {
+			return []schemaProblem{problem("does not match any of the allowed alternatives")}
+		}
+		missing = append(missing, problems[0].missing)
+	}
+	first.message = fmt.Sprintf("must set one of %s", strings.Join(missing, ", "))
+	first.missing = ""
+	return []schemaProblem{first}
+}
+
+// requiredAlternatives returns the properties of alternatives that each only
+// require a single property, like the task types.
+func requiredAlternatives(schemas []*schema) ([]string, bool) {
+	names := make([]string, 0, len(schemas))
+	for _, s := range schemas {
+		if len(s.Required) != 1 || len(s.Properties) > 0 || s.Ref != "" || len(s.Type) > 0 {
+			return nil, false
+		}
+		names = append(names, s.Required[0])
+	}
+	return names, true
+}
+
+// nodeType returns the JSON schema type of a YAML node.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+func matchesSchemaType(t string, node *yaml.Node) bool {
+	got := nodeType(node)
+	switch {
+	case t == got:
+		return true
+	case t == "number":
+		return got == "integer"
+	case t == "string":
+		// Configs unmarshal every scalar into a string field, like PORT: 8080
+		return node.Kind == yaml.ScalarNode && got != "null"
+	}
+	return false
+}
+
+// scalarValue returns the value of a scalar like encoding/json decodes it,
+// so it can be compared with const and enum values of the schema.
+func scalarValue(node *yaml.Node) any {
+	if node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	switch nodeType(node) {
+	case "integer", "number":
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return node.Value
+		}
+		return f
+	case "boolean":
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return node.Value
+		}
+		return b
+	case "null":
+		return nil
+	default:
+		return node.Value
+	}
+}
@@ -153,8 +153,9 @@ func TestExecutor_WebhookWithSecretFromEnvVar(t *testing.T) {
 	defer testServer.Close()
 
 	executor := NewExecutor()
-	// Empty secrets map - should fall back to env var
+	// Empty secrets map - should fall back to env var when testing locally
 	executor.SetSecrets(map[string]string{})
+	executor.SetEnvironmentSecrets(true)
 
 	configYAML := fmt.Sprintf(`
 version: 1
@@ -199,6 +200,32 @@ do:
 	}
 }
 
+func TestExecutor_SecretNotReadFromEnvVar(t *testing.T) {
+	t.Setenv("POGO_TEST_SERVER_ONLY", "host-value")
+
+	executor := NewExecutor()
+	executor.SetSecrets(map[string]string{})
+
+	configFiles := map[string][]byte{
+		"ci.yaml": []byte(`
+version: 1
+on:
+  push:
+    bookmarks: ["main"]
+do:
+  - webhook:
+      url: https://example.com/webhook
+      method: POST
+      body: token={{ secret "POGO_TEST_SERVER_ONLY" }}
+`),
+	}
+
+	_, err := executor.ExecuteForBookmarkEvent(context.Background(), configFiles, Event{Rev: "main"}, EventTypePush)
+	if err == nil || !strings.Contains(err.Error(), `secret "POGO_TEST_SERVER_ONLY" not found`) {
+		t.Fatalf("ExecuteForBookmarkEvent() error = %v, want the secret to be missing", err)
+	}
+}
+
 func TestExecutor_SecretMapTakesPriorityOverEnvVar(t *testing.T) {
 	ctx := context.Background()
 
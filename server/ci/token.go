@@ -0,0 +1,82 @@
+package ci
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// TokenPermission is something the access token of a run may do.
+type TokenPermission string
+
+const (
+	// TokenReadArchive allows downloading archives of the repository
+	TokenReadArchive TokenPermission = "read_archive"
+	// TokenWriteAssets allows uploading and deleting assets of the repository
+	TokenWriteAssets TokenPermission = "write_assets"
+	// TokenReportStatus allows reporting the status of external jobs of the pipeline
+	TokenReportStatus TokenPermission = "report_status"
+)
+
+const (
+	// DefaultTokenTTL is how long tokens of configs without a ttl are valid
+	DefaultTokenTTL = time.Hour
+	// MaxTokenTTL is the longest ttl a config may set
+	MaxTokenTTL = 24 * time.Hour
+)
+
+// defaultTokenPermissions are granted to tokens of configs without permissions.
+var defaultTokenPermissions = []TokenPermission{TokenReadArchive, TokenWriteAssets}
+
+// TokenConfig configures the access token of the runs of a config.
+type TokenConfig struct {
+	// How long the token is valid, e.g. 2h, defaults to 1h
+	TTL string `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// What the token may do, defaults to read_archive and write_assets
+	Permissions []TokenPermission `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	// Keep the token valid until its ttl ends after the run finished, for external CI systems started by webhooks
+	WebhookGracePeriod bool `yaml:"webhook_grace_period,omitempty" json:"webhook_grace_period,omitempty"`
+}
+
+// TokenSettings are the resolved settings of a run's access token.
+type TokenSettings struct {
+	TTL                time.Duration
+	Permissions        []TokenPermission
+	WebhookGracePeriod bool
+}
+
+// Resolve applies the defaults to the token config and validates it. A nil
+// config resolves to the defaults.
+func (c *TokenConfig) Resolve() (TokenSettings, error) {
+	settings := TokenSettings{TTL: DefaultTokenTTL, Permissions: defaultTokenPermissions}
+	if c == nil {
+		return settings, nil
+	}
+	settings.WebhookGracePeriod = c.WebhookGracePeriod
+
+	if c.TTL != "" {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return settings, fmt.Errorf("invalid ttl %q: %w", c.TTL, err)
+		}
+		if ttl <= 0 || ttl > MaxTokenTTL {
+			return settings, fmt.Errorf("ttl must be between 0 and %s, got %s", MaxTokenTTL, ttl)
+		}
+		settings.TTL = ttl
+	}
+
+	if c.Permissions != nil {
+		settings.Permissions = make([]TokenPermission, 0, len(c.Permissions))
+		for _, p := range c.Permissions {
+			switch p {
+			case TokenReadArchive, TokenWriteAssets, TokenReportStatus:
+			default:
+				return settings, fmt.Errorf("unknown permission %q", p)
+			}
+			if !slices.Contains(settings.Permissions, p) {
+				settings.Permissions = append(settings.Permissions, p)
+			}
+		}
+	}
+	return settings, nil
+}
@@ -0,0 +1,70 @@
+package ci
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenConfig_Resolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *TokenConfig
+		want    TokenSettings
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			config: nil,
+			want:   TokenSettings{TTL: DefaultTokenTTL, Permissions: []TokenPermission{TokenReadArchive, TokenWriteAssets}},
+		},
+		{
+			name:   "ttl and grace period",
+			config: &TokenConfig{TTL: "2h", WebhookGracePeriod: true},
+			want:   TokenSettings{TTL: 2 * time.Hour, Permissions: []TokenPermission{TokenReadArchive, TokenWriteAssets}, WebhookGracePeriod: true},
+		},
+		{
+			name:   "permissions",
+			config: &TokenConfig{Permissions: []TokenPermission{TokenReportStatus, TokenReportStatus}},
+			want:   TokenSettings{TTL: DefaultTokenTTL, Permissions: []TokenPermission{TokenReportStatus}},
+		},
+		{
+			name:   "no permissions",
+			config: &TokenConfig{Permissions: []TokenPermission{}},
+			want:   TokenSettings{TTL: DefaultTokenTTL, Permissions: []TokenPermission{}},
+		},
+		{
+			name:    "unknown permission",
+			config:  &TokenConfig{Permissions: []TokenPermission{"admin"}},
+			wantErr: true,
+		},
+		{
+			name:    "ttl too long",
+			config:  &TokenConfig{TTL: "25h"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid ttl",
+			config:  &TokenConfig{TTL: "soon"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.Resolve()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Resolve() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
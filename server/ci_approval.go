@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+var _ ci.Approver = (*ciRunRecorder)(nil)
+
+// jobWaiting stores the run of a job that waits for approval. The run is
+// reused once the job starts.
+func (r *ciRunRecorder) jobWaiting(res ci.TaskExecutionResult) (int32, error) {
+	runID, err := storeCIRun(r.repo.ID, r.pipelineID, res)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.jobs[ciJobKey(res.ConfigFilename, res.JobName)] = ciRecordedJob{runID: runID}
+	r.mu.Unlock()
+	notifyCILogFollowers()
+	return runID, nil
+}
+
+// WaitForApproval stores the job as waiting and blocks until its run is
+// approved. The pipeline keeps its worker while it waits.
+func (r *ciRunRecorder) WaitForApproval(ctx context.Context, res ci.TaskExecutionResult) error {
+	runID, err := r.jobWaiting(res)
+	if err != nil {
+		return fmt.Errorf("store ci run: %w", err)
+	}
+	fmt.Printf("CI run waiting for approval: repo=%s pipeline_id=%d run_id=%d job=%s\n", r.repo.Name, r.pipelineID, runID, res.JobName)
+	return waitForCIApproval(ctx, func() (bool, error) {
+		return db.Q.IsCIRunApproved(ctx, runID)
+	})
+}
+
+// waitForCIApproval checks approved whenever a run changes, or at least every
+// ciPollInterval, until it reports true or ctx is done.
+func waitForCIApproval(ctx context.Context, approved func() (bool, error)) error {
+	for {
+		changed := ciLogChanged()
+		ok, err := approved()
+		if err != nil {
+			return fmt.Errorf("get approval: %w", err)
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-time.After(ciPollInterval):
+		}
+	}
+}
+
+// approveCIRun approves a run that waits for approval on behalf of the user.
+// Unlike viewing runs of public repositories, approving requires access to
+// the repository.
+func approveCIRun(ctx context.Context, repositoryID int32, runID int32, userID int32) error {
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, repositoryID, userID)
+	if err != nil {
+		return fmt.Errorf("check user repository access: %w", err)
+	}
+	if !hasAccess {
+		return errors.New("approving runs requires access to the repository")
+	}
+
+	approved, err := db.Q.ApproveCIRun(ctx, &userID, repositoryID, runID)
+	if err != nil {
+		return fmt.Errorf("approve ci run: %w", err)
+	}
+	if approved == 0 {
+		return fmt.Errorf("run %d is not waiting for approval", runID)
+	}
+
+	fmt.Printf("CI run approved: repo_id=%d run_id=%d user_id=%d\n", repositoryID, runID, userID)
+	notifyCILogFollowers()
+	return nil
+}
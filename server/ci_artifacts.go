@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/moby/go-archive"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+var _ ci.Storage = (*ciRunRecorder)(nil)
+
+// StoreArtifacts moves the artifacts of a job into the object store and links
+// them to its run.
+func (r *ciRunRecorder) StoreArtifacts(res ci.TaskExecutionResult, dir string, names []string) error {
+	r.mu.Lock()
+	job, ok := r.jobs[ciJobKey(res.ConfigFilename, res.JobName)]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("job %s has not started", res.JobName)
+	}
+
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	ctx := context.Background()
+	for _, name := range names {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		stat, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("stat artifact %s: %w", name, err)
+		}
+		hash, err := filecontents.StoreFile(p)
+		if err != nil {
+			return fmt.Errorf("store artifact %s: %w", name, err)
+		}
+		if err := db.Q.CreateCIArtifact(ctx, job.runID, name, stat.Size(), hash); err != nil {
+			return fmt.Errorf("create artifact %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RestoreCache extracts the cache stored under key into dir.
+func (r *ciRunRecorder) RestoreCache(key string, dir string) (bool, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	hash, err := db.Q.UseCICache(context.Background(), r.repo.ID, key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get cache: %w", err)
+	}
+
+	f, err := filecontents.OpenFileByHash(base64.URLEncoding.EncodeToString(hash))
+	if err != nil {
+		return false, fmt.Errorf("open cache: %w", err)
+	}
+	defer f.Close()
+
+	if err := archive.Untar(f, dir, &archive.TarOptions{NoLchown: true}); err != nil {
+		return false, fmt.Errorf("extract cache: %w", err)
+	}
+	return true, nil
+}
+
+// StoreCache archives dir into the object store under key. An existing cache
+// with the same key is kept.
+func (r *ciRunRecorder) StoreCache(key string, dir string) error {
+	tarReader, err := archive.TarWithOptions(dir, &archive.TarOptions{})
+	if err != nil {
+		return fmt.Errorf("archive cache: %w", err)
+	}
+	defer tarReader.Close()
+
+	f, err := os.CreateTemp("", "pogo-ci-cache-*.tar")
+	if err != nil {
+		return fmt.Errorf("create cache file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	size, err := io.Copy(f, tarReader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write cache file: %w", err)
+	}
+
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	hash, err := filecontents.StoreFile(f.Name())
+	if err != nil {
+		return fmt.Errorf("store cache: %w", err)
+	}
+	if err := db.Q.CreateCICache(context.Background(), r.repo.ID, key, size, hash); err != nil {
+		return fmt.Errorf("create cache: %w", err)
+	}
+	return nil
+}
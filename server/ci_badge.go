@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/pogo-vcs/pogo/db"
+)
+
+// ciBadgeLabels maps the CI status of a change to the text and color of its
+// badge.
+var ciBadgeLabels = map[string][2]string{
+	"success":   {"passing", "#40a02b"},
+	"failure":   {"failing", "#d20f39"},
+	"pending":   {"pending", "#df8e1d"},
+	"cancelled": {"cancelled", "#8c8fa1"},
+	"":          {"unknown", "#8c8fa1"},
+}
+
+// handleCIBadge serves an SVG badge with the CI status of the change a
+// bookmark points to. Private repositories require access.
+func handleCIBadge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	bookmark, ok := strings.CutSuffix(r.PathValue("badge"), ".svg")
+	if !ok || bookmark == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	repository, err := db.Q.GetRepositoryByName(ctx, r.PathValue("repo"))
+	if err != nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	if !repository.Public && !CheckRepoAccess(ctx, repository.ID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	changeId, err := db.Q.GetBookmark(ctx, repository.ID, bookmark)
+	if err != nil {
+		http.Error(w, "Bookmark not found", http.StatusNotFound)
+		return
+	}
+
+	var status string
+	statuses, err := db.Q.GetChangeCIStatuses(ctx, repository.ID, []int64{changeId})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get CI status: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(statuses) > 0 {
+		status = statuses[0].Status
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	_, _ = w.Write([]byte(renderCIBadge("ci", status)))
+}
+
+// renderCIBadge renders a flat badge with the label on the left and the
+// status on the right.
+func renderCIBadge(label, status string) string {
+	text, ok := ciBadgeLabels[status]
+	if !ok {
+		text = ciBadgeLabels[""]
+	}
+	// Estimate the text width, the badge has no access to font metrics
+	labelWidth := 10 + 7*len(label)
+	valueWidth := 10 + 7*len(text[0])
+	width := labelWidth + valueWidth
+	label = html.EscapeString(label)
+	value := html.EscapeString(text[0])
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, value)
+	fmt.Fprintf(&sb, `<title>%s: %s</title>`, label, value)
+	fmt.Fprintf(&sb, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	sb.WriteString(`<g clip-path="url(#r)">`)
+	fmt.Fprintf(&sb, `<rect width="%d" height="20" fill="#4c4f69"/>`, labelWidth)
+	fmt.Fprintf(&sb, `<rect x="%d" width="%d" height="20" fill="%s"/>`, labelWidth, valueWidth, text[1])
+	sb.WriteString(`</g>`)
+	sb.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&sb, `<text x="%d" y="14">%s</text>`, labelWidth/2, label)
+	fmt.Fprintf(&sb, `<text x="%d" y="14">%s</text>`, labelWidth+valueWidth/2, value)
+	sb.WriteString(`</g></svg>`)
+	return sb.String()
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pogo-vcs/pogo/compressions"
 	"github.com/pogo-vcs/pogo/db"
@@ -37,6 +40,98 @@ func getCIConfigFiles(ctx context.Context, changeId int64) (map[string][]byte, e
 	return configFiles, nil
 }
 
+// resolveCIConfigName finds a config by its full path, its file name or its
+// file name without extension.
+func resolveCIConfigName(configFiles map[string][]byte, name string) (string, bool) {
+	candidates := []string{name, ".pogo/ci/" + name}
+	if !isCIConfigFile(name) {
+		candidates = append(candidates, ".pogo/ci/"+name+".yaml", ".pogo/ci/"+name+".yml")
+	}
+	for _, candidate := range candidates {
+		if _, ok := configFiles[candidate]; ok {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// getCISecretScope decrypts the secrets of a repository for CI, including the
+// org secrets it inherits. Only pipelines that run and the masking of their
+// output decrypt secrets, everything else only sees their keys. Org secrets of
+// environments the repository does not have are left out, secrets of the
+// repository take precedence.
+func getCISecretScope(ctx context.Context, repositoryId int32) (ci.Secrets, error) {
+	return loadCISecretScope(ctx, repositoryId, true)
+}
+
+// getCISecretKeys returns the secret scope of a repository with empty values.
+// It is enough to check triggers, environments and secret references of
+// configs without decrypting anything.
+func getCISecretKeys(ctx context.Context, repositoryId int32) (ci.Secrets, error) {
+	return loadCISecretScope(ctx, repositoryId, false)
+}
+
+func loadCISecretScope(ctx context.Context, repositoryId int32, decrypt bool) (ci.Secrets, error) {
+	scope := ci.Secrets{
+		Shared:       make(map[string]string),
+		Environments: make(map[string]ci.Environment),
+	}
+
+	environments, err := db.Q.GetEnvironments(ctx, repositoryId)
+	if err != nil {
+		return scope, fmt.Errorf("get environments: %w", err)
+	}
+	for _, e := range environments {
+		scope.Environments[e.Name] = ci.Environment{Bookmarks: e.Bookmarks, Secrets: make(map[string]string)}
+	}
+	add := func(repositoryId int32, environment, key string, ciphertext []byte) error {
+		var value string
+		if decrypt {
+			var err error
+			if value, err = decryptSecret(repositoryId, environment, key, ciphertext); err != nil {
+				return err
+			}
+		}
+		if environment == "" {
+			scope.Shared[key] = value
+		} else if e, ok := scope.Environments[environment]; ok {
+			e.Secrets[key] = value
+		}
+		return nil
+	}
+
+	orgSecrets, err := db.Q.GetAllOrgSecrets(ctx)
+	if err != nil {
+		return scope, fmt.Errorf("get org secrets: %w", err)
+	}
+	for _, secret := range orgSecrets {
+		if err := add(0, secret.Environment, secret.Key, secret.Ciphertext); err != nil {
+			return scope, err
+		}
+	}
+
+	secrets, err := db.Q.GetAllSecrets(ctx, repositoryId)
+	if err != nil {
+		return scope, fmt.Errorf("get secrets: %w", err)
+	}
+	for _, secret := range secrets {
+		if err := add(repositoryId, secret.Environment, secret.Key, secret.Ciphertext); err != nil {
+			return scope, err
+		}
+	}
+	return scope, nil
+}
+
+// ciSecretValues decrypts the values of all secrets of a repository, to mask
+// them in CI output.
+func ciSecretValues(ctx context.Context, repositoryId int32) ([]string, error) {
+	scope, err := getCISecretScope(ctx, repositoryId)
+	if err != nil {
+		return nil, err
+	}
+	return scope.Values(), nil
+}
+
 func isCIConfigFile(filename string) bool {
 	ext := filepath.Ext(filename)
 	return ext == ".yaml" || ext == ".yml"
@@ -53,8 +148,8 @@ func readFileContent(contentHash []byte) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
-func extractRepositoryContentToTemp(ctx context.Context, repositoryId int32, bookmarkName string) (string, error) {
-	vcsFiles, err := db.Q.GetRepositoryFilesForRevisionFuzzy(ctx, repositoryId, bookmarkName)
+func extractRepositoryContentToTemp(ctx context.Context, repositoryId int32, changeId int64) (string, error) {
+	vcsFiles, err := db.Q.GetRepositoryFilesForChangeId(ctx, changeId)
 	if err != nil {
 		return "", fmt.Errorf("get repository files: %w", err)
 	}
@@ -105,7 +200,7 @@ func extractRepositoryContentToTemp(ctx context.Context, repositoryId int32, boo
 	return tempDir, nil
 }
 
-func storeCIRun(repositoryID int32, res ci.TaskExecutionResult) error {
+func storeCIRun(repositoryID int32, pipelineID int64, res ci.TaskExecutionResult) (int32, error) {
 	start := res.StartedAt
 	if start.IsZero() {
 		start = time.Now()
@@ -117,6 +212,24 @@ func storeCIRun(repositoryID int32, res ci.TaskExecutionResult) error {
 		pattern = &res.Pattern
 	}
 
+	var jobName *string
+	if res.JobName != "" {
+		jobName = &res.JobName
+	}
+
+	needs := res.Needs
+	if needs == nil {
+		needs = []string{}
+	}
+
+	var matrix []byte
+	if res.Matrix != nil {
+		var err error
+		if matrix, err = json.Marshal(res.Matrix); err != nil {
+			return 0, fmt.Errorf("marshal matrix: %w", err)
+		}
+	}
+
 	startTS := pgtype.Timestamptz{
 		Time:  start,
 		Valid: true,
@@ -124,7 +237,7 @@ func storeCIRun(repositoryID int32, res ci.TaskExecutionResult) error {
 
 	compressedLog, err := compressions.CompressBytes([]byte(res.Log))
 	if err != nil {
-		return fmt.Errorf("compress log: %w", err)
+		return 0, fmt.Errorf("compress log: %w", err)
 	}
 
 	var finishTS pgtype.Timestamptz
@@ -135,28 +248,51 @@ func storeCIRun(repositoryID int32, res ci.TaskExecutionResult) error {
 		}
 	}
 
-	_, err = db.Q.CreateCIRun(context.Background(),
-		repositoryID,
-		res.ConfigFilename,
-		res.EventType.String(),
-		res.Rev,
-		pattern,
-		res.Reason,
-		res.TaskType,
-		int32(res.StatusCode),
-		res.Success,
-		startTS,
-		finishTS,
-		compressedLog,
-	)
-	return err
+	return db.Q.CreateCIRun(context.Background(), db.CreateCIRunParams{
+		RepositoryID:   repositoryID,
+		ConfigFilename: res.ConfigFilename,
+		EventType:      res.EventType.String(),
+		Rev:            res.Rev,
+		Pattern:        pattern,
+		Reason:         res.Reason,
+		TaskType:       res.TaskType,
+		StatusCode:     int32(res.StatusCode),
+		Success:        res.Success,
+		StartedAt:      startTS,
+		FinishedAt:     finishTS,
+		Log:            compressedLog,
+		PipelineID:     &pipelineID,
+		JobName:        jobName,
+		Needs:          needs,
+		Status:         string(res.Status),
+		Matrix:         matrix,
+	})
 }
 
-func executeCIForBookmarkEvent(ctx context.Context, changeId int64, bookmarkName string, eventType ci.EventType) {
+// ciTrigger is who and what caused a CI event, stored with its pipelines.
+type ciTrigger struct {
+	// userID is the user who caused the event, nil for schedules
+	userID *int32
+	// previousChangeID is the change a pushed bookmark pointed to before
+	previousChangeID *int64
+}
+
+// enqueueCI queues the CI configs of a change that are triggered by the event.
+// The caller sets the trigger fields of the event (type, rev, schedule, inputs and
+// changed paths), the remaining fields are filled in when a worker runs the
+// pipeline. If configName is set, only that config is considered.
+func enqueueCI(ctx context.Context, changeId int64, event ci.Event, configName string, trigger ciTrigger) {
 	configFiles, err := getCIConfigFiles(ctx, changeId)
 	if err != nil || len(configFiles) == 0 {
 		return
 	}
+	if configName != "" {
+		configData, ok := configFiles[configName]
+		if !ok {
+			return
+		}
+		configFiles = map[string][]byte{configName: configData}
+	}
 
 	change, err := db.Q.GetChange(ctx, changeId)
 	if err != nil {
@@ -164,12 +300,179 @@ func executeCIForBookmarkEvent(ctx context.Context, changeId int64, bookmarkName
 		return
 	}
 
-	repo, err := db.Q.GetRepository(ctx, change.RepositoryID)
+	secretScope, err := getCISecretKeys(ctx, change.RepositoryID)
 	if err != nil {
-		fmt.Printf("CI execution error: failed to get repository: %v\n", err)
+		fmt.Printf("CI execution error: change_id=%d rev=%s event=%s detail=%v\n", changeId, event.Rev, event.Type.String(), err)
 		return
 	}
 
+	var schedule *string
+	if event.Schedule != "" {
+		schedule = &event.Schedule
+	}
+
+	var inputs []byte
+	if len(event.Inputs) > 0 {
+		if inputs, err = json.Marshal(event.Inputs); err != nil {
+			fmt.Printf("CI execution error: change_id=%d rev=%s event=%s detail=marshal inputs: %v\n", changeId, event.Rev, event.Type.String(), err)
+			return
+		}
+	}
+
+	if event.ChangedPaths != nil {
+		event.ChangedFiles = ci.ChangedPathNames(event.ChangedPaths)
+	}
+
+	event.RepositoryID = change.RepositoryID
+	queued := 0
+	for filename, configData := range configFiles {
+		config, _, err := ci.UnmarshalConfigWithScope(configData, event, secretScope)
+		if err != nil {
+			fmt.Printf("CI execution error: change_id=%d rev=%s event=%s config=%s detail=%v\n", changeId, event.Rev, event.Type.String(), filename, err)
+			continue
+		}
+		if _, ok := config.Trigger(event); !ok {
+			continue
+		}
+
+		runsOn := config.RunsOn
+		if runsOn == nil {
+			runsOn = []string{}
+		}
+
+		// Manual runs of a bookmark store the bookmark, the change is known
+		// from the pipeline
+		rev := event.Rev
+		if event.Type == ci.EventTypeManual && event.Bookmark != "" {
+			rev = event.Bookmark
+		}
+		pipelineID, err := db.Q.EnqueueCIPipeline(ctx,
+			change.RepositoryID,
+			changeId,
+			filename,
+			event.Type.String(),
+			rev,
+			schedule,
+			inputs,
+			event.ChangedFiles,
+			runsOn,
+			trigger.previousChangeID,
+			ci.ChangedPathOperations(event.ChangedPaths),
+			trigger.userID,
+		)
+		if err != nil {
+			fmt.Printf("CI execution error: change_id=%d rev=%s event=%s config=%s detail=enqueue pipeline: %v\n", changeId, event.Rev, event.Type.String(), filename, err)
+			continue
+		}
+		fmt.Printf("CI pipeline queued: pipeline_id=%d change_id=%d rev=%s event=%s config=%s\n", pipelineID, changeId, event.Rev, event.Type.String(), filename)
+		queued++
+	}
+
+	if queued > 0 {
+		wakeCIWorkers()
+	}
+}
+
+// rerunCIRun queues the pipeline of a run again on behalf of the user. The new
+// pipeline runs the config of the same change with the same event and inputs.
+func rerunCIRun(ctx context.Context, repositoryID int32, runID int32, userID int32) (int64, error) {
+	pipelineID, err := db.Q.RerunCIPipeline(ctx, userID, repositoryID, runID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("run %d does not belong to a pipeline that still exists", runID)
+		}
+		return 0, fmt.Errorf("rerun pipeline: %w", err)
+	}
+	fmt.Printf("CI pipeline queued: pipeline_id=%d repo_id=%d rerun_of_run_id=%d\n", pipelineID, repositoryID, runID)
+	wakeCIWorkers()
+	return pipelineID, nil
+}
+
+// addCIEventContext adds the parents of the change, the changed paths and
+// what triggered the pipeline to its event.
+func addCIEventContext(ctx context.Context, p db.CiPipeline, event *ci.Event) error {
+	parents, err := db.Q.GetChangeParents(ctx, p.ChangeID)
+	if err != nil {
+		return fmt.Errorf("get change parents: %w", err)
+	}
+	event.Parents = make([]string, len(parents))
+	for i, parent := range parents {
+		event.Parents[i] = parent.Name
+	}
+
+	// Pipelines queued before operations were stored only know the names
+	if p.ChangedFileOperations != nil && len(p.ChangedFileOperations) == len(p.ChangedFiles) {
+		event.ChangedPaths = make([]ci.ChangedPath, len(p.ChangedFiles))
+		for i, name := range p.ChangedFiles {
+			event.ChangedPaths[i] = ci.ChangedPath{Path: name, Operation: ci.FileOperation(p.ChangedFileOperations[i])}
+		}
+	}
+
+	if p.PreviousChangeID != nil {
+		previous, err := db.Q.GetChange(ctx, *p.PreviousChangeID)
+		if err != nil {
+			return fmt.Errorf("get previous change: %w", err)
+		}
+		event.PreviousChangeName = previous.Name
+	}
+
+	if p.TriggeredByUserID != nil {
+		user, err := db.Q.GetUser(ctx, *p.TriggeredByUserID)
+		if err != nil {
+			return fmt.Errorf("get triggering user: %w", err)
+		}
+		event.TriggeredBy = user.Username
+	}
+	return nil
+}
+
+// manualCIBookmark returns rev if it is a bookmark pointing to the change of a
+// manual run, so environments can check it.
+func manualCIBookmark(ctx context.Context, repositoryId int32, rev string, changeId int64) string {
+	bookmarkChangeId, err := db.Q.GetBookmark(ctx, repositoryId, rev)
+	if err != nil || bookmarkChangeId != changeId {
+		return ""
+	}
+	return rev
+}
+
+// ciPipelineJob is everything needed to execute a claimed pipeline, either on
+// the server or on a runner.
+type ciPipelineJob struct {
+	repo       db.Repository
+	change     db.GetChangeRow
+	configData []byte
+	event      ci.Event
+	secrets    ci.Secrets
+}
+
+// prepareCIPipeline loads the config of a claimed pipeline and rebuilds its
+// event. It issues the CI access token of the run.
+func prepareCIPipeline(ctx context.Context, p db.CiPipeline) (*ciPipelineJob, error) {
+	eventType, err := ci.ParseEventType(p.EventType)
+	if err != nil {
+		return nil, err
+	}
+
+	configFiles, err := getCIConfigFiles(ctx, p.ChangeID)
+	if err != nil {
+		return nil, err
+	}
+	configData, ok := configFiles[p.ConfigFilename]
+	if !ok {
+		return nil, fmt.Errorf("CI config %s not found", p.ConfigFilename)
+	}
+
+	change, err := db.Q.GetChange(ctx, p.ChangeID)
+	if err != nil {
+		return nil, fmt.Errorf("get change: %w", err)
+	}
+
+	repo, err := db.Q.GetRepository(ctx, p.RepositoryID)
+	if err != nil {
+		return nil, fmt.Errorf("get repository: %w", err)
+	}
+
 	var author string
 	if change.AuthorID != nil {
 		user, err := db.Q.GetUser(ctx, *change.AuthorID)
@@ -183,69 +486,119 @@ func executeCIForBookmarkEvent(ctx context.Context, changeId int64, bookmarkName
 		description = *change.Description
 	}
 
-	archiveUrl := fmt.Sprintf("%s/repository/%s/archive/%s", env.PublicAddress, repo.Name, bookmarkName)
-
-	// Generate a temporary CI access token for this run
-	accessToken, err := GenerateCIToken(repo.ID)
-	if err != nil {
-		fmt.Printf("CI execution error: repo=%s change_id=%d bookmark=%s event=%s detail=generate ci token: %v\n", repo.Name, changeId, bookmarkName, eventType.String(), err)
-		return
+	// Manual runs always build the change itself, even when they were
+	// dispatched for a bookmark that has moved on since
+	rev := p.Rev
+	if eventType == ci.EventTypeManual {
+		rev = change.Name
 	}
 
 	event := ci.Event{
-		Rev:          bookmarkName,
-		ArchiveUrl:   archiveUrl,
+		Type:         eventType,
+		Rev:          rev,
+		ChangedFiles: p.ChangedFiles,
 		Author:       author,
 		Description:  description,
-		AccessToken:  accessToken,
+		ArchiveUrl:   fmt.Sprintf("%s/repository/%s/archive/%s", env.PublicAddress, repo.Name, rev),
 		ServerUrl:    env.PublicAddress,
 		RepositoryID: repo.ID,
+		ChangeName:   change.Name,
+		ChangeID:     change.ID,
+	}
+	if p.Schedule != nil {
+		event.Schedule = *p.Schedule
+	}
+	switch eventType {
+	case ci.EventTypePush, ci.EventTypeRemove, ci.EventTypeSchedule:
+		event.Bookmark = p.Rev
+	case ci.EventTypeManual:
+		event.Bookmark = manualCIBookmark(ctx, p.RepositoryID, p.Rev, p.ChangeID)
+	}
+	if err := addCIEventContext(ctx, p, &event); err != nil {
+		return nil, err
+	}
+	if len(p.Inputs) > 0 {
+		if err := json.Unmarshal(p.Inputs, &event.Inputs); err != nil {
+			return nil, fmt.Errorf("unmarshal inputs: %w", err)
+		}
 	}
 
-	go func() {
-		// Schedule the CI token to be revoked after CITokenTTL (1 hour).
-		// We don't revoke immediately because webhook tasks trigger external CI systems
-		// that need time to actually use the token. The time.AfterFunc call is
-		// energy-efficient as it just sets a timer without blocking.
-		ScheduleCITokenRevocation(accessToken)
+	secretScope, err := getCISecretScope(ctx, p.RepositoryID)
+	if err != nil {
+		return nil, err
+	}
 
-		tempDir, err := extractRepositoryContentToTemp(context.Background(), change.RepositoryID, bookmarkName)
-		if err != nil {
-			fmt.Printf("CI execution error: repo=%s change_id=%d bookmark=%s event=%s detail=extract repository content: %v\n", repo.Name, changeId, bookmarkName, eventType.String(), err)
-			return
-		}
-		defer os.RemoveAll(tempDir)
+	// The config is rendered again with the token once it is known, its
+	// settings must not depend on it
+	config, _, err := ci.UnmarshalConfigWithScope(configData, event, secretScope)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal config %s: %w", p.ConfigFilename, err)
+	}
+	tokenSettings, err := config.Token.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("CI config %s: token: %w", p.ConfigFilename, err)
+	}
 
-		secrets, err := db.Q.GetAllSecrets(context.Background(), change.RepositoryID)
-		if err != nil {
-			fmt.Printf("CI execution error: repo=%s change_id=%d bookmark=%s event=%s detail=get secrets: %v\n", repo.Name, changeId, bookmarkName, eventType.String(), err)
-			return
-		}
+	// Generate the CI access token of this run, revoked when the pipeline finishes
+	accessToken, err := GenerateCIToken(ctx, repo.ID, p.ID, tokenSettings)
+	if err != nil {
+		return nil, fmt.Errorf("generate ci token: %w", err)
+	}
+	event.AccessToken = accessToken
+
+	return &ciPipelineJob{
+		repo:       repo,
+		change:     change,
+		configData: configData,
+		event:      event,
+		secrets:    secretScope,
+	}, nil
+}
 
-		secretsMap := make(map[string]string)
-		for _, secret := range secrets {
-			secretsMap[secret.Key] = secret.Value
-		}
+// runCIPipeline executes a claimed pipeline on the server and stores the
+// runs of its jobs while they execute. The returned error is recorded on the
+// pipeline. Cancelling ctx stops the running jobs.
+func runCIPipeline(ctx context.Context, p db.CiPipeline) error {
+	job, err := prepareCIPipeline(ctx, p)
+	if err != nil {
+		return err
+	}
+	repo := job.repo
+
+	// Extract by change id, a removed bookmark can no longer be resolved
+	gcMutex.RLock()
+	tempDir, err := extractRepositoryContentToTemp(ctx, p.RepositoryID, p.ChangeID)
+	gcMutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("extract repository content: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
 
-		executor := ci.NewExecutor()
-		executor.SetRepoContentDir(tempDir)
-		executor.SetSecrets(secretsMap)
+	if err := db.Q.DeleteCIRunsForPipeline(ctx, &p.ID); err != nil {
+		return fmt.Errorf("delete runs of previous attempts: %w", err)
+	}
 
-		fmt.Printf("CI execution started: repo=%s change_id=%d bookmark=%s event=%s\n", repo.Name, changeId, bookmarkName, eventType.String())
+	executor := ci.NewExecutor()
+	executor.SetRepoContentDir(tempDir)
+	executor.SetSecretScope(job.secrets)
+	recorder := newCIRunRecorder(repo, p.ID)
+	executor.SetObserver(recorder)
+	executor.SetStorage(recorder)
+	executor.SetApprover(recorder)
 
-		results, execErr := executor.ExecuteForBookmarkEvent(context.Background(), configFiles, event, eventType)
+	fmt.Printf("CI execution started: repo=%s change_id=%d rev=%s event=%s pipeline_id=%d attempt=%d\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, p.Attempts)
 
-		for _, res := range results {
-			if err := storeCIRun(repo.ID, res); err != nil {
-				fmt.Printf("CI execution error: repo=%s change_id=%d bookmark=%s event=%s detail=store ci run: %v\n", repo.Name, changeId, bookmarkName, eventType.String(), err)
-			}
-		}
+	results, execErr := executor.ExecuteForEvent(ctx, map[string][]byte{p.ConfigFilename: job.configData}, job.event)
 
-		if execErr != nil {
-			fmt.Printf("CI execution completed: status=failure repo=%s change_id=%d bookmark=%s event=%s error=%v\n", repo.Name, changeId, bookmarkName, eventType.String(), execErr)
-			return
-		}
+	if errors.Is(execErr, ci.ErrCancelled) {
+		fmt.Printf("CI execution completed: status=cancelled repo=%s change_id=%d rev=%s event=%s pipeline_id=%d\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID)
+		return execErr
+	}
+	if execErr != nil {
+		fmt.Printf("CI execution completed: status=failure repo=%s change_id=%d rev=%s event=%s pipeline_id=%d error=%v\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, execErr)
+		return execErr
+	}
 
-		fmt.Printf("CI execution completed: status=success repo=%s change_id=%d bookmark=%s event=%s runs=%d\n", repo.Name, changeId, bookmarkName, eventType.String(), len(results))
-	}()
+	fmt.Printf("CI execution completed: status=success repo=%s change_id=%d rev=%s event=%s pipeline_id=%d runs=%d\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, len(results))
+	return nil
 }
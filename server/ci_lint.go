@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+// lintCIConfigs lints the CI configs that are among the changed files, so a
+// push reports broken configs before a bookmark is moved to them. Problems
+// never fail the push, configs that can not be read are logged and skipped.
+func lintCIConfigs(ctx context.Context, repositoryId int32, files []db.GetChangeFilesRow, changedFiles []string) []*protos.CILintProblem {
+	var configs []db.GetChangeFilesRow
+	for _, file := range files {
+		if strings.HasPrefix(file.Name, ".pogo/ci/") && isCIConfigFile(file.Name) && slices.Contains(changedFiles, file.Name) {
+			configs = append(configs, file)
+		}
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+	slices.SortFunc(configs, func(a, b db.GetChangeFilesRow) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	scope, err := getCISecretKeys(ctx, repositoryId)
+	if err != nil {
+		fmt.Printf("CI lint error: repo_id=%d detail=get secrets: %v\n", repositoryId, err)
+		return nil
+	}
+
+	var problems []*protos.CILintProblem
+	for _, config := range configs {
+		content, err := readFileContent(config.ContentHash)
+		if err != nil {
+			fmt.Printf("CI lint error: repo_id=%d config=%s detail=read file: %v\n", repositoryId, config.Name, err)
+			continue
+		}
+		for _, problem := range ci.Lint(content, scope.Has) {
+			problems = append(problems, &protos.CILintProblem{
+				Filename: config.Name,
+				Severity: string(problem.Severity),
+				Line:     int32(problem.Line),
+				Message:  problem.Message,
+			})
+		}
+	}
+	return problems
+}
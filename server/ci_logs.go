@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pogo-vcs/pogo/compressions"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+const (
+	// ciLogFlushInterval is how often buffered log output of a running job is stored.
+	ciLogFlushInterval = time.Second
+	// ciLogFlushSize is the amount of buffered log output that is stored right
+	// away, even without a complete line.
+	ciLogFlushSize = 64 << 10
+)
+
+// ciLogSignal is closed and replaced whenever log output is stored, a job
+// finishes or a run is approved, waking everyone following a run.
+var ciLogSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// ciLogChanged returns a channel that is closed when log output is stored.
+// Get it before reading the log so no update is missed.
+func ciLogChanged() <-chan struct{} {
+	ciLogSignal.mu.Lock()
+	defer ciLogSignal.mu.Unlock()
+	if ciLogSignal.ch == nil {
+		ciLogSignal.ch = make(chan struct{})
+	}
+	return ciLogSignal.ch
+}
+
+func notifyCILogFollowers() {
+	ciLogSignal.mu.Lock()
+	defer ciLogSignal.mu.Unlock()
+	if ciLogSignal.ch != nil {
+		close(ciLogSignal.ch)
+		ciLogSignal.ch = nil
+	}
+}
+
+// ciRunLog appends the log output of a running job to ci_run_logs. Output is
+// stored in complete lines, so secrets are never split across chunks.
+type ciRunLog struct {
+	runID int32
+	mu    sync.Mutex
+	buf   []byte
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newCIRunLog(runID int32) *ciRunLog {
+	l := &ciRunLog{runID: runID, done: make(chan struct{})}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(ciLogFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				l.flush(false)
+			}
+		}
+	}()
+	return l
+}
+
+func (l *ciRunLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.buf = append(l.buf, p...)
+	full := len(l.buf) >= ciLogFlushSize
+	l.mu.Unlock()
+
+	if full {
+		l.flush(true)
+	}
+	return len(p), nil
+}
+
+// flush stores the buffered complete lines, or everything if all is set.
+func (l *ciRunLog) flush(all bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.buf)
+	if !all {
+		n = bytes.LastIndexByte(l.buf, '\n') + 1
+	}
+	if n == 0 {
+		return
+	}
+
+	chunk := l.buf[:n:n]
+	l.buf = append([]byte(nil), l.buf[n:]...)
+	if err := db.Q.AppendCIRunLog(context.Background(), l.runID, chunk); err != nil {
+		fmt.Printf("CI execution error: run_id=%d detail=append log: %v\n", l.runID, err)
+		return
+	}
+	notifyCILogFollowers()
+}
+
+// close stores the remaining output and stops the periodic flush.
+func (l *ciRunLog) close() {
+	close(l.done)
+	l.wg.Wait()
+	l.flush(true)
+}
+
+// ciRunRecorder stores the jobs of a pipeline while they run. A run is created
+// when its job starts or starts waiting for approval, its log is appended as
+// the job writes it, and the final result and compressed log are stored when
+// the job finishes.
+type ciRunRecorder struct {
+	repo       db.Repository
+	pipelineID int64
+
+	mu   sync.Mutex
+	jobs map[string]ciRecordedJob
+}
+
+// ciRecordedJob is a started or waiting job that has not finished yet.
+type ciRecordedJob struct {
+	runID int32
+	// log is nil while the job waits for approval
+	log *ciRunLog
+}
+
+var _ ci.JobObserver = (*ciRunRecorder)(nil)
+
+func newCIRunRecorder(repo db.Repository, pipelineID int64) *ciRunRecorder {
+	return &ciRunRecorder{
+		repo:       repo,
+		pipelineID: pipelineID,
+		jobs:       make(map[string]ciRecordedJob),
+	}
+}
+
+func ciJobKey(configFilename, jobName string) string {
+	return configFilename + "\x00" + jobName
+}
+
+func (r *ciRunRecorder) JobStarted(res ci.TaskExecutionResult) io.Writer {
+	key := ciJobKey(res.ConfigFilename, res.JobName)
+	r.mu.Lock()
+	job, waited := r.jobs[key]
+	r.mu.Unlock()
+
+	if waited {
+		startTS := pgtype.Timestamptz{Time: res.StartedAt.UTC(), Valid: true}
+		if err := db.Q.StartCIRun(context.Background(), startTS, job.runID); err != nil {
+			fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=start ci run: %v\n", r.repo.Name, r.pipelineID, res.JobName, err)
+			return nil
+		}
+	} else {
+		runID, err := storeCIRun(r.repo.ID, r.pipelineID, res)
+		if err != nil {
+			fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=store ci run: %v\n", r.repo.Name, r.pipelineID, res.JobName, err)
+			return nil
+		}
+		job.runID = runID
+	}
+	notifyCILogFollowers()
+
+	job.log = newCIRunLog(job.runID)
+	r.mu.Lock()
+	r.jobs[key] = job
+	r.mu.Unlock()
+	return job.log
+}
+
+// appendLog adds output of a started job that was sent as a whole, like the
+// log chunks reported by a runner.
+func (r *ciRunRecorder) appendLog(configFilename, jobName string, data []byte) {
+	r.mu.Lock()
+	job, ok := r.jobs[ciJobKey(configFilename, jobName)]
+	r.mu.Unlock()
+	if ok && job.log != nil {
+		_, _ = job.log.Write(data)
+	}
+}
+
+func (r *ciRunRecorder) JobFinished(res ci.TaskExecutionResult) {
+	key := ciJobKey(res.ConfigFilename, res.JobName)
+	r.mu.Lock()
+	job, started := r.jobs[key]
+	delete(r.jobs, key)
+	r.mu.Unlock()
+
+	if started {
+		if job.log != nil {
+			job.log.close()
+		}
+		if err := finishCIRun(job.runID, res); err != nil {
+			fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=finish ci run: %v\n", r.repo.Name, r.pipelineID, res.JobName, err)
+		}
+	} else if runID, err := storeCIRun(r.repo.ID, r.pipelineID, res); err != nil {
+		fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=store ci run: %v\n", r.repo.Name, r.pipelineID, res.JobName, err)
+	} else {
+		job.runID = runID
+	}
+	if job.runID != 0 {
+		if err := storeCITestResults(job.runID, res.Tests); err != nil {
+			fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=store test results: %v\n", r.repo.Name, r.pipelineID, res.JobName, err)
+		}
+	}
+	notifyCILogFollowers()
+}
+
+// abandon finishes the started jobs that will never report a result with the
+// given status, keeping the log output received so far.
+func (r *ciRunRecorder) abandon(status ci.JobStatus) {
+	r.mu.Lock()
+	jobs := r.jobs
+	r.jobs = make(map[string]ciRecordedJob)
+	r.mu.Unlock()
+
+	for _, job := range jobs {
+		if job.log != nil {
+			job.log.close()
+		}
+		log, err := getCIRunLog(context.Background(), job.runID, string(ci.JobStatusRunning), nil)
+		if err != nil {
+			fmt.Printf("CI execution error: run_id=%d detail=%v\n", job.runID, err)
+		}
+		res := ci.TaskExecutionResult{
+			Status:     status,
+			StatusCode: -1,
+			FinishedAt: time.Now(),
+			Log:        string(log),
+		}
+		if err := finishCIRun(job.runID, res); err != nil {
+			fmt.Printf("CI execution error: run_id=%d detail=finish ci run: %v\n", job.runID, err)
+		}
+	}
+	if len(jobs) > 0 {
+		notifyCILogFollowers()
+	}
+}
+
+// finishCIRun stores the final result of a run and replaces the log chunks
+// with the compressed log.
+func finishCIRun(runID int32, res ci.TaskExecutionResult) error {
+	compressedLog, err := compressions.CompressBytes([]byte(res.Log))
+	if err != nil {
+		return fmt.Errorf("compress log: %w", err)
+	}
+
+	var finishTS pgtype.Timestamptz
+	if !res.FinishedAt.IsZero() {
+		finishTS = pgtype.Timestamptz{
+			Time:  res.FinishedAt.UTC(),
+			Valid: true,
+		}
+	}
+
+	if err := db.Q.FinishCIRun(context.Background(),
+		int32(res.StatusCode),
+		res.Success,
+		finishTS,
+		compressedLog,
+		string(res.Status),
+		runID,
+	); err != nil {
+		return err
+	}
+	return db.Q.DeleteCIRunLogs(context.Background(), runID)
+}
+
+// ciRunInProgress reports whether a run is waiting or running. Its log is then
+// stored in chunks instead of compressed.
+func ciRunInProgress(status string) bool {
+	return status == string(ci.JobStatusRunning) || status == string(ci.JobStatusWaiting)
+}
+
+// getCIRunLog returns the log of a run. While the run is in progress it is
+// assembled from the stored chunks.
+func getCIRunLog(ctx context.Context, runID int32, status string, compressedLog []byte) ([]byte, error) {
+	if !ciRunInProgress(status) {
+		return compressions.DecompressBytes(compressedLog)
+	}
+
+	chunks, err := db.Q.GetCIRunLogChunks(ctx, runID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get log chunks: %w", err)
+	}
+	var log []byte
+	for _, chunk := range chunks {
+		log = append(log, chunk.Data...)
+	}
+	return log, nil
+}
+
+// followCIRunLog passes the log output of a run to onData as it is stored.
+// Once the run finished, the rest of the final log is passed to onData and the
+// final status to onDone.
+func followCIRunLog(ctx context.Context, runID int32, onData func([]byte) error, onDone func(status string) error) error {
+	var lastChunkID int64
+	sent := 0
+	for {
+		changed := ciLogChanged()
+
+		run, err := db.Q.GetCIRunStatus(ctx, runID)
+		if err != nil {
+			return fmt.Errorf("get ci run: %w", err)
+		}
+
+		if !ciRunInProgress(run.Status) {
+			log, err := compressions.DecompressBytes(run.Log)
+			if err != nil {
+				return fmt.Errorf("decompress log: %w", err)
+			}
+			if sent < len(log) {
+				if err := onData(log[sent:]); err != nil {
+					return err
+				}
+			}
+			return onDone(run.Status)
+		}
+
+		chunks, err := db.Q.GetCIRunLogChunks(ctx, runID, lastChunkID)
+		if err != nil {
+			return fmt.Errorf("get log chunks: %w", err)
+		}
+		for _, chunk := range chunks {
+			if err := onData(chunk.Data); err != nil {
+				return err
+			}
+			lastChunkID = chunk.ID
+			sent += len(chunk.Data)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-time.After(ciLogFlushInterval):
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pogo-vcs/pogo/db"
+)
+
+const (
+	// ciHeartbeatInterval is how often a worker marks its pipeline as alive.
+	ciHeartbeatInterval = 30 * time.Second
+	// ciOrphanTimeout is how long a running pipeline may go without a heartbeat
+	// before it is considered orphaned by a crashed server.
+	ciOrphanTimeout = 2 * time.Minute
+	// ciPollInterval is how often idle workers and waiting runners look for
+	// pipelines queued by other server instances.
+	ciPollInterval = 5 * time.Second
+	// ciMaxAttempts is how often an orphaned pipeline is started before it fails.
+	ciMaxAttempts = 3
+)
+
+// ciQueueSignal is closed and replaced whenever pipelines are queued, waking
+// all idle workers and waiting runners.
+var ciQueueSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// errCIWorkersStopped cancels the pipelines running when the workers stop.
+var errCIWorkersStopped = errors.New("CI workers stopped")
+
+// ciWorkers holds the state of the running workers. Running pipelines are
+// mapped to the function that cancels their execution.
+var ciWorkers struct {
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	running  map[int64]context.CancelCauseFunc
+	finished sync.WaitGroup
+}
+
+// ciQueueChanged returns a channel that is closed when pipelines are queued.
+// Get it before looking at the queue so no wake up is missed.
+func ciQueueChanged() <-chan struct{} {
+	ciQueueSignal.mu.Lock()
+	defer ciQueueSignal.mu.Unlock()
+	if ciQueueSignal.ch == nil {
+		ciQueueSignal.ch = make(chan struct{})
+	}
+	return ciQueueSignal.ch
+}
+
+func wakeCIWorkers() {
+	ciQueueSignal.mu.Lock()
+	defer ciQueueSignal.mu.Unlock()
+	if ciQueueSignal.ch != nil {
+		close(ciQueueSignal.ch)
+		ciQueueSignal.ch = nil
+	}
+}
+
+// StartCIWorkers recovers orphaned pipelines and starts n workers that run
+// pipelines without runner labels on the server. With n = 0 all pipelines are
+// left to remote runners.
+func StartCIWorkers(ctx context.Context, n int) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	ciWorkers.mu.Lock()
+	ciWorkers.cancel = cancel
+	ciWorkers.running = make(map[int64]context.CancelCauseFunc)
+	ciWorkers.mu.Unlock()
+
+	go recoverOrphanedCIPipelines(ctx)
+	for range n {
+		go runCIWorker(ctx)
+	}
+}
+
+// StopCIWorkers stops claiming pipelines and cancels the running ones. Once
+// they stopped, they are put back into the queue, so they are picked up again
+// after the restart.
+func StopCIWorkers() {
+	ciWorkers.mu.Lock()
+	cancel := ciWorkers.cancel
+	ciWorkers.cancel = nil
+	running := ciWorkers.running
+	ciWorkers.running = nil
+	ciWorkers.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+
+	for _, cancelPipeline := range running {
+		cancelPipeline(errCIWorkersStopped)
+	}
+	ciWorkers.finished.Wait()
+}
+
+// releaseCIPipeline puts a pipeline stopped by a shutdown back into the queue.
+func releaseCIPipeline(pipelineID int64) {
+	RevokeCITokens(context.Background(), pipelineID)
+	if err := db.Q.ReleaseCIPipeline(context.Background(), pipelineID); err != nil {
+		fmt.Printf("CI queue error: pipeline_id=%d detail=release pipeline: %v\n", pipelineID, err)
+	}
+}
+
+// recoverOrphanedCIPipelines requeues running pipelines whose worker stopped
+// sending heartbeats. It runs on startup and then periodically.
+func recoverOrphanedCIPipelines(ctx context.Context) {
+	ticker := time.NewTicker(ciOrphanTimeout)
+	defer ticker.Stop()
+
+	for {
+		staleBefore := pgtype.Timestamptz{
+			Time:  time.Now().Add(-ciOrphanTimeout).UTC(),
+			Valid: true,
+		}
+		pipelines, err := db.Q.RequeueOrphanedCIPipelines(ctx, ciMaxAttempts, staleBefore)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("CI queue error: detail=requeue orphaned pipelines: %v\n", err)
+		}
+		for _, p := range pipelines {
+			fmt.Printf("CI pipeline orphaned: pipeline_id=%d state=%s\n", p.ID, p.State)
+		}
+		if len(pipelines) > 0 {
+			wakeCIWorkers()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runCIWorker(ctx context.Context) {
+	for {
+		changed := ciQueueChanged()
+		p, err := db.Q.ClaimCIPipeline(ctx)
+		if err == nil {
+			runClaimedCIPipeline(p)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			fmt.Printf("CI queue error: detail=claim pipeline: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(ciPollInterval):
+		}
+	}
+}
+
+// cancelLocalCIPipeline stops the execution of a pipeline if it runs on this
+// server instance.
+func cancelLocalCIPipeline(pipelineID int64) {
+	ciWorkers.mu.Lock()
+	cancel := ciWorkers.running[pipelineID]
+	ciWorkers.mu.Unlock()
+	if cancel != nil {
+		cancel(nil)
+	}
+}
+
+func runClaimedCIPipeline(p db.CiPipeline) {
+	// Pipelines are not bound to the worker context. On shutdown they are
+	// released back to the queue instead of being recorded as failed.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	ciWorkers.mu.Lock()
+	if ciWorkers.running == nil {
+		// The workers stopped after the pipeline was claimed
+		ciWorkers.mu.Unlock()
+		releaseCIPipeline(p.ID)
+		return
+	}
+	ciWorkers.running[p.ID] = cancel
+	ciWorkers.finished.Add(1)
+	ciWorkers.mu.Unlock()
+	defer ciWorkers.finished.Done()
+	defer func() {
+		ciWorkers.mu.Lock()
+		delete(ciWorkers.running, p.ID)
+		ciWorkers.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(ciHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				alive, err := db.Q.HeartbeatCIPipeline(context.Background(), p.ID)
+				if err != nil {
+					fmt.Printf("CI queue error: pipeline_id=%d detail=heartbeat: %v\n", p.ID, err)
+				} else if alive == 0 {
+					// Cancelled through another server instance
+					cancel(nil)
+				}
+			}
+		}
+	}()
+
+	state := "succeeded"
+	var errMsg *string
+	if err := runCIPipeline(ctx, p); err != nil {
+		state = "failed"
+		msg := err.Error()
+		errMsg = &msg
+	}
+	if errors.Is(context.Cause(ctx), errCIWorkersStopped) {
+		releaseCIPipeline(p.ID)
+		return
+	}
+
+	finished, err := db.Q.FinishCIPipeline(context.Background(), state, errMsg, p.ID)
+	if err != nil {
+		fmt.Printf("CI queue error: pipeline_id=%d detail=finish pipeline: %v\n", p.ID, err)
+	}
+	RevokeCITokens(context.Background(), p.ID)
+	// Cancelled pipelines were reported when they were cancelled
+	if finished > 0 {
+		notifyCIPipelineFinished(context.Background(), p.ID)
+	}
+}
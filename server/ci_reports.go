@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+// ciTestHistorySize is the number of runs shown in the history of a test.
+const ciTestHistorySize = 10
+
+// storeCITestResults stores the tests parsed from the reports of a job.
+func storeCITestResults(runID int32, tests []ci.TestResult) error {
+	if len(tests) == 0 {
+		return nil
+	}
+
+	suites := make([]string, len(tests))
+	names := make([]string, len(tests))
+	statuses := make([]string, len(tests))
+	durations := make([]int64, len(tests))
+	messages := make([]string, len(tests))
+	for i, t := range tests {
+		suites[i] = t.Suite
+		names[i] = t.Name
+		statuses[i] = string(t.Status)
+		durations[i] = t.Duration.Milliseconds()
+		messages[i] = t.Message
+	}
+	return db.Q.CreateCITestResults(context.Background(), runID, suites, names, statuses, durations, messages)
+}
+
+// getCIRunTests returns the test counts of a run and its failed tests with
+// their history.
+func getCIRunTests(ctx context.Context, repoID int32, runID int32) (*protos.CITestCounts, []*protos.CIFailedTest, error) {
+	counts, err := db.Q.GetCIRunTestCounts(ctx, runID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get test counts: %w", err)
+	}
+	if counts.Passed+counts.Failed+counts.Skipped == 0 {
+		return nil, nil, nil
+	}
+
+	rows, err := db.Q.ListCIRunFailedTests(ctx, ciTestHistorySize, repoID, runID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list failed tests: %w", err)
+	}
+	failed := make([]*protos.CIFailedTest, len(rows))
+	for i, row := range rows {
+		failed[i] = &protos.CIFailedTest{
+			Suite:      row.Suite,
+			Name:       row.Name,
+			DurationMs: row.DurationMs,
+			History:    row.History,
+		}
+		if row.Message != nil {
+			failed[i].Message = *row.Message
+		}
+	}
+
+	return &protos.CITestCounts{
+		Passed:  counts.Passed,
+		Failed:  counts.Failed,
+		Skipped: counts.Skipped,
+	}, failed, nil
+}
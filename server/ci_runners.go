@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/env"
+	"google.golang.org/grpc"
+)
+
+// ciRunnerWait is how long AcquireCIJob waits for a pipeline before it
+// returns without a job and the runner asks again.
+const ciRunnerWait = 30 * time.Second
+
+func (a *Server) CreateCIRunner(ctx context.Context, req *protos.CreateCIRunnerRequest) (*protos.CreateCIRunnerResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+	if req.Name == "" {
+		return nil, errors.New("runner name is required")
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate runner token: %w", err)
+	}
+
+	if _, err := db.Q.CreateCIRunner(ctx, req.RepoId, req.Name, token, userId); err != nil {
+		return nil, fmt.Errorf("create runner: %w", err)
+	}
+
+	return &protos.CreateCIRunnerResponse{RunnerToken: db.EncodeToken(token)}, nil
+}
+
+func (a *Server) ListCIRunners(ctx context.Context, req *protos.ListCIRunnersRequest) (*protos.ListCIRunnersResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	rows, err := db.Q.ListCIRunners(ctx, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("list runners: %w", err)
+	}
+
+	runners := make([]*protos.CIRunner, 0, len(rows))
+	for _, row := range rows {
+		runners = append(runners, &protos.CIRunner{
+			Name:       row.Name,
+			Labels:     row.Labels,
+			CreatedAt:  formatTimestamptz(row.CreatedAt),
+			LastSeenAt: formatTimestamptz(row.LastSeenAt),
+		})
+	}
+
+	return &protos.ListCIRunnersResponse{Runners: runners}, nil
+}
+
+func (a *Server) DeleteCIRunner(ctx context.Context, req *protos.DeleteCIRunnerRequest) (*protos.DeleteCIRunnerResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	deleted, err := db.Q.DeleteCIRunner(ctx, req.RepoId, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("delete runner: %w", err)
+	}
+	if deleted == 0 {
+		return nil, fmt.Errorf("runner %s not found", req.Name)
+	}
+
+	return &protos.DeleteCIRunnerResponse{}, nil
+}
+
+func getCIRunnerFromToken(ctx context.Context, token []byte) (db.CiRunner, error) {
+	runner, err := db.Q.GetCIRunnerByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return runner, errors.New("invalid runner token")
+		}
+		return runner, fmt.Errorf("get runner: %w", err)
+	}
+	return runner, nil
+}
+
+func (a *Server) RegisterCIRunner(ctx context.Context, req *protos.RegisterCIRunnerRequest) (*protos.RegisterCIRunnerResponse, error) {
+	runner, err := getCIRunnerFromToken(ctx, req.RunnerToken)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := req.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	if err := db.Q.RegisterCIRunner(ctx, labels, runner.ID); err != nil {
+		return nil, fmt.Errorf("register runner: %w", err)
+	}
+
+	repo, err := db.Q.GetRepository(ctx, runner.RepositoryID)
+	if err != nil {
+		return nil, fmt.Errorf("get repository: %w", err)
+	}
+
+	fmt.Printf("CI runner registered: repo=%s runner=%s labels=%v\n", repo.Name, runner.Name, labels)
+	return &protos.RegisterCIRunnerResponse{Name: runner.Name, RepoName: repo.Name}, nil
+}
+
+// AcquireCIJob claims the oldest queued pipeline of the runner's repository
+// whose runs_on labels are all carried by the runner. Without one it waits up
+// to ciRunnerWait for a pipeline to be queued.
+func (a *Server) AcquireCIJob(ctx context.Context, req *protos.AcquireCIJobRequest) (*protos.AcquireCIJobResponse, error) {
+	runner, err := getCIRunnerFromToken(ctx, req.RunnerToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Q.TouchCIRunner(ctx, runner.ID); err != nil {
+		return nil, fmt.Errorf("update runner: %w", err)
+	}
+
+	deadline := time.NewTimer(ciRunnerWait)
+	defer deadline.Stop()
+
+	for {
+		changed := ciQueueChanged()
+		p, err := db.Q.ClaimCIPipelineForRunner(ctx, &runner.ID, runner.RepositoryID, runner.Labels)
+		switch {
+		case err == nil:
+			job, err := buildCIRunnerJob(ctx, p)
+			if err != nil {
+				fmt.Printf("CI execution error: pipeline_id=%d runner=%s detail=%v\n", p.ID, runner.Name, err)
+				errMsg := err.Error()
+				if finished, _ := db.Q.FinishCIPipeline(context.Background(), "failed", &errMsg, p.ID); finished > 0 {
+					notifyCIPipelineFinished(context.Background(), p.ID)
+				}
+				RevokeCITokens(context.Background(), p.ID)
+				continue
+			}
+			fmt.Printf("CI execution started: change_id=%d rev=%s event=%s pipeline_id=%d attempt=%d runner=%s\n", p.ChangeID, p.Rev, p.EventType, p.ID, p.Attempts, runner.Name)
+			return &protos.AcquireCIJobResponse{Job: job}, nil
+		case !errors.Is(err, pgx.ErrNoRows):
+			return nil, fmt.Errorf("claim pipeline: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return &protos.AcquireCIJobResponse{}, nil
+		case <-changed:
+		case <-time.After(ciPollInterval):
+		}
+	}
+}
+
+func buildCIRunnerJob(ctx context.Context, p db.CiPipeline) (*protos.CIJob, error) {
+	job, err := prepareCIPipeline(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Q.DeleteCIRunsForPipeline(ctx, &p.ID); err != nil {
+		return nil, fmt.Errorf("delete runs of previous attempts: %w", err)
+	}
+
+	// The runner only gets the secrets of the environment of the config
+	secrets, err := job.secrets.Resolve(job.configData, job.event)
+	if err != nil {
+		return nil, fmt.Errorf("resolve secrets: %w", err)
+	}
+
+	event := job.event
+	return &protos.CIJob{
+		PipelineId:     p.ID,
+		ConfigFilename: p.ConfigFilename,
+		Config:         job.configData,
+		Secrets:        secrets,
+		// The change name still resolves when the bookmark of the event is gone
+		ArchiveUrl: fmt.Sprintf("%s/repository/%s/archive/%s", env.PublicAddress, job.repo.Name, job.change.Name),
+		Event: &protos.CIJobEvent{
+			Type:                  event.Type.String(),
+			Rev:                   event.Rev,
+			ArchiveUrl:            event.ArchiveUrl,
+			Author:                event.Author,
+			Description:           event.Description,
+			AccessToken:           event.AccessToken,
+			ServerUrl:             event.ServerUrl,
+			RepositoryId:          event.RepositoryID,
+			Schedule:              event.Schedule,
+			Inputs:                event.Inputs,
+			ChangedFiles:          event.ChangedFiles,
+			ChangedFilesKnown:     event.ChangedFiles != nil,
+			ChangedFileOperations: ci.ChangedPathOperations(event.ChangedPaths),
+			ChangeName:            event.ChangeName,
+			ChangeId:              event.ChangeID,
+			Parents:               event.Parents,
+			Bookmark:              event.Bookmark,
+			PreviousChangeName:    event.PreviousChangeName,
+			TriggeredBy:           event.TriggeredBy,
+		},
+	}, nil
+}
+
+// ReportCIJob receives the heartbeats, the jobs with their log output and the
+// final outcome of a pipeline executed by a runner. If the stream ends without
+// a finish report, the pipeline is left running and requeued once its
+// heartbeat goes stale. Once the pipeline is cancelled, the stream is closed
+// with an error, which tells the runner to stop.
+func (a *Server) ReportCIJob(stream grpc.ClientStreamingServer[protos.ReportCIJobRequest, protos.ReportCIJobResponse]) error {
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("receive report: %w", err)
+	}
+
+	runner, err := getCIRunnerFromToken(ctx, req.RunnerToken)
+	if err != nil {
+		return err
+	}
+	p, err := db.Q.GetRunningCIPipelineForRunner(ctx, req.PipelineId, &runner.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("pipeline %d is not running on runner %s", req.PipelineId, runner.Name)
+		}
+		return fmt.Errorf("get pipeline: %w", err)
+	}
+	repo, err := db.Q.GetRepository(ctx, p.RepositoryID)
+	if err != nil {
+		return fmt.Errorf("get repository: %w", err)
+	}
+
+	recorder := newCIRunRecorder(repo, p.ID)
+	defer recorder.abandon(ci.JobStatusFailure)
+
+	heartbeat := func() error {
+		alive, err := db.Q.HeartbeatCIPipeline(ctx, p.ID)
+		if err != nil {
+			return fmt.Errorf("heartbeat: %w", err)
+		}
+		if alive == 0 {
+			recorder.abandon(ci.JobStatusCancelled)
+			fmt.Printf("CI execution completed: status=cancelled repo=%s change_id=%d rev=%s event=%s pipeline_id=%d runner=%s\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, runner.Name)
+			return ci.ErrCancelled
+		}
+		return nil
+	}
+
+	runs := 0
+	for {
+		if req.PipelineId != p.ID || !slices.Equal(req.RunnerToken, runner.Token) {
+			return errors.New("report does not belong to the pipeline")
+		}
+
+		switch report := req.Report.(type) {
+		case *protos.ReportCIJobRequest_Heartbeat:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+
+		case *protos.ReportCIJobRequest_Started:
+			res, err := ciResultFromProto(report.Started)
+			if err != nil {
+				return err
+			}
+			recorder.JobStarted(res)
+
+		case *protos.ReportCIJobRequest_Waiting:
+			res, err := ciResultFromProto(report.Waiting)
+			if err != nil {
+				return err
+			}
+			if _, err := recorder.jobWaiting(res); err != nil {
+				fmt.Printf("CI execution error: repo=%s pipeline_id=%d job=%s detail=store ci run: %v\n", repo.Name, p.ID, res.JobName, err)
+			}
+
+		case *protos.ReportCIJobRequest_Log:
+			recorder.appendLog(report.Log.ConfigFilename, report.Log.JobName, report.Log.Data)
+			if err := heartbeat(); err != nil {
+				return err
+			}
+
+		case *protos.ReportCIJobRequest_Result:
+			res, err := ciResultFromProto(report.Result)
+			if err != nil {
+				return err
+			}
+			recorder.JobFinished(res)
+			runs++
+			if err := heartbeat(); err != nil {
+				return err
+			}
+
+		case *protos.ReportCIJobRequest_Finish:
+			state := "succeeded"
+			if report.Finish.Error != nil {
+				state = "failed"
+				fmt.Printf("CI execution completed: status=failure repo=%s change_id=%d rev=%s event=%s pipeline_id=%d runner=%s error=%s\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, runner.Name, *report.Finish.Error)
+			} else {
+				fmt.Printf("CI execution completed: status=success repo=%s change_id=%d rev=%s event=%s pipeline_id=%d runner=%s runs=%d\n", repo.Name, p.ChangeID, p.Rev, p.EventType, p.ID, runner.Name, runs)
+			}
+			finished, err := db.Q.FinishCIPipeline(ctx, state, report.Finish.Error, p.ID)
+			if err != nil {
+				return fmt.Errorf("finish pipeline: %w", err)
+			}
+			RevokeCITokens(ctx, p.ID)
+			if finished > 0 {
+				notifyCIPipelineFinished(ctx, p.ID)
+			}
+			return stream.SendAndClose(&protos.ReportCIJobResponse{})
+		}
+
+		req, err = stream.Recv()
+		if err == io.EOF {
+			return errors.New("report ended without finishing the pipeline")
+		}
+		if err != nil {
+			return fmt.Errorf("receive report: %w", err)
+		}
+	}
+}
+
+// WaitCIApproval reports whether a job the runner reported as waiting was
+// approved. Without an approval it waits up to ciRunnerWait for one.
+func (a *Server) WaitCIApproval(ctx context.Context, req *protos.WaitCIApprovalRequest) (*protos.WaitCIApprovalResponse, error) {
+	runner, err := getCIRunnerFromToken(ctx, req.RunnerToken)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Q.GetRunningCIPipelineForRunner(ctx, req.PipelineId, &runner.ID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("pipeline %d is not running on runner %s", req.PipelineId, runner.Name)
+		}
+		return nil, fmt.Errorf("get pipeline: %w", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, ciRunnerWait)
+	defer cancel()
+	err = waitForCIApproval(waitCtx, func() (bool, error) {
+		approved, err := db.Q.IsCIJobApproved(waitCtx, &req.PipelineId, req.ConfigFilename, &req.JobName)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The waiting report may not have been stored yet
+			return false, nil
+		}
+		return approved, err
+	})
+	switch {
+	case err == nil:
+		return &protos.WaitCIApprovalResponse{Approved: true}, nil
+	case waitCtx.Err() != nil && ctx.Err() == nil:
+		return &protos.WaitCIApprovalResponse{}, nil
+	default:
+		return nil, err
+	}
+}
+
+func ciResultFromProto(r *protos.CIJobResult) (ci.TaskExecutionResult, error) {
+	eventType, err := ci.ParseEventType(r.EventType)
+	if err != nil {
+		return ci.TaskExecutionResult{}, err
+	}
+	res := ci.TaskExecutionResult{
+		ConfigFilename: r.ConfigFilename,
+		EventType:      eventType,
+		Rev:            r.Rev,
+		Pattern:        r.Pattern,
+		Reason:         r.Reason,
+		TaskType:       r.TaskType,
+		JobName:        r.JobName,
+		Needs:          r.Needs,
+		Status:         ci.JobStatus(r.Status),
+		StatusCode:     int(r.StatusCode),
+		Success:        r.Success,
+		Log:            r.Log,
+		Matrix:         r.Matrix,
+	}
+	for _, t := range r.Tests {
+		res.Tests = append(res.Tests, ci.TestResult{
+			Suite:    t.Suite,
+			Name:     t.Name,
+			Status:   ci.TestStatus(t.Status),
+			Duration: time.Duration(t.DurationMs) * time.Millisecond,
+			Message:  t.Message,
+		})
+	}
+	if r.StartedAt != "" {
+		if res.StartedAt, err = time.Parse(time.RFC3339Nano, r.StartedAt); err != nil {
+			return res, fmt.Errorf("parse started at: %w", err)
+		}
+	}
+	if r.FinishedAt != "" {
+		if res.FinishedAt, err = time.Parse(time.RFC3339Nano, r.FinishedAt); err != nil {
+			return res, fmt.Errorf("parse finished at: %w", err)
+		}
+	}
+	return res, nil
+}
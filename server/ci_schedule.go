@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/robfig/cron/v3"
+)
+
+// ciScheduler holds the cron entries of all schedule triggers, grouped by repository.
+// Entries are rebuilt whenever a bookmark of the repository changes.
+var ciScheduler struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[int32][]cron.EntryID
+}
+
+// StartCIScheduler registers the schedule triggers of all repositories and starts the scheduler.
+func StartCIScheduler(ctx context.Context) error {
+	repoIds, err := db.Q.GetRepositoriesWithBookmarks(ctx)
+	if err != nil {
+		return fmt.Errorf("get repositories with bookmarks: %w", err)
+	}
+
+	ciScheduler.mu.Lock()
+	ciScheduler.cron = cron.New()
+	ciScheduler.entries = make(map[int32][]cron.EntryID)
+	ciScheduler.cron.Start()
+	ciScheduler.mu.Unlock()
+
+	for _, repoId := range repoIds {
+		syncCISchedules(ctx, repoId)
+	}
+	return nil
+}
+
+// StopCIScheduler stops the scheduler and waits for running triggers to be dispatched.
+func StopCIScheduler() {
+	ciScheduler.mu.Lock()
+	c := ciScheduler.cron
+	ciScheduler.cron = nil
+	ciScheduler.entries = nil
+	ciScheduler.mu.Unlock()
+
+	if c != nil {
+		<-c.Stop().Done()
+	}
+}
+
+// syncCISchedules replaces the cron entries of a repository with the schedule
+// triggers found in the CI configs of its bookmarks.
+func syncCISchedules(ctx context.Context, repoId int32) {
+	ciScheduler.mu.Lock()
+	defer ciScheduler.mu.Unlock()
+
+	if ciScheduler.cron == nil {
+		return
+	}
+	for _, entryId := range ciScheduler.entries[repoId] {
+		ciScheduler.cron.Remove(entryId)
+	}
+	delete(ciScheduler.entries, repoId)
+
+	bookmarks, err := db.Q.GetBookmarks(ctx, repoId)
+	if err != nil {
+		fmt.Printf("CI schedule error: repo_id=%d detail=get bookmarks: %v\n", repoId, err)
+		return
+	}
+	if len(bookmarks) == 0 {
+		return
+	}
+
+	secretScope, err := getCISecretKeys(ctx, repoId)
+	if err != nil {
+		fmt.Printf("CI schedule error: repo_id=%d detail=%v\n", repoId, err)
+		return
+	}
+
+	for _, bookmark := range bookmarks {
+		configFiles, err := getCIConfigFiles(ctx, bookmark.ChangeID)
+		if err != nil {
+			fmt.Printf("CI schedule error: repo_id=%d bookmark=%s detail=%v\n", repoId, bookmark.Bookmark, err)
+			continue
+		}
+
+		for filename, configData := range configFiles {
+			event := ci.Event{Type: ci.EventTypeSchedule, Rev: bookmark.Bookmark, Bookmark: bookmark.Bookmark, RepositoryID: repoId}
+			config, _, err := ci.UnmarshalConfigWithScope(configData, event, secretScope)
+			if err != nil {
+				fmt.Printf("CI schedule error: repo_id=%d bookmark=%s config=%s detail=%v\n", repoId, bookmark.Bookmark, filename, err)
+				continue
+			}
+
+			scheduled := make(map[string]struct{})
+			for _, schedule := range config.On.Schedule {
+				if _, ok := scheduled[schedule.Cron]; ok {
+					continue
+				}
+				event.Schedule = schedule.Cron
+				if _, ok := config.Trigger(event); !ok {
+					continue
+				}
+				scheduled[schedule.Cron] = struct{}{}
+
+				entryId, err := ciScheduler.cron.AddFunc(schedule.Cron, scheduledCIFunc(repoId, bookmark.Bookmark, filename, schedule.Cron))
+				if err != nil {
+					fmt.Printf("CI schedule error: repo_id=%d bookmark=%s config=%s cron=%q detail=%v\n", repoId, bookmark.Bookmark, filename, schedule.Cron, err)
+					continue
+				}
+				ciScheduler.entries[repoId] = append(ciScheduler.entries[repoId], entryId)
+			}
+		}
+	}
+}
+
+func scheduledCIFunc(repoId int32, bookmarkName, configName, schedule string) func() {
+	return func() {
+		ctx := context.Background()
+		changeId, err := db.Q.GetBookmark(ctx, repoId, bookmarkName)
+		if err != nil {
+			// The bookmark or repository is gone, drop the stale entries
+			syncCISchedules(ctx, repoId)
+			return
+		}
+		enqueueCI(ctx, changeId, ci.Event{Type: ci.EventTypeSchedule, Rev: bookmarkName, Schedule: schedule}, configName, ciTrigger{})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+// ciStatusReport is the status of a job of an external CI system.
+type ciStatusReport struct {
+	// Name of the job, later reports with the same name update its run
+	Name string `json:"name"`
+	// Status is running, success, failure or cancelled
+	Status string `json:"status"`
+	// Log replaces the log of the run
+	Log string `json:"log,omitempty"`
+}
+
+// handleCIStatusReport stores the status of a job of an external CI system,
+// e.g. one started by a webhook task, as a run of the pipeline the CI token
+// was issued for. It requires a CI token with the report_status permission.
+func handleCIStatusReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	ciToken := GetCITokenInfo(ctx)
+	if ciToken == nil || !ciToken.Allows(ciToken.RepoID, ci.TokenReportStatus) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var report ciStatusReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid status report: %v", err), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(report.Name)
+	if name == "" {
+		http.Error(w, "Job name is required", http.StatusBadRequest)
+		return
+	}
+
+	status := ci.JobStatus(report.Status)
+	statusCode := 0
+	switch status {
+	case ci.JobStatusRunning, ci.JobStatusSuccess:
+	case ci.JobStatusFailure:
+		statusCode = 1
+	case ci.JobStatusCancelled:
+		statusCode = -1
+	default:
+		http.Error(w, fmt.Sprintf("Invalid status %q, must be running, success, failure or cancelled", report.Status), http.StatusBadRequest)
+		return
+	}
+
+	p, err := db.Q.GetCIPipeline(ctx, ciToken.PipelineID)
+	if err != nil {
+		http.Error(w, "CI pipeline not found", http.StatusNotFound)
+		return
+	}
+	eventType, err := ci.ParseEventType(p.EventType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	res := ci.TaskExecutionResult{
+		ConfigFilename: p.ConfigFilename,
+		EventType:      eventType,
+		Rev:            p.Rev,
+		Reason:         "reported by external CI",
+		TaskType:       "external",
+		JobName:        name,
+		Status:         status,
+		StatusCode:     statusCode,
+		Success:        status == ci.JobStatusSuccess,
+		StartedAt:      now,
+		Log:            report.Log,
+	}
+	if status != ci.JobStatusRunning {
+		res.FinishedAt = now
+	}
+
+	var runID int32
+	existing, err := db.Q.GetExternalCIRun(ctx, &p.ID, &name)
+	switch {
+	case err == nil:
+		runID = existing.ID
+		err = finishCIRun(runID, res)
+	case errors.Is(err, pgx.ErrNoRows):
+		runID, err = storeCIRun(p.RepositoryID, p.ID, res)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store status: %v", err), http.StatusInternalServerError)
+		return
+	}
+	notifyCILogFollowers()
+
+	fmt.Printf("CI status reported: repo_id=%d pipeline_id=%d run_id=%d job=%s status=%s\n", p.RepositoryID, p.ID, runID, name, status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"run_id":  runID,
+	})
+}
@@ -1,66 +1,80 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"sync"
+	"fmt"
+	"slices"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/server/ci"
 )
 
 // CITokenInfo contains information about a CI access token.
 type CITokenInfo struct {
-	RepoID int32
+	RepoID      int32
+	PipelineID  int64
+	Permissions []ci.TokenPermission
 }
 
-// CITokenTTL is the time-to-live for CI tokens. After this duration, the token
-// will be automatically revoked. This gives external CI systems enough time to
-// use the token after being triggered by a webhook.
-const CITokenTTL = 1 * time.Hour
-
-var (
-	ciTokensMu sync.RWMutex
-	ciTokens   = make(map[string]*CITokenInfo)
-)
+// Allows reports whether the token grants the permission in the given
+// repository.
+func (i *CITokenInfo) Allows(repoID int32, permission ci.TokenPermission) bool {
+	return i.RepoID == repoID && slices.Contains(i.Permissions, permission)
+}
 
-// GenerateCIToken creates a new temporary CI access token for the given repository.
-// The token is stored in memory and can be used for authenticated requests during CI runs.
-func GenerateCIToken(repoID int32) (string, error) {
+// GenerateCIToken creates a CI access token for a pipeline of the given
+// repository. The token is stored in the database, so it stays valid across
+// restarts until its TTL ends or the pipeline finishes.
+func GenerateCIToken(ctx context.Context, repoID int32, pipelineID int64, settings ci.TokenSettings) (string, error) {
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
 		return "", err
 	}
 
-	token := base64.URLEncoding.EncodeToString(tokenBytes)
-
-	ciTokensMu.Lock()
-	ciTokens[token] = &CITokenInfo{
-		RepoID: repoID,
+	permissions := make([]string, len(settings.Permissions))
+	for i, p := range settings.Permissions {
+		permissions[i] = string(p)
 	}
-	ciTokensMu.Unlock()
+	expiresAt := pgtype.Timestamptz{Time: time.Now().Add(settings.TTL).UTC(), Valid: true}
 
-	return token, nil
+	if err := db.Q.CreateCIToken(ctx, tokenBytes, repoID, pipelineID, permissions, settings.WebhookGracePeriod, expiresAt); err != nil {
+		return "", fmt.Errorf("store ci token: %w", err)
+	}
+	return base64.URLEncoding.EncodeToString(tokenBytes), nil
 }
 
 // ValidateCIToken checks if the given token is a valid CI token.
 // Returns the token info if valid, nil otherwise.
-func ValidateCIToken(token string) *CITokenInfo {
-	ciTokensMu.RLock()
-	defer ciTokensMu.RUnlock()
-	return ciTokens[token]
-}
+func ValidateCIToken(ctx context.Context, token string) *CITokenInfo {
+	tokenBytes, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil
+	}
+	row, err := db.Q.GetCIToken(ctx, tokenBytes)
+	if err != nil {
+		return nil
+	}
 
-// RevokeCIToken removes the given token from the CI token store.
-func RevokeCIToken(token string) {
-	ciTokensMu.Lock()
-	delete(ciTokens, token)
-	ciTokensMu.Unlock()
+	info := &CITokenInfo{
+		RepoID:      row.RepositoryID,
+		PipelineID:  row.PipelineID,
+		Permissions: make([]ci.TokenPermission, len(row.Permissions)),
+	}
+	for i, p := range row.Permissions {
+		info.Permissions[i] = ci.TokenPermission(p)
+	}
+	return info
 }
 
-// ScheduleCITokenRevocation schedules a token to be revoked after CITokenTTL.
-// This uses time.AfterFunc which is energy-efficient as it doesn't block or
-// consume CPU while waiting - it simply schedules a timer in the runtime.
-func ScheduleCITokenRevocation(token string) {
-	time.AfterFunc(CITokenTTL, func() {
-		RevokeCIToken(token)
-	})
+// RevokeCITokens revokes the CI tokens of a finished pipeline. Tokens of
+// configs with webhook_grace_period stay valid until they expire, so external
+// CI systems triggered by webhooks can still use them.
+func RevokeCITokens(ctx context.Context, pipelineID int64) {
+	if err := db.Q.RevokeCITokensForPipeline(ctx, pipelineID); err != nil {
+		fmt.Printf("CI execution error: pipeline_id=%d detail=revoke ci tokens: %v\n", pipelineID, err)
+	}
 }
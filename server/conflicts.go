@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/protos"
+)
+
+func (a *Server) GetConflicts(ctx context.Context, req *protos.GetConflictsRequest) (*protos.GetConflictsResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	if _, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId); err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	change, err := db.Q.GetChange(ctx, req.CheckedOutChangeId)
+	if err != nil {
+		return nil, fmt.Errorf("get change: %w", err)
+	}
+	if change.RepositoryID != req.RepoId {
+		return nil, fmt.Errorf("change %d does not belong to repository %d", req.CheckedOutChangeId, req.RepoId)
+	}
+
+	parents, err := db.Q.GetChangeParents(ctx, change.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get parents: %w", err)
+	}
+
+	// Conflicts are rendered with the attributes the merge used
+	var attributes *filecontents.Attributes
+	if len(parents) > 0 {
+		if attributes, err = GetChangeAttributes(ctx, db.Q, parents[0].ID); err != nil {
+			return nil, fmt.Errorf("get attributes: %w", err)
+		}
+	}
+
+	conflictFiles, err := db.Q.GetConflictFilesForChange(ctx, change.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get conflict files: %w", err)
+	}
+
+	sides, err := db.Q.GetConflictSidesForChange(ctx, change.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get conflict sides: %w", err)
+	}
+	sidesByName := make(map[string][]db.GetConflictSidesForChangeRow)
+	for _, side := range sides {
+		rows := sidesByName[side.Name]
+		for len(rows) <= int(side.Position) {
+			rows = append(rows, db.GetConflictSidesForChangeRow{})
+		}
+		rows[side.Position] = side
+		sidesByName[side.Name] = rows
+	}
+
+	resp := &protos.GetConflictsResponse{}
+	for _, f := range conflictFiles {
+		if req.Name != nil && f.Name != *req.Name {
+			continue
+		}
+
+		conflict := &protos.Conflict{
+			Name:       f.Name,
+			Binary:     f.IsBinary,
+			Structured: f.Structured,
+		}
+		if !f.Structured {
+			resp.Conflicts = append(resp.Conflicts, conflict)
+			continue
+		}
+
+		if conflict.Base, err = conflictSide("base", f.BaseHash, f.BaseSymlinkTarget, req.IncludeContents); err != nil {
+			return nil, fmt.Errorf("read base of %s: %w", f.Name, err)
+		}
+
+		parentSides := sidesByName[f.Name]
+		for i, row := range parentSides {
+			label := fmt.Sprintf("parent %d", i+1)
+			if i < len(parents) {
+				label = parents[i].Name
+			}
+			side, err := conflictSide(label, row.ContentHash, row.SymlinkTarget, req.IncludeContents)
+			if err != nil {
+				return nil, fmt.Errorf("read %s of %s: %w", label, f.Name, err)
+			}
+			if side == nil {
+				conflict.Parents = append(conflict.Parents, &protos.ConflictSide{Label: label, Deleted: true})
+				continue
+			}
+			conflict.Parents = append(conflict.Parents, side)
+			if i == 0 {
+				conflict.Ours = side
+			}
+			if i == len(parentSides)-1 {
+				conflict.Theirs = side
+			}
+		}
+
+		switch {
+		case !req.IncludeContents || f.IsBinary:
+		case len(parentSides) != 2:
+			// The parents were merged one after another against different
+			// bases, so there is no single three-way merge to render
+			conflict.UnmaterializedReason = fmt.Sprintf("conflict between %d parents can only be shown with the markers in the file, pick a parent with pogo resolve --parent", len(parentSides))
+		default:
+			labels := filecontents.ConflictLabels{Base: "base", Ours: conflict.Parents[0].Label, Theirs: conflict.Parents[1].Label}
+			materialized, err := a.materializeConflict(f.BaseHash, parentSides[0].ContentHash, parentSides[1].ContentHash, labels, attributes.Lookup(f.Name), filecontents.ConflictStyle(req.Style))
+			if err != nil {
+				return nil, fmt.Errorf("materialize conflict %s: %w", f.Name, err)
+			}
+			conflict.Materialized = materialized
+		}
+
+		resp.Conflicts = append(resp.Conflicts, conflict)
+	}
+
+	return resp, nil
+}
+
+func (a *Server) MarkConflictsResolved(ctx context.Context, req *protos.MarkConflictsResolvedRequest) (*protos.MarkConflictsResolvedResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	if _, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId); err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	change, err := db.Q.GetChange(ctx, req.CheckedOutChangeId)
+	if err != nil {
+		return nil, fmt.Errorf("get change: %w", err)
+	}
+	if change.RepositoryID != req.RepoId {
+		return nil, fmt.Errorf("change %d does not belong to repository %d", req.CheckedOutChangeId, req.RepoId)
+	}
+
+	tx, err := db.Q.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open db transaction: %w", err)
+	}
+	defer tx.Close()
+
+	for _, name := range req.Names {
+		if err := tx.DeleteConflict(ctx, change.ID, name); err != nil {
+			return nil, fmt.Errorf("delete conflict %s: %w", name, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return &protos.MarkConflictsResolvedResponse{}, nil
+}
+
+func conflictSide(label string, contentHash []byte, symlinkTarget *string, includeContent bool) (*protos.ConflictSide, error) {
+	if contentHash == nil {
+		return nil, nil
+	}
+
+	side := &protos.ConflictSide{
+		Label:         label,
+		ContentHash:   contentHash,
+		SymlinkTarget: symlinkTarget,
+	}
+	// Symlinks have no content in the file store
+	if !includeContent || symlinkTarget != nil {
+		return side, nil
+	}
+
+	f, err := filecontents.OpenFileByHash(base64.URLEncoding.EncodeToString(contentHash))
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	if side.Content, err = io.ReadAll(f); err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return side, nil
+}
+
+// materializeConflict renders a stored text conflict in the given style
+func (a *Server) materializeConflict(baseHash, oursHash, theirsHash []byte, labels filecontents.ConflictLabels, attrs filecontents.FileAttributes, style filecontents.ConflictStyle) ([]byte, error) {
+	oReader, oType, err := a.getFileReader(baseHash, baseHash != nil, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("open base: %w", err)
+	}
+	defer a.closeReader(oReader)
+
+	aReader, aType, err := a.getFileReader(oursHash, oursHash != nil, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("open ours: %w", err)
+	}
+	defer a.closeReader(aReader)
+
+	bReader, bType, err := a.getFileReader(theirsHash, theirsHash != nil, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("open theirs: %w", err)
+	}
+	defer a.closeReader(bReader)
+
+	result, _, err := filecontents.MaterializeConflict(oReader, aReader, bReader, labels, style)
+	if err != nil {
+		return nil, err
+	}
+
+	mType := attrs.ApplyTo(filecontents.ThreeWayMergeResultType(aType, oType, bType))
+	return io.ReadAll(mType.TypeReader(result))
+}
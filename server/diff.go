@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -38,7 +39,11 @@ type FileDiff struct {
 }
 
 func collectFileStates(ctx context.Context, changeId int64) (map[string]FileState, error) {
-	files, err := db.Q.GetFilesForChange(ctx, changeId)
+	return fileStatesForChange(ctx, db.Q, changeId)
+}
+
+func fileStatesForChange(ctx context.Context, q *db.Queries, changeId int64) (map[string]FileState, error) {
+	files, err := q.GetFilesForChange(ctx, changeId)
 	if err != nil {
 		return nil, fmt.Errorf("get files for change: %w", err)
 	}
@@ -108,8 +113,21 @@ func determineFileOperations(oldFiles, newFiles map[string]FileState) []FileDiff
 	return diffs
 }
 
-func readFileContentAsString(hash []byte) (string, error) {
+func readFileContentAsString(hash []byte, attrs filecontents.FileAttributes) (string, error) {
 	hashStr := base64.URLEncoding.EncodeToString(hash)
+	if attrs.Encoding != filecontents.UnknownEncoding || attrs.LineEnding != "" {
+		f, fileType, err := filecontents.OpenFileByHashWithType(hashStr)
+		if err != nil {
+			return "", fmt.Errorf("open file by hash: %w", err)
+		}
+		defer f.Close()
+		content, err := io.ReadAll(diffReadType(fileType, attrs).CanonicalizeReader(f))
+		if err != nil {
+			return "", fmt.Errorf("read file: %w", err)
+		}
+		return string(content), nil
+	}
+
 	f, err := filecontents.OpenFileByHash(hashStr)
 	if err != nil {
 		return "", fmt.Errorf("open file by hash: %w", err)
@@ -133,7 +151,10 @@ func readFileContentAsString(hash []byte) (string, error) {
 	return string(content), nil
 }
 
-func isBinaryFile(hash []byte) (bool, error) {
+func isBinaryFile(hash []byte, attrs filecontents.FileAttributes) (bool, error) {
+	if attrs.Text != nil {
+		return !*attrs.Text, nil
+	}
 	hashStr := base64.URLEncoding.EncodeToString(hash)
 	f, fileType, err := filecontents.OpenFileByHashWithType(hashStr)
 	if err != nil {
@@ -143,6 +164,45 @@ func isBinaryFile(hash []byte) (bool, error) {
 	return fileType.Binary, nil
 }
 
+// diffReadType is the type used to read text for a diff. A configured line
+// ending normalizes every CRLF, so line ending changes don't show up.
+func diffReadType(detected filecontents.FileType, attrs filecontents.FileAttributes) filecontents.FileType {
+	t := attrs.ApplyTo(detected)
+	if attrs.LineEnding != "" {
+		t.LineEnding = filecontents.CRLF
+	}
+	return t
+}
+
+// canonicalizeLocalContent applies the configured encoding and line endings to
+// content uploaded from the working copy.
+func canonicalizeLocalContent(content string, attrs filecontents.FileAttributes) (string, error) {
+	if attrs.Encoding == filecontents.UnknownEncoding && attrs.LineEnding == "" {
+		return content, nil
+	}
+	b, err := io.ReadAll(diffReadType(filecontents.FileType{}, attrs).CanonicalizeReader(strings.NewReader(content)))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func diffFileStatus(op FileOperation) protos.DiffFileStatus {
+	switch op {
+	case FileAdded:
+		return protos.DiffFileStatus_DIFF_FILE_STATUS_ADDED
+	case FileDeleted:
+		return protos.DiffFileStatus_DIFF_FILE_STATUS_DELETED
+	default:
+		return protos.DiffFileStatus_DIFF_FILE_STATUS_MODIFIED
+	}
+}
+
+var suppressedDiffBlocks = []*protos.DiffBlock{{
+	Type:  protos.DiffBlockType_DIFF_BLOCK_TYPE_METADATA,
+	Lines: []string{"Diff suppressed by " + filecontents.AttributesFileName},
+}}
+
 func getFileSize(hash []byte) (int64, error) {
 	hashStr := base64.URLEncoding.EncodeToString(hash)
 	filePath := filecontents.GetFilePathFromHash(hashStr)
@@ -214,6 +274,10 @@ func (s *Server) Diff(req *protos.DiffRequest, stream protos.Pogo_DiffServer) er
 		return fmt.Errorf("check repository access: %w", err)
 	}
 
+	if req.Remerge != nil && *req.Remerge {
+		return s.remergeDiff(ctx, req, stream)
+	}
+
 	var change1Id, change2Id int64
 	var change1Name, change2Name string
 
@@ -250,7 +314,7 @@ func (s *Server) Diff(req *protos.DiffRequest, stream protos.Pogo_DiffServer) er
 			return fmt.Errorf("current change has no parents")
 		}
 		if len(parents) > 1 {
-			return fmt.Errorf("current change has multiple parents, please specify which one to diff against")
+			return fmt.Errorf("current change has multiple parents, please specify which one to diff against or use remerge")
 		}
 		change1Id = parents[0].ID
 		change2Id = *req.CheckedOutChangeId
@@ -280,6 +344,11 @@ func (s *Server) Diff(req *protos.DiffRequest, stream protos.Pogo_DiffServer) er
 
 	fileDiffs := determineFileOperations(oldFiles, newFiles)
 
+	attributes, err := GetChangeAttributes(ctx, db.Q, change2Id)
+	if err != nil {
+		return fmt.Errorf("get attributes: %w", err)
+	}
+
 	usePatience := false
 	if req.UsePatience != nil {
 		usePatience = *req.UsePatience
@@ -290,7 +359,60 @@ func (s *Server) Diff(req *protos.DiffRequest, stream protos.Pogo_DiffServer) er
 	}
 
 	for _, fileDiff := range fileDiffs {
-		if err := s.streamFileDiff(stream, fileDiff, change1Name, change2Name, usePatience, includeLargeFiles); err != nil {
+		if err := s.streamFileDiff(stream, fileDiff, change1Name, change2Name, attributes, usePatience, includeLargeFiles); err != nil {
+			return fmt.Errorf("stream file diff for %s: %w", fileDiff.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// remergeDiff re-runs the three-way merge of a merge change's parents and
+// diffs the automatic merge result against the stored change. The output only
+// contains what was edited by hand while resolving the merge.
+func (s *Server) remergeDiff(ctx context.Context, req *protos.DiffRequest, stream protos.Pogo_DiffServer) error {
+	if req.Rev2 != nil {
+		return fmt.Errorf("remerge diff accepts at most one revision")
+	}
+
+	var changeId int64
+	if req.Rev1 != nil {
+		var err error
+		changeId, err = db.Q.FindChangeByNameFuzzyUnique(ctx, req.RepoId, *req.Rev1)
+		if err != nil {
+			return fmt.Errorf("resolve rev1 %q: %w", *req.Rev1, err)
+		}
+	} else if req.CheckedOutChangeId != nil {
+		changeId = *req.CheckedOutChangeId
+	} else {
+		return fmt.Errorf("current change id is required when no revision is provided")
+	}
+
+	change, err := db.Q.GetChange(ctx, changeId)
+	if err != nil {
+		return fmt.Errorf("get change: %w", err)
+	}
+
+	remergedFiles, err := s.remergeFileStates(ctx, changeId)
+	if err != nil {
+		return fmt.Errorf("remerge %s: %w", change.Name, err)
+	}
+
+	storedFiles, err := collectFileStates(ctx, changeId)
+	if err != nil {
+		return fmt.Errorf("collect stored file states: %w", err)
+	}
+
+	attributes, err := GetChangeAttributes(ctx, db.Q, changeId)
+	if err != nil {
+		return fmt.Errorf("get attributes: %w", err)
+	}
+
+	usePatience := req.UsePatience != nil && *req.UsePatience
+	includeLargeFiles := req.IncludeLargeFiles != nil && *req.IncludeLargeFiles
+
+	for _, fileDiff := range determineFileOperations(remergedFiles, storedFiles) {
+		if err := s.streamFileDiff(stream, fileDiff, "remerge", change.Name, attributes, usePatience, includeLargeFiles); err != nil {
 			return fmt.Errorf("stream file diff for %s: %w", fileDiff.Path, err)
 		}
 	}
@@ -298,7 +420,38 @@ func (s *Server) Diff(req *protos.DiffRequest, stream protos.Pogo_DiffServer) er
 	return nil
 }
 
-func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff, oldChangeName, newChangeName string, usePatience, includeLargeFiles bool) error {
+// remergeFileStates merges the parents of a merge change again and returns the
+// resulting files without storing them in a change. Merged text content is
+// written to the object store so it can be diffed like any other file, GC
+// removes it again if nothing references it.
+func (s *Server) remergeFileStates(ctx context.Context, changeId int64) (map[string]FileState, error) {
+	sink := make(fileStateMergeSink)
+	if err := s.remergeChange(ctx, db.Q, changeId, sink, nil); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// remergeChange merges the parents of a merge change again and passes the result to sink.
+// If include is not nil, only the files it accepts are merged.
+func (s *Server) remergeChange(ctx context.Context, q *db.Queries, changeId int64, sink mergeSink, include func(name string) bool) error {
+	parents, err := q.GetChangeParents(ctx, changeId)
+	if err != nil {
+		return fmt.Errorf("get parents: %w", err)
+	}
+	if len(parents) < 2 {
+		return fmt.Errorf("change has %d parents, remerge requires a merge change", len(parents))
+	}
+
+	parentChangeIds := make([]int64, len(parents))
+	for i, p := range parents {
+		parentChangeIds[i] = p.ID
+	}
+
+	return s.mergeParents(ctx, q, parentChangeIds, sink, include)
+}
+
+func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff, oldChangeName, newChangeName string, attributes *filecontents.Attributes, usePatience, includeLargeFiles bool) error {
 	var oldHash, newHash string
 	var oldLineCount, newLineCount int32
 
@@ -323,8 +476,14 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 	oldIsSymlink := fileDiff.OldState != nil && fileDiff.OldState.SymlinkTarget != nil
 	newIsSymlink := fileDiff.NewState != nil && fileDiff.NewState.SymlinkTarget != nil
 
-	switch fileDiff.Operation {
-	case FileAdded:
+	attrs := attributes.Lookup(fileDiff.Path)
+
+	switch {
+	case attrs.NoDiff:
+		status = diffFileStatus(fileDiff.Operation)
+		blocks = suppressedDiffBlocks
+
+	case fileDiff.Operation == FileAdded:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_ADDED
 
 		if newIsSymlink {
@@ -346,7 +505,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 					Lines: []string{fmt.Sprintf("File too large (%d bytes). Use --include-large-files to show diff.", size)},
 				}}
 			} else {
-				isBinary, err := isBinaryFile(fileDiff.NewState.ContentHash)
+				isBinary, err := isBinaryFile(fileDiff.NewState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("check if binary: %w", err)
 				}
@@ -358,7 +517,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 						Lines: []string{"Binary file"},
 					}}
 				} else {
-					content, err := readFileContentAsString(fileDiff.NewState.ContentHash)
+					content, err := readFileContentAsString(fileDiff.NewState.ContentHash, attrs)
 					if err != nil {
 						return fmt.Errorf("read new file content: %w", err)
 					}
@@ -374,7 +533,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 			}
 		}
 
-	case FileDeleted:
+	case fileDiff.Operation == FileDeleted:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_DELETED
 
 		if oldIsSymlink {
@@ -396,7 +555,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 					Lines: []string{fmt.Sprintf("File too large (%d bytes). Use --include-large-files to show diff.", size)},
 				}}
 			} else {
-				isBinary, err := isBinaryFile(fileDiff.OldState.ContentHash)
+				isBinary, err := isBinaryFile(fileDiff.OldState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("check if binary: %w", err)
 				}
@@ -408,7 +567,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 						Lines: []string{"Binary file"},
 					}}
 				} else {
-					content, err := readFileContentAsString(fileDiff.OldState.ContentHash)
+					content, err := readFileContentAsString(fileDiff.OldState.ContentHash, attrs)
 					if err != nil {
 						return fmt.Errorf("read old file content: %w", err)
 					}
@@ -424,7 +583,7 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 			}
 		}
 
-	case FileModified:
+	case fileDiff.Operation == FileModified:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_MODIFIED
 
 		// Handle symlink type changes
@@ -484,11 +643,11 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 					Lines: []string{fmt.Sprintf("File too large (%d bytes). Use --include-large-files to show diff.", maxSize)},
 				}}
 			} else {
-				isBinary1, err := isBinaryFile(fileDiff.OldState.ContentHash)
+				isBinary1, err := isBinaryFile(fileDiff.OldState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("check if old file is binary: %w", err)
 				}
-				isBinary2, err := isBinaryFile(fileDiff.NewState.ContentHash)
+				isBinary2, err := isBinaryFile(fileDiff.NewState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("check if new file is binary: %w", err)
 				}
@@ -500,11 +659,11 @@ func (s *Server) streamFileDiff(stream protos.Pogo_DiffServer, fileDiff FileDiff
 						Lines: []string{"Binary files differ"},
 					}}
 				} else {
-					oldContent, err := readFileContentAsString(fileDiff.OldState.ContentHash)
+					oldContent, err := readFileContentAsString(fileDiff.OldState.ContentHash, attrs)
 					if err != nil {
 						return fmt.Errorf("read old file content: %w", err)
 					}
-					newContent, err := readFileContentAsString(fileDiff.NewState.ContentHash)
+					newContent, err := readFileContentAsString(fileDiff.NewState.ContentHash, attrs)
 					if err != nil {
 						return fmt.Errorf("read new file content: %w", err)
 					}
@@ -676,6 +835,11 @@ MetadataComplete:
 		return fmt.Errorf("get change: %w", err)
 	}
 
+	attributes, err := GetChangeAttributes(ctx, db.Q, changeId)
+	if err != nil {
+		return fmt.Errorf("get attributes: %w", err)
+	}
+
 	allPaths := make(map[string]bool)
 	for path := range localFiles {
 		allPaths[path] = true
@@ -775,7 +939,7 @@ MetadataComplete:
 			continue
 		}
 
-		if err := s.streamFileDiffLocal(stream, fileDiff, change.Name, localFileContents, usePatience, includeLargeFiles); err != nil {
+		if err := s.streamFileDiffLocal(stream, fileDiff, change.Name, localFileContents, attributes, usePatience, includeLargeFiles); err != nil {
 			return fmt.Errorf("stream file diff local for %s: %w", path, err)
 		}
 	}
@@ -783,7 +947,7 @@ MetadataComplete:
 	return nil
 }
 
-func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDiff FileDiff, oldChangeName string, localContents map[string]string, usePatience, includeLargeFiles bool) error {
+func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDiff FileDiff, oldChangeName string, localContents map[string]string, attributes *filecontents.Attributes, usePatience, includeLargeFiles bool) error {
 	var oldHash, newHash string
 	var oldLineCount, newLineCount int32
 
@@ -804,8 +968,14 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 	var status protos.DiffFileStatus
 	var blocks []*protos.DiffBlock
 
-	switch fileDiff.Operation {
-	case FileAdded:
+	attrs := attributes.Lookup(fileDiff.Path)
+
+	switch {
+	case attrs.NoDiff:
+		status = diffFileStatus(fileDiff.Operation)
+		blocks = suppressedDiffBlocks
+
+	case fileDiff.Operation == FileAdded:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_ADDED
 
 		content := localContents[fileDiff.Path]
@@ -818,10 +988,14 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 			}}
 		} else {
 			isBinary := false
-			for i := 0; i < len(content) && i < 8192; i++ {
-				if content[i] == 0 {
-					isBinary = true
-					break
+			if attrs.Text != nil {
+				isBinary = !*attrs.Text
+			} else {
+				for i := 0; i < len(content) && i < 8192; i++ {
+					if content[i] == 0 {
+						isBinary = true
+						break
+					}
 				}
 			}
 
@@ -832,6 +1006,11 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 					Lines: []string{"Binary file"},
 				}}
 			} else {
+				content, err := canonicalizeLocalContent(content, attrs)
+				if err != nil {
+					return fmt.Errorf("read new file content: %w", err)
+				}
+
 				lines := strings.Split(content, "\n")
 				newLineCount = int32(len(lines))
 
@@ -842,7 +1021,7 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 			}
 		}
 
-	case FileDeleted:
+	case fileDiff.Operation == FileDeleted:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_DELETED
 
 		size, err := getFileSize(fileDiff.OldState.ContentHash)
@@ -856,7 +1035,7 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 				Lines: []string{fmt.Sprintf("File too large (%d bytes). Use --include-large-files to show diff.", size)},
 			}}
 		} else {
-			isBinary, err := isBinaryFile(fileDiff.OldState.ContentHash)
+			isBinary, err := isBinaryFile(fileDiff.OldState.ContentHash, attrs)
 			if err != nil {
 				return fmt.Errorf("check if binary: %w", err)
 			}
@@ -868,7 +1047,7 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 					Lines: []string{"Binary file"},
 				}}
 			} else {
-				content, err := readFileContentAsString(fileDiff.OldState.ContentHash)
+				content, err := readFileContentAsString(fileDiff.OldState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("read old file content: %w", err)
 				}
@@ -883,7 +1062,7 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 			}
 		}
 
-	case FileModified:
+	case fileDiff.Operation == FileModified:
 		status = protos.DiffFileStatus_DIFF_FILE_STATUS_MODIFIED
 
 		newContent := localContents[fileDiff.Path]
@@ -905,16 +1084,20 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 				Lines: []string{fmt.Sprintf("File too large (%d bytes). Use --include-large-files to show diff.", maxSize)},
 			}}
 		} else {
-			isBinary1, err := isBinaryFile(fileDiff.OldState.ContentHash)
+			isBinary1, err := isBinaryFile(fileDiff.OldState.ContentHash, attrs)
 			if err != nil {
 				return fmt.Errorf("check if old file is binary: %w", err)
 			}
 
 			isBinary2 := false
-			for i := 0; i < len(newContent) && i < 8192; i++ {
-				if newContent[i] == 0 {
-					isBinary2 = true
-					break
+			if attrs.Text != nil {
+				isBinary2 = !*attrs.Text
+			} else {
+				for i := 0; i < len(newContent) && i < 8192; i++ {
+					if newContent[i] == 0 {
+						isBinary2 = true
+						break
+					}
 				}
 			}
 
@@ -925,10 +1108,14 @@ func (s *Server) streamFileDiffLocal(stream protos.Pogo_DiffLocalServer, fileDif
 					Lines: []string{"Binary files differ"},
 				}}
 			} else {
-				oldContent, err := readFileContentAsString(fileDiff.OldState.ContentHash)
+				oldContent, err := readFileContentAsString(fileDiff.OldState.ContentHash, attrs)
 				if err != nil {
 					return fmt.Errorf("read old file content: %w", err)
 				}
+				newContent, err := canonicalizeLocalContent(newContent, attrs)
+				if err != nil {
+					return fmt.Errorf("read new file content: %w", err)
+				}
 
 				oldLines := strings.Split(oldContent, "\n")
 				newLines := strings.Split(newContent, "\n")
@@ -4,8 +4,15 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pogo-vcs/pogo/secrets"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/policy"
 )
 
 var (
@@ -16,6 +23,18 @@ var (
 	ListenAddress     string
 	GcMemoryThreshold int64
 	CiRunRetention    time.Duration
+	CiMaxParallelJobs int
+	CiWorkers         int
+	// CiContainerLimits are the defaults and maximums of container CI tasks
+	CiContainerLimits ci.Limits
+	// CiProcessConfig controls CI tasks running as processes on the host
+	CiProcessConfig ci.ProcessConfig
+	// SecretsKey encrypts the secrets of repositories
+	SecretsKey []byte
+	// SecretsKeyFile SecretsKey was read from, empty if it was set directly
+	SecretsKeyFile string
+	// Policy checks pushes, new changes and descriptions, nil if not configured
+	Policy *policy.Config
 )
 
 type Config struct {
@@ -26,6 +45,13 @@ type Config struct {
 	ListenAddress     string
 	GcMemoryThreshold int64
 	CiRunRetention    time.Duration
+	CiMaxParallelJobs int
+	CiWorkers         int
+	CiContainerLimits ci.Limits
+	CiProcessConfig   ci.ProcessConfig
+	SecretsKey        []byte
+	SecretsKeyFile    string
+	Policy            *policy.Config
 }
 
 func InitFromEnvironment() error {
@@ -72,7 +98,169 @@ func InitFromEnvironment() error {
 		}
 		CiRunRetention = duration
 	}
+	CiMaxParallelJobs = runtime.NumCPU()
+	if maxJobsStr, ok := os.LookupEnv("CI_MAX_PARALLEL_JOBS"); ok {
+		maxJobs, err := strconv.Atoi(maxJobsStr)
+		if err != nil {
+			return fmt.Errorf("invalid CI_MAX_PARALLEL_JOBS: %w", err)
+		}
+		if maxJobs <= 0 {
+			return fmt.Errorf("CI_MAX_PARALLEL_JOBS must be positive, got %d", maxJobs)
+		}
+		CiMaxParallelJobs = maxJobs
+	}
+	CiWorkers = runtime.NumCPU()
+	if workersStr, ok := os.LookupEnv("CI_WORKERS"); ok {
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil {
+			return fmt.Errorf("invalid CI_WORKERS: %w", err)
+		}
+		if workers < 0 {
+			return fmt.Errorf("CI_WORKERS must not be negative, got %d", workers)
+		}
+		CiWorkers = workers
+	}
+	CiContainerLimits = defaultCiContainerLimits()
+	if err := lookupDuration("CI_DEFAULT_TIMEOUT", &CiContainerLimits.DefaultTimeout); err != nil {
+		return err
+	}
+	if err := lookupDuration("CI_MAX_TIMEOUT", &CiContainerLimits.MaxTimeout); err != nil {
+		return err
+	}
+	if err := lookupCPU("CI_DEFAULT_CPU", &CiContainerLimits.DefaultCPU); err != nil {
+		return err
+	}
+	if err := lookupCPU("CI_MAX_CPU", &CiContainerLimits.MaxCPU); err != nil {
+		return err
+	}
+	if err := lookupMemory("CI_DEFAULT_MEMORY", &CiContainerLimits.DefaultMemory); err != nil {
+		return err
+	}
+	if err := lookupMemory("CI_MAX_MEMORY", &CiContainerLimits.MaxMemory); err != nil {
+		return err
+	}
+	if pidsStr, ok := os.LookupEnv("CI_PIDS_LIMIT"); ok {
+		pids, err := strconv.ParseInt(pidsStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid CI_PIDS_LIMIT: %w", err)
+		}
+		if pids < 0 {
+			return fmt.Errorf("CI_PIDS_LIMIT must not be negative, got %d", pids)
+		}
+		CiContainerLimits.PidsLimit = pids
+	}
+	CiProcessConfig = ci.ProcessConfig{Mode: ci.ProcessModeDeny}
+	if modeStr, ok := os.LookupEnv("CI_PROCESS_TASKS"); ok {
+		mode, err := ci.ParseProcessMode(modeStr)
+		if err != nil {
+			return fmt.Errorf("invalid CI_PROCESS_TASKS: %w", err)
+		}
+		CiProcessConfig.Mode = mode
+	}
+	if allowlistStr, ok := os.LookupEnv("CI_PROCESS_ENV_ALLOWLIST"); ok {
+		CiProcessConfig.EnvAllowlist = []string{}
+		for _, key := range strings.Split(allowlistStr, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				CiProcessConfig.EnvAllowlist = append(CiProcessConfig.EnvAllowlist, key)
+			}
+		}
+	}
+	if keyStr, ok := os.LookupEnv("SECRETS_KEY"); ok {
+		key, err := secrets.DecodeKey(keyStr)
+		if err != nil {
+			return fmt.Errorf("invalid SECRETS_KEY: %w", err)
+		}
+		SecretsKey = key
+		SecretsKeyFile = ""
+		// Nothing started by the server, like hooks or CI processes, may read it
+		if err := os.Unsetenv("SECRETS_KEY"); err != nil {
+			return fmt.Errorf("unset SECRETS_KEY: %w", err)
+		}
+	} else {
+		if SecretsKeyFile, ok = os.LookupEnv("SECRETS_KEY_FILE"); !ok {
+			SecretsKeyFile = defaultSecretsKeyFile
+		}
+		key, err := secrets.LoadOrCreateKeyFile(SecretsKeyFile)
+		if err != nil {
+			return fmt.Errorf("load SECRETS_KEY_FILE: %w", err)
+		}
+		SecretsKey = key
+	}
+	if policyFile, ok := os.LookupEnv("POLICY_FILE"); ok && policyFile != "" {
+		config, err := policy.Load(policyFile)
+		if err != nil {
+			return fmt.Errorf("invalid POLICY_FILE: %w", err)
+		}
+		Policy = config
+	}
+
+	return nil
+}
+
+// defaultSecretsKeyFile is generated on the first start if neither
+// SECRETS_KEY nor SECRETS_KEY_FILE is set.
+var defaultSecretsKeyFile = filepath.Join("data", "secrets.key")
+
+// defaultCiContainerLimits only limits the run time of container CI tasks.
+func defaultCiContainerLimits() ci.Limits {
+	return ci.Limits{
+		DefaultTimeout: time.Hour,
+		MaxTimeout:     6 * time.Hour,
+	}
+}
+
+// lookupDuration sets d to the duration in the environment variable key, if
+// set. A value of 0 removes the limit.
+func lookupDuration(key string, d *time.Duration) error {
+	str, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if duration < 0 {
+		return fmt.Errorf("%s must not be negative, got %s", key, str)
+	}
+	*d = duration
+	return nil
+}
+
+// lookupCPU sets cpu to the number of CPUs in the environment variable key,
+// if set. A value of 0 removes the limit.
+func lookupCPU(key string, cpu *float64) error {
+	str, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if value < 0 {
+		return fmt.Errorf("%s must not be negative, got %s", key, str)
+	}
+	*cpu = value
+	return nil
+}
 
+// lookupMemory sets memory to the size in the environment variable key, like
+// 2g, if set. A value of 0 removes the limit.
+func lookupMemory(key string, memory *int64) error {
+	str, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	if str == "0" {
+		*memory = 0
+		return nil
+	}
+	value, err := ci.ParseMemory(str)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*memory = value
 	return nil
 }
 
@@ -87,6 +275,41 @@ func InitFromConfig(config Config) error {
 	} else {
 		CiRunRetention = 30 * 24 * time.Hour
 	}
+	if config.CiMaxParallelJobs > 0 {
+		CiMaxParallelJobs = config.CiMaxParallelJobs
+	} else {
+		CiMaxParallelJobs = runtime.NumCPU()
+	}
+	if config.CiWorkers > 0 {
+		CiWorkers = config.CiWorkers
+	} else {
+		CiWorkers = runtime.NumCPU()
+	}
+	if config.CiContainerLimits != (ci.Limits{}) {
+		CiContainerLimits = config.CiContainerLimits
+	} else {
+		CiContainerLimits = defaultCiContainerLimits()
+	}
+	CiProcessConfig = config.CiProcessConfig
+	if CiProcessConfig.Mode == "" {
+		CiProcessConfig.Mode = ci.ProcessModeDeny
+	}
+
+	SecretsKey = config.SecretsKey
+	SecretsKeyFile = config.SecretsKeyFile
+	if SecretsKey == nil {
+		var err error
+		if SecretsKeyFile != "" {
+			SecretsKey, err = secrets.LoadOrCreateKeyFile(SecretsKeyFile)
+		} else {
+			SecretsKey, err = secrets.GenerateKey()
+		}
+		if err != nil {
+			return fmt.Errorf("load secrets key: %w", err)
+		}
+	}
+
+	Policy = config.Policy
 
 	if config.Hostname != "" {
 		Hostname = config.Hostname
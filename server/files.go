@@ -6,7 +6,9 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
@@ -78,3 +80,38 @@ func GetRevisionIgnoreMatcher(ctx context.Context, params GetRevisionIgnoreMatch
 
 	return gitignore.NewMatcher(patterns), nil
 }
+
+// GetChangeAttributes reads all attributes files of a change. Files in deeper
+// directories are applied last so they override their parents.
+func GetChangeAttributes(ctx context.Context, q *db.Queries, changeId int64) (*filecontents.Attributes, error) {
+	attributesFiles, err := q.GetAttributesFilesForChangeId(ctx, changeId)
+	if err != nil {
+		return nil, errors.Join(errors.New("get attributes files"), err)
+	}
+	slices.SortStableFunc(attributesFiles, func(a, b db.File) int {
+		return strings.Count(a.Name, "/") - strings.Count(b.Name, "/")
+	})
+
+	attributes := &filecontents.Attributes{}
+	for _, attributesFile := range attributesFiles {
+		var domain []string
+		if relDir := path.Dir(attributesFile.Name); relDir != "." {
+			domain = strings.Split(relDir, "/")
+		}
+
+		hashStr := base64.URLEncoding.EncodeToString(attributesFile.ContentHash)
+		f, err := filecontents.OpenFileByHash(hashStr)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("open attributes file %s", attributesFile.Name), err)
+		}
+		err = attributes.Parse(f, domain)
+		f.Close()
+		if errors.Is(err, filecontents.ErrInvalidAttribute) {
+			fmt.Printf("warning: skipping invalid lines of attributes file %s of change %d: %v\n", attributesFile.Name, changeId, strings.ReplaceAll(err.Error(), "\n", "; "))
+		} else if err != nil {
+			return nil, errors.Join(fmt.Errorf("parse attributes file %s", attributesFile.Name), err)
+		}
+	}
+
+	return attributes, nil
+}
@@ -41,6 +41,9 @@ func (s *Server) GarbageCollect(ctx context.Context, req *protos.GarbageCollectR
 
 // runGarbageCollectionInternal contains the actual GC logic
 func runGarbageCollectionInternal(ctx context.Context) (*protos.GarbageCollectResponse, error) {
+	if err := db.Q.DeleteExpiredCITokens(ctx); err != nil {
+		fmt.Printf("GC: failed to delete expired CI tokens: %v\n", err)
+	}
 	if env.CiRunRetention > 0 {
 		cutoff := time.Now().Add(-env.CiRunRetention).UTC()
 		cutoffTS := pgtype.Timestamptz{
@@ -53,6 +56,16 @@ func runGarbageCollectionInternal(ctx context.Context) (*protos.GarbageCollectRe
 		} else if deletedRuns > 0 {
 			fmt.Printf("GC: deleted %d CI runs older than %s\n", deletedRuns, env.CiRunRetention)
 		}
+		if err := db.Q.DeleteExpiredCIPipelines(ctx, cutoffTS); err != nil {
+			fmt.Printf("GC: failed to delete expired CI pipelines: %v\n", err)
+		}
+		// Caches are kept as long as they are used
+		if err := db.Q.DeleteExpiredCICaches(ctx, cutoffTS); err != nil {
+			fmt.Printf("GC: failed to delete expired CI caches: %v\n", err)
+		}
+		if err := db.Q.DeleteExpiredWebhookDeliveries(ctx, cutoffTS); err != nil {
+			fmt.Printf("GC: failed to delete expired webhook deliveries: %v\n", err)
+		}
 	}
 
 	// Start a database transaction for cleanup
@@ -5,22 +5,27 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/devsisters/go-diff3"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/pogo-vcs/pogo/compressions"
 	"github.com/pogo-vcs/pogo/db"
 	"github.com/pogo-vcs/pogo/filecontents"
 	"github.com/pogo-vcs/pogo/protos"
 	"github.com/pogo-vcs/pogo/server/ci"
 	"github.com/pogo-vcs/pogo/server/env"
+	"github.com/pogo-vcs/pogo/server/policy"
+	"github.com/pogo-vcs/pogo/server/webhook"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 )
@@ -132,7 +137,12 @@ func (a *Server) DeleteRepository(ctx context.Context, req *protos.DeleteReposit
 }
 
 func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullRequest, protos.PushFullResponse]) error {
-	var previousFiles []db.GetChangeFilesRow
+	var (
+		previousFiles []db.GetChangeFilesRow
+		pushedChange  db.GetChangeRow
+		pushedBy      *int32
+		changed       []ci.ChangedPath
+	)
 
 	ctx, cancel := context.WithCancel(stream.Context())
 	defer cancel()
@@ -141,12 +151,6 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 		gcMutex.RLock()
 		defer gcMutex.RUnlock()
 
-		tx, err := db.Q.Begin(ctx)
-		if err != nil {
-			return fmt.Errorf("open db transaction: %w", err)
-		}
-		defer tx.Close()
-
 		// read auth
 		req, err := stream.Recv()
 		if err != nil {
@@ -178,38 +182,29 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 		}
 
 		// Get the repository ID from the change
-		change, err := tx.GetChange(ctx, changeId.ChangeId)
+		change, err := db.Q.GetChange(ctx, changeId.ChangeId)
 		if err != nil {
 			return fmt.Errorf("get change: %w", err)
 		}
+		pushedChange = change
 
 		// Check repository access
 		userId, err := checkRepositoryAccessFromAuth(ctx, auth.Auth, change.RepositoryID)
 		if err != nil {
 			return fmt.Errorf("check repository access: %w", err)
 		}
+		pushedBy = userId
 
 		// Check if change is readonly
 		var shouldRejectModifications bool
 		if !forceFlag.Force {
-			isReadonly, err := tx.IsReadonly(ctx, changeId.ChangeId, userId)
+			isReadonly, err := db.Q.IsReadonly(ctx, changeId.ChangeId, userId)
 			if err != nil {
 				return fmt.Errorf("check readonly: %w", err)
 			}
 			shouldRejectModifications = isReadonly
 		}
 
-		// Get files currently in the change before clearing them
-		// These are potential candidates for garbage collection
-		previousFiles, err = tx.GetChangeFiles(ctx, changeId.ChangeId)
-		if err != nil {
-			return fmt.Errorf("get current change files: %w", err)
-		}
-
-		if err := tx.ClearChangeFiles(ctx, changeId.ChangeId); err != nil {
-			return fmt.Errorf("clear change files: %w", err)
-		}
-
 		tempDir, err := os.MkdirTemp("", "pogo-*")
 		if err != nil {
 			return fmt.Errorf("create temp dir: %w", err)
@@ -280,6 +275,13 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 			}
 		}
 
+		// The policy checks the uploaded content in the temp dir, so a rejected
+		// push stores nothing. It runs before the transaction, so slow checks
+		// and hooks do not hold locks on the change
+		if err := checkPushPolicy(ctx, change, userId, tempDir, filesWithContent, fileMeta); err != nil {
+			return err
+		}
+
 		// Move new files to permanent store
 		moved, err := filecontents.MoveAllFiles(tempDir)
 		if err != nil {
@@ -298,6 +300,43 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 			}
 		}
 
+		hashes := make(map[string][]byte, len(fileMeta))
+		for relPath, header := range fileMeta {
+			if header.SymlinkTarget == nil && filesWithContent[relPath] {
+				// Use hash from newly stored file
+				hashes[relPath] = moved[relPath]
+			} else {
+				// File already exists or symlink, the client sends the hash
+				hashes[relPath] = header.ContentHash
+			}
+		}
+
+		tx, err := db.Q.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("open db transaction: %w", err)
+		}
+		defer tx.Close()
+
+		// Get files currently in the change before clearing them
+		// These are potential candidates for garbage collection
+		previousFiles, err = tx.GetChangeFiles(ctx, changeId.ChangeId)
+		if err != nil {
+			return fmt.Errorf("get current change files: %w", err)
+		}
+
+		if err := tx.ClearChangeFiles(ctx, changeId.ChangeId); err != nil {
+			return fmt.Errorf("clear change files: %w", err)
+		}
+
+		storedConflicts, err := tx.GetConflictsForChange(ctx, changeId.ChangeId)
+		if err != nil {
+			return fmt.Errorf("get conflicts for change: %w", err)
+		}
+		conflictsByName := make(map[string]db.Conflict, len(storedConflicts))
+		for _, c := range storedConflicts {
+			conflictsByName[c.Name] = c
+		}
+
 		// Process all file metadata
 		for relPath, header := range fileMeta {
 			exec := false
@@ -305,7 +344,7 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 				exec = *header.Executable
 			}
 
-			var hash []byte
+			hash := hashes[relPath]
 			var symlinkTarget *string
 
 			// Check if this is a symlink
@@ -326,31 +365,19 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 				}
 
 				symlinkTarget = &target
-				hash = header.ContentHash // Client sends hash of target path
-			} else {
-				// Regular file
-				if filesWithContent[relPath] {
-					// Use hash from newly stored file
-					hash = moved[relPath]
-				} else {
-					// File already exists, use hash from metadata
-					hash = header.ContentHash
-				}
 			}
 
-			// Check for conflicts (only for regular files)
+			// Binary and symlink conflicts have no markers, they stay conflicted until the content changes
 			hasConflicts := false
-			if symlinkTarget == nil {
-				hasConflicts = filecontents.IsBinaryConflictFileName(relPath)
-				if !hasConflicts {
-					hashStr := base64.URLEncoding.EncodeToString(hash)
-					filePath := filecontents.GetFilePathFromHash(hashStr)
-					if hasConflicts = filecontents.IsBinaryConflictFileName(relPath); !hasConflicts {
-						hasConflicts, err = filecontents.HasConflictMarkers(filePath)
-						if err != nil {
-							return fmt.Errorf("check conflict markers for file %s: %w", relPath, err)
-						}
-					}
+			if c, ok := conflictsByName[relPath]; ok && c.IsBinary && bytes.Equal(c.ResultHash, hash) {
+				hasConflicts = true
+			} else if symlinkTarget == nil {
+				// Check for conflict markers (only for regular files)
+				hashStr := base64.URLEncoding.EncodeToString(hash)
+				filePath := filecontents.GetFilePathFromHash(hashStr)
+				hasConflicts, err = filecontents.HasConflictMarkers(filePath)
+				if err != nil {
+					return fmt.Errorf("check conflict markers for file %s: %w", relPath, err)
 				}
 			}
 
@@ -359,7 +386,23 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 			}
 		}
 
-		if err := stream.SendAndClose(&protos.PushFullResponse{}); err != nil {
+		if err := tx.DeleteResolvedConflicts(ctx, changeId.ChangeId); err != nil {
+			return fmt.Errorf("delete resolved conflicts: %w", err)
+		}
+
+		currentFiles, err := tx.GetChangeFiles(ctx, changeId.ChangeId)
+		if err != nil {
+			return fmt.Errorf("get updated change files: %w", err)
+		}
+		changed = changedPaths(previousFiles, currentFiles)
+
+		if err := a.remergeChildConflicts(ctx, tx, changeId.ChangeId); err != nil {
+			return fmt.Errorf("remerge conflicts in children: %w", err)
+		}
+
+		ciProblems := lintCIConfigs(ctx, change.RepositoryID, currentFiles, ci.ChangedPathNames(changed))
+
+		if err := stream.SendAndClose(&protos.PushFullResponse{CiProblems: ciProblems}); err != nil {
 			return fmt.Errorf("send response: %w", err)
 		}
 
@@ -386,15 +429,66 @@ func (a *Server) PushFull(stream grpc.ClientStreamingServer[protos.PushFullReque
 		}
 	}()
 
+	if len(changed) > 0 {
+		enqueueCI(ctx, pushedChange.ID, ci.Event{Type: ci.EventTypeChangePush, Rev: pushedChange.Name, ChangedPaths: changed}, "", ciTrigger{userID: pushedBy})
+		notifyChangeEvent(ctx, webhook.EventChangePushed, pushedChange.ID, pushedBy, changed)
+	}
+
 	return nil
 }
 
+// changedPaths returns the files that differ between two file sets, sorted by
+// name. File rows are unique by name, executable flag and content hash.
+func changedPaths(a, b []db.GetChangeFilesRow) []ci.ChangedPath {
+	idsA := make(map[int64]struct{}, len(a))
+	namesA := make(map[string]struct{}, len(a))
+	for _, f := range a {
+		idsA[f.ID] = struct{}{}
+		namesA[f.Name] = struct{}{}
+	}
+	idsB := make(map[int64]struct{}, len(b))
+	namesB := make(map[string]struct{}, len(b))
+	for _, f := range b {
+		idsB[f.ID] = struct{}{}
+		namesB[f.Name] = struct{}{}
+	}
+
+	operations := make(map[string]ci.FileOperation)
+	for _, f := range a {
+		if _, ok := idsB[f.ID]; !ok {
+			if _, ok := namesB[f.Name]; ok {
+				operations[f.Name] = ci.FileModified
+			} else {
+				operations[f.Name] = ci.FileRemoved
+			}
+		}
+	}
+	for _, f := range b {
+		if _, ok := idsA[f.ID]; !ok {
+			if _, ok := namesA[f.Name]; ok {
+				operations[f.Name] = ci.FileModified
+			} else {
+				operations[f.Name] = ci.FileAdded
+			}
+		}
+	}
+
+	changed := make([]ci.ChangedPath, 0, len(operations))
+	for name, operation := range operations {
+		changed = append(changed, ci.ChangedPath{Path: name, Operation: operation})
+	}
+	slices.SortFunc(changed, func(x, y ci.ChangedPath) int {
+		return strings.Compare(x.Path, y.Path)
+	})
+	return changed
+}
+
 func (a *Server) SetBookmark(ctx context.Context, req *protos.SetBookmarkRequest) (*protos.SetBookmarkResponse, error) {
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
 
 	// Check repository access
-	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
 	if err != nil {
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
@@ -417,6 +511,24 @@ func (a *Server) SetBookmark(ctx context.Context, req *protos.SetBookmarkRequest
 		return nil, errors.New("either change_name or checked_out_change_id must be provided")
 	}
 
+	// The files changed between the old and new target are used by CI path filters
+	trigger := ciTrigger{userID: userId}
+	var changed []ci.ChangedPath
+	if previousChangeId, err := tx.GetBookmark(ctx, req.RepoId, req.BookmarkName); err == nil {
+		trigger.previousChangeID = &previousChangeId
+		previousFiles, err := tx.GetChangeFiles(ctx, previousChangeId)
+		if err != nil {
+			return nil, fmt.Errorf("get previous bookmark files: %w", err)
+		}
+		currentFiles, err := tx.GetChangeFiles(ctx, changeId)
+		if err != nil {
+			return nil, fmt.Errorf("get bookmark files: %w", err)
+		}
+		changed = changedPaths(previousFiles, currentFiles)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get bookmark: %w", err)
+	}
+
 	if err := tx.SetBookmark(ctx, req.RepoId, req.BookmarkName, changeId); err != nil {
 		return nil, fmt.Errorf("set bookmark: %w", err)
 	}
@@ -426,7 +538,9 @@ func (a *Server) SetBookmark(ctx context.Context, req *protos.SetBookmarkRequest
 	}
 
 	// Execute CI for bookmark push event
-	executeCIForBookmarkEvent(ctx, changeId, req.BookmarkName, ci.EventTypePush)
+	enqueueCI(ctx, changeId, ci.Event{Type: ci.EventTypePush, Rev: req.BookmarkName, ChangedPaths: changed}, "", trigger)
+	notifyBookmarkEvent(ctx, webhook.EventBookmarkSet, req.RepoId, req.BookmarkName, changeId, trigger.previousChangeID, userId)
+	go syncCISchedules(context.Background(), req.RepoId)
 
 	return &protos.SetBookmarkResponse{}, nil
 }
@@ -436,7 +550,7 @@ func (a *Server) RemoveBookmark(ctx context.Context, req *protos.RemoveBookmarkR
 	defer gcMutex.RUnlock()
 
 	// Check repository access
-	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
 	if err != nil {
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
@@ -454,14 +568,21 @@ func (a *Server) RemoveBookmark(ctx context.Context, req *protos.RemoveBookmarkR
 	// Note: We need to execute CI before the bookmark is removed, so we need to get the change ID
 	// For now, let's use a simple approach and execute CI with the current repository state
 	changeId, err := db.Q.GetBookmark(ctx, req.RepoId, req.BookmarkName)
-	if err == nil {
-		executeCIForBookmarkEvent(ctx, changeId, req.BookmarkName, ci.EventTypeRemove)
+	bookmarkFound := err == nil
+	if bookmarkFound {
+		enqueueCI(ctx, changeId, ci.Event{Type: ci.EventTypeRemove, Rev: req.BookmarkName}, "", ciTrigger{userID: userId})
 	}
 
 	if err = tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("commit transaction: %w", err)
 	}
 
+	if bookmarkFound {
+		notifyBookmarkEvent(ctx, webhook.EventBookmarkRemoved, req.RepoId, req.BookmarkName, changeId, nil, userId)
+	}
+
+	go syncCISchedules(context.Background(), req.RepoId)
+
 	return &protos.RemoveBookmarkResponse{}, nil
 }
 
@@ -511,7 +632,7 @@ func (a *Server) createSingleParentChange(ctx context.Context, tx *db.TxQueries,
 	}
 
 	// Set parent relationship
-	if err := tx.SetParent(ctx, changeId, &parentChangeId); err != nil {
+	if err := tx.SetParent(ctx, changeId, &parentChangeId, 0); err != nil {
 		return nil, fmt.Errorf("set parent: %w", err)
 	}
 
@@ -533,50 +654,150 @@ func (a *Server) createSingleParentChange(ctx context.Context, tx *db.TxQueries,
 
 type emptyReader struct{}
 
-func (emptyReader) Read(p []byte) (n int, err error) {
-	return 0, io.EOF
+// mergeSink receives the final result of merging all parents of a change.
+type mergeSink interface {
+	addFile(ctx context.Context, name string, executable bool, contentHash []byte, conflict bool, symlinkTarget *string) error
+	// A nil parent means the file does not exist in that parent.
+	addConflict(ctx context.Context, name string, base *FileState, parents []*FileState, resultHash []byte, binary bool) error
 }
 
-func (a *Server) createMergeChange(ctx context.Context, tx *db.TxQueries, req *protos.NewChangeRequest, parentChangeIds []int64, userId *int32) (*protos.NewChangeResponse, error) {
-	if len(parentChangeIds) != 2 {
-		return nil, errors.New("merge commits must have exactly two parents, octopus merge is not supported")
+// changeMergeSink adds merged files to a change in the database.
+type changeMergeSink struct {
+	tx       *db.TxQueries
+	changeId int64
+}
+
+func (s changeMergeSink) addFile(ctx context.Context, name string, executable bool, contentHash []byte, conflict bool, symlinkTarget *string) error {
+	return s.tx.AddFileToChange(ctx, s.changeId, name, executable, contentHash, conflict, symlinkTarget)
+}
+
+func (s changeMergeSink) addConflict(ctx context.Context, name string, base *FileState, parents []*FileState, resultHash []byte, binary bool) error {
+	baseHash, baseSymlinkTarget := conflictVersion(base)
+	if err := s.tx.SetConflict(ctx, s.changeId, name, baseHash, baseSymlinkTarget, resultHash, binary); err != nil {
+		return err
+	}
+	if err := s.tx.DeleteConflictSides(ctx, s.changeId, name); err != nil {
+		return err
+	}
+	for i, parent := range parents {
+		hash, symlinkTarget := conflictVersion(parent)
+		if err := s.tx.AddConflictSide(ctx, s.changeId, name, int32(i), hash, symlinkTarget); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	lcaChangeId, err := tx.FindLCA(ctx, parentChangeIds[0], parentChangeIds[1])
-	if err != nil {
-		return nil, fmt.Errorf("find lca of %d and %d: %w", parentChangeIds[0], parentChangeIds[1], err)
+// conflictVersion returns the content hash and symlink target stored for one
+// version of a conflicted file, both are nil if the file does not exist.
+func conflictVersion(f *FileState) ([]byte, *string) {
+	if f == nil {
+		return nil, nil
 	}
+	return f.ContentHash, f.SymlinkTarget
+}
 
-	fmt.Printf("A: %d B: %d LCA: %d\n", parentChangeIds[0], parentChangeIds[1], lcaChangeId)
+// fileStateMergeSink collects merged files in memory without persisting them.
+type fileStateMergeSink map[string]FileState
 
-	newChangeId, changeName, err := a.createMergeChangeRecord(ctx, tx, req, parentChangeIds, userId)
-	if err != nil {
-		return nil, err
+func (s fileStateMergeSink) addFile(_ context.Context, name string, executable bool, contentHash []byte, _ bool, symlinkTarget *string) error {
+	s[name] = FileState{
+		Path:          name,
+		ContentHash:   contentHash,
+		Executable:    executable,
+		SymlinkTarget: symlinkTarget,
 	}
+	return nil
+}
 
-	changeA, changeB, err := a.getParentChanges(ctx, tx, parentChangeIds)
-	if err != nil {
-		return nil, err
+func (s fileStateMergeSink) addConflict(context.Context, string, *FileState, []*FileState, []byte, bool) error {
+	return nil
+}
+
+// mergeFileRow describes one path in the base and both sides of a three-way merge.
+// A nil content hash means the file does not exist on that side.
+type mergeFileRow struct {
+	FileName         string
+	LcaExecutable    *bool
+	LcaContentHash   []byte
+	LcaSymlinkTarget *string
+	AExecutable      *bool
+	AContentHash     []byte
+	ASymlinkTarget   *string
+	BExecutable      *bool
+	BContentHash     []byte
+	BSymlinkTarget   *string
+}
+
+// mergedFile is a file produced by one step of an iterated merge.
+type mergedFile struct {
+	FileState
+	Conflict bool
+}
+
+type stepConflict struct {
+	base   *FileState
+	binary bool
+}
+
+// stepMergeSink collects the result of a single three-way merge step.
+type stepMergeSink struct {
+	files     map[string]mergedFile
+	conflicts map[string]stepConflict
+}
+
+func newStepMergeSink() *stepMergeSink {
+	return &stepMergeSink{
+		files:     make(map[string]mergedFile),
+		conflicts: make(map[string]stepConflict),
 	}
+}
 
-	mergeFiles, err := tx.GetThreeWayMergeFiles(ctx, lcaChangeId, parentChangeIds[0], parentChangeIds[1])
-	if err != nil {
-		return nil, fmt.Errorf("get three way merge files: %w", err)
+func (s *stepMergeSink) addFile(_ context.Context, name string, executable bool, contentHash []byte, conflict bool, symlinkTarget *string) error {
+	s.files[name] = mergedFile{
+		FileState: FileState{
+			Path:          name,
+			ContentHash:   contentHash,
+			Executable:    executable,
+			SymlinkTarget: symlinkTarget,
+		},
+		Conflict: conflict,
 	}
+	return nil
+}
 
-	tempDir, err := os.MkdirTemp("", "pogo-merge-*")
-	if err != nil {
-		return nil, fmt.Errorf("create temp dir for merge: %w", err)
+// addConflict records a conflict against the base version of the merge step,
+// a nil base hash means the file does not exist in the base.
+func (s *stepMergeSink) addConflict(_ context.Context, name string, baseHash []byte, baseSymlinkTarget *string, binary bool) error {
+	c := stepConflict{binary: binary}
+	if baseHash != nil {
+		c.base = &FileState{Path: name, ContentHash: baseHash, SymlinkTarget: baseSymlinkTarget}
 	}
-	defer os.RemoveAll(tempDir)
+	s.conflicts[name] = c
+	return nil
+}
 
-	for _, mergeFile := range mergeFiles {
-		fmt.Printf("mergeFile: %+v\n", mergeFile)
-		if err := a.processMergeFile(ctx, tx, newChangeId, mergeFile, changeA, changeB, tempDir); err != nil {
-			return nil, err
+func (emptyReader) Read(p []byte) (n int, err error) {
+	return 0, io.EOF
+}
+
+func (a *Server) createMergeChange(ctx context.Context, tx *db.TxQueries, req *protos.NewChangeRequest, parentChangeIds []int64, userId *int32) (*protos.NewChangeResponse, error) {
+	for i, id := range parentChangeIds {
+		if slices.Contains(parentChangeIds[:i], id) {
+			return nil, errors.New("parents of a merge must be distinct changes")
 		}
 	}
 
+	newChangeId, changeName, err := a.createMergeChangeRecord(ctx, tx, req, parentChangeIds, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := changeMergeSink{tx: tx, changeId: newChangeId}
+	if err := a.mergeParents(ctx, tx.Queries, parentChangeIds, sink, nil); err != nil {
+		return nil, err
+	}
+
 	return &protos.NewChangeResponse{
 		ChangeId:   newChangeId,
 		ChangeName: changeName,
@@ -598,36 +819,172 @@ func (a *Server) createMergeChangeRecord(ctx context.Context, tx *db.TxQueries,
 		return 0, "", fmt.Errorf("create change: %w", err)
 	}
 
-	if err := tx.SetParent(ctx, newChangeId, &parentChangeIds[0]); err != nil {
-		return 0, "", fmt.Errorf("set parent: %w", err)
+	for i := range parentChangeIds {
+		if err := tx.SetParent(ctx, newChangeId, &parentChangeIds[i], int32(i)); err != nil {
+			return 0, "", fmt.Errorf("set parent: %w", err)
+		}
+		if err := tx.SetDepthFromParent(ctx, newChangeId, parentChangeIds[i]); err != nil {
+			return 0, "", fmt.Errorf("set depth from parent: %w", err)
+		}
+	}
+
+	return newChangeId, changeName, nil
+}
+
+// mergeParents merges the given parents by iterated three-way merges. Each
+// parent is merged into the result of the previous ones against its closest
+// common ancestor with any of them. If include is not nil, only the files it
+// accepts are merged.
+func (a *Server) mergeParents(ctx context.Context, q *db.Queries, parentChangeIds []int64, sink mergeSink, include func(name string) bool) error {
+	if len(parentChangeIds) < 2 {
+		return fmt.Errorf("a merge requires at least two parents, got %d", len(parentChangeIds))
 	}
-	if err := tx.SetParent(ctx, newChangeId, &parentChangeIds[1]); err != nil {
-		return 0, "", fmt.Errorf("set parent: %w", err)
+
+	parents := make([]db.GetChangeRow, len(parentChangeIds))
+	parentFiles := make([]map[string]FileState, len(parentChangeIds))
+	for i, id := range parentChangeIds {
+		change, err := q.GetChange(ctx, id)
+		if err != nil {
+			return fmt.Errorf("get parent change %d: %w", id, err)
+		}
+		parents[i] = change
+		if parentFiles[i], err = fileStatesForChange(ctx, q, id); err != nil {
+			return fmt.Errorf("get files of parent %s: %w", change.Name, err)
+		}
 	}
 
-	if err := tx.SetDepthFromParent(ctx, newChangeId, parentChangeIds[0]); err != nil {
-		return 0, "", fmt.Errorf("set depth from parent: %w", err)
+	// Merge behaviour is configured by the attributes of the change being merged into
+	attributes, err := GetChangeAttributes(ctx, q, parentChangeIds[0])
+	if err != nil {
+		return fmt.Errorf("get attributes of parent %s: %w", parents[0].Name, err)
 	}
-	if err := tx.SetDepthFromParent(ctx, newChangeId, parentChangeIds[1]); err != nil {
-		return 0, "", fmt.Errorf("set depth from parent: %w", err)
+
+	tempDir, err := os.MkdirTemp("", "pogo-merge-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir for merge: %w", err)
 	}
+	defer os.RemoveAll(tempDir)
 
-	return newChangeId, changeName, nil
+	result := make(map[string]mergedFile, len(parentFiles[0]))
+	for name, f := range parentFiles[0] {
+		result[name] = mergedFile{FileState: f}
+	}
+	conflicts := make(map[string]stepConflict)
+
+	for i := 1; i < len(parents); i++ {
+		lcaChangeId, err := a.findClosestCommonAncestor(ctx, q, parents[:i], parents[i])
+		if err != nil {
+			return err
+		}
+		baseFiles, err := fileStatesForChange(ctx, q, lcaChangeId)
+		if err != nil {
+			return fmt.Errorf("get files of common ancestor: %w", err)
+		}
+
+		var oursNames []string
+		for _, p := range parents[:i] {
+			oursNames = append(oursNames, p.Name)
+		}
+		labels := filecontents.ConflictLabels{
+			Base:   "base",
+			Ours:   strings.Join(oursNames, "+"),
+			Theirs: parents[i].Name,
+		}
+
+		step := newStepMergeSink()
+		for _, row := range mergeFileRows(baseFiles, result, parentFiles[i]) {
+			if include != nil && !include(row.FileName) {
+				continue
+			}
+			if err := a.processMergeFile(ctx, step, row, labels, attributes.Lookup(row.FileName), tempDir); err != nil {
+				return err
+			}
+		}
+
+		result = step.files
+		for name, c := range step.conflicts {
+			if _, ok := conflicts[name]; !ok {
+				conflicts[name] = c
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(result)) {
+		f := result[name]
+		c, conflicted := conflicts[name]
+		if err := sink.addFile(ctx, name, f.Executable, f.ContentHash, f.Conflict || conflicted, f.SymlinkTarget); err != nil {
+			return fmt.Errorf("add file %s: %w", name, err)
+		}
+		if !conflicted {
+			continue
+		}
+
+		sides := make([]*FileState, len(parentFiles))
+		for i, files := range parentFiles {
+			if pf, ok := files[name]; ok {
+				sides[i] = &pf
+			}
+		}
+		if err := sink.addConflict(ctx, name, c.base, sides, f.ContentHash, c.binary); err != nil {
+			return fmt.Errorf("record conflict for file %s: %w", name, err)
+		}
+	}
+
+	return nil
 }
 
-func (a *Server) getParentChanges(ctx context.Context, tx *db.TxQueries, parentChangeIds []int64) (*db.GetChangeRow, *db.GetChangeRow, error) {
-	changeA, err := tx.GetChange(ctx, parentChangeIds[0])
-	if err != nil {
-		return nil, nil, fmt.Errorf("get change A: %w", err)
+// findClosestCommonAncestor returns the deepest common ancestor of change and any of others.
+func (a *Server) findClosestCommonAncestor(ctx context.Context, q *db.Queries, others []db.GetChangeRow, change db.GetChangeRow) (int64, error) {
+	var (
+		best      int64
+		bestDepth int64 = -1
+	)
+	for _, other := range others {
+		lcaChangeId, err := q.FindLCA(ctx, other.ID, change.ID)
+		if err != nil {
+			return 0, fmt.Errorf("find lca of %s and %s: %w", other.Name, change.Name, err)
+		}
+		lca, err := q.GetChange(ctx, lcaChangeId)
+		if err != nil {
+			return 0, fmt.Errorf("get change %d: %w", lcaChangeId, err)
+		}
+		if lca.Depth > bestDepth {
+			best, bestDepth = lca.ID, lca.Depth
+		}
 	}
-	changeB, err := tx.GetChange(ctx, parentChangeIds[1])
-	if err != nil {
-		return nil, nil, fmt.Errorf("get change B: %w", err)
+	return best, nil
+}
+
+func mergeFileRows(base map[string]FileState, ours map[string]mergedFile, theirs map[string]FileState) []mergeFileRow {
+	names := make(map[string]struct{})
+	for name := range base {
+		names[name] = struct{}{}
 	}
-	return &changeA, &changeB, nil
+	for name := range ours {
+		names[name] = struct{}{}
+	}
+	for name := range theirs {
+		names[name] = struct{}{}
+	}
+
+	rows := make([]mergeFileRow, 0, len(names))
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		row := mergeFileRow{FileName: name}
+		if f, ok := base[name]; ok {
+			row.LcaExecutable, row.LcaContentHash, row.LcaSymlinkTarget = &f.Executable, f.ContentHash, f.SymlinkTarget
+		}
+		if f, ok := ours[name]; ok {
+			row.AExecutable, row.AContentHash, row.ASymlinkTarget = &f.Executable, f.ContentHash, f.SymlinkTarget
+		}
+		if f, ok := theirs[name]; ok {
+			row.BExecutable, row.BContentHash, row.BSymlinkTarget = &f.Executable, f.ContentHash, f.SymlinkTarget
+		}
+		rows = append(rows, row)
+	}
+	return rows
 }
 
-func (a *Server) processMergeFile(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, changeA, changeB *db.GetChangeRow, tempDir string) error {
+func (a *Server) processMergeFile(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, labels filecontents.ConflictLabels, attrs filecontents.FileAttributes, tempDir string) error {
 	aExists := mergeFile.AContentHash != nil
 	oExists := mergeFile.LcaContentHash != nil
 	bExists := mergeFile.BContentHash != nil
@@ -641,18 +998,33 @@ func (a *Server) processMergeFile(ctx context.Context, tx *db.TxQueries, newChan
 	}
 
 	if a.isSimpleCase(aExists, oExists, bExists) {
-		return a.handleSimpleCase(ctx, tx, newChangeId, mergeFile, aExists, bExists)
+		return a.handleSimpleCase(ctx, sink, mergeFile, aExists, bExists)
+	}
+
+	switch attrs.Merge {
+	case filecontents.MergeDriverOurs:
+		return a.keepMergeSide(ctx, sink, mergeFile.FileName, mergeFile.AExecutable, mergeFile.AContentHash, mergeFile.ASymlinkTarget)
+	case filecontents.MergeDriverTheirs:
+		return a.keepMergeSide(ctx, sink, mergeFile.FileName, mergeFile.BExecutable, mergeFile.BContentHash, mergeFile.BSymlinkTarget)
 	}
 
 	// Handle symlink conflicts
 	if aIsSymlink || bIsSymlink || oIsSymlink {
-		return a.handleSymlinkMerge(ctx, tx, newChangeId, mergeFile, changeA, changeB, aExists, oExists, bExists, aIsSymlink, oIsSymlink, bIsSymlink)
+		return a.handleSymlinkMerge(ctx, sink, mergeFile, aExists, bExists, aIsSymlink, bIsSymlink)
 	}
 
-	return a.handleThreeWayMerge(ctx, tx, newChangeId, mergeFile, changeA, changeB, tempDir, aExists, oExists, bExists)
+	return a.handleThreeWayMerge(ctx, sink, mergeFile, labels, attrs, tempDir, aExists, oExists, bExists)
+}
+
+// keepMergeSide resolves a file to one side of the merge, a nil content hash deletes it.
+func (a *Server) keepMergeSide(ctx context.Context, sink *stepMergeSink, name string, executable *bool, contentHash []byte, symlinkTarget *string) error {
+	if contentHash == nil {
+		return nil
+	}
+	return sink.addFile(ctx, name, executable != nil && *executable, contentHash, false, symlinkTarget)
 }
 
-func (a *Server) shouldSkipFile(mergeFile db.GetThreeWayMergeFilesRow, aExists, oExists, bExists bool) bool {
+func (a *Server) shouldSkipFile(mergeFile mergeFileRow, aExists, oExists, bExists bool) bool {
 	if oExists && aExists && !bExists && bytes.Equal(mergeFile.AContentHash, mergeFile.LcaContentHash) {
 		return true
 	}
@@ -669,7 +1041,7 @@ func (a *Server) isSimpleCase(aExists, oExists, bExists bool) bool {
 	return (!oExists && aExists && !bExists) || (!oExists && !aExists && bExists)
 }
 
-func (a *Server) handleSimpleCase(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, aExists, bExists bool) error {
+func (a *Server) handleSimpleCase(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, aExists, bExists bool) error {
 	var hash []byte
 	var executable bool
 	var symlinkTarget *string
@@ -692,19 +1064,17 @@ func (a *Server) handleSimpleCase(ctx context.Context, tx *db.TxQueries, newChan
 	)
 	// Only check for conflicts in regular files
 	if symlinkTarget == nil {
-		if hasConflicts = filecontents.IsBinaryConflictFileName(mergeFile.FileName); !hasConflicts {
-			hasConflicts, err = filecontents.HasConflictMarkers(filePath)
-			if err != nil {
-				return fmt.Errorf("check conflict markers for file %s: %w", filePath, err)
-			}
+		hasConflicts, err = filecontents.HasConflictMarkers(filePath)
+		if err != nil {
+			return fmt.Errorf("check conflict markers for file %s: %w", filePath, err)
 		}
 	}
 
-	return tx.AddFileToChange(ctx, newChangeId, mergeFile.FileName, executable, hash, hasConflicts, symlinkTarget)
+	return sink.addFile(ctx, mergeFile.FileName, executable, hash, hasConflicts, symlinkTarget)
 }
 
 // handleSymlinkMerge handles merging when at least one version is a symlink
-func (a *Server) handleSymlinkMerge(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, changeA, changeB *db.GetChangeRow, aExists, oExists, bExists, aIsSymlink, oIsSymlink, bIsSymlink bool) error {
+func (a *Server) handleSymlinkMerge(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, aExists, bExists, aIsSymlink, bIsSymlink bool) error {
 	// Case 1: Both A and B are symlinks with the same target - no conflict
 	if aIsSymlink && bIsSymlink && aExists && bExists {
 		if *mergeFile.ASymlinkTarget == *mergeFile.BSymlinkTarget {
@@ -713,71 +1083,67 @@ func (a *Server) handleSymlinkMerge(ctx context.Context, tx *db.TxQueries, newCh
 			if mergeFile.AExecutable != nil {
 				executable = *mergeFile.AExecutable
 			}
-			return tx.AddFileToChange(ctx, newChangeId, mergeFile.FileName, executable, mergeFile.AContentHash, false, mergeFile.ASymlinkTarget)
+			return sink.addFile(ctx, mergeFile.FileName, executable, mergeFile.AContentHash, false, mergeFile.ASymlinkTarget)
 		}
 	}
 
 	// Case 2: Conflicting changes - symlink vs symlink with different targets, or symlink vs regular file
-	// Mark as conflict and create conflict files
-
+	// Like a binary conflict, keep our version and record every side
 	executable := threeWayMergeExecutable(mergeFile.AExecutable, mergeFile.LcaExecutable, mergeFile.BExecutable)
-
-	// Add LCA version if it exists
-	if oExists {
-		if err := tx.AddFileToChange(ctx, newChangeId, mergeFile.FileName, executable, mergeFile.LcaContentHash, true, mergeFile.LcaSymlinkTarget); err != nil {
-			return fmt.Errorf("add LCA file %s to change: %w", mergeFile.FileName, err)
-		}
+	resultHash, symlinkTarget := mergeFile.AContentHash, mergeFile.ASymlinkTarget
+	if !aExists {
+		resultHash, symlinkTarget = mergeFile.BContentHash, mergeFile.BSymlinkTarget
 	}
 
-	// Add A version if it exists
-	if aExists {
-		aFileName := fmt.Sprintf("%s.%s", mergeFile.FileName, changeA.Name)
-		if err := tx.AddFileToChange(ctx, newChangeId, aFileName, executable, mergeFile.AContentHash, true, mergeFile.ASymlinkTarget); err != nil {
-			return fmt.Errorf("add A file %s to change: %w", aFileName, err)
-		}
+	if err := sink.addFile(ctx, mergeFile.FileName, executable, resultHash, true, symlinkTarget); err != nil {
+		return fmt.Errorf("add symlink conflict %s to change: %w", mergeFile.FileName, err)
 	}
-
-	// Add B version if it exists
-	if bExists {
-		bFileName := fmt.Sprintf("%s.%s", mergeFile.FileName, changeB.Name)
-		if err := tx.AddFileToChange(ctx, newChangeId, bFileName, executable, mergeFile.BContentHash, true, mergeFile.BSymlinkTarget); err != nil {
-			return fmt.Errorf("add B file %s to change: %w", bFileName, err)
-		}
+	if err := sink.addConflict(ctx, mergeFile.FileName, mergeFile.LcaContentHash, mergeFile.LcaSymlinkTarget, true); err != nil {
+		return fmt.Errorf("record symlink conflict %s: %w", mergeFile.FileName, err)
 	}
 
 	return nil
 }
 
-func (a *Server) handleThreeWayMerge(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, changeA, changeB *db.GetChangeRow, tempDir string, aExists, oExists, bExists bool) error {
-	aReader, aType, err := a.getFileReader(mergeFile.AContentHash, aExists)
+func (a *Server) handleThreeWayMerge(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, labels filecontents.ConflictLabels, attrs filecontents.FileAttributes, tempDir string, aExists, oExists, bExists bool) error {
+	aReader, aType, err := a.getFileReader(mergeFile.AContentHash, aExists, attrs)
 	if err != nil {
 		return fmt.Errorf("get file reader A %s: %w", mergeFile.FileName, err)
 	}
 	defer a.closeReader(aReader)
 
-	oReader, oType, err := a.getFileReader(mergeFile.LcaContentHash, oExists)
+	oReader, oType, err := a.getFileReader(mergeFile.LcaContentHash, oExists, attrs)
 	if err != nil {
 		return fmt.Errorf("get file reader O %s: %w", mergeFile.FileName, err)
 	}
 	defer a.closeReader(oReader)
 
-	bReader, bType, err := a.getFileReader(mergeFile.BContentHash, bExists)
+	bReader, bType, err := a.getFileReader(mergeFile.BContentHash, bExists, attrs)
 	if err != nil {
 		return fmt.Errorf("get file reader B %s: %w", mergeFile.FileName, err)
 	}
 	defer a.closeReader(bReader)
 
 	executable := threeWayMergeExecutable(mergeFile.AExecutable, mergeFile.LcaExecutable, mergeFile.BExecutable)
-	mType := filecontents.ThreeWayMergeResultType(aType, oType, bType)
+	mType := attrs.ApplyTo(filecontents.ThreeWayMergeResultType(aType, oType, bType))
 
-	if mType.Binary {
-		return a.handleBinaryConflict(ctx, tx, newChangeId, mergeFile, changeA, changeB, aExists, oExists, bExists, executable)
+	switch {
+	case attrs.Merge == filecontents.MergeDriverBinary, mType.Binary && attrs.Merge != filecontents.MergeDriverText:
+		if aExists && bExists && bytes.Equal(mergeFile.AContentHash, mergeFile.BContentHash) {
+			return sink.addFile(ctx, mergeFile.FileName, executable, mergeFile.AContentHash, false, nil)
+		}
+		return a.handleBinaryConflict(ctx, sink, mergeFile, aExists, executable)
+	case attrs.Merge == filecontents.MergeDriverUnion:
+		return a.handleUnionMerge(ctx, sink, mergeFile, tempDir, aReader, oReader, bReader, mType, executable)
+	default:
+		mType.Binary = false
+		return a.handleTextMerge(ctx, sink, mergeFile, labels, tempDir, aReader, oReader, bReader, mType, executable)
 	}
-
-	return a.handleTextMerge(ctx, tx, newChangeId, mergeFile, changeA, changeB, tempDir, aReader, oReader, bReader, mType, executable)
 }
 
-func (a *Server) getFileReader(contentHash []byte, exists bool) (io.Reader, filecontents.FileType, error) {
+// getFileReader opens a file as canonical text. Encoding and forced text or
+// binary attributes apply when reading, line endings are normalized on write.
+func (a *Server) getFileReader(contentHash []byte, exists bool, attrs filecontents.FileAttributes) (io.Reader, filecontents.FileType, error) {
 	if !exists {
 		return emptyReader{}, filecontents.FileType{}, nil
 	}
@@ -788,7 +1154,10 @@ func (a *Server) getFileReader(contentHash []byte, exists bool) (io.Reader, file
 		return nil, filecontents.FileType{}, err
 	}
 
-	return fileType.CanonicalizeReader(file), fileType, nil
+	readType := attrs.ApplyTo(fileType)
+	readType.LineEnding = fileType.LineEnding
+
+	return readType.CanonicalizeReader(file), readType, nil
 }
 
 func (a *Server) closeReader(reader io.Reader) {
@@ -797,69 +1166,140 @@ func (a *Server) closeReader(reader io.Reader) {
 	}
 }
 
-func (a *Server) handleBinaryConflict(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, changeA, changeB *db.GetChangeRow, aExists, oExists, bExists bool, executable bool) error {
-	if oExists {
-		if err := tx.AddFileToChange(ctx, newChangeId, mergeFile.FileName, executable, mergeFile.LcaContentHash, true, mergeFile.LcaSymlinkTarget); err != nil {
-			return fmt.Errorf("add LCA file %s to change: %w", mergeFile.FileName, err)
-		}
+// handleBinaryConflict keeps our version at the path and records both sides as a conflict
+func (a *Server) handleBinaryConflict(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, aExists bool, executable bool) error {
+	resultHash := mergeFile.AContentHash
+	if !aExists {
+		resultHash = mergeFile.BContentHash
 	}
 
-	if aExists {
-		aFileName := fmt.Sprintf("%s.%s", mergeFile.FileName, changeA.Name)
-		if err := tx.AddFileToChange(ctx, newChangeId, aFileName, executable, mergeFile.AContentHash, true, mergeFile.ASymlinkTarget); err != nil {
-			return fmt.Errorf("add A file %s to change: %w", aFileName, err)
-		}
+	if err := sink.addFile(ctx, mergeFile.FileName, executable, resultHash, true, nil); err != nil {
+		return fmt.Errorf("add binary conflict file %s to change: %w", mergeFile.FileName, err)
+	}
+
+	if err := sink.addConflict(ctx, mergeFile.FileName, mergeFile.LcaContentHash, mergeFile.LcaSymlinkTarget, true); err != nil {
+		return fmt.Errorf("record conflict for file %s: %w", mergeFile.FileName, err)
+	}
+
+	return nil
+}
+
+func (a *Server) handleTextMerge(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, labels filecontents.ConflictLabels, tempDir string, aReader, oReader, bReader io.Reader, mType filecontents.FileType, executable bool) error {
+	mergeResult, mergeConflict, err := filecontents.MaterializeConflict(oReader, aReader, bReader, labels, filecontents.ConflictStyleGit)
+	if err != nil {
+		return fmt.Errorf("merge file %s: %w", mergeFile.FileName, err)
+	}
+
+	hash, hasMarkers, err := storeMergeResult(tempDir, mergeFile.FileName, mType.TypeReader(mergeResult))
+	if err != nil {
+		return err
+	}
+
+	if err := sink.addFile(ctx, mergeFile.FileName, executable, hash, mergeConflict || hasMarkers, nil); err != nil {
+		return err
 	}
 
-	if bExists {
-		bFileName := fmt.Sprintf("%s.%s", mergeFile.FileName, changeB.Name)
-		if err := tx.AddFileToChange(ctx, newChangeId, bFileName, executable, mergeFile.BContentHash, true, mergeFile.BSymlinkTarget); err != nil {
-			return fmt.Errorf("add B file %s to change: %w", bFileName, err)
+	// Only conflicts produced by this merge have sides to record
+	if mergeConflict {
+		if err := sink.addConflict(ctx, mergeFile.FileName, mergeFile.LcaContentHash, mergeFile.LcaSymlinkTarget, false); err != nil {
+			return fmt.Errorf("record conflict for file %s: %w", mergeFile.FileName, err)
 		}
 	}
 
 	return nil
 }
 
-func (a *Server) handleTextMerge(ctx context.Context, tx *db.TxQueries, newChangeId int64, mergeFile db.GetThreeWayMergeFilesRow, changeA, changeB *db.GetChangeRow, tempDir string, aReader, oReader, bReader io.Reader, mType filecontents.FileType, executable bool) error {
-	mergeResult, err := diff3.Merge(aReader, oReader, bReader, true, changeA.Name, changeB.Name)
+// handleUnionMerge keeps the lines of both sides for conflicting hunks, so it never records a conflict
+func (a *Server) handleUnionMerge(ctx context.Context, sink *stepMergeSink, mergeFile mergeFileRow, tempDir string, aReader, oReader, bReader io.Reader, mType filecontents.FileType, executable bool) error {
+	mergeResult, err := filecontents.MergeUnion(oReader, aReader, bReader)
 	if err != nil {
 		return fmt.Errorf("merge file %s: %w", mergeFile.FileName, err)
 	}
 
-	absPath := filepath.Join(tempDir, filepath.FromSlash(mergeFile.FileName))
+	hash, hasMarkers, err := storeMergeResult(tempDir, mergeFile.FileName, mType.TypeReader(mergeResult))
+	if err != nil {
+		return err
+	}
+
+	return sink.addFile(ctx, mergeFile.FileName, executable, hash, hasMarkers, nil)
+}
+
+// storeMergeResult writes merged content to the object store and reports
+// whether it contains conflict markers.
+func storeMergeResult(tempDir, name string, r io.Reader) ([]byte, bool, error) {
+	absPath := filepath.Join(tempDir, filepath.FromSlash(name))
 	_ = os.MkdirAll(filepath.Dir(absPath), 0755)
 
 	mergedFile, err := os.Create(absPath)
 	if err != nil {
-		return fmt.Errorf("create merged file %s: %w", absPath, err)
+		return nil, false, fmt.Errorf("create merged file %s: %w", absPath, err)
 	}
 
-	if _, err := io.Copy(mergedFile, mType.TypeReader(mergeResult.Result)); err != nil {
+	if _, err := io.Copy(mergedFile, r); err != nil {
 		mergedFile.Close()
-		return fmt.Errorf("write merged content %s: %w", absPath, err)
+		return nil, false, fmt.Errorf("write merged content %s: %w", absPath, err)
 	}
 	mergedFile.Close()
 
-	hasConflicts := mergeResult.Conflicts
-	if !hasConflicts {
-		if hasConflicts = filecontents.IsBinaryConflictFileName(mergeFile.FileName); !hasConflicts {
-			if hasConflicts, err = filecontents.HasConflictMarkers(absPath); err != nil {
-				return fmt.Errorf("check conflict markers for file %s: %w", mergeFile.FileName, err)
-			}
-		}
+	hasMarkers, err := filecontents.HasConflictMarkers(absPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("check conflict markers for file %s: %w", name, err)
 	}
 
 	hash, err := filecontents.StoreFile(absPath)
 	if err != nil {
-		return fmt.Errorf("store merged file %s: %w", mergeFile.FileName, err)
+		return nil, false, fmt.Errorf("store merged file %s: %w", name, err)
 	}
 
-	return tx.AddFileToChange(ctx, newChangeId, mergeFile.FileName, executable, hash, hasConflicts, nil)
+	return hash, hasMarkers, nil
 }
 
-func threeWayMergeExecutable(a, o, b *bool) bool {
-	base := false
+// remergeChildConflicts merges conflicted files in the children of a change again
+// if they still contain the content produced by the previous merge.
+func (a *Server) remergeChildConflicts(ctx context.Context, tx *db.TxQueries, parentId int64) error {
+	untouched, err := tx.GetUntouchedConflictsInChildren(ctx, parentId)
+	if err != nil {
+		return fmt.Errorf("get untouched conflicts in children: %w", err)
+	}
+
+	namesByChild := make(map[int64]map[string]bool)
+	for _, c := range untouched {
+		if namesByChild[c.ChangeID] == nil {
+			namesByChild[c.ChangeID] = make(map[string]bool)
+		}
+		namesByChild[c.ChangeID][c.Name] = true
+	}
+
+	for childId, names := range namesByChild {
+		parents, err := tx.GetChangeParents(ctx, childId)
+		if err != nil {
+			return fmt.Errorf("get parents of change %d: %w", childId, err)
+		}
+		if len(parents) < 2 {
+			continue
+		}
+
+		for name := range names {
+			if err := tx.RemoveFileFromChange(ctx, childId, name); err != nil {
+				return fmt.Errorf("remove file %s from change %d: %w", name, childId, err)
+			}
+			if err := tx.DeleteConflict(ctx, childId, name); err != nil {
+				return fmt.Errorf("delete conflict %s of change %d: %w", name, childId, err)
+			}
+		}
+
+		sink := changeMergeSink{tx: tx, changeId: childId}
+		include := func(name string) bool { return names[name] }
+		if err := a.remergeChange(ctx, tx.Queries, childId, sink, include); err != nil {
+			return fmt.Errorf("remerge change %d: %w", childId, err)
+		}
+	}
+
+	return nil
+}
+
+func threeWayMergeExecutable(a, o, b *bool) bool {
+	base := false
 	if o != nil {
 		base = *o
 	}
@@ -926,10 +1366,23 @@ func (a *Server) NewChange(ctx context.Context, req *protos.NewChangeRequest) (*
 		return nil, err
 	}
 
+	if req.Description != nil {
+		if err := checkPolicy(ctx, policy.EventNewChange, req.RepoId, response.ChangeId, response.ChangeName, userId, req.Description, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	if err = tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("commit transaction: %w", err)
 	}
 
+	eventType := ci.EventTypeNewChange
+	if len(parentChangeIds) > 1 {
+		eventType = ci.EventTypeMerge
+	}
+	enqueueCI(ctx, response.ChangeId, ci.Event{Type: eventType, Rev: response.ChangeName}, "", ciTrigger{userID: userId})
+	notifyChangeEvent(ctx, webhook.EventChangeCreated, response.ChangeId, userId, nil)
+
 	return response, nil
 }
 
@@ -971,11 +1424,15 @@ func (a *Server) SetDescription(ctx context.Context, req *protos.SetDescriptionR
 	}
 
 	// Check repository access
-	_, err = checkRepositoryAccessFromAuth(ctx, req.Auth, change.RepositoryID)
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, change.RepositoryID)
 	if err != nil {
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
 
+	if err := checkPolicy(ctx, policy.EventDescribe, change.RepositoryID, change.ID, change.Name, userId, &req.Description, nil); err != nil {
+		return nil, err
+	}
+
 	err = db.Q.SetChangeDescription(ctx, req.ChangeId, req.Description)
 	if err != nil {
 		return nil, fmt.Errorf("set change description: %w", err)
@@ -1031,6 +1488,17 @@ func (a *Server) Log(ctx context.Context, req *protos.LogRequest) (*protos.LogRe
 		}
 	}
 
+	ciStatuses := make(map[int64]string)
+	if len(changeIds) > 0 {
+		statuses, err := db.Q.GetChangeCIStatuses(ctx, req.RepoId, changeIds)
+		if err != nil {
+			return nil, fmt.Errorf("get CI statuses: %w", err)
+		}
+		for _, s := range statuses {
+			ciStatuses[s.ChangeID] = s.Status
+		}
+	}
+
 	// Build the response
 	response := &protos.LogResponse{
 		CheckedOutChangeId: req.CheckedOutChangeId,
@@ -1045,6 +1513,7 @@ func (a *Server) Log(ctx context.Context, req *protos.LogRequest) (*protos.LogRe
 			CreatedAt:    change.CreatedAt.Time.Format("2006-01-02T15:04:05Z07:00"),
 			UpdatedAt:    change.UpdatedAt.Time.Format("2006-01-02T15:04:05Z07:00"),
 			Bookmarks:    bookmarksMap[change.Name],
+			CiStatus:     ciStatuses[change.ID],
 		}
 		if change.Description != nil {
 			logChange.Description = change.Description
@@ -1055,7 +1524,9 @@ func (a *Server) Log(ctx context.Context, req *protos.LogRequest) (*protos.LogRe
 		if err != nil {
 			return nil, fmt.Errorf("get conflict files for change %d: %w", change.ID, err)
 		}
-		logChange.ConflictFiles = conflictFiles
+		for _, f := range conflictFiles {
+			logChange.ConflictFiles = append(logChange.ConflictFiles, f.Name)
+		}
 
 		response.Changes = append(response.Changes, logChange)
 	}
@@ -1120,6 +1591,14 @@ func (a *Server) Info(ctx context.Context, req *protos.InfoRequest) (*protos.Inf
 		resp.IsInConflict = conflict
 	}
 
+	statuses, err := db.Q.GetChangeCIStatuses(ctx, req.RepoId, []int64{req.CheckedOutChangeId})
+	if err != nil {
+		return nil, fmt.Errorf("get CI status: %w", err)
+	}
+	if len(statuses) > 0 {
+		resp.CiStatus = statuses[0].Status
+	}
+
 	return resp, nil
 }
 
@@ -1325,8 +1804,8 @@ func (a *Server) RemoveChange(ctx context.Context, req *protos.RemoveChangeReque
 
 		// First, connect each child to each parent of the deleted change
 		for _, child := range children {
-			for _, parent := range parents {
-				if err := tx.SetParent(ctx, child.ID, &parent.ID); err != nil {
+			for i, parent := range parents {
+				if err := tx.SetParent(ctx, child.ID, &parent.ID, int32(i)); err != nil {
 					return nil, fmt.Errorf("set parent for child: %w", err)
 				}
 				if err := tx.SetDepthFromParent(ctx, child.ID, parent.ID); err != nil {
@@ -1609,7 +2088,7 @@ func (a *Server) SetRepositoryVisibility(ctx context.Context, req *protos.SetRep
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
 
-	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
 	if err != nil {
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
@@ -1617,6 +2096,7 @@ func (a *Server) SetRepositoryVisibility(ctx context.Context, req *protos.SetRep
 	if err := db.Q.UpdateRepositoryVisibility(ctx, req.RepoId, req.Public); err != nil {
 		return nil, fmt.Errorf("update repository visibility: %w", err)
 	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "visibility", "changed", visibilityName(req.Public))
 
 	return &protos.SetRepositoryVisibilityResponse{}, nil
 }
@@ -1640,6 +2120,14 @@ func buildCIRunSummary(rowID int32, configFilename, eventType, rev string, patte
 	return summary
 }
 
+func setCIRunJob(summary *protos.CIRunSummary, pipelineID *int64, jobName *string, needs []string, status string) *protos.CIRunSummary {
+	summary.PipelineId = pipelineID
+	summary.JobName = jobName
+	summary.Needs = needs
+	summary.Status = status
+	return summary
+}
+
 func formatTimestamptz(ts pgtype.Timestamptz) string {
 	if !ts.Valid {
 		return ""
@@ -1663,7 +2151,7 @@ func (a *Server) ListCIRuns(ctx context.Context, req *protos.ListCIRunsRequest)
 
 	summaries := make([]*protos.CIRunSummary, 0, len(rows))
 	for _, row := range rows {
-		summaries = append(summaries, buildCIRunSummary(
+		summaries = append(summaries, setCIRunJob(buildCIRunSummary(
 			row.ID,
 			row.ConfigFilename,
 			row.EventType,
@@ -1675,10 +2163,29 @@ func (a *Server) ListCIRuns(ctx context.Context, req *protos.ListCIRunsRequest)
 			row.Success,
 			row.StartedAt,
 			row.FinishedAt,
-		))
+		), row.PipelineID, row.JobName, row.Needs, row.Status))
 	}
 
-	return &protos.ListCIRunsResponse{Runs: summaries}, nil
+	pipelines, err := db.Q.ListActiveCIPipelines(ctx, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("list active ci pipelines: %w", err)
+	}
+
+	queued := make([]*protos.CIQueuedPipeline, 0, len(pipelines))
+	for _, p := range pipelines {
+		queued = append(queued, &protos.CIQueuedPipeline{
+			Id:             p.ID,
+			ConfigFilename: p.ConfigFilename,
+			EventType:      p.EventType,
+			Rev:            p.Rev,
+			State:          p.State,
+			Attempts:       p.Attempts,
+			QueuedAt:       formatTimestamptz(p.QueuedAt),
+			StartedAt:      formatTimestamptz(p.StartedAt),
+		})
+	}
+
+	return &protos.ListCIRunsResponse{Runs: summaries, Queued: queued}, nil
 }
 
 func (a *Server) GetCIRun(ctx context.Context, req *protos.GetCIRunRequest) (*protos.GetCIRunResponse, error) {
@@ -1695,30 +2202,107 @@ func (a *Server) GetCIRun(ctx context.Context, req *protos.GetCIRunRequest) (*pr
 		return nil, fmt.Errorf("get ci run: %w", err)
 	}
 
-	decompressedLog, err := compressions.DecompressBytes(row.Log)
+	log, err := getCIRunLog(ctx, row.ID, row.Status, row.Log)
+	if err != nil {
+		return nil, fmt.Errorf("get log: %w", err)
+	}
+
+	var pipeline []*protos.CIRunSummary
+	if row.PipelineID != nil {
+		jobs, err := db.Q.GetCIPipelineRuns(ctx, req.RepoId, row.PipelineID)
+		if err != nil {
+			return nil, fmt.Errorf("get ci pipeline runs: %w", err)
+		}
+		for _, j := range jobs {
+			pipeline = append(pipeline, setCIRunJob(buildCIRunSummary(
+				j.ID,
+				row.ConfigFilename,
+				row.EventType,
+				row.Rev,
+				row.Pattern,
+				row.Reason,
+				j.TaskType,
+				j.StatusCode,
+				j.Success,
+				j.StartedAt,
+				j.FinishedAt,
+			), row.PipelineID, j.JobName, j.Needs, j.Status))
+		}
+	}
+
+	artifactRows, err := db.Q.ListCIArtifacts(ctx, row.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list ci artifacts: %w", err)
+	}
+	artifacts := make([]*protos.CIArtifact, len(artifactRows))
+	for i, a := range artifactRows {
+		artifacts[i] = &protos.CIArtifact{Name: a.Name, Size: a.Size}
+	}
+
+	run := setCIRunJob(buildCIRunSummary(
+		row.ID,
+		row.ConfigFilename,
+		row.EventType,
+		row.Rev,
+		row.Pattern,
+		row.Reason,
+		row.TaskType,
+		row.StatusCode,
+		row.Success,
+		row.StartedAt,
+		row.FinishedAt,
+	), row.PipelineID, row.JobName, row.Needs, row.Status)
+	if row.Matrix != nil {
+		if err := json.Unmarshal(row.Matrix, &run.Matrix); err != nil {
+			return nil, fmt.Errorf("unmarshal matrix: %w", err)
+		}
+	}
+
+	approval, err := db.Q.GetCIRunApproval(ctx, row.ID)
 	if err != nil {
-		return nil, fmt.Errorf("decompress log: %w", err)
+		return nil, fmt.Errorf("get ci run approval: %w", err)
+	}
+	run.ApprovedBy = approval.ApprovedBy
+	run.ApprovedAt = formatTimestamptz(approval.ApprovedAt)
+
+	testCounts, failedTests, err := getCIRunTests(ctx, req.RepoId, row.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	return &protos.GetCIRunResponse{
-		Run: buildCIRunSummary(
-			row.ID,
-			row.ConfigFilename,
-			row.EventType,
-			row.Rev,
-			row.Pattern,
-			row.Reason,
-			row.TaskType,
-			row.StatusCode,
-			row.Success,
-			row.StartedAt,
-			row.FinishedAt,
-		),
-		Log: string(decompressedLog),
+		Run:         run,
+		Log:         string(log),
+		Pipeline:    pipeline,
+		Artifacts:   artifacts,
+		TestCounts:  testCounts,
+		FailedTests: failedTests,
 	}, nil
 }
 
-func (a *Server) SetSecret(ctx context.Context, req *protos.SetSecretRequest) (*protos.SetSecretResponse, error) {
+// FollowCIRun streams the log of a run as it is written until the run finishes.
+func (a *Server) FollowCIRun(req *protos.FollowCIRunRequest, stream grpc.ServerStreamingServer[protos.FollowCIRunResponse]) error {
+	ctx := stream.Context()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return fmt.Errorf("check repository access: %w", err)
+	}
+
+	if _, err := db.Q.GetCIRun(ctx, req.RepoId, int32(req.RunId)); err != nil {
+		return fmt.Errorf("get ci run: %w", err)
+	}
+
+	return followCIRunLog(ctx, int32(req.RunId), func(data []byte) error {
+		return stream.Send(&protos.FollowCIRunResponse{Data: data})
+	}, func(status string) error {
+		return stream.Send(&protos.FollowCIRunResponse{Status: &status})
+	})
+}
+
+// CancelCIRun cancels the pipeline of a run. Running jobs are stopped and
+// jobs that did not start yet are not run.
+func (a *Server) CancelCIRun(ctx context.Context, req *protos.CancelCIRunRequest) (*protos.CancelCIRunResponse, error) {
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
 
@@ -1727,30 +2311,168 @@ func (a *Server) SetSecret(ctx context.Context, req *protos.SetSecretRequest) (*
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
 
-	if err := db.Q.SetSecret(ctx, req.RepoId, req.Key, req.Value); err != nil {
+	row, err := db.Q.GetCIRun(ctx, req.RepoId, int32(req.RunId))
+	if err != nil {
+		return nil, fmt.Errorf("get ci run: %w", err)
+	}
+	if row.PipelineID == nil {
+		return nil, fmt.Errorf("run %d is not part of a pipeline", row.ID)
+	}
+
+	errMsg := "cancelled"
+	cancelled, err := db.Q.CancelCIPipeline(ctx, &errMsg, *row.PipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("cancel pipeline: %w", err)
+	}
+	if cancelled == 0 {
+		return nil, fmt.Errorf("run %d is not running", row.ID)
+	}
+
+	fmt.Printf("CI pipeline cancelled: repo_id=%d pipeline_id=%d run_id=%d\n", req.RepoId, *row.PipelineID, row.ID)
+	cancelLocalCIPipeline(*row.PipelineID)
+	RevokeCITokens(ctx, *row.PipelineID)
+	notifyCIPipelineFinished(ctx, *row.PipelineID)
+	return &protos.CancelCIRunResponse{}, nil
+}
+
+// RerunCIRun queues the pipeline of a run again, e.g. after a flaky failure.
+func (a *Server) RerunCIRun(ctx context.Context, req *protos.RerunCIRunRequest) (*protos.RerunCIRunResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	pipelineID, err := rerunCIRun(ctx, req.RepoId, int32(req.RunId), *userId)
+	if err != nil {
+		return nil, err
+	}
+	return &protos.RerunCIRunResponse{PipelineId: pipelineID}, nil
+}
+
+// ApproveCIRun lets a run that waits for approval start.
+func (a *Server) ApproveCIRun(ctx context.Context, req *protos.ApproveCIRunRequest) (*protos.ApproveCIRunResponse, error) {
+	userID, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	if err := approveCIRun(ctx, req.RepoId, int32(req.RunId), *userID); err != nil {
+		return nil, err
+	}
+	return &protos.ApproveCIRunResponse{}, nil
+}
+
+func (a *Server) RunCI(ctx context.Context, req *protos.RunCIRequest) (*protos.RunCIResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	changeId := req.CheckedOutChangeId
+	if req.Rev != nil {
+		changeId, err = db.Q.FindChangeByNameFuzzyUnique(ctx, req.RepoId, *req.Rev)
+		if err != nil {
+			return nil, fmt.Errorf("find change by name: %w", err)
+		}
+	}
+	change, err := db.Q.GetChange(ctx, changeId)
+	if err != nil {
+		return nil, fmt.Errorf("get change: %w", err)
+	}
+	rev := change.Name
+	var bookmark string
+	if req.Rev != nil {
+		bookmark = manualCIBookmark(ctx, req.RepoId, *req.Rev, changeId)
+	}
+
+	configFiles, err := getCIConfigFiles(ctx, changeId)
+	if err != nil {
+		return nil, err
+	}
+	configName, ok := resolveCIConfigName(configFiles, req.ConfigFilename)
+	if !ok {
+		return nil, fmt.Errorf("CI config %s not found in %s", req.ConfigFilename, rev)
+	}
+
+	secretScope, err := getCISecretKeys(ctx, req.RepoId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the trigger, inputs and environment here so mistakes are
+	// reported to the caller
+	event := ci.Event{
+		Type:     ci.EventTypeManual,
+		Rev:      rev,
+		Bookmark: bookmark,
+		Inputs:   req.Inputs,
+	}
+	config, _, err := ci.UnmarshalConfigWithScope(configFiles[configName], event, secretScope)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal config %s: %w", configName, err)
+	}
+	if _, ok := config.Trigger(event); !ok {
+		return nil, fmt.Errorf("CI config %s does not allow manual runs", configName)
+	}
+	if _, err := config.On.Manual.ResolveInputs(req.Inputs); err != nil {
+		return nil, fmt.Errorf("CI config %s: %w", configName, err)
+	}
+
+	enqueueCI(ctx, changeId, event, configName, ciTrigger{userID: userId})
+
+	return &protos.RunCIResponse{ConfigFilename: configName, Rev: rev}, nil
+}
+
+func (a *Server) SetSecret(ctx context.Context, req *protos.SetSecretRequest) (*protos.SetSecretResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	if err := checkEnvironmentExists(ctx, req.RepoId, req.Environment); err != nil {
+		return nil, err
+	}
+	if err := setSecret(ctx, req.RepoId, req.Environment, req.Key, req.Value); err != nil {
 		return nil, fmt.Errorf("set secret: %w", err)
 	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "secret", "set", secretSettingValue(req.Environment, req.Key))
 
 	return &protos.SetSecretResponse{}, nil
 }
 
+// GetSecret returns the value of a secret. Secrets are write-only, only
+// admins may read their values.
 func (a *Server) GetSecret(ctx context.Context, req *protos.GetSecretRequest) (*protos.GetSecretResponse, error) {
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
 
-	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
-	if err != nil {
-		return nil, fmt.Errorf("check repository access: %w", err)
+	if _, err := checkAdminFromAuth(ctx, req.Auth); err != nil {
+		return nil, fmt.Errorf("secrets are write-only, reading them requires an admin: %w", err)
 	}
 
-	value, err := db.Q.GetSecret(ctx, req.RepoId, req.Key)
+	ciphertext, err := db.Q.GetSecret(ctx, req.RepoId, req.Environment, req.Key)
 	if err != nil {
 		return nil, fmt.Errorf("get secret: %w", err)
 	}
+	value, err := decryptSecret(req.RepoId, req.Environment, req.Key, ciphertext)
+	if err != nil {
+		return nil, err
+	}
 
 	return &protos.GetSecretResponse{Value: value}, nil
 }
 
+// GetAllSecrets lists the keys of the secrets of a repository, including the
+// org secrets it inherits.
 func (a *Server) GetAllSecrets(ctx context.Context, req *protos.GetAllSecretsRequest) (*protos.GetAllSecretsResponse, error) {
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
@@ -1760,17 +2482,28 @@ func (a *Server) GetAllSecrets(ctx context.Context, req *protos.GetAllSecretsReq
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
 
-	secrets, err := db.Q.GetAllSecrets(ctx, req.RepoId)
+	keys, err := db.Q.GetSecretKeys(ctx, req.RepoId)
 	if err != nil {
 		return nil, fmt.Errorf("get all secrets: %w", err)
 	}
+	orgKeys, err := db.Q.GetOrgSecretKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get org secrets: %w", err)
+	}
 
-	protoSecrets := make([]*protos.Secret, len(secrets))
-	for i, s := range secrets {
-		protoSecrets[i] = &protos.Secret{
-			Key:   s.Key,
-			Value: s.Value,
-		}
+	protoSecrets := make([]*protos.Secret, 0, len(keys)+len(orgKeys))
+	for _, key := range keys {
+		protoSecrets = append(protoSecrets, &protos.Secret{
+			Key:         key.Key,
+			Environment: key.Environment,
+		})
+	}
+	for _, key := range orgKeys {
+		protoSecrets = append(protoSecrets, &protos.Secret{
+			Key:         key.Key,
+			Environment: key.Environment,
+			Org:         true,
+		})
 	}
 
 	return &protos.GetAllSecretsResponse{Secrets: protoSecrets}, nil
@@ -1780,14 +2513,292 @@ func (a *Server) DeleteSecret(ctx context.Context, req *protos.DeleteSecretReque
 	gcMutex.RLock()
 	defer gcMutex.RUnlock()
 
-	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
 	if err != nil {
 		return nil, fmt.Errorf("check repository access: %w", err)
 	}
 
-	if err := db.Q.DeleteSecret(ctx, req.RepoId, req.Key); err != nil {
+	if err := db.Q.DeleteSecret(ctx, req.RepoId, req.Environment, req.Key); err != nil {
 		return nil, fmt.Errorf("delete secret: %w", err)
 	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "secret", "deleted", secretSettingValue(req.Environment, req.Key))
 
 	return &protos.DeleteSecretResponse{}, nil
 }
+
+// SetEnvironment creates or updates an environment of a repository.
+func (a *Server) SetEnvironment(ctx context.Context, req *protos.SetEnvironmentRequest) (*protos.SetEnvironmentResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	if err := setEnvironment(ctx, req.RepoId, req.Environment.GetName(), req.Environment.GetBookmarks()); err != nil {
+		return nil, err
+	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "environment", "set", req.Environment.GetName())
+
+	return &protos.SetEnvironmentResponse{}, nil
+}
+
+// GetEnvironments lists the environments of a repository.
+func (a *Server) GetEnvironments(ctx context.Context, req *protos.GetEnvironmentsRequest) (*protos.GetEnvironmentsResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	environments, err := db.Q.GetEnvironments(ctx, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("get environments: %w", err)
+	}
+
+	protoEnvironments := make([]*protos.Environment, len(environments))
+	for i, e := range environments {
+		protoEnvironments[i] = &protos.Environment{
+			Name:      e.Name,
+			Bookmarks: e.Bookmarks,
+		}
+	}
+
+	return &protos.GetEnvironmentsResponse{Environments: protoEnvironments}, nil
+}
+
+// DeleteEnvironment deletes an environment of a repository with its secrets.
+func (a *Server) DeleteEnvironment(ctx context.Context, req *protos.DeleteEnvironmentRequest) (*protos.DeleteEnvironmentResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	if err := deleteEnvironment(ctx, req.RepoId, req.Name); err != nil {
+		return nil, err
+	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "environment", "deleted", req.Name)
+
+	return &protos.DeleteEnvironmentResponse{}, nil
+}
+
+// SetOrgSecret sets a server-wide secret inherited by all repositories.
+func (a *Server) SetOrgSecret(ctx context.Context, req *protos.SetOrgSecretRequest) (*protos.SetOrgSecretResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	if _, err := checkAdminFromAuth(ctx, req.Auth); err != nil {
+		return nil, fmt.Errorf("check admin: %w", err)
+	}
+
+	if err := setOrgSecret(ctx, req.Environment, req.Key, req.Value); err != nil {
+		return nil, fmt.Errorf("set org secret: %w", err)
+	}
+
+	return &protos.SetOrgSecretResponse{}, nil
+}
+
+// GetAllOrgSecrets lists the keys of the server-wide secrets.
+func (a *Server) GetAllOrgSecrets(ctx context.Context, req *protos.GetAllOrgSecretsRequest) (*protos.GetAllOrgSecretsResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	if _, err := checkAdminFromAuth(ctx, req.Auth); err != nil {
+		return nil, fmt.Errorf("check admin: %w", err)
+	}
+
+	keys, err := db.Q.GetOrgSecretKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get org secrets: %w", err)
+	}
+
+	protoSecrets := make([]*protos.Secret, len(keys))
+	for i, key := range keys {
+		protoSecrets[i] = &protos.Secret{
+			Key:         key.Key,
+			Environment: key.Environment,
+			Org:         true,
+		}
+	}
+
+	return &protos.GetAllOrgSecretsResponse{Secrets: protoSecrets}, nil
+}
+
+// DeleteOrgSecret deletes a server-wide secret.
+func (a *Server) DeleteOrgSecret(ctx context.Context, req *protos.DeleteOrgSecretRequest) (*protos.DeleteOrgSecretResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	if _, err := checkAdminFromAuth(ctx, req.Auth); err != nil {
+		return nil, fmt.Errorf("check admin: %w", err)
+	}
+
+	if err := db.Q.DeleteOrgSecret(ctx, req.Environment, req.Key); err != nil {
+		return nil, fmt.Errorf("delete org secret: %w", err)
+	}
+
+	return &protos.DeleteOrgSecretResponse{}, nil
+}
+
+// RotateSecretsKey re-encrypts all secrets with a new key.
+func (a *Server) RotateSecretsKey(ctx context.Context, req *protos.RotateSecretsKeyRequest) (*protos.RotateSecretsKeyResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	user, err := checkAdminFromAuth(ctx, req.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("check admin: %w", err)
+	}
+
+	count, keyId, err := rotateSecretsKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rotate secrets key: %w", err)
+	}
+	fmt.Printf("Secrets key rotated: user=%s key_id=%s secrets=%d\n", user.Username, keyId, count)
+
+	return &protos.RotateSecretsKeyResponse{ReencryptedSecrets: int32(count), KeyId: keyId}, nil
+}
+
+// CreateWebhook adds a webhook to a repository. Without a secret, a random
+// secret is generated and returned once.
+func (a *Server) CreateWebhook(ctx context.Context, req *protos.CreateWebhookRequest) (*protos.CreateWebhookResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	secret := req.Secret
+	var generatedSecret string
+	if secret == "" {
+		token, err := generateSecureToken()
+		if err != nil {
+			return nil, fmt.Errorf("generate webhook secret: %w", err)
+		}
+		secret = base64.RawURLEncoding.EncodeToString(token)
+		generatedSecret = secret
+	}
+
+	id, err := createWebhook(ctx, req.RepoId, req.Url, req.Events, secret, req.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "webhook", "created", req.Url)
+
+	return &protos.CreateWebhookResponse{Id: id, Secret: generatedSecret}, nil
+}
+
+// ListWebhooks lists the webhooks of a repository.
+func (a *Server) ListWebhooks(ctx context.Context, req *protos.ListWebhooksRequest) (*protos.ListWebhooksResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	hooks, err := db.Q.GetWebhooks(ctx, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("get webhooks: %w", err)
+	}
+
+	protoHooks := make([]*protos.Webhook, len(hooks))
+	for i, hook := range hooks {
+		protoHooks[i] = &protos.Webhook{
+			Id:          hook.ID,
+			Url:         hook.Url,
+			Events:      hook.Events,
+			MaxAttempts: hook.MaxAttempts,
+			CreatedAt:   formatTimestamptz(hook.CreatedAt),
+		}
+	}
+
+	return &protos.ListWebhooksResponse{Webhooks: protoHooks}, nil
+}
+
+// DeleteWebhook deletes a webhook with its deliveries.
+func (a *Server) DeleteWebhook(ctx context.Context, req *protos.DeleteWebhookRequest) (*protos.DeleteWebhookResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	userId, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	deleted, err := db.Q.DeleteWebhook(ctx, req.RepoId, req.Id)
+	if err != nil {
+		return nil, fmt.Errorf("delete webhook: %w", err)
+	}
+	if deleted == 0 {
+		return nil, fmt.Errorf("webhook %d not found", req.Id)
+	}
+	notifySettingsChanged(ctx, req.RepoId, userId, "webhook", "deleted", strconv.Itoa(int(req.Id)))
+
+	return &protos.DeleteWebhookResponse{}, nil
+}
+
+// ListWebhookDeliveries lists the latest deliveries of a webhook.
+func (a *Server) ListWebhookDeliveries(ctx context.Context, req *protos.ListWebhookDeliveriesRequest) (*protos.ListWebhookDeliveriesResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	deliveries, err := db.Q.GetWebhookDeliveries(ctx, req.RepoId, req.WebhookId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get webhook deliveries: %w", err)
+	}
+
+	protoDeliveries := make([]*protos.WebhookDelivery, len(deliveries))
+	for i, d := range deliveries {
+		protoDeliveries[i] = &protos.WebhookDelivery{
+			Id:           d.ID,
+			Event:        d.Event,
+			State:        d.State,
+			Attempts:     d.Attempts,
+			StatusCode:   d.StatusCode,
+			Error:        d.Error,
+			Response:     d.Response,
+			CreatedAt:    formatTimestamptz(d.CreatedAt),
+			FinishedAt:   formatTimestamptz(d.FinishedAt),
+			RedeliveryOf: d.RedeliveryOf,
+		}
+	}
+
+	return &protos.ListWebhookDeliveriesResponse{Deliveries: protoDeliveries}, nil
+}
+
+// RedeliverWebhook queues the payload of a delivery again.
+func (a *Server) RedeliverWebhook(ctx context.Context, req *protos.RedeliverWebhookRequest) (*protos.RedeliverWebhookResponse, error) {
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	_, err := checkRepositoryAccessFromAuth(ctx, req.Auth, req.RepoId)
+	if err != nil {
+		return nil, fmt.Errorf("check repository access: %w", err)
+	}
+
+	id, err := redeliverWebhook(ctx, req.RepoId, req.DeliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.RedeliverWebhookResponse{DeliveryId: id}, nil
+}
@@ -9,11 +9,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/a-h/templ"
@@ -23,6 +25,7 @@ import (
 	"github.com/pogo-vcs/pogo/brand"
 	"github.com/pogo-vcs/pogo/db"
 	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/secrets"
 	"github.com/pogo-vcs/pogo/server/ci"
 	"github.com/pogo-vcs/pogo/server/public"
 	"github.com/pogo-vcs/pogo/server/webui"
@@ -90,7 +93,7 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 			// If regular token validation failed, try CI token
 			// CI tokens are stored directly in context without a fake user
-			if ciTokenInfo := ValidateCIToken(token); ciTokenInfo != nil {
+			if ciTokenInfo := ValidateCIToken(r.Context(), token); ciTokenInfo != nil {
 				ctx := context.WithValue(r.Context(), CITokenCtxKey, ciTokenInfo)
 				r = r.WithContext(ctx)
 			}
@@ -107,16 +110,10 @@ func GetCITokenInfo(ctx context.Context) *CITokenInfo {
 	return nil
 }
 
-// CheckRepoAccess checks if the request has access to the given repository.
-// It handles both regular user authentication and CI token authentication.
-// Returns true if access is granted, false otherwise.
+// CheckRepoAccess checks if the user of the request has access to the given
+// repository. CI tokens do not grant repository access, their permissions are
+// checked with CheckCITokenPermission.
 func CheckRepoAccess(ctx context.Context, repoID int32) bool {
-	// First check for CI token - CI tokens are scoped to a specific repository
-	if ciToken := GetCITokenInfo(ctx); ciToken != nil {
-		return ciToken.RepoID == repoID
-	}
-
-	// Check for regular user authentication
 	userInterface := ctx.Value(auth.UserCtxKey)
 	if userInterface == nil {
 		return false
@@ -130,13 +127,16 @@ func CheckRepoAccess(ctx context.Context, repoID int32) bool {
 	return err == nil && hasAccess
 }
 
-// IsAuthenticated checks if there's any form of authentication (user or CI token).
+// CheckCITokenPermission checks if the request uses a CI token of the given
+// repository that grants the permission.
+func CheckCITokenPermission(ctx context.Context, repoID int32, permission ci.TokenPermission) bool {
+	ciToken := GetCITokenInfo(ctx)
+	return ciToken != nil && ciToken.Allows(repoID, permission)
+}
+
+// IsAuthenticated checks if the request is authenticated as a user. CI tokens
+// are not, they only grant their permissions.
 func IsAuthenticated(ctx context.Context) bool {
-	// Check for CI token
-	if GetCITokenInfo(ctx) != nil {
-		return true
-	}
-	// Check for user
 	if userInterface := ctx.Value(auth.UserCtxKey); userInterface != nil {
 		if user, ok := userInterface.(*db.User); ok && user != nil {
 			return true
@@ -146,6 +146,7 @@ func IsAuthenticated(ctx context.Context) bool {
 }
 
 func RegisterWebUI(s *Server) {
+	webui.SecretValues = ciSecretValues
 	s.httpMux.HandleFunc("/", authMiddleware(rootHandler(webui.Repositories())))
 	s.httpMux.HandleFunc("/favicon.svg", brand.LogoHandler)
 	s.httpMux.HandleFunc("/public/{file}", public.Handle)
@@ -154,10 +155,14 @@ func RegisterWebUI(s *Server) {
 	s.httpMux.HandleFunc("/repository/{id}/settings", authMiddleware(templComponentToHandler(webui.Settings())))
 	s.httpMux.HandleFunc("/repository/{id}/ci", authMiddleware(templComponentToHandler(webui.CIRuns())))
 	s.httpMux.HandleFunc("/repository/{id}/ci/{runId}", authMiddleware(templComponentToHandler(webui.CIRunDetail())))
+	s.httpMux.HandleFunc("/repository/{id}/ci/{runId}/log/stream", authMiddleware(handleCIRunLogStream))
+	s.httpMux.HandleFunc("/repository/{id}/ci/{runId}/artifacts/{name...}", authMiddleware(handleCIArtifactDownload))
 	s.httpMux.HandleFunc("/repository/{repo}/archive/{rev}", authMiddleware(handleZipDownload))
+	s.httpMux.HandleFunc("/repository/{repo}/badge/{badge...}", authMiddleware(handleCIBadge))
 	s.httpMux.HandleFunc("/objects/{hash}/", handleObjectServe)
 	s.httpMux.HandleFunc("/v1/objects/{hash}", authMiddleware(handleObjectUpload))
 	s.httpMux.HandleFunc("/assets/", authMiddleware(handleAssets))
+	s.httpMux.HandleFunc("/api/ci/status", authMiddleware(handleCIStatusReport))
 
 	// Auth routes
 	s.httpMux.HandleFunc("/login", authMiddleware(templComponentToHandler(webui.Login())))
@@ -177,6 +182,13 @@ func RegisterWebUI(s *Server) {
 	s.httpMux.HandleFunc("/api/repository/{id}/delete", authMiddleware(handleDeleteRepository))
 	s.httpMux.HandleFunc("/api/repository/{id}/secrets/set", authMiddleware(handleSetSecret))
 	s.httpMux.HandleFunc("/api/repository/{id}/secrets/delete", authMiddleware(handleDeleteSecret))
+	s.httpMux.HandleFunc("/api/repository/{id}/environments/set", authMiddleware(handleSetEnvironment))
+	s.httpMux.HandleFunc("/api/repository/{id}/environments/delete", authMiddleware(handleDeleteEnvironment))
+	s.httpMux.HandleFunc("/api/repository/{id}/webhooks/create", authMiddleware(handleCreateWebhook))
+	s.httpMux.HandleFunc("/api/repository/{id}/webhooks/delete", authMiddleware(handleDeleteWebhook))
+	s.httpMux.HandleFunc("/api/repository/{id}/webhooks/redeliver", authMiddleware(handleRedeliverWebhook))
+	s.httpMux.HandleFunc("/api/repository/{id}/ci/{runId}/rerun", authMiddleware(handleRerunCIRun))
+	s.httpMux.HandleFunc("/api/repository/{id}/ci/{runId}/approve", authMiddleware(handleApproveCIRun))
 }
 
 func newGoProxy() *goproxy.Goproxy {
@@ -423,7 +435,7 @@ func handleZipDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !repository.Public {
-		if !CheckRepoAccess(ctx, repository.ID) {
+		if !CheckRepoAccess(ctx, repository.ID) && !CheckCITokenPermission(ctx, repository.ID, ci.TokenReadArchive) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -472,7 +484,13 @@ func handleZipDownload(w http.ResponseWriter, r *http.Request) {
 			}
 
 			zipEntryName := vcsFile.Name
-			fileWriter, err := zipWriter.Create(zipEntryName)
+			header := &zip.FileHeader{Name: zipEntryName, Method: zip.Deflate}
+			if vcsFile.Executable {
+				header.SetMode(0755)
+			} else {
+				header.SetMode(0644)
+			}
+			fileWriter, err := zipWriter.CreateHeader(header)
 			if err != nil {
 				f.Close()
 				log.Printf("Failed to create zip entry %q: %s", zipEntryName, err.Error())
@@ -537,6 +555,7 @@ func handleRenameRepository(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to rename repository: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "name", "changed", newName)
 
 	// Redirect back to settings page
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
@@ -590,6 +609,7 @@ func handleGrantAccess(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to grant access: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "access", "granted", username)
 
 	// Redirect back to settings page
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
@@ -649,11 +669,134 @@ func handleRevokeAccess(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to revoke access: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "access", "revoked", username)
 
 	// Redirect back to settings page
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
 }
 
+// handleCIRunLogStream sends the log of a CI run as server-sent events while
+// it is written. Each "log" event carries a JSON encoded piece of the log, the
+// final "done" event carries the JSON encoded status of the run.
+func handleCIRunLogStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	repoId, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+	runId, err := strconv.ParseInt(r.PathValue("runId"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	repo, err := db.Q.GetRepository(ctx, int32(repoId))
+	if err != nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	// Like on the run page, logs of public repositories are shown to everyone logged in
+	if !CheckRepoAccess(ctx, repo.ID) && !(repo.Public && IsAuthenticated(ctx)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if _, err := db.Q.GetCIRun(ctx, repo.ID, int32(runId)); err != nil {
+		http.Error(w, "CI run not found", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Secrets are only decrypted once there is output to mask
+	secretValues := sync.OnceValues(func() ([]string, error) {
+		return ciSecretValues(ctx, repo.ID)
+	})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sendEvent := func(event, data string) error {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	err = followCIRunLog(ctx, int32(runId), func(data []byte) error {
+		values, err := secretValues()
+		if err != nil {
+			return fmt.Errorf("get secrets: %w", err)
+		}
+		return sendEvent("log", secrets.Hide(string(data), values))
+	}, func(status string) error {
+		return sendEvent("done", status)
+	})
+	if err != nil && ctx.Err() == nil {
+		fmt.Printf("CI log stream error: run_id=%d detail=%v\n", runId, err)
+	}
+}
+
+// handleCIArtifactDownload sends an artifact of a CI run as an attachment.
+func handleCIArtifactDownload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	repoId, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+	runId, err := strconv.ParseInt(r.PathValue("runId"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	repo, err := db.Q.GetRepository(ctx, int32(repoId))
+	if err != nil {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	if !CheckRepoAccess(ctx, repo.ID) && !(repo.Public && IsAuthenticated(ctx)) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	gcMutex.RLock()
+	defer gcMutex.RUnlock()
+
+	artifact, err := db.Q.GetCIArtifact(ctx, repo.ID, int32(runId), r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := filecontents.OpenFileByHash(base64.URLEncoding.EncodeToString(artifact.ContentHash))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open artifact: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(artifact.Name)}))
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	_, _ = io.Copy(w, f)
+}
+
 func handleCISchemas(w http.ResponseWriter, r *http.Request) {
 	// Extract the schema filename from the URL path
 	schemaFile := r.PathValue("schema")
@@ -1018,6 +1161,7 @@ func handleSetRepositoryVisibility(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to update repository visibility: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "visibility", "changed", visibilityName(public))
 
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
 }
@@ -1117,15 +1261,21 @@ func handleSetSecret(w http.ResponseWriter, r *http.Request) {
 	}
 	key := r.FormValue("key")
 	value := r.FormValue("value")
+	environment := strings.TrimSpace(r.FormValue("environment"))
 	if key == "" || value == "" {
 		http.Error(w, "Key and value are required", http.StatusBadRequest)
 		return
 	}
 
-	if err := db.Q.SetSecret(ctx, int32(repoId), key, value); err != nil {
+	if err := checkEnvironmentExists(ctx, int32(repoId), environment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := setSecret(ctx, int32(repoId), environment, key, value); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to set secret: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "secret", "set", secretSettingValue(environment, key))
 
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
 }
@@ -1171,10 +1321,351 @@ func handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := db.Q.DeleteSecret(ctx, int32(repoId), key); err != nil {
+	environment := r.FormValue("environment")
+	if err := db.Q.DeleteSecret(ctx, int32(repoId), environment, key); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete secret: %v", err), http.StatusInternalServerError)
 		return
 	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "secret", "deleted", secretSettingValue(environment, key))
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
+}
+
+func handleSetEnvironment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoIdStr := r.PathValue("id")
+	repoId, err := strconv.ParseInt(repoIdStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	var bookmarks []string
+	for _, bookmark := range strings.Split(r.FormValue("bookmarks"), ",") {
+		if bookmark = strings.TrimSpace(bookmark); bookmark != "" {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+
+	name := r.FormValue("name")
+	if err := setEnvironment(ctx, int32(repoId), name, bookmarks); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set environment: %v", err), http.StatusBadRequest)
+		return
+	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "environment", "set", name)
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
+}
+
+func handleDeleteEnvironment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoIdStr := r.PathValue("id")
+	repoId, err := strconv.ParseInt(repoIdStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := deleteEnvironment(ctx, int32(repoId), name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete environment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "environment", "deleted", name)
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
+}
+
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoIdStr := r.PathValue("id")
+	repoId, err := strconv.ParseInt(repoIdStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	var maxAttempts int64
+	if s := r.FormValue("max_attempts"); s != "" {
+		if maxAttempts, err = strconv.ParseInt(s, 10, 32); err != nil {
+			http.Error(w, "Invalid max attempts", http.StatusBadRequest)
+			return
+		}
+	}
+	webhookURL := strings.TrimSpace(r.FormValue("url"))
+
+	if _, err := createWebhook(ctx, int32(repoId), webhookURL, r.Form["events"], r.FormValue("secret"), int32(maxAttempts)); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "webhook", "created", webhookURL)
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
+}
+
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoIdStr := r.PathValue("id")
+	repoId, err := strconv.ParseInt(repoIdStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	webhookId, err := strconv.ParseInt(r.FormValue("webhook_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := db.Q.DeleteWebhook(ctx, int32(repoId), int32(webhookId))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete webhook: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	notifySettingsChanged(ctx, int32(repoId), &user.ID, "webhook", "deleted", strconv.FormatInt(webhookId, 10))
 
 	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
 }
+
+func handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoIdStr := r.PathValue("id")
+	repoId, err := strconv.ParseInt(repoIdStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	deliveryId, err := strconv.ParseInt(r.FormValue("delivery_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := redeliverWebhook(ctx, int32(repoId), deliveryId); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to redeliver webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/settings", repoId), http.StatusSeeOther)
+}
+
+func handleRerunCIRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoId, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+	runId, err := strconv.ParseInt(r.PathValue("runId"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	hasAccess, err := db.Q.CheckUserRepositoryAccess(ctx, int32(repoId), user.ID)
+	if err != nil || !hasAccess {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := rerunCIRun(ctx, int32(repoId), int32(runId), user.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to rerun CI run: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/ci", repoId), http.StatusSeeOther)
+}
+
+func handleApproveCIRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	userInterface := ctx.Value(auth.UserCtxKey)
+	if userInterface == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	user, ok := userInterface.(*db.User)
+	if !ok || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoId, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid repository ID", http.StatusBadRequest)
+		return
+	}
+	runId, err := strconv.ParseInt(r.PathValue("runId"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := approveCIRun(ctx, int32(repoId), int32(runId), user.ID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to approve CI run: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/repository/%d/ci/%d", repoId, runId), http.StatusSeeOther)
+}
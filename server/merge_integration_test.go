@@ -0,0 +1,396 @@
+//go:build fakekeyring
+
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pogo-vcs/pogo/client"
+	"github.com/pogo-vcs/pogo/protos"
+)
+
+// mergeTestRepo is a working copy of a repository for building change graphs
+// in merge tests. Every operation opens its own client, like the CLI does.
+type mergeTestRepo struct {
+	t   *testing.T
+	ctx context.Context
+	dir string
+}
+
+func newMergeTestRepo(t *testing.T, ctx context.Context, serverAddr, name string) *mergeTestRepo {
+	t.Helper()
+	dir := t.TempDir()
+	repoName := fmt.Sprintf("test-merge-%s-%d", name, time.Now().UnixNano())
+	if _, _, err := initializeRepository(ctx, dir, repoName, serverAddr); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	return &mergeTestRepo{t: t, ctx: ctx, dir: dir}
+}
+
+func (r *mergeTestRepo) open() *client.Client {
+	r.t.Helper()
+	c, err := client.OpenFromFile(r.ctx, r.dir)
+	if err != nil {
+		r.t.Fatalf("Failed to open client: %v", err)
+	}
+	return c
+}
+
+// push writes the files to the working copy and pushes them to the checked
+// out change. An empty content removes the file.
+func (r *mergeTestRepo) push(files map[string]string) {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.dir, name)
+		if content == "" {
+			if err := os.Remove(path); err != nil {
+				r.t.Fatalf("Failed to remove %s: %v", name, err)
+			}
+			continue
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			r.t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := pushFiles(r.ctx, r.dir); err != nil {
+		r.t.Fatalf("Failed to push files: %v", err)
+	}
+}
+
+// pushSymlinks points the symlinks in the working copy at the given targets
+// and pushes them to the checked out change.
+func (r *mergeTestRepo) pushSymlinks(links map[string]string) {
+	r.t.Helper()
+	for name, target := range links {
+		path := filepath.Join(r.dir, name)
+		_ = os.Remove(path)
+		if err := os.Symlink(target, path); err != nil {
+			r.t.Fatalf("Failed to create symlink %s: %v", name, err)
+		}
+	}
+	if err := pushFiles(r.ctx, r.dir); err != nil {
+		r.t.Fatalf("Failed to push files: %v", err)
+	}
+}
+
+// newChange creates a change with the given parents and checks it out.
+func (r *mergeTestRepo) newChange(parents ...string) string {
+	r.t.Helper()
+	c := r.open()
+	_, name, err := c.NewChange(nil, parents)
+	c.Close()
+	if err != nil {
+		r.t.Fatalf("Failed to create change with parents %v: %v", parents, err)
+	}
+	r.edit(name)
+	return name
+}
+
+func (r *mergeTestRepo) edit(name string) {
+	r.t.Helper()
+	c := r.open()
+	defer c.Close()
+	if err := c.Edit(name); err != nil {
+		r.t.Fatalf("Failed to edit %s: %v", name, err)
+	}
+}
+
+func (r *mergeTestRepo) current() *protos.InfoResponse {
+	r.t.Helper()
+	c := r.open()
+	defer c.Close()
+	info, err := c.Info()
+	if err != nil {
+		r.t.Fatalf("Failed to get info: %v", err)
+	}
+	return info
+}
+
+func (r *mergeTestRepo) conflicts() []*protos.Conflict {
+	r.t.Helper()
+	c := r.open()
+	defer c.Close()
+	conflicts, err := c.GetConflicts(nil, protos.ConflictStyle_CONFLICT_STYLE_GIT, false)
+	if err != nil {
+		r.t.Fatalf("Failed to get conflicts: %v", err)
+	}
+	return conflicts
+}
+
+// assertFiles checks the files of the working copy, an empty content means
+// the file must not exist.
+func (r *mergeTestRepo) assertFiles(want map[string]string) {
+	r.t.Helper()
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(r.dir, name))
+		if content == "" {
+			if !os.IsNotExist(err) {
+				r.t.Errorf("%s exists after merge, want it removed", name)
+			}
+			continue
+		}
+		if err != nil {
+			r.t.Errorf("Failed to read %s: %v", name, err)
+		} else if string(got) != content {
+			r.t.Errorf("%s = %q, want %q", name, string(got), content)
+		}
+	}
+}
+
+func lines(l ...string) string {
+	return strings.Join(l, "\n") + "\n"
+}
+
+// TestMergeParents tests merges of two and more parents, including the choice
+// of the common ancestor each parent is merged against.
+func TestMergeParents(t *testing.T) {
+	env := setupTestEnvironment(t, "")
+	defer env.cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	base := lines("1", "2", "3", "4", "5", "6", "7", "8", "9")
+
+	t.Run("TwoParents", func(t *testing.T) {
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "two")
+		r.push(map[string]string{"a.txt": base, "c.txt": "removed\n"})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "9"), "c.txt": ""})
+		y := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine"), "b.txt": "added\n"})
+
+		r.newChange(x, y)
+		r.assertFiles(map[string]string{
+			"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "nine"),
+			"b.txt": "added\n",
+			"c.txt": "",
+		})
+		if info := r.current(); info.IsInConflict {
+			t.Errorf("clean merge is in conflict")
+		}
+	})
+
+	t.Run("ThreeParents", func(t *testing.T) {
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "three")
+		r.push(map[string]string{"a.txt": base})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "9")})
+		y := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "five", "6", "7", "8", "9")})
+		z := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine"), "z.txt": "z\n"})
+
+		r.newChange(x, y, z)
+		r.assertFiles(map[string]string{
+			"a.txt": lines("one", "2", "3", "4", "five", "6", "7", "8", "nine"),
+			"z.txt": "z\n",
+		})
+		if info := r.current(); info.IsInConflict {
+			t.Errorf("clean merge is in conflict")
+		}
+	})
+
+	t.Run("ClosestCommonAncestor", func(t *testing.T) {
+		// z is merged against d, its common ancestor with y. Merging it
+		// against root, its common ancestor with x, conflicts on line 2.
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "closest")
+		r.push(map[string]string{"a.txt": base})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine")})
+		d := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "two", "3", "4", "5", "6", "7", "8", "9")})
+		y := r.newChange(d)
+		r.push(map[string]string{"b.txt": "y\n"})
+		z := r.newChange(d)
+		r.push(map[string]string{"a.txt": lines("1", "TWO", "3", "4", "5", "6", "7", "8", "9")})
+
+		r.newChange(x, y, z)
+		r.assertFiles(map[string]string{
+			"a.txt": lines("1", "TWO", "3", "4", "5", "6", "7", "8", "nine"),
+			"b.txt": "y\n",
+		})
+		if conflicts := r.conflicts(); len(conflicts) != 0 {
+			t.Errorf("conflicts = %v, want none", conflicts)
+		}
+	})
+
+	t.Run("CrissCross", func(t *testing.T) {
+		// m1 and m2 both merge x and y, so x and y are both closest common
+		// ancestors of their children
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "crisscross")
+		r.push(map[string]string{"a.txt": base})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "9")})
+		y := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine")})
+
+		m1 := r.newChange(x, y)
+		r.newChange(m1)
+		r.push(map[string]string{"a.txt": lines("one", "2", "three", "4", "5", "6", "7", "8", "nine")})
+		x2 := r.current().ChangeName
+
+		m2 := r.newChange(y, x)
+		r.newChange(m2)
+		r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "seven", "8", "nine")})
+		y2 := r.current().ChangeName
+
+		r.newChange(x2, y2)
+		r.assertFiles(map[string]string{
+			"a.txt": lines("one", "2", "three", "4", "5", "6", "seven", "8", "nine"),
+		})
+		if info := r.current(); info.IsInConflict {
+			t.Errorf("criss-cross merge is in conflict")
+		}
+	})
+
+	t.Run("ConflictInSecondStep", func(t *testing.T) {
+		// x and y merge cleanly, z conflicts with x on line 1
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "secondstep")
+		r.push(map[string]string{"a.txt": base})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "9")})
+		y := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine")})
+		z := r.newChange(root)
+		r.push(map[string]string{"a.txt": lines("ONE", "2", "3", "4", "5", "6", "7", "8", "9")})
+
+		r.newChange(x, y, z)
+		if info := r.current(); !info.IsInConflict {
+			t.Errorf("merge with conflicting third parent is not in conflict")
+		}
+
+		content, err := os.ReadFile(filepath.Join(r.dir, "a.txt"))
+		if err != nil {
+			t.Fatalf("Failed to read a.txt: %v", err)
+		}
+		for _, want := range []string{"<<<<<<< " + x + "+" + y, "one", "ONE", ">>>>>>> " + z, "nine"} {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("a.txt does not contain %q:\n%s", want, content)
+			}
+		}
+
+		conflicts := r.conflicts()
+		if len(conflicts) != 1 || conflicts[0].Name != "a.txt" {
+			t.Fatalf("conflicts = %v, want a.txt", conflicts)
+		}
+		if !conflicts[0].Structured || len(conflicts[0].Parents) != 3 {
+			t.Errorf("conflict is structured %v with %d parents, want structured with 3", conflicts[0].Structured, len(conflicts[0].Parents))
+		}
+
+		// The sides are labeled by parent so one can be picked, but there
+		// is no single three-way merge to materialize
+		c := r.open()
+		defer c.Close()
+		withContents, err := c.GetConflicts(nil, protos.ConflictStyle_CONFLICT_STYLE_DIFF3, true)
+		if err != nil {
+			t.Fatalf("Failed to get conflicts with contents: %v", err)
+		}
+		conflict := withContents[0]
+		for i, want := range []string{x, y, z} {
+			if i < len(conflict.Parents) && conflict.Parents[i].Label != want {
+				t.Errorf("parent %d is labeled %s, want %s", i, conflict.Parents[i].Label, want)
+			}
+		}
+		if conflict.Materialized != nil || conflict.UnmaterializedReason == "" {
+			t.Errorf("conflict between 3 parents is materialized %v with reason %q, want a reason only", conflict.Materialized != nil, conflict.UnmaterializedReason)
+		}
+	})
+
+	t.Run("SymlinkConflict", func(t *testing.T) {
+		// Our version of a symlink changed differently by both parents is
+		// kept and both targets are recorded with the conflict
+		r := newMergeTestRepo(t, ctx, env.serverAddr, "symlink")
+		r.push(map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"})
+		r.pushSymlinks(map[string]string{"link": "a.txt"})
+		root := r.current().ChangeName
+
+		x := r.newChange(root)
+		r.pushSymlinks(map[string]string{"link": "b.txt"})
+		y := r.newChange(root)
+		r.pushSymlinks(map[string]string{"link": "c.txt"})
+
+		r.newChange(x, y)
+		if target, err := os.Readlink(filepath.Join(r.dir, "link")); err != nil {
+			t.Errorf("link is not a symlink after merge: %v", err)
+		} else if target != "b.txt" {
+			t.Errorf("link points to %s, want our target b.txt", target)
+		}
+		r.assertFiles(map[string]string{"link." + x: "", "link." + y: ""})
+
+		// Pushing the unresolved merge keeps it in conflict
+		r.push(nil)
+		if info := r.current(); !info.IsInConflict {
+			t.Errorf("merge with conflicting symlink is not in conflict")
+		}
+
+		conflicts := r.conflicts()
+		if len(conflicts) != 1 || conflicts[0].Name != "link" {
+			t.Fatalf("conflicts = %v, want link", conflicts)
+		}
+		conflict := conflicts[0]
+		if !conflict.Structured || !conflict.Binary {
+			t.Errorf("conflict is structured %v and binary %v, want both", conflict.Structured, conflict.Binary)
+		}
+		if conflict.Base.GetSymlinkTarget() != "a.txt" {
+			t.Errorf("base target = %q, want a.txt", conflict.Base.GetSymlinkTarget())
+		}
+		var targets []string
+		for _, side := range conflict.Parents {
+			targets = append(targets, side.GetSymlinkTarget())
+		}
+		if len(targets) != 2 || targets[0] != "b.txt" || targets[1] != "c.txt" {
+			t.Errorf("parent targets = %v, want [b.txt c.txt]", targets)
+		}
+	})
+}
+
+// TestRemergeDiffCleanMerge tests that a merge stored as it was merged has no
+// remerge diff.
+func TestRemergeDiffCleanMerge(t *testing.T) {
+	env := setupTestEnvironment(t, "")
+	defer env.cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	r := newMergeTestRepo(t, ctx, env.serverAddr, "remerge")
+	r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "9")})
+	root := r.current().ChangeName
+
+	x := r.newChange(root)
+	r.push(map[string]string{"a.txt": lines("one", "2", "3", "4", "5", "6", "7", "8", "9")})
+	y := r.newChange(root)
+	r.push(map[string]string{"a.txt": lines("1", "2", "3", "4", "5", "6", "7", "8", "nine"), "b.txt": "added\n"})
+
+	r.newChange(x, y)
+	c := r.open()
+	defer c.Close()
+	diff, err := c.CollectRemergeDiff(nil, false, false)
+	if err != nil {
+		t.Fatalf("Failed to collect remerge diff: %v", err)
+	}
+	if len(diff.Files) != 0 {
+		var names []string
+		for _, f := range diff.Files {
+			names = append(names, f.Header.GetPath())
+		}
+		t.Errorf("remerge diff of clean merge contains %v, want no files", names)
+	}
+}
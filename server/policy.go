@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/filecontents"
+	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/env"
+	"github.com/pogo-vcs/pogo/server/policy"
+)
+
+// checkPolicy runs the policy of the server for an operation on a change. The
+// violations are returned as error, so the client shows them; errors running
+// the checks reject the operation as well.
+func checkPolicy(ctx context.Context, event policy.Event, repositoryId int32, changeId int64, changeName string, userId *int32, description *string, files []policy.File) error {
+	if env.Policy == nil {
+		return nil
+	}
+
+	repo, err := db.Q.GetRepository(ctx, repositoryId)
+	if err != nil {
+		return fmt.Errorf("get repository: %w", err)
+	}
+	input := policy.Input{
+		Event:        event,
+		RepositoryID: repositoryId,
+		Repository:   repo.Name,
+		ChangeID:     changeId,
+		Change:       changeName,
+		Description:  description,
+		Files:        files,
+	}
+	if userId != nil {
+		if user, err := db.Q.GetUser(ctx, *userId); err == nil {
+			input.User = user.Username
+		}
+	}
+
+	violations, err := env.Policy.Check(ctx, input)
+	if err != nil {
+		fmt.Printf("Policy error: repo_id=%d change=%s event=%s detail=%v\n", repositoryId, changeName, event, err)
+		return fmt.Errorf("check policy: %w", err)
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// checkPushPolicy checks the files of a push against the files the change
+// has now. Uploaded files are read from tempDir before they are moved to the
+// store, the others are referenced by the hash in their header.
+func checkPushPolicy(ctx context.Context, change db.GetChangeRow, userId *int32, tempDir string, uploaded map[string]bool, headers map[string]*protos.FileHeader) error {
+	if env.Policy == nil {
+		return nil
+	}
+
+	hashes := make(map[string][]byte, len(headers))
+	for relPath, header := range headers {
+		if header.SymlinkTarget != nil || !uploaded[relPath] {
+			hashes[relPath] = header.ContentHash
+			continue
+		}
+		hash, err := filecontents.HashFile(filepath.Join(tempDir, relPath))
+		if err != nil {
+			return fmt.Errorf("hash uploaded file %s: %w", relPath, err)
+		}
+		hashes[relPath] = hash
+	}
+
+	previousFiles, err := db.Q.GetChangeFiles(ctx, change.ID)
+	if err != nil {
+		return fmt.Errorf("get current change files: %w", err)
+	}
+	changed := pushedPaths(previousFiles, hashes)
+	if len(changed) == 0 {
+		return nil
+	}
+	return checkPolicy(ctx, policy.EventPush, change.RepositoryID, change.ID, change.Name, userId, change.Description, pushedFiles(changed, hashes, headers, tempDir, uploaded))
+}
+
+// pushedPaths returns the files that differ between the change and a push,
+// sorted by name.
+func pushedPaths(previous []db.GetChangeFilesRow, hashes map[string][]byte) []ci.ChangedPath {
+	var changed []ci.ChangedPath
+	previousHashes := make(map[string][]byte, len(previous))
+	for _, f := range previous {
+		previousHashes[f.Name] = f.ContentHash
+		if _, ok := hashes[f.Name]; !ok {
+			changed = append(changed, ci.ChangedPath{Path: f.Name, Operation: ci.FileRemoved})
+		}
+	}
+	for name, hash := range hashes {
+		if previousHash, ok := previousHashes[name]; !ok {
+			changed = append(changed, ci.ChangedPath{Path: name, Operation: ci.FileAdded})
+		} else if !bytes.Equal(previousHash, hash) {
+			changed = append(changed, ci.ChangedPath{Path: name, Operation: ci.FileModified})
+		}
+	}
+	slices.SortFunc(changed, func(x, y ci.ChangedPath) int {
+		return strings.Compare(x.Path, y.Path)
+	})
+	return changed
+}
+
+// pushedFiles returns the files changed by a push for the policy. Uploaded
+// files are opened in tempDir, symlinks have no content to check.
+func pushedFiles(changed []ci.ChangedPath, hashes map[string][]byte, headers map[string]*protos.FileHeader, tempDir string, uploaded map[string]bool) []policy.File {
+	pushed := make([]policy.File, 0, len(changed))
+	for _, path := range changed {
+		file := policy.File{Path: path.Path, Operation: path.Operation}
+		if header, ok := headers[path.Path]; ok && header.SymlinkTarget != nil {
+			file.Symlink = true
+		} else if uploaded[path.Path] {
+			absPath := filepath.Join(tempDir, path.Path)
+			file.Open = func() (io.ReadCloser, error) {
+				return os.Open(absPath)
+			}
+		} else if hash, ok := hashes[path.Path]; ok && path.Operation != ci.FileRemoved {
+			hashStr := base64.URLEncoding.EncodeToString(hash)
+			file.Open = func() (io.ReadCloser, error) {
+				return filecontents.OpenFileByHash(hashStr)
+			}
+		}
+		pushed = append(pushed, file)
+	}
+	return pushed
+}
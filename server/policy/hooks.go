@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pogo-vcs/pogo/server/ci"
+)
+
+// maxHookViolations limits the violations read from the output of a hook.
+const maxHookViolations = 100
+
+// runHooks runs the hooks of the policy with the files of the input in a
+// temporary directory.
+func (p *Policy) runHooks(ctx context.Context, input Input) (Violations, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal input: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "pogo-policy-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeFiles(dir, input.Files); err != nil {
+		return nil, err
+	}
+
+	var violations Violations
+	for _, hook := range p.Hooks {
+		found, err := hook.run(ctx, input, dir, payload)
+		if err != nil {
+			return nil, fmt.Errorf("run hook %s: %w", hook.Name, err)
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
+}
+
+// writeFiles writes the content of the added and modified files to dir.
+func writeFiles(dir string, files []File) error {
+	for _, file := range files {
+		if !file.hasContent() || !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, filepath.FromSlash(file.Path)), file); err != nil {
+			return fmt.Errorf("write %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+func writeFile(name string, file File) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (h *Hook) run(ctx context.Context, input Input, dir string, payload []byte) (Violations, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+	cmd.Env = hookEnv(input)
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return Violations{{Message: fmt.Sprintf("hook %s timed out after %s", h.Name, h.timeout)}}, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return h.parseOutput(&output, input.Files, exitErr.ExitCode()), nil
+	}
+	return nil, err
+}
+
+// hookEnv returns the environment of a hook. Like process tasks, hooks only see
+// the allowlisted variables of the server, not its database or secrets
+// configuration.
+func hookEnv(input Input) []string {
+	var env []string
+	for _, key := range ci.DefaultProcessEnvAllowlist {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+	return append(env,
+		"POGO_POLICY_EVENT="+string(input.Event),
+		"POGO_REPOSITORY="+input.Repository,
+		"POGO_CHANGE_NAME="+input.Change,
+		"POGO_USER="+input.User,
+	)
+}
+
+// parseOutput turns the lines of a rejecting hook into violations. Lines
+// starting with the path of a pushed file and ": " are about that file.
+func (h *Hook) parseOutput(output io.Reader, files []File, exitCode int) Violations {
+	var violations Violations
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() && len(violations) < maxHookViolations {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if path, message, ok := strings.Cut(line, ": "); ok && slices.ContainsFunc(files, func(f File) bool { return f.Path == path }) {
+			violations = append(violations, Violation{Path: path, Message: message})
+			continue
+		}
+		violations = append(violations, Violation{Message: fmt.Sprintf("hook %s: %s", h.Name, line)})
+	}
+	if len(violations) == 0 {
+		violations = append(violations, Violation{Message: fmt.Sprintf("rejected by hook %s (exit code %d)", h.Name, exitCode)})
+	}
+	return violations
+}
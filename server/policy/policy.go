@@ -0,0 +1,402 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leodido/go-conventionalcommits"
+	"github.com/leodido/go-conventionalcommits/parser"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"gopkg.in/yaml.v3"
+)
+
+// Event is the operation a policy checks.
+type Event string
+
+const (
+	// EventPush checks the files pushed to a change and its description
+	EventPush Event = "push"
+	// EventNewChange checks the description of a new change, if it has one
+	EventNewChange Event = "new_change"
+	// EventDescribe checks a new description of a change
+	EventDescribe Event = "describe"
+)
+
+// Config is the policy file of a server.
+type Config struct {
+	Policies []*Policy `yaml:"policies"`
+}
+
+// Policy is a set of checks for the repositories it applies to.
+type Policy struct {
+	// Name identifies the policy in violations
+	Name string `yaml:"name"`
+	// Repositories are globs of the repository names the policy applies to,
+	// all repositories if empty
+	Repositories []string `yaml:"repositories"`
+	// MaxFileSize like 50MB rejects larger files
+	MaxFileSize string `yaml:"max_file_size"`
+	// ForbiddenPaths are globs of paths that can't be added
+	ForbiddenPaths []string `yaml:"forbidden_paths"`
+	// Secrets rejects files containing secrets
+	Secrets *SecretScan `yaml:"secrets"`
+	// Description checks the descriptions of changes
+	Description *DescriptionRule `yaml:"description"`
+	// Hooks are external executables checking a push
+	Hooks []*Hook `yaml:"hooks"`
+
+	maxFileSize int64
+}
+
+// SecretScan finds secrets in the lines of text files.
+type SecretScan struct {
+	// Builtin enables the patterns of common secrets, like private keys
+	Builtin bool `yaml:"builtin"`
+	// Patterns are additional regular expressions of secrets
+	Patterns []SecretPattern `yaml:"patterns"`
+
+	patterns []SecretPattern
+}
+
+type SecretPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// DescriptionRule checks the description of a change.
+type DescriptionRule struct {
+	// Required rejects pushes to changes without a description
+	Required bool `yaml:"required"`
+	// Pattern is a regular expression the description must match
+	Pattern string `yaml:"pattern"`
+	// ConventionalCommit requires descriptions to be conventional commits
+	ConventionalCommit bool `yaml:"conventional_commit"`
+
+	re *regexp.Regexp
+}
+
+// Hook is an executable that receives the Input as JSON on stdin and the
+// pushed files in its working directory. A non-zero exit code rejects the
+// operation; lines of its output like "path: message" become violations of
+// the file.
+type Hook struct {
+	Name string `yaml:"name"`
+	// Command is the executable and its arguments
+	Command []string `yaml:"command"`
+	// Timeout of the hook, 30 seconds if not set
+	Timeout string `yaml:"timeout"`
+
+	timeout time.Duration
+}
+
+const defaultHookTimeout = 30 * time.Second
+
+var builtinSecretPatterns = []SecretPattern{
+	{Name: "private key", Regex: `-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`},
+	{Name: "AWS access key", Regex: `\b(AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "GitHub token", Regex: `\b(gh[pousr]|github_pat)_[A-Za-z0-9_]{36,}\b`},
+	{Name: "Slack token", Regex: `\bxox[abprs]-[A-Za-z0-9-]{10,}`},
+	{Name: "Google API key", Regex: `\bAIza[0-9A-Za-z_-]{35}\b`},
+}
+
+// Load reads and validates a policy file.
+func Load(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates a policy file.
+func Parse(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse policy file: %w", err)
+	}
+	for i, p := range config.Policies {
+		if p == nil {
+			return nil, fmt.Errorf("policies[%d] is empty", i)
+		}
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policies[%d]", i)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+	}
+	return &config, nil
+}
+
+func (p *Policy) validate() error {
+	for _, pattern := range p.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid repository glob %q", pattern)
+		}
+	}
+	if p.MaxFileSize != "" {
+		size, err := ci.ParseMemory(p.MaxFileSize)
+		if err != nil {
+			return fmt.Errorf("invalid max_file_size: %w", err)
+		}
+		p.maxFileSize = size
+	}
+	for _, pattern := range p.ForbiddenPaths {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid forbidden path glob %q", pattern)
+		}
+	}
+	if p.Secrets != nil {
+		patterns := p.Secrets.Patterns
+		if p.Secrets.Builtin {
+			patterns = slices.Concat(builtinSecretPatterns, patterns)
+		}
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern.Regex)
+			if err != nil || pattern.Regex == "" {
+				return fmt.Errorf("invalid secret pattern %q", pattern.Regex)
+			}
+			if pattern.Name == "" {
+				pattern.Name = "secret"
+			}
+			pattern.re = re
+			p.Secrets.patterns = append(p.Secrets.patterns, pattern)
+		}
+	}
+	if p.Description != nil && p.Description.Pattern != "" {
+		re, err := regexp.Compile(p.Description.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid description pattern: %w", err)
+		}
+		p.Description.re = re
+	}
+	for i, hook := range p.Hooks {
+		if hook == nil || len(hook.Command) == 0 || hook.Command[0] == "" {
+			return fmt.Errorf("hooks[%d] has no command", i)
+		}
+		if hook.Name == "" {
+			hook.Name = path.Base(hook.Command[0])
+		}
+		hook.timeout = defaultHookTimeout
+		if hook.Timeout != "" {
+			timeout, err := time.ParseDuration(hook.Timeout)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid timeout %q of hook %s", hook.Timeout, hook.Name)
+			}
+			hook.timeout = timeout
+		}
+	}
+	return nil
+}
+
+// AppliesTo reports whether the policy applies to the repository.
+func (p *Policy) AppliesTo(repository string) bool {
+	if len(p.Repositories) == 0 {
+		return true
+	}
+	for _, pattern := range p.Repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Input is what a policy checks, hooks receive it as JSON.
+type Input struct {
+	Event        Event  `json:"event"`
+	RepositoryID int32  `json:"repository_id"`
+	Repository   string `json:"repository"`
+	ChangeID     int64  `json:"change_id"`
+	Change       string `json:"change"`
+	// User who pushed, empty for anonymous access
+	User        string  `json:"user,omitempty"`
+	Description *string `json:"description"`
+	// Files changed by a push
+	Files []File `json:"files,omitempty"`
+}
+
+// File is a file changed by a push.
+type File struct {
+	// Path is slash separated and relative to the repository
+	Path string `json:"path"`
+	// Operation is added, modified or removed
+	Operation ci.FileOperation `json:"operation"`
+	// Symlink is set for symbolic links, they have no content to check
+	Symlink bool `json:"symlink,omitempty"`
+	// Open reads the content of added and modified files
+	Open func() (io.ReadCloser, error) `json:"-"`
+}
+
+// hasContent reports whether the content of the file can be checked.
+func (f File) hasContent() bool {
+	return f.Operation != ci.FileRemoved && !f.Symlink && f.Open != nil
+}
+
+// Violation is a reason an operation is rejected.
+type Violation struct {
+	// Path of the file, empty if the violation is not about a file
+	Path    string
+	Message string
+	// Policy that was violated
+	Policy string
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Violations are returned as an error when an operation is rejected.
+type Violations []Violation
+
+func (v Violations) Error() string {
+	var sb strings.Builder
+	sb.WriteString("rejected by policy:")
+	for _, violation := range v {
+		sb.WriteString("\n  ")
+		sb.WriteString(violation.String())
+	}
+	return sb.String()
+}
+
+// Check runs the policies applying to the repository of the input. The
+// error is only set if the checks could not run.
+func (c *Config) Check(ctx context.Context, input Input) (Violations, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var violations Violations
+	for _, p := range c.Policies {
+		if !p.AppliesTo(input.Repository) {
+			continue
+		}
+		found, err := p.check(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+		for _, violation := range found {
+			violation.Policy = p.Name
+			violations = append(violations, violation)
+		}
+	}
+	return violations, nil
+}
+
+func (p *Policy) check(ctx context.Context, input Input) (Violations, error) {
+	var violations Violations
+	if p.Description != nil {
+		violations = append(violations, p.Description.check(input.Event, input.Description)...)
+	}
+	for _, file := range input.Files {
+		if file.Operation == ci.FileRemoved {
+			continue
+		}
+		for _, pattern := range p.ForbiddenPaths {
+			if ci.MatchGlob(pattern, file.Path) {
+				violations = append(violations, Violation{Path: file.Path, Message: fmt.Sprintf("path matches forbidden pattern %q", pattern)})
+				break
+			}
+		}
+		if !file.hasContent() || (p.maxFileSize == 0 && p.Secrets == nil) {
+			continue
+		}
+		found, err := p.checkContent(file)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", file.Path, err)
+		}
+		violations = append(violations, found...)
+	}
+	if len(p.Hooks) > 0 {
+		found, err := p.runHooks(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	return violations, nil
+}
+
+func (p *Policy) checkContent(file File) (Violations, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer r.Close()
+
+	counter := &countingReader{r: r}
+	var violations Violations
+	if p.Secrets != nil {
+		for _, finding := range scanSecrets(counter, p.Secrets.patterns) {
+			violations = append(violations, Violation{Path: file.Path, Message: fmt.Sprintf("%s found on line %d", finding.name, finding.line)})
+		}
+	}
+	if p.maxFileSize > 0 {
+		// The size only matters up to the limit
+		if _, err := io.Copy(io.Discard, io.LimitReader(counter, p.maxFileSize+1-counter.n)); err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		if counter.n > p.maxFileSize {
+			violations = append(violations, Violation{Path: file.Path, Message: fmt.Sprintf("file is larger than the limit of %s", formatSize(p.maxFileSize))})
+		}
+	}
+	return violations, nil
+}
+
+func (d *DescriptionRule) check(event Event, description *string) Violations {
+	if description == nil || strings.TrimSpace(*description) == "" {
+		// New changes are often described later
+		if d.Required && event != EventNewChange {
+			return Violations{{Message: "the change needs a description"}}
+		}
+		return nil
+	}
+	var violations Violations
+	if d.re != nil && !d.re.MatchString(*description) {
+		violations = append(violations, Violation{Message: fmt.Sprintf("description does not match %q", d.Pattern)})
+	}
+	if d.ConventionalCommit {
+		// Machines keep state while parsing, so every check gets its own
+		machine := parser.NewMachine(conventionalcommits.WithTypes(conventionalcommits.TypesConventional))
+		if _, err := machine.Parse([]byte(*description)); err != nil {
+			violations = append(violations, Violation{Message: fmt.Sprintf("description is not a conventional commit: %v", err)})
+		}
+	}
+	return violations
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// formatSize formats a size like ParseMemory reads it.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", size>>30)
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size>>20)
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", size>>10)
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
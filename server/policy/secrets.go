@@ -0,0 +1,43 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const (
+	// binarySniffSize is how much of a file is searched for NUL bytes to skip
+	// binary files
+	binarySniffSize = 8000
+	// maxLineSize ends the scan of files with longer lines, like minified code
+	maxLineSize = 1 << 20
+)
+
+type secretFinding struct {
+	name string
+	line int
+}
+
+// scanSecrets returns the first line each pattern matches in a text file.
+// Binary files and files with very long lines are not scanned further.
+func scanSecrets(r io.Reader, patterns []SecretPattern) []secretFinding {
+	br := bufio.NewReaderSize(r, binarySniffSize)
+	if head, _ := br.Peek(binarySniffSize); bytes.IndexByte(head, 0) >= 0 {
+		return nil
+	}
+
+	var findings []secretFinding
+	found := make([]bool, len(patterns))
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		for i, pattern := range patterns {
+			if !found[i] && pattern.re.Match(scanner.Bytes()) {
+				found[i] = true
+				findings = append(findings, secretFinding{name: pattern.Name, line: line})
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/secrets"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/env"
+)
+
+var (
+	// secretsKeyMutex is held for writing while the key is rotated, so no
+	// secret is encrypted with the old key after the rotation.
+	secretsKeyMutex sync.RWMutex
+	secretsKey      *secrets.Key
+)
+
+// loadSecretsKey returns the key of repository secrets. The caller must hold
+// secretsKeyMutex.
+func loadSecretsKey() (*secrets.Key, error) {
+	if secretsKey == nil {
+		key, err := secrets.NewKey(env.SecretsKey)
+		if err != nil {
+			return nil, fmt.Errorf("load secrets key: %w", err)
+		}
+		secretsKey = key
+	}
+	return secretsKey, nil
+}
+
+// withSecretsKey calls f with the key of secrets. The key is not rotated
+// while f runs, so f can store what it encrypted.
+func withSecretsKey(f func(k *secrets.Key) error) error {
+	secretsKeyMutex.RLock()
+	defer secretsKeyMutex.RUnlock()
+
+	k, err := loadSecretsKey()
+	if err != nil {
+		return err
+	}
+	return f(k)
+}
+
+// secretAdditionalData binds the ciphertext of a secret to its repository,
+// environment and key, so it can't be copied to another secret. Org secrets
+// belong to no repository and are passed repository 0.
+func secretAdditionalData(repositoryId int32, environment, key string) []byte {
+	owner := "org"
+	if repositoryId != 0 {
+		owner = strconv.FormatInt(int64(repositoryId), 10)
+	}
+	ad := owner + "/" + key
+	if environment != "" {
+		ad += "\x00" + environment
+	}
+	return []byte(ad)
+}
+
+// setSecret encrypts the value and stores it as a secret of the repository,
+// shared by all configs if environment is empty.
+func setSecret(ctx context.Context, repositoryId int32, environment, key, value string) error {
+	return withSecretsKey(func(k *secrets.Key) error {
+		ciphertext, err := k.Encrypt(value, secretAdditionalData(repositoryId, environment, key))
+		if err != nil {
+			return fmt.Errorf("encrypt secret: %w", err)
+		}
+		keyId := k.ID()
+		return db.Q.SetSecret(ctx, repositoryId, environment, key, ciphertext, &keyId)
+	})
+}
+
+// setOrgSecret encrypts the value and stores it as a server-wide secret,
+// inherited by all repositories.
+func setOrgSecret(ctx context.Context, environment, key, value string) error {
+	return withSecretsKey(func(k *secrets.Key) error {
+		ciphertext, err := k.Encrypt(value, secretAdditionalData(0, environment, key))
+		if err != nil {
+			return fmt.Errorf("encrypt secret: %w", err)
+		}
+		return db.Q.SetOrgSecret(ctx, environment, key, ciphertext, k.ID())
+	})
+}
+
+// decryptSecret decrypts the value of a secret, repositoryId is 0 for org
+// secrets.
+func decryptSecret(repositoryId int32, environment, key string, ciphertext []byte) (string, error) {
+	var value string
+	err := withSecretsKey(func(k *secrets.Key) error {
+		var err error
+		value, err = k.Decrypt(ciphertext, secretAdditionalData(repositoryId, environment, key))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// checkEnvironmentExists fails if the repository has no environment of the
+// name. The empty name of secrets shared by all configs always exists.
+func checkEnvironmentExists(ctx context.Context, repositoryId int32, name string) error {
+	if name == "" {
+		return nil
+	}
+	exists, err := db.Q.EnvironmentExists(ctx, repositoryId, name)
+	if err != nil {
+		return fmt.Errorf("check environment: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("environment %s not found, create it first", name)
+	}
+	return nil
+}
+
+// setEnvironment creates or updates an environment of a repository.
+func setEnvironment(ctx context.Context, repositoryId int32, name string, bookmarks []string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("environment name is required")
+	}
+	if err := (ci.Environment{Bookmarks: bookmarks}).Validate(); err != nil {
+		return err
+	}
+	if err := db.Q.SetEnvironment(ctx, repositoryId, name, bookmarks); err != nil {
+		return fmt.Errorf("set environment: %w", err)
+	}
+	return nil
+}
+
+// deleteEnvironment deletes an environment of a repository with its secrets.
+func deleteEnvironment(ctx context.Context, repositoryId int32, name string) error {
+	tx, err := db.Q.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("open db transaction: %w", err)
+	}
+	defer tx.Close()
+
+	if err := tx.DeleteEnvironmentSecrets(ctx, repositoryId, name); err != nil {
+		return fmt.Errorf("delete environment secrets: %w", err)
+	}
+	if err := tx.DeleteEnvironment(ctx, repositoryId, name); err != nil {
+		return fmt.Errorf("delete environment: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// EncryptPlaintextSecrets encrypts secrets stored before values were
+// encrypted.
+func EncryptPlaintextSecrets(ctx context.Context) error {
+	rows, err := db.Q.GetPlaintextSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("get plaintext secrets: %w", err)
+	}
+	for _, row := range rows {
+		var value string
+		if row.Value != nil {
+			value = *row.Value
+		}
+		if err := setSecret(ctx, row.RepositoryID, row.Environment, row.Key, value); err != nil {
+			return fmt.Errorf("encrypt secret %s of repository %d: %w", row.Key, row.RepositoryID, err)
+		}
+	}
+	if len(rows) > 0 {
+		fmt.Printf("Encrypted %d plaintext secrets\n", len(rows))
+	}
+	return nil
+}
+
+// rotateSecretsKey re-encrypts all secrets, including the secrets of
+// webhooks, with a new key and replaces the key file with it. Returns the
+// number of re-encrypted secrets and the ID of the new key.
+//
+// The new key is written next to the key file before the secrets are
+// re-encrypted, so it is not lost if the server stops before the key file
+// is replaced.
+func rotateSecretsKey(ctx context.Context) (int, string, error) {
+	if env.SecretsKeyFile == "" {
+		return 0, "", errors.New("the secrets key is set by SECRETS_KEY and can't be rotated by the server, re-encrypt the secrets by changing SECRETS_KEY_FILE instead")
+	}
+
+	secretsKeyMutex.Lock()
+	defer secretsKeyMutex.Unlock()
+
+	oldKey, err := loadSecretsKey()
+	if err != nil {
+		return 0, "", err
+	}
+	raw, err := secrets.GenerateKey()
+	if err != nil {
+		return 0, "", fmt.Errorf("generate key: %w", err)
+	}
+	newKey, err := secrets.NewKey(raw)
+	if err != nil {
+		return 0, "", err
+	}
+	newKeyFile := env.SecretsKeyFile + ".new"
+	if err := secrets.WriteKeyFile(newKeyFile, raw); err != nil {
+		return 0, "", fmt.Errorf("write new key file: %w", err)
+	}
+
+	tx, err := db.Q.Begin(ctx)
+	if err != nil {
+		return 0, "", fmt.Errorf("open db transaction: %w", err)
+	}
+	defer tx.Close()
+
+	reencrypt := func(repositoryId int32, environment, key string, ciphertext []byte) ([]byte, error) {
+		ad := secretAdditionalData(repositoryId, environment, key)
+		value, err := oldKey.Decrypt(ciphertext, ad)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt secret %s: %w", key, err)
+		}
+		return newKey.Encrypt(value, ad)
+	}
+	keyId := newKey.ID()
+
+	rows, err := tx.GetEncryptedSecretsForUpdate(ctx)
+	if err != nil {
+		return 0, "", fmt.Errorf("get secrets: %w", err)
+	}
+	for _, row := range rows {
+		ciphertext, err := reencrypt(row.RepositoryID, row.Environment, row.Key, row.Ciphertext)
+		if err != nil {
+			return 0, "", fmt.Errorf("re-encrypt secret of repository %d: %w", row.RepositoryID, err)
+		}
+		if err := tx.UpdateSecretCiphertext(ctx, row.RepositoryID, row.Environment, row.Key, ciphertext, &keyId); err != nil {
+			return 0, "", fmt.Errorf("update secret %s of repository %d: %w", row.Key, row.RepositoryID, err)
+		}
+	}
+
+	orgRows, err := tx.GetOrgSecretsForUpdate(ctx)
+	if err != nil {
+		return 0, "", fmt.Errorf("get org secrets: %w", err)
+	}
+	for _, row := range orgRows {
+		ciphertext, err := reencrypt(0, row.Environment, row.Key, row.Ciphertext)
+		if err != nil {
+			return 0, "", fmt.Errorf("re-encrypt org secret: %w", err)
+		}
+		if err := tx.UpdateOrgSecretCiphertext(ctx, row.Environment, row.Key, ciphertext, keyId); err != nil {
+			return 0, "", fmt.Errorf("update org secret %s: %w", row.Key, err)
+		}
+	}
+
+	hooks, err := tx.GetWebhookSecretsForUpdate(ctx)
+	if err != nil {
+		return 0, "", fmt.Errorf("get webhook secrets: %w", err)
+	}
+	for _, hook := range hooks {
+		ad := webhookAdditionalData(hook.RepositoryID, hook.Url)
+		value, err := oldKey.Decrypt(hook.SecretCiphertext, ad)
+		if err != nil {
+			return 0, "", fmt.Errorf("decrypt secret of webhook %d: %w", hook.ID, err)
+		}
+		ciphertext, err := newKey.Encrypt(value, ad)
+		if err != nil {
+			return 0, "", fmt.Errorf("re-encrypt secret of webhook %d: %w", hook.ID, err)
+		}
+		if err := tx.UpdateWebhookSecret(ctx, hook.ID, ciphertext, keyId); err != nil {
+			return 0, "", fmt.Errorf("update secret of webhook %d: %w", hook.ID, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, "", fmt.Errorf("commit transaction: %w", err)
+	}
+	secretsKey = newKey
+	env.SecretsKey = raw
+
+	if err := os.Rename(newKeyFile, env.SecretsKeyFile); err != nil {
+		return 0, "", fmt.Errorf("secrets were re-encrypted, but replacing the key file failed, move %s to %s manually: %w", newKeyFile, env.SecretsKeyFile, err)
+	}
+	return len(rows) + len(orgRows) + len(hooks), keyId, nil
+}
@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/pogo-vcs/pogo/protos"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/env"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
@@ -34,6 +36,9 @@ func NewServer() *Server {
 	}
 	protos.RegisterPogoServer(s.grpcServer, s)
 	RegisterWebUI(s)
+	ci.SetMaxParallelJobs(env.CiMaxParallelJobs)
+	ci.SetContainerLimits(env.CiContainerLimits)
+	ci.SetProcessConfig(env.CiProcessConfig)
 	return s
 }
 
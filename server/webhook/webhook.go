@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is the kind of repository event a webhook is delivered for.
+type Event string
+
+const (
+	EventChangePushed    Event = "change.pushed"
+	EventChangeCreated   Event = "change.created"
+	EventBookmarkSet     Event = "bookmark.set"
+	EventBookmarkRemoved Event = "bookmark.removed"
+	EventCIRunFinished   Event = "ci_run.finished"
+	EventSettingsChanged Event = "repository.settings_changed"
+)
+
+// Events lists all events a webhook can subscribe to.
+var Events = []Event{
+	EventChangePushed,
+	EventChangeCreated,
+	EventBookmarkSet,
+	EventBookmarkRemoved,
+	EventCIRunFinished,
+	EventSettingsChanged,
+}
+
+// ParseEvents checks the names of events a webhook subscribes to. No events
+// subscribe to all events.
+func ParseEvents(names []string) ([]string, error) {
+	events := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(Events, Event(name)) {
+			return nil, fmt.Errorf("unknown webhook event %q", name)
+		}
+		if !slices.Contains(events, name) {
+			events = append(events, name)
+		}
+	}
+	return events, nil
+}
+
+// ValidateURL checks that a webhook URL is an absolute http or https URL.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q, must be an http or https URL", rawURL)
+	}
+	return nil
+}
+
+// Subscribed reports whether a webhook subscribed to the events receives the
+// event.
+func Subscribed(events []string, event Event) bool {
+	return len(events) == 0 || slices.Contains(events, string(event))
+}
+
+const (
+	// HeaderEvent holds the event of a delivery
+	HeaderEvent = "X-Pogo-Event"
+	// HeaderDelivery holds the ID of a delivery, redeliveries get a new ID
+	HeaderDelivery = "X-Pogo-Delivery"
+	// HeaderSignature holds the HMAC-SHA256 of the body as sha256=<hex>
+	HeaderSignature = "X-Pogo-Signature-256"
+
+	// maxResponseSize is how much of a response body is kept for the delivery log
+	maxResponseSize = 4096
+)
+
+// Sign returns the signature of a payload for HeaderSignature.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of the payload, as
+// receivers of webhooks should check it.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Payload is the JSON body of a delivery. Only the fields of the event are
+// set.
+type Payload struct {
+	Event      Event      `json:"event"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Repository Repository `json:"repository"`
+	Sender     *User      `json:"sender,omitempty"`
+	Change     *Change    `json:"change,omitempty"`
+	Bookmark   *Bookmark  `json:"bookmark,omitempty"`
+	CIRun      *CIRun     `json:"ci_run,omitempty"`
+	Settings   *Settings  `json:"settings,omitempty"`
+}
+
+type Repository struct {
+	ID     int32  `json:"id"`
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+type User struct {
+	ID       int32  `json:"id"`
+	Username string `json:"username"`
+}
+
+type Change struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description"`
+	Author      string   `json:"author,omitempty"`
+	Parents     []string `json:"parents,omitempty"`
+	// ChangedFiles are the paths changed by a push
+	ChangedFiles []ChangedFile `json:"changed_files,omitempty"`
+}
+
+type ChangedFile struct {
+	Path string `json:"path"`
+	// Operation is added, modified or removed
+	Operation string `json:"operation"`
+}
+
+type Bookmark struct {
+	Name string `json:"name"`
+	// Change the bookmark points to, or pointed to before it was removed
+	Change string `json:"change"`
+	// PreviousChange the bookmark pointed to before it was set, empty for new bookmarks
+	PreviousChange string `json:"previous_change,omitempty"`
+}
+
+type CIRun struct {
+	PipelineID int64  `json:"pipeline_id"`
+	Config     string `json:"config"`
+	EventType  string `json:"event_type"`
+	Rev        string `json:"rev"`
+	Change     string `json:"change"`
+	// State is succeeded, failed or cancelled
+	State string  `json:"state"`
+	Error *string `json:"error,omitempty"`
+	Jobs  []CIJob `json:"jobs"`
+	URL   string  `json:"url,omitempty"`
+}
+
+type CIJob struct {
+	RunID   int32  `json:"run_id"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Success bool   `json:"success"`
+}
+
+type Settings struct {
+	// Setting that changed, e.g. visibility, access, secret, environment or webhook
+	Setting string `json:"setting"`
+	// Action on the setting, e.g. changed, granted, revoked, set or deleted
+	Action string `json:"action"`
+	// Value identifies what changed, e.g. the key of a secret; never a secret value
+	Value string `json:"value,omitempty"`
+}
+
+// Result is the outcome of a delivery attempt.
+type Result struct {
+	// StatusCode of the response, 0 if there was none
+	StatusCode int
+	// Response is the beginning of the response body
+	Response string
+	// Err is set if the attempt failed
+	Err error
+}
+
+// Deliver posts a payload to a webhook URL once. Responses with a status
+// other than 2xx fail.
+func Deliver(ctx context.Context, client *http.Client, webhookURL string, secret []byte, event Event, deliveryID int64, body []byte) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return Result{Err: fmt.Errorf("create request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pogo-webhook")
+	req.Header.Set(HeaderEvent, string(event))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	result := Result{StatusCode: resp.StatusCode, Response: string(response)}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"event":"bookmark.set"}`)
+
+	signature := Sign(secret, body)
+	if !Verify(secret, body, signature) {
+		t.Errorf("Verify() of Sign() = false")
+	}
+	if Verify([]byte("other"), body, signature) {
+		t.Error("Verify() with other secret = true")
+	}
+	if Verify(secret, []byte(`{"event":"bookmark.removed"}`), signature) {
+		t.Error("Verify() of other body = true")
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, url := range []string{"https://chat.example.com/hooks/1", "http://localhost:8080"} {
+		if err := ValidateURL(url); err != nil {
+			t.Errorf("ValidateURL(%q) error = %v", url, err)
+		}
+	}
+	for _, url := range []string{"", "ftp://example.com", "https://", "not a url"} {
+		if err := ValidateURL(url); err == nil {
+			t.Errorf("ValidateURL(%q) succeeded", url)
+		}
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := ParseEvents([]string{"bookmark.set", " change.pushed", "bookmark.set", ""})
+	if err != nil {
+		t.Fatalf("ParseEvents() error = %v", err)
+	}
+	if len(events) != 2 || events[0] != "bookmark.set" || events[1] != "change.pushed" {
+		t.Errorf("ParseEvents() = %v", events)
+	}
+	if _, err := ParseEvents([]string{"bookmark.moved"}); err == nil {
+		t.Error("ParseEvents() of unknown event succeeded")
+	}
+
+	if !Subscribed(nil, EventCIRunFinished) {
+		t.Error("Subscribed() without events = false, want all events")
+	}
+	if Subscribed(events, EventCIRunFinished) {
+		t.Error("Subscribed() of unsubscribed event = true")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	secret := []byte("s3cret")
+	body := []byte(`{"event":"change.created"}`)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := io.ReadAll(r.Body)
+		if !Verify(secret, got, r.Header.Get(HeaderSignature)) {
+			t.Errorf("invalid signature %q", r.Header.Get(HeaderSignature))
+		}
+		if r.Header.Get(HeaderEvent) != string(EventChangeCreated) {
+			t.Errorf("%s = %q", HeaderEvent, r.Header.Get(HeaderEvent))
+		}
+		if r.Header.Get(HeaderDelivery) != "42" {
+			t.Errorf("%s = %q", HeaderDelivery, r.Header.Get(HeaderDelivery))
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	result := Deliver(context.Background(), server.Client(), server.URL, secret, EventChangeCreated, 42, body)
+	if result.Err != nil || result.StatusCode != http.StatusOK || result.Response != "ok" {
+		t.Errorf("Deliver() = %+v", result)
+	}
+
+	status = http.StatusBadGateway
+	result = Deliver(context.Background(), server.Client(), server.URL, secret, EventChangeCreated, 42, body)
+	if result.Err == nil || result.StatusCode != http.StatusBadGateway {
+		t.Errorf("Deliver() to failing server = %+v, want an error", result)
+	}
+}
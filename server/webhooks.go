@@ -0,0 +1,391 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pogo-vcs/pogo/db"
+	"github.com/pogo-vcs/pogo/secrets"
+	"github.com/pogo-vcs/pogo/server/ci"
+	"github.com/pogo-vcs/pogo/server/env"
+	"github.com/pogo-vcs/pogo/server/webhook"
+)
+
+const (
+	// webhookWorkers is how many deliveries are sent at the same time.
+	webhookWorkers = 4
+	// webhookTimeout is how long a webhook may take to respond.
+	webhookTimeout = 10 * time.Second
+	// webhookOrphanTimeout is how long a delivery may be claimed before it is
+	// considered orphaned by a crashed server.
+	webhookOrphanTimeout = 2 * time.Minute
+	// webhookPollInterval is how often idle workers look for due retries and
+	// deliveries queued by other server instances.
+	webhookPollInterval = 5 * time.Second
+	// webhookRetryBase is the delay before the first retry, it doubles with
+	// every attempt up to webhookRetryLimit.
+	webhookRetryBase  = 10 * time.Second
+	webhookRetryLimit = 10 * time.Minute
+	// defaultWebhookAttempts is how often a delivery is attempted unless the
+	// webhook sets another limit.
+	defaultWebhookAttempts = 5
+	// maxWebhookAttempts limits the attempts webhooks may set.
+	maxWebhookAttempts = 10
+)
+
+// webhookQueueSignal is closed and replaced whenever deliveries are queued,
+// waking all idle delivery workers.
+var webhookQueueSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var webhookWorkersCancel context.CancelFunc
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func webhookQueueChanged() <-chan struct{} {
+	webhookQueueSignal.mu.Lock()
+	defer webhookQueueSignal.mu.Unlock()
+	if webhookQueueSignal.ch == nil {
+		webhookQueueSignal.ch = make(chan struct{})
+	}
+	return webhookQueueSignal.ch
+}
+
+func wakeWebhookWorkers() {
+	webhookQueueSignal.mu.Lock()
+	defer webhookQueueSignal.mu.Unlock()
+	if webhookQueueSignal.ch != nil {
+		close(webhookQueueSignal.ch)
+		webhookQueueSignal.ch = nil
+	}
+}
+
+// webhookAdditionalData binds the ciphertext of a webhook secret to its
+// repository and URL.
+func webhookAdditionalData(repositoryId int32, url string) []byte {
+	return []byte("webhook/" + strconv.FormatInt(int64(repositoryId), 10) + "/" + url)
+}
+
+// createWebhook validates a webhook and stores it with its secret encrypted.
+// maxAttempts of 0 uses the default.
+func createWebhook(ctx context.Context, repositoryId int32, url string, events []string, secret string, maxAttempts int32) (int32, error) {
+	if err := webhook.ValidateURL(url); err != nil {
+		return 0, err
+	}
+	events, err := webhook.ParseEvents(events)
+	if err != nil {
+		return 0, err
+	}
+	if secret == "" {
+		return 0, errors.New("webhook secret is required")
+	}
+	if maxAttempts == 0 {
+		maxAttempts = defaultWebhookAttempts
+	}
+	if maxAttempts < 1 || maxAttempts > maxWebhookAttempts {
+		return 0, fmt.Errorf("max attempts must be between 1 and %d", maxWebhookAttempts)
+	}
+
+	var id int32
+	err = withSecretsKey(func(k *secrets.Key) error {
+		ciphertext, err := k.Encrypt(secret, webhookAdditionalData(repositoryId, url))
+		if err != nil {
+			return fmt.Errorf("encrypt webhook secret: %w", err)
+		}
+		id, err = db.Q.CreateWebhook(ctx, repositoryId, url, events, ciphertext, k.ID(), maxAttempts)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create webhook: %w", err)
+	}
+	return id, nil
+}
+
+// queueWebhookEvent queues a delivery of the payload for every webhook of the
+// repository subscribed to its event. Like CI events, failures are logged and
+// don't fail the operation that caused the event.
+func queueWebhookEvent(ctx context.Context, repositoryId int32, senderId *int32, payload webhook.Payload) {
+	hooks, err := db.Q.GetWebhooksForEvent(ctx, repositoryId, string(payload.Event))
+	if err != nil {
+		fmt.Printf("Webhook error: repo_id=%d event=%s detail=get webhooks: %v\n", repositoryId, payload.Event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	repo, err := db.Q.GetRepository(ctx, repositoryId)
+	if err != nil {
+		fmt.Printf("Webhook error: repo_id=%d event=%s detail=get repository: %v\n", repositoryId, payload.Event, err)
+		return
+	}
+	payload.Timestamp = time.Now().UTC()
+	payload.Repository = webhook.Repository{ID: repo.ID, Name: repo.Name, Public: repo.Public}
+	if senderId != nil {
+		if user, err := db.Q.GetUser(ctx, *senderId); err == nil {
+			payload.Sender = &webhook.User{ID: user.ID, Username: user.Username}
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Webhook error: repo_id=%d event=%s detail=marshal payload: %v\n", repositoryId, payload.Event, err)
+		return
+	}
+	for _, hook := range hooks {
+		if _, err := db.Q.QueueWebhookDelivery(ctx, hook.ID, string(payload.Event), body); err != nil {
+			fmt.Printf("Webhook error: webhook_id=%d event=%s detail=queue delivery: %v\n", hook.ID, payload.Event, err)
+		}
+	}
+	wakeWebhookWorkers()
+}
+
+// webhookChange describes a change for a payload.
+func webhookChange(ctx context.Context, changeId int64) (*webhook.Change, int32, error) {
+	change, err := db.Q.GetChange(ctx, changeId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get change: %w", err)
+	}
+	result := &webhook.Change{
+		ID:          change.ID,
+		Name:        change.Name,
+		Description: change.Description,
+	}
+	if change.AuthorID != nil {
+		if author, err := db.Q.GetUser(ctx, *change.AuthorID); err == nil {
+			result.Author = author.Username
+		}
+	}
+	parents, err := db.Q.GetChangeParents(ctx, changeId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get change parents: %w", err)
+	}
+	for _, parent := range parents {
+		result.Parents = append(result.Parents, parent.Name)
+	}
+	return result, change.RepositoryID, nil
+}
+
+// notifyChangeEvent sends the change.pushed or change.created event of a
+// change. changed lists the files a push changed.
+func notifyChangeEvent(ctx context.Context, event webhook.Event, changeId int64, senderId *int32, changed []ci.ChangedPath) {
+	change, repositoryId, err := webhookChange(ctx, changeId)
+	if err != nil {
+		fmt.Printf("Webhook error: change_id=%d event=%s detail=%v\n", changeId, event, err)
+		return
+	}
+	for _, path := range changed {
+		change.ChangedFiles = append(change.ChangedFiles, webhook.ChangedFile{Path: path.Path, Operation: string(path.Operation)})
+	}
+	queueWebhookEvent(ctx, repositoryId, senderId, webhook.Payload{Event: event, Change: change})
+}
+
+// notifyBookmarkEvent sends the bookmark.set or bookmark.removed event of a
+// bookmark pointing to the change. previousChangeId is the change a set
+// bookmark pointed to before.
+func notifyBookmarkEvent(ctx context.Context, event webhook.Event, repositoryId int32, name string, changeId int64, previousChangeId *int64, senderId *int32) {
+	change, err := db.Q.GetChange(ctx, changeId)
+	if err != nil {
+		fmt.Printf("Webhook error: repo_id=%d event=%s detail=get change: %v\n", repositoryId, event, err)
+		return
+	}
+	bookmark := &webhook.Bookmark{Name: name, Change: change.Name}
+	if previousChangeId != nil {
+		if previous, err := db.Q.GetChange(ctx, *previousChangeId); err == nil {
+			bookmark.PreviousChange = previous.Name
+		}
+	}
+	queueWebhookEvent(ctx, repositoryId, senderId, webhook.Payload{Event: event, Bookmark: bookmark})
+}
+
+// notifyCIPipelineFinished sends the ci_run.finished event of a pipeline. It
+// must be called once, by whoever finished or cancelled the pipeline.
+func notifyCIPipelineFinished(ctx context.Context, pipelineId int64) {
+	p, err := db.Q.GetCIPipeline(ctx, pipelineId)
+	if err != nil {
+		fmt.Printf("Webhook error: pipeline_id=%d detail=get pipeline: %v\n", pipelineId, err)
+		return
+	}
+	change, err := db.Q.GetChange(ctx, p.ChangeID)
+	if err != nil {
+		fmt.Printf("Webhook error: pipeline_id=%d detail=get change: %v\n", pipelineId, err)
+		return
+	}
+	runs, err := db.Q.GetCIPipelineRuns(ctx, p.RepositoryID, p.ID)
+	if err != nil {
+		fmt.Printf("Webhook error: pipeline_id=%d detail=get runs: %v\n", pipelineId, err)
+		return
+	}
+
+	run := &webhook.CIRun{
+		PipelineID: p.ID,
+		Config:     p.ConfigFilename,
+		EventType:  p.EventType,
+		Rev:        p.Rev,
+		Change:     change.Name,
+		State:      p.State,
+		Error:      p.Error,
+		Jobs:       make([]webhook.CIJob, len(runs)),
+	}
+	for i, r := range runs {
+		job := webhook.CIJob{RunID: r.ID, Status: r.Status, Success: r.Success}
+		if r.JobName != nil {
+			job.Name = *r.JobName
+		}
+		run.Jobs[i] = job
+	}
+	if len(runs) > 0 {
+		run.URL = fmt.Sprintf("%s/repository/%d/ci/%d", env.PublicAddress, p.RepositoryID, runs[0].ID)
+	}
+	queueWebhookEvent(ctx, p.RepositoryID, p.TriggeredByUserID, webhook.Payload{Event: webhook.EventCIRunFinished, CIRun: run})
+}
+
+// notifySettingsChanged sends the repository.settings_changed event. value
+// identifies what changed and must not contain secret values.
+func notifySettingsChanged(ctx context.Context, repositoryId int32, senderId *int32, setting, action, value string) {
+	queueWebhookEvent(ctx, repositoryId, senderId, webhook.Payload{
+		Event:    webhook.EventSettingsChanged,
+		Settings: &webhook.Settings{Setting: setting, Action: action, Value: value},
+	})
+}
+
+// secretSettingValue identifies a secret in a settings event.
+func secretSettingValue(environment, key string) string {
+	if environment == "" {
+		return key
+	}
+	return environment + "/" + key
+}
+
+func visibilityName(public bool) string {
+	if public {
+		return "public"
+	}
+	return "private"
+}
+
+// StartWebhookDeliveries starts the workers sending queued webhook
+// deliveries.
+func StartWebhookDeliveries(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	webhookWorkersCancel = cancel
+	for range webhookWorkers {
+		go runWebhookWorker(ctx)
+	}
+}
+
+// StopWebhookDeliveries stops claiming deliveries. Deliveries being sent are
+// finished, those left claimed by a crash are picked up again after
+// webhookOrphanTimeout.
+func StopWebhookDeliveries() {
+	if webhookWorkersCancel != nil {
+		webhookWorkersCancel()
+	}
+}
+
+func runWebhookWorker(ctx context.Context) {
+	for {
+		changed := webhookQueueChanged()
+		staleBefore := pgtype.Timestamptz{
+			Time:  time.Now().Add(-webhookOrphanTimeout).UTC(),
+			Valid: true,
+		}
+		d, err := db.Q.ClaimWebhookDelivery(ctx, staleBefore)
+		if err == nil {
+			deliverWebhook(d)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			fmt.Printf("Webhook error: detail=claim delivery: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-time.After(webhookPollInterval):
+		}
+	}
+}
+
+// deliverWebhook makes one attempt of a claimed delivery and schedules the
+// next attempt with backoff if it failed and the retry policy of the webhook
+// allows it.
+func deliverWebhook(d db.WebhookDelivery) {
+	ctx := context.Background()
+
+	hook, err := db.Q.GetWebhook(ctx, d.WebhookID)
+	if err != nil {
+		fmt.Printf("Webhook error: delivery_id=%d detail=get webhook: %v\n", d.ID, err)
+		return
+	}
+
+	var result webhook.Result
+	var secret string
+	err = withSecretsKey(func(k *secrets.Key) error {
+		var err error
+		secret, err = k.Decrypt(hook.SecretCiphertext, webhookAdditionalData(hook.RepositoryID, hook.Url))
+		return err
+	})
+	if err != nil {
+		result.Err = fmt.Errorf("decrypt webhook secret: %w", err)
+	} else {
+		result = webhook.Deliver(ctx, webhookClient, hook.Url, []byte(secret), webhook.Event(d.Event), d.ID, d.Payload)
+	}
+
+	state := "succeeded"
+	nextAttemptAt := time.Now()
+	var errMsg *string
+	if result.Err != nil {
+		msg := result.Err.Error()
+		errMsg = &msg
+		policy := &ci.RetryPolicy{MaxAttempts: int(hook.MaxAttempts)}
+		if int(d.Attempts) < policy.Attempts() {
+			state = "pending"
+			nextAttemptAt = nextAttemptAt.Add(policy.Backoff(int(d.Attempts), webhookRetryBase, webhookRetryLimit))
+		} else {
+			state = "failed"
+		}
+	}
+	var statusCode *int32
+	if result.StatusCode != 0 {
+		code := int32(result.StatusCode)
+		statusCode = &code
+	}
+	var response *string
+	if result.Response != "" {
+		response = &result.Response
+	}
+
+	if err := db.Q.FinishWebhookDeliveryAttempt(ctx, state, statusCode, response, errMsg, pgtype.Timestamptz{Time: nextAttemptAt.UTC(), Valid: true}, d.ID); err != nil {
+		fmt.Printf("Webhook error: delivery_id=%d detail=finish attempt: %v\n", d.ID, err)
+		return
+	}
+	fmt.Printf("Webhook delivery: delivery_id=%d webhook_id=%d event=%s attempt=%d state=%s\n", d.ID, d.WebhookID, d.Event, d.Attempts, state)
+}
+
+// redeliverWebhook queues the payload of a delivery again as a new delivery.
+func redeliverWebhook(ctx context.Context, repositoryId int32, deliveryId int64) (int64, error) {
+	id, err := db.Q.RedeliverWebhookDelivery(ctx, repositoryId, deliveryId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("delivery %d not found", deliveryId)
+		}
+		return 0, fmt.Errorf("redeliver webhook: %w", err)
+	}
+	wakeWebhookWorkers()
+	return id, nil
+}
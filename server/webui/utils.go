@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -39,11 +40,15 @@ func GetParamI32(ctx context.Context, name string) (int32, bool) {
 }
 
 type UiContext struct {
-	ctx  context.Context
-	req  *http.Request
-	user *db.User
+	ctx          context.Context
+	req          *http.Request
+	user         *db.User
+	secretValues map[int32][]string
 }
 
+// uiContextKey looks up the UiContext of a page in contexts derived from it.
+type uiContextKey struct{}
+
 func NewUiContext(req *http.Request) context.Context {
 	ctx := &UiContext{
 		ctx: req.Context(),
@@ -77,6 +82,8 @@ func (c *UiContext) Err() error {
 
 func (c *UiContext) Value(key any) any {
 	switch key := key.(type) {
+	case uiContextKey:
+		return c
 	case string:
 		switch key {
 		case auth.UserCtxKey:
@@ -181,18 +188,46 @@ func GetSanitizedLog(ctx context.Context, repoId int32, compressedLog []byte) st
 	if err != nil {
 		return "Error decompressing log: " + err.Error()
 	}
+	return GetSanitizedText(ctx, repoId, string(decompressed))
+}
 
-	secretRows, err := db.Q.GetAllSecrets(ctx, repoId)
+// SecretValues decrypts the values of the secrets of a repository. It is set
+// by the server, which holds the key.
+var SecretValues func(ctx context.Context, repoId int32) ([]string, error)
+
+// GetSanitizedText hides the secret values of the repository in text.
+func GetSanitizedText(ctx context.Context, repoId int32, text string) string {
+	secretValues, err := repoSecretValues(ctx, repoId)
 	if err != nil {
-		return string(decompressed)
+		return "Error reading secrets to hide them: " + err.Error()
 	}
+	return secrets.Hide(text, secretValues)
+}
 
-	secretValues := make([]string, 0, len(secretRows))
-	for _, secret := range secretRows {
-		secretValues = append(secretValues, secret.Value)
+// repoSecretValues returns the secret values of a repository, decrypted once
+// per page.
+func repoSecretValues(ctx context.Context, repoId int32) ([]string, error) {
+	uiCtx, _ := ctx.Value(uiContextKey{}).(*UiContext)
+	if uiCtx == nil {
+		return SecretValues(ctx, repoId)
+	}
+	if values, ok := uiCtx.secretValues[repoId]; ok {
+		return values, nil
+	}
+	values, err := SecretValues(ctx, repoId)
+	if err != nil {
+		return nil, err
 	}
+	if uiCtx.secretValues == nil {
+		uiCtx.secretValues = make(map[int32][]string)
+	}
+	uiCtx.secretValues[repoId] = values
+	return values, nil
+}
 
-	return secrets.Hide(string(decompressed), secretValues)
+// IsFlakyTest reports whether a test both passed and failed in its history.
+func IsFlakyTest(history []string) bool {
+	return slices.Contains(history, "passed") && slices.Contains(history, "failed")
 }
 
 func FormatTimestamptz(ts interface{}) string {
@@ -211,6 +246,22 @@ func FormatTimestamptz(ts interface{}) string {
 	}
 }
 
+// GetChangeCIStatuses returns the CI status of each of the changes that has
+// one, keyed by change ID.
+func GetChangeCIStatuses(ctx context.Context, repoId int32, changes []db.GetNewestChangesRow) map[int64]string {
+	changeIds := make([]int64, len(changes))
+	for i, c := range changes {
+		changeIds[i] = c.ID
+	}
+	statuses := make(map[int64]string)
+	if rows, err := db.Q.GetChangeCIStatuses(ctx, repoId, changeIds); err == nil {
+		for _, row := range rows {
+			statuses[row.ChangeID] = row.Status
+		}
+	}
+	return statuses
+}
+
 // AssetNode represents a file or directory in the asset tree.
 type AssetNode struct {
 	Name     string